@@ -0,0 +1,12 @@
+package main
+
+import "time"
+
+func explode() {
+	time.Sleep(100 * time.Millisecond)
+	panic("boom")
+}
+
+func main() {
+	explode()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Add(d int) int {
+	c.n += d
+	return c.n
+}
+
+var total = &Counter{n: 40}
+
+func double(x int) int {
+	return 2 * x
+}
+
+func join(sep string, parts ...string) string {
+	return strings.Join(parts, sep)
+}
+
+func main() {
+	x := 21
+	fmt.Println(total.Add(0), x)
+	fmt.Println(double(x), join("-", "a", "b"))
+}
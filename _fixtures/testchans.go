@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func main() {
+	c1 := make(chan int, 10)
+	c2 := make(chan int)
+
+	// Leave the buffer of c1 wrapped around its end.
+	for i := 1; i <= 10; i++ {
+		c1 <- i
+	}
+	for i := 0; i < 7; i++ {
+		<-c1
+	}
+	c1 <- 11
+	c1 <- 12
+
+	for i := 0; i < 2; i++ {
+		go func() { <-c2 }()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	fmt.Println(len(c1), len(c2))
+}
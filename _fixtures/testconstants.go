@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+type State int
+
+const (
+	Idle State = iota
+	Running
+	Stopped
+)
+
+type Perm uint8
+
+const (
+	Read Perm = 1 << iota
+	Write
+	Exec
+)
+
+var (
+	state = Running
+	perms = Read | Exec
+	mode  = Perm(Write | 16)
+	odd   = State(7)
+)
+
+func main() {
+	fmt.Println(state, perms, mode, odd)
+}
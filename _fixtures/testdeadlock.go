@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Idle workers wait on a channel any goroutine can send on.
+var jobs = make(chan int)
+
+func worker() {
+	for j := range jobs {
+		fmt.Println(j)
+	}
+}
+
+func lockBoth(first, second *sync.Mutex) {
+	first.Lock()
+	time.Sleep(10 * time.Millisecond)
+	second.Lock()
+}
+
+// The mutexes are only known to the goroutines locking them.
+func startLockers() {
+	var a, b sync.Mutex
+	go lockBoth(&a, &b)
+	go lockBoth(&b, &a)
+}
+
+func stop() {
+	fmt.Println("stop")
+}
+
+func main() {
+	go worker()
+	go worker()
+	startLockers()
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+}
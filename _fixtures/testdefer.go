@@ -0,0 +1,28 @@
+package main
+
+import "fmt"
+
+func deferred() {
+	fmt.Println("deferred")
+}
+
+func recoverer() {
+	recover()
+}
+
+func withDefer() {
+	defer deferred()
+	fmt.Println("body")
+}
+
+func panicking() {
+	defer recoverer()
+	panic("panicking")
+}
+
+func main() {
+	for {
+		withDefer()
+		panicking()
+	}
+}
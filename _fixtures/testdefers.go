@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+func cleanup(s string) {
+	fmt.Println("cleanup", s)
+}
+
+func work() {
+	defer cleanup("work")
+	fmt.Println("working")
+}
+
+func main() {
+	defer cleanup("main")
+	work()
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+type Base struct {
+	ID   int
+	Name string
+}
+
+type Logger struct {
+	Prefix string
+	Level  int
+}
+
+type Server struct {
+	Base
+	*Logger
+	Addr string
+}
+
+type Wrapper struct {
+	Server
+	Name string
+}
+
+type A struct{ X int }
+
+type B struct{ X int }
+
+type Ambiguous struct {
+	A
+	B
+}
+
+var (
+	srv = Wrapper{
+		Server: Server{
+			Base:   Base{ID: 7, Name: "base"},
+			Logger: &Logger{Prefix: "srv: ", Level: 2},
+			Addr:   ":8080",
+		},
+		Name: "outer",
+	}
+	bare Server
+	amb  Ambiguous
+)
+
+func main() {
+	fmt.Println(srv.Addr, srv.ID, srv.Level, bare.Addr, amb.A.X)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func child() {
+	fmt.Println("child")
+}
+
+func started(pid int) {
+	fmt.Println("started", pid)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "child" {
+		child()
+		return
+	}
+
+	// Not a Go program, which is not followed.
+	exec.Command("true").Run()
+
+	cmd := exec.Command(os.Args[0], "child")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Start(); err != nil {
+		panic(err)
+	}
+
+	started(cmd.Process.Pid)
+	cmd.Wait()
+}
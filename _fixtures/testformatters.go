@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+type Celsius int
+
+var (
+	timeout = 90 * time.Second
+	start   = time.Date(2014, 9, 1, 10, 0, 0, 0, time.UTC)
+	addr    = net.IPv4(10, 0, 0, 1)
+	huge    = new(big.Int).Lsh(big.NewInt(-1), 100)
+	mu      sync.Mutex
+	temp    = Celsius(21)
+)
+
+func main() {
+	mu.Lock()
+	fmt.Println(timeout, start, addr, huge, temp)
+	mu.Unlock()
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+func double(n int) int {
+	return 2 * n
+}
+
+func makeAdder(base int, name string) func(int) int {
+	return func(n int) int {
+		return base + n + len(name)
+	}
+}
+
+var (
+	fn    = double
+	adder = makeAdder(40, "ab")
+	none  func()
+)
+
+func main() {
+	fmt.Println(fn(1), adder(1), none == nil)
+}
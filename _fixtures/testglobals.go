@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+type Config struct {
+	Name    string
+	Retries int
+}
+
+var config = Config{Name: "delve", Retries: 3}
+
+var counter int
+
+func bump() {
+	counter++
+	fmt.Println(counter, filepath.SkipDir)
+}
+
+func main() {
+	config.Retries++
+	bump()
+	fmt.Println(config, current.Name)
+}
+
+var current = &config
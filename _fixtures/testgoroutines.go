@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+func worker(ch chan int, wg *sync.WaitGroup) {
+	<-ch
+	wg.Done()
+}
+
+func stop() {
+	fmt.Println("stop")
+}
+
+func main() {
+	var (
+		wg sync.WaitGroup
+		ch = make(chan int)
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go worker(ch, &wg)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	close(ch)
+	wg.Wait()
+}
@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+type FooBar struct {
+	Baz, Qux int
+}
+
+type code int
+
+func (c code) Error() string {
+	return fmt.Sprint("code ", int(c))
+}
+
+func main() {
+	var (
+		i1 interface{} = 42
+		i2 interface{} = &FooBar{Baz: 5, Qux: 6}
+		i3 fmt.Stringer
+		e1 error = code(3)
+	)
+
+	fmt.Println(i1, i2, i3, e1)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+)
+
+func stop() {
+	fmt.Println("stop")
+}
+
+func main() {
+	labels := pprof.Labels("request_id", "42", "user", "gopher")
+	pprof.Do(context.Background(), labels, func(context.Context) {
+		stop()
+	})
+}
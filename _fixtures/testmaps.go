@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+func maps() {
+	var (
+		m1 = map[int]int{1: 10, 2: 20, 3: 30}
+		m2 map[int]int
+	)
+
+	fmt.Println(m1, m2)
+}
+
+func main() {
+	maps()
+}
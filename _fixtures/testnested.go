@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+type Inner struct {
+	Vals []int
+	N    int
+}
+
+type Middle struct {
+	In *Inner
+}
+
+type Outer struct {
+	M *Middle
+}
+
+func main() {
+	in := &Inner{Vals: []int{1, 2, 3}, N: 4}
+	o := Outer{M: &Middle{In: in}}
+	p := &o
+
+	fmt.Println(o, p)
+}
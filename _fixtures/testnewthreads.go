@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func work(id int) {
+	fmt.Println("work", id)
+}
+
+func thread(id int) {
+	runtime.LockOSThread()
+	for {
+		work(id)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func main() {
+	// Let the debugger attach before any thread is started.
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		go thread(i)
+	}
+
+	for {
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+func double(n int) int {
+	return 2 * n
+}
+
+var (
+	c64   = complex64(complex(1.5, -2))
+	c128  = complex(3, 4.25)
+	f32   = float32(1.25)
+	raw   = unsafe.Pointer(&f32)
+	fn    = unsafe.Pointer(reflect.ValueOf(double).Pointer())
+	code  uintptr
+	stack uintptr
+)
+
+func main() {
+	n := 1
+	pc, _, _, _ := runtime.Caller(0)
+	code, stack = pc, uintptr(unsafe.Pointer(&n))
+	fmt.Println(c64, c128, f32, raw, fn, code, double(n))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"time"
+)
+
+func loaded() {}
+
+func main() {
+	for {
+		// The plugin is built next to the program, opening
+		// it again returns the plugin already loaded.
+		p, err := plugin.Open(filepath.Join(filepath.Dir(os.Args[0]), "testpluginlib.so"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		loaded()
+
+		greet, err := p.Lookup("Greet")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(greet.(func(string) string)("plugin"))
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+func Greet(name string) string {
+	msg := fmt.Sprintf("hello %s", name)
+	return msg
+}
@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+func fact(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return n * fact(n-1)
+}
+
+func main() {
+	for {
+		fmt.Println(fact(5))
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+func square(n int) int {
+	return n * n
+}
+
+func main() {
+	for i := 0; ; i++ {
+		fmt.Println(square(7))
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+func main() {
+	a := 1
+	fmt.Println(a)
+	for i := 0; i < 2; i++ {
+		a := "inner"
+		if i > 0 {
+			a := i * 10
+			fmt.Println(a)
+		}
+		fmt.Println(a)
+	}
+	fmt.Println(a)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+func work(id int) {
+	fmt.Println("work", id)
+}
+
+func stop() {
+	fmt.Println("stop")
+}
+
+func main() {
+	time.Sleep(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			// The thread exits along with the goroutine,
+			// as it is never unlocked.
+			runtime.LockOSThread()
+			work(id)
+		}(i)
+	}
+	wg.Wait()
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func work(id int) {
+	fmt.Println("work", id)
+}
+
+func thread(id int) {
+	runtime.LockOSThread()
+	for {
+		work(id)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func main() {
+	for i := 0; i < 4; i++ {
+		go thread(i)
+	}
+
+	for {
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Lists of commands attached to breakpoints, keyed by breakpoint ID,
+// which are run whenever the breakpoint stops execution.
+type hitActions struct {
+	commands *Commands
+	actions  map[int][]string
+}
+
+// Attaches commands to a breakpoint: on <id> <command>; <command>; ...
+// With no commands any attached to the breakpoint are removed.
+func (ha *hitActions) on(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: on <breakpoint id> [command; command; ...]")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	if id == 0 || p.FindBreakpointByID(id) == nil {
+		return fmt.Errorf("no breakpoint with id %d", id)
+	}
+
+	actions := splitActions(strings.Join(args[1:], " "))
+	if len(actions) == 0 {
+		delete(ha.actions, id)
+		fmt.Printf("Removed the commands of breakpoint %d\n", id)
+		return nil
+	}
+
+	ha.actions[id] = actions
+	fmt.Printf("Breakpoint %d will run: %s\n", id, strings.Join(actions, "; "))
+
+	return nil
+}
+
+// Wraps a command resuming execution. Whenever the process stops at a
+// breakpoint with commands attached they are run in order, and if one
+// of them is continue the process is continued without returning to
+// the user.
+func (ha *hitActions) stopping(cmd cmdfunc) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		err := cmd(p, args...)
+		for err == nil && !p.ProcessState.Exited() {
+			bp, berr := p.CurrentBreakpoint()
+			if berr != nil || bp == nil {
+				return berr
+			}
+
+			if !ha.run(p, ha.actions[bp.ID]) {
+				return nil
+			}
+
+			err = cont(p)
+		}
+
+		return err
+	}
+}
+
+// Runs the given commands, reporting rather than stopping on failures.
+// Returns true if the commands asked for execution to be resumed.
+func (ha *hitActions) run(p *proctl.DebuggedProcess, actions []string) bool {
+	for _, action := range actions {
+		fields := strings.Fields(action)
+		if fields[0] == "continue" {
+			return true
+		}
+
+		cmd, ok := ha.commands.cmds[fields[0]]
+		if !ok {
+			fmt.Printf("Command %q not available\n", fields[0])
+			continue
+		}
+
+		err := cmd(p, fields[1:]...)
+		if err != nil {
+			fmt.Printf("Command %q failed: %s\n", action, err)
+		}
+	}
+
+	return false
+}
+
+// Splits a ; separated list of commands, dropping empty ones.
+func splitActions(s string) []string {
+	actions := make([]string, 0)
+	for _, a := range strings.Split(s, ";") {
+		if a = strings.TrimSpace(a); a != "" {
+			actions = append(actions, a)
+		}
+	}
+
+	return actions
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Lists breakpoints, or saves them to and loads them from a file with
+// breakpoints save <file> and breakpoints load <file>.
+func breakpoints(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return listBreakpoints(p)
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: breakpoints [save|load <file>]")
+	}
+
+	switch args[0] {
+	case "save":
+		return saveBreakpoints(p, args[1])
+	case "load":
+		return loadBreakpoints(p, args[1])
+	}
+
+	return fmt.Errorf("unknown breakpoints subcommand %s", args[0])
+}
+
+// Writes the breakpoints to path as break, tbreak, trace and logpoint commands. Locations
+// are written symbolically so the file can still be loaded after the
+// program has been rebuilt and its addresses have changed.
+func saveBreakpoints(p *proctl.DebuggedProcess, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bps := userBreakpoints(p)
+	for _, bp := range bps {
+		fn := p.LookupFunc(bp.FunctionName)
+		atEntry := fn != nil && fn.Entry == bp.Addr
+
+		_, err := fmt.Fprintln(f, breakpointSpec(bp, atEntry))
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Saved %d breakpoints to %s\n", len(bps), path)
+
+	return nil
+}
+
+// Sets the breakpoints saved in path. Breakpoints which can no longer
+// be set, because their location has gone away, are reported and
+// skipped so that the rest are still loaded.
+func loadBreakpoints(p *proctl.DebuggedProcess, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		cmd, args, ok := parseBreakpointSpec(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		var bp *proctl.BreakPoint
+		if cmd == "logpoint" {
+			bp, err = setLogpoint(p, args)
+		} else {
+			bp, err = setBreakpoint(p, cmd, args)
+		}
+		if err != nil {
+			fmt.Printf("Could not set breakpoint %s: %s\n", strings.Join(args, " "), err)
+			continue
+		}
+		bp.OneShot = cmd == "tbreak"
+		bp.Tracepoint = cmd == "trace"
+
+		fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	}
+
+	return scanner.Err()
+}
+
+// Returns the command which sets bp. Breakpoints at the entry of a
+// function are described by the function name, any others by file:line.
+// The thread a breakpoint is restricted to is left out, thread IDs
+// being meaningless to another run of the program.
+func breakpointSpec(bp *proctl.BreakPoint, atEntry bool) string {
+	cmd := "break"
+	switch {
+	case bp.LogMessage != "":
+		cmd = "logpoint"
+	case bp.Tracepoint:
+		cmd = "trace"
+	case bp.OneShot:
+		cmd = "tbreak"
+	}
+
+	loc := fmt.Sprintf("%s:%d", bp.File, bp.Line)
+	if atEntry {
+		loc = bp.FunctionName
+	}
+
+	spec := cmd + " " + loc
+	if bp.LogMessage != "" {
+		spec += " " + strconv.Quote(bp.LogMessage)
+	}
+	if bp.MaxHits > 0 {
+		spec += fmt.Sprintf(" -max %d", bp.MaxHits)
+	}
+	if bp.Condition != "" {
+		spec += " if " + bp.Condition
+	}
+
+	return spec
+}
+
+// Splits a line written by breakpointSpec into the command and its
+// arguments. Blank lines and lines starting with # are ignored.
+func parseBreakpointSpec(line string) (string, []string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+		return "", nil, false
+	}
+
+	switch fields[0] {
+	case "break", "tbreak", "trace", "logpoint":
+	default:
+		return "", nil, false
+	}
+
+	return fields[0], fields[1:], true
+}
@@ -3,16 +3,15 @@
 package command
 
 import (
-	"bufio"
-	"debug/gosym"
+	"encoding/hex"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/derekparker/delve/proctl"
+	"github.com/derekparker/delve/version"
 )
 
 type cmdfunc func(proc *proctl.DebuggedProcess, args ...string) error
@@ -23,17 +22,83 @@ type Commands struct {
 
 // Returns a Commands struct with default commands defined.
 func DebugCommands() *Commands {
-	cmds := map[string]cmdfunc{
-		"continue": cont,
-		"next":     next,
-		"break":    breakpoint,
-		"step":     step,
-		"clear":    clear,
-		"print":    printVar,
-		"":         nullCommand,
+	fc := &frameContext{}
+	bt := &backtraceContext{fc: fc}
+	c := &Commands{}
+	ha := &hitActions{commands: c, actions: make(map[int][]string)}
+	gs := &goroutineSnapshot{}
+	dl := &displayList{}
+
+	// Commands resuming execution run the commands of the breakpoint
+	// they stop at, and print the display expressions once the process
+	// stops again.
+	resuming := func(cmd cmdfunc) cmdfunc {
+		return dl.stopping(gs.resuming(reportWatchpointChanges(ha.stopping(cmd))))
+	}
+
+	// Commands stepping the current thread refuse to run
+	// while a goroutine which is not running is selected.
+	stepping := func(cmd cmdfunc) cmdfunc {
+		return resuming(fc.stepping(cmd))
 	}
 
-	return &Commands{cmds}
+	c.cmds = map[string]cmdfunc{
+		"continue":    resuming(cont),
+		"on":          ha.on,
+		"next":        stepping(next),
+		"break":       breakpoint,
+		"tbreak":      tbreakpoint,
+		"trace":       tracepoint,
+		"logpoint":    logpoint,
+		"break-on-go": breakOnGo,
+		"breakpoints": breakpoints,
+		"watch":       watch,
+		"unwatch":     unwatch,
+		"step":        stepping(step),
+		"stepcall":    stepping(stepcall),
+		"stepi":       stepping(stepi),
+		"skip":        skip,
+		"unskip":      unskip,
+		"nexti":       stepping(nexti),
+		"finish":      stepping(finish),
+		"until":       stepping(until),
+		"advance":     stepping(advance),
+		"stepout":     stepping(finish),
+		"clear":       clear,
+		"clearall":    clearAll,
+		"print":       fc.printVar,
+		"printf":      fc.printf,
+		"whatis":      fc.whatis,
+		"x":           fc.examine,
+		"write":       fc.write,
+		"maps":        memoryMap,
+		"search":      fc.search,
+		"disassemble": fc.disassemble,
+		"types":       types,
+		"set":         fc.set,
+		"call":        call,
+		"locals":      fc.locals,
+		"args":        fc.args,
+		"vars":        packageVars,
+		"display":     dl.display,
+		"undisplay":   dl.undisplay,
+		"frame":       fc.frame,
+		"up":          fc.up,
+		"down":        fc.down,
+		"bt":          bt.backtrace,
+		"dump-stacks": dumpStacks,
+		"goroutine":   fc.goroutineCommand,
+		"goroutines":  gs.list,
+		"deadlock":    deadlock,
+		"threads":     threads,
+		"regs":        regs,
+		"thread":      fc.thread,
+		"version":     printVersion,
+		"list":        list,
+		"":            nullCommand,
+	}
+
+	return c
 }
 
 // Register custom commands. Expects cf to be a func of type cmdfunc,
@@ -47,6 +112,18 @@ func (c *Commands) Register(cmdstr string, cf cmdfunc) {
 // If the command is an empty string it will replay the last command.
 func (c *Commands) Find(cmdstr string) cmdfunc {
 	cmd, ok := c.cmds[cmdstr]
+
+	// A command may be followed by a format, as in x/16xb,
+	// which is passed to it as its first argument.
+	if i := strings.Index(cmdstr, "/"); !ok && i > 0 {
+		if fn, found := c.cmds[cmdstr[:i]]; found {
+			format := cmdstr[i:]
+			cmd, ok = func(p *proctl.DebuggedProcess, args ...string) error {
+				return fn(p, append([]string{format}, args...)...)
+			}, true
+		}
+	}
+
 	if !ok {
 		return noCmdAvailable
 	}
@@ -71,64 +148,342 @@ func nullCommand(p *proctl.DebuggedProcess, ars ...string) error {
 	return nil
 }
 
-func cont(p *proctl.DebuggedProcess, ars ...string) error {
-	err := p.Continue()
+// Continues execution, with a location as argument
+// until that location is reached.
+func cont(p *proctl.DebuggedProcess, args ...string) error {
+	err := resume(p, args)
 	if err != nil {
 		return err
 	}
 
+	if p.ProcessState.Exited() {
+		fmt.Printf("Process %d exited with status %d\n", p.Pid, p.ProcessState.ExitStatus())
+		return nil
+	}
+
+	bp, err := p.CurrentBreakpoint()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bp == nil:
+	case bp.IsPanic():
+		fmt.Println("Stopped on panic, use bt to see where it was raised")
+	case bp.IsGo():
+		fn, site, err := p.GoStatement()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stopped at go %s in %s at %s:%d\n", fn.Name, site.Fn.Name, site.File, site.Line)
+	default:
+		fmt.Printf("Breakpoint at %s:%d hit %d times\n", bp.File, bp.Line, bp.HitCount)
+	}
+
+	wp, err := p.CurrentWatchpoint()
+	if err != nil {
+		return err
+	}
+
+	if wp != nil {
+		old, cur, err := p.WatchpointValues(wp)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Watchpoint %d hit, %s changed from %d to %d\n", wp.ID, wp.Expr, old, cur)
+	}
+
 	return printcontext(p)
 }
 
-func step(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Step()
+func resume(p *proctl.DebuggedProcess, args []string) error {
+	if len(args) == 0 {
+		return p.Continue()
+	}
+
+	pc, _, err := findLocation(p, args[0])
 	if err != nil {
 		return err
 	}
 
-	return printcontext(p)
+	return p.ContinueTo(pc)
 }
 
-func next(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Next()
+func step(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 0 && args[0] == "--into" {
+		return stepcall(p, args[1:]...)
+	}
+
+	return repeat(p, args, p.Step)
+}
+
+// Steps into the call to the named function
+// made by the current line, stepping over the others.
+func stepcall(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: stepcall <function>")
+	}
+
+	if strings.ContainsRune(args[0], ':') {
+		return fmt.Errorf("%s is not a function", args[0])
+	}
+
+	pc, _, err := findLocation(p, args[0])
 	if err != nil {
 		return err
 	}
 
+	err = p.StepInto(pc)
+	if err != nil {
+		printcontext(p)
+		return err
+	}
+
 	return printcontext(p)
 }
 
-func clear(p *proctl.DebuggedProcess, args ...string) error {
-	var (
-		fn    *gosym.Func
-		pc    uint64
-		fname = args[0]
-	)
+func next(p *proctl.DebuggedProcess, args ...string) error {
+	return repeat(p, args, p.Next)
+}
 
-	if strings.ContainsRune(fname, ':') {
-		fl := strings.Split(fname, ":")
+// Runs op the number of times given by the optional count in args,
+// stopping early if the process exits or stops at a breakpoint
+// or watchpoint, then reports where execution stopped.
+func repeat(p *proctl.DebuggedProcess, args []string, op func() error) error {
+	n, err := repeatCount(args)
+	if err != nil {
+		return err
+	}
 
-		f, err := filepath.Abs(fl[0])
+	for i := 1; i <= n; i++ {
+		err := op()
 		if err != nil {
 			return err
 		}
 
-		l, err := strconv.Atoi(fl[1])
+		if p.ProcessState.Exited() {
+			return nil
+		}
+
+		if i == n {
+			break
+		}
+
+		stopped, err := stoppedByUser(p)
 		if err != nil {
 			return err
 		}
 
-		pc, fn, err = p.GoSymTable.LineToPC(f, l)
+		if stopped != "" {
+			fmt.Printf("%s after %d of %d steps\n", stopped, i, n)
+			break
+		}
+	}
+
+	return printcontext(p)
+}
+
+// Parses the optional repeat count of step and next.
+func repeatCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %q", args[0])
+	}
+
+	return n, nil
+}
+
+// Describes the breakpoint or watchpoint set by the user that the
+// process is stopped at, or returns an empty string if there is none.
+func stoppedByUser(p *proctl.DebuggedProcess) (string, error) {
+	wp, err := p.CurrentWatchpoint()
+	if err != nil {
+		return "", err
+	}
+
+	if wp != nil {
+		return fmt.Sprintf("Watchpoint %d hit", wp.ID), nil
+	}
+
+	bp, err := p.CurrentBreakpoint()
+	if err != nil {
+		return "", err
+	}
+
+	if bp != nil && bp.ID != 0 {
+		return fmt.Sprintf("Breakpoint %d hit", bp.ID), nil
+	}
+
+	return "", nil
+}
+
+// Adds a pattern to the functions step never stops in,
+// or lists the patterns when called without one.
+func skip(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		if len(p.SkipFunctions) == 0 {
+			fmt.Println("No functions are skipped.")
+		}
+		for _, pattern := range p.SkipFunctions {
+			fmt.Println(pattern)
+		}
+		return nil
+	}
+
+	for _, pattern := range p.SkipFunctions {
+		if pattern == args[0] {
+			return fmt.Errorf("%s is already skipped", pattern)
+		}
+	}
+
+	p.SkipFunctions = append(p.SkipFunctions, args[0])
+	fmt.Printf("Skipping %s\n", args[0])
+
+	return nil
+}
+
+func unskip(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: unskip <pattern>")
+	}
+
+	for i, pattern := range p.SkipFunctions {
+		if pattern == args[0] {
+			p.SkipFunctions = append(p.SkipFunctions[:i], p.SkipFunctions[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not skipped", args[0])
+}
+
+func stepi(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.StepInstruction()
+	if err != nil {
+		return err
+	}
+
+	return printInstructionContext(p)
+}
+
+func nexti(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.NextInstruction()
+	if err != nil {
+		return err
+	}
+
+	return printInstructionContext(p)
+}
+
+// Runs to a location in the current frame, or with no
+// argument to the first line after the current loop.
+func until(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		err := p.UntilLoopExit()
 		if err != nil {
 			return err
 		}
-	} else {
-		fn = p.GoSymTable.LookupFunc(fname)
-		if fn == nil {
-			return fmt.Errorf("No function named %s", fname)
+
+		return printcontext(p)
+	}
+
+	pc, _, err := findLocation(p, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = p.Until(pc)
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+// Continues until the named function is entered
+// or the current function returns.
+func advance(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: advance <function>")
+	}
+
+	if strings.ContainsRune(args[0], ':') {
+		return fmt.Errorf("%s is not a function", args[0])
+	}
+
+	pc, _, err := findLocation(p, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = p.Advance(pc)
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+func finish(p *proctl.DebuggedProcess, args ...string) error {
+	pc, err := p.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	_, _, fn := p.PCToLine(pc)
+
+	vals, err := p.Finish()
+	if err != nil {
+		return err
+	}
+
+	if vals != nil && fn != nil {
+		fmt.Printf("Returned from %s\n", fn.Name)
+		for _, v := range vals {
+			fmt.Printf("\t%s = %s\n", v.Name, v.Formatted())
+		}
+	}
+
+	return printcontext(p)
+}
+
+// Clears the breakpoint or watchpoint with the given
+// ID, or the breakpoint at the given location.
+func clear(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments to clear")
+	}
+
+	if id, err := strconv.Atoi(args[0]); err == nil {
+		for _, wp := range p.Watchpoints {
+			if wp != nil && wp.ID == id {
+				err := p.ClearWatchpoint(wp)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("Watchpoint %d cleared for %s\n", wp.ID, wp.Expr)
+				return nil
+			}
 		}
 
-		pc = fn.Entry
+		bp, err := p.ClearByID(id)
+		if err != nil {
+			return err
+		}
+
+		printCleared(bp)
+		return nil
+	}
+
+	pc, _, err := findLocation(p, args[0])
+	if err != nil {
+		return err
 	}
 
 	bp, err := p.Clear(pc)
@@ -136,114 +491,569 @@ func clear(p *proctl.DebuggedProcess, args ...string) error {
 		return err
 	}
 
-	fmt.Printf("Breakpoint cleared at %#v for %s %s:%d\n", bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	printCleared(bp)
 
 	return nil
 }
 
-func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
-	var (
-		fn    *gosym.Func
-		pc    uint64
-		fname = args[0]
-	)
+// Clears every breakpoint, or with an argument only those in the
+// given file or function. Files and functions may be named by any
+// trailing part of their path, as for break. Watchpoints are only
+// cleared when no filter is given.
+func clearAll(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: clearall [file|function]")
+	}
 
-	if strings.ContainsRune(fname, ':') {
-		fl := strings.Split(fname, ":")
+	for _, bp := range userBreakpoints(p) {
+		if len(args) == 1 && !breakpointMatches(bp, args[0]) {
+			continue
+		}
 
-		f, err := filepath.Abs(fl[0])
+		_, err := p.Clear(bp.Addr)
 		if err != nil {
 			return err
 		}
 
-		l, err := strconv.Atoi(fl[1])
-		if err != nil {
-			return err
+		printCleared(bp)
+	}
+
+	if len(args) == 1 {
+		return nil
+	}
+
+	for _, wp := range p.Watchpoints {
+		if wp == nil {
+			continue
 		}
 
-		pc, fn, err = p.GoSymTable.LineToPC(f, l)
+		err := p.ClearWatchpoint(wp)
 		if err != nil {
 			return err
 		}
-	} else {
-		fn = p.GoSymTable.LookupFunc(fname)
-		if fn == nil {
-			return fmt.Errorf("No function named %s", fname)
-		}
 
-		pc = fn.Entry
+		fmt.Printf("Watchpoint %d cleared for %s\n", wp.ID, wp.Expr)
 	}
 
-	bp, err := p.Break(uintptr(pc))
+	return nil
+}
+
+// Reports whether bp is in the file or function named by filter.
+func breakpointMatches(bp *proctl.BreakPoint, filter string) bool {
+	return len(matchSuffix([]string{bp.File}, filter, "/")) > 0 ||
+		len(matchSuffix([]string{bp.FunctionName}, filter, "/", ".")) > 0
+}
+
+func printCleared(bp *proctl.BreakPoint) {
+	fmt.Printf("Breakpoint %d cleared at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+}
+
+func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	bp, err := setBreakpoint(p, "break", args)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Breakpoint set at %#v for %s %s:%d\n", bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	if bp.Condition != "" {
+		fmt.Println("Condition:", bp.Condition)
+	}
+	if bp.ThreadID != 0 {
+		fmt.Println("Thread:", bp.ThreadID)
+	}
 
 	return nil
 }
 
-func printVar(p *proctl.DebuggedProcess, args ...string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("Not enough arguments to print command")
+func tbreakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	bp, err := setBreakpoint(p, "tbreak", args)
+	if err != nil {
+		return err
 	}
+	bp.OneShot = true
 
-	val, err := p.EvalSymbol(args[0])
+	fmt.Printf("Temporary breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	if bp.Condition != "" {
+		fmt.Println("Condition:", bp.Condition)
+	}
+	if bp.ThreadID != 0 {
+		fmt.Println("Thread:", bp.ThreadID)
+	}
+
+	return nil
+}
+
+func tracepoint(p *proctl.DebuggedProcess, args ...string) error {
+	bp, err := setBreakpoint(p, "trace", args)
 	if err != nil {
 		return err
 	}
+	bp.Tracepoint = true
+
+	fmt.Printf("Tracepoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	if bp.Condition != "" {
+		fmt.Println("Condition:", bp.Condition)
+	}
+	if bp.ThreadID != 0 {
+		fmt.Println("Thread:", bp.ThreadID)
+	}
 
-	fmt.Println(val.Value)
 	return nil
 }
 
-func printcontext(p *proctl.DebuggedProcess) error {
-	var context []string
+// Stops at every go statement, or with -log
+// prints each of them and carries on.
+func breakOnGo(p *proctl.DebuggedProcess, args ...string) error {
+	trace := len(args) > 0 && args[0] == "-log"
 
-	regs, err := p.Registers()
+	bp, err := p.BreakOnGo()
 	if err != nil {
 		return err
 	}
+	bp.Tracepoint = trace
 
-	f, l, _ := p.GoSymTable.PCToLine(regs.PC())
+	action := "stopping at"
+	if trace {
+		action = "logging"
+	}
 
-	fmt.Printf("Stopped at: %s:%d\n", f, l)
-	file, err := os.Open(f)
+	fmt.Printf("Breakpoint %d set at %#v for %s, %s every go statement\n", bp.ID, bp.Addr, bp.FunctionName, action)
+
+	return nil
+}
+
+func logpoint(p *proctl.DebuggedProcess, args ...string) error {
+	bp, err := setLogpoint(p, args)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	buf := bufio.NewReader(file)
-	for i := 1; i < l-5; i++ {
-		_, err := buf.ReadString('\n')
-		if err != nil && err != io.EOF {
-			return err
+	fmt.Printf("Logpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	if bp.Condition != "" {
+		fmt.Println("Condition:", bp.Condition)
+	}
+	if bp.ThreadID != 0 {
+		fmt.Println("Thread:", bp.ThreadID)
+	}
+
+	return nil
+}
+
+// Sets a logpoint from the arguments of the logpoint command: a
+// location, a quoted message and optionally if <condition>.
+func setLogpoint(p *proctl.DebuggedProcess, args []string) (*proctl.BreakPoint, error) {
+	usage := fmt.Errorf(`usage: logpoint <location> "message {expr}" [if <condition>]`)
+	if len(args) < 2 {
+		return nil, usage
+	}
+
+	rest := strings.Join(args[1:], " ")
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return nil, usage
+	}
+
+	msg, err := strconv.Unquote(quoted)
+	if err != nil {
+		return nil, usage
+	}
+
+	bpargs := append([]string{args[0]}, strings.Fields(rest[len(quoted):])...)
+	bp, err := setBreakpoint(p, "logpoint", bpargs)
+	if err != nil {
+		return nil, err
+	}
+	bp.LogMessage = msg
+
+	return bp, nil
+}
+
+// Sets a breakpoint from the arguments of the break, tbreak and trace
+// commands: a location optionally followed by -max <hits>, the number
+// of hits after which the breakpoint is cleared, -thread <id>, the only
+// OS thread to stop at the breakpoint, and if <condition>.
+func setBreakpoint(p *proctl.DebuggedProcess, cmd string, args []string) (*proctl.BreakPoint, error) {
+	usage := fmt.Errorf("usage: %s <location> [-max <hits>] [-thread <id>] [if <condition>]", cmd)
+	if len(args) == 0 {
+		return nil, usage
+	}
+
+	pc, _, err := findLocation(p, args[0])
+	if err != nil {
+		return nil, err
+	}
+	args = args[1:]
+
+	var (
+		max uint64
+		tid int
+	)
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if len(args) < 2 {
+			return nil, usage
+		}
+
+		switch args[0] {
+		case "-max":
+			max, err = strconv.ParseUint(args[1], 10, 64)
+			if err != nil || max == 0 {
+				return nil, fmt.Errorf("invalid number of hits %s", args[1])
+			}
+		case "-thread":
+			tid, err = strconv.Atoi(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid thread id %s", args[1])
+			}
+			if _, ok := p.Threads[tid]; !ok {
+				return nil, fmt.Errorf("no thread with id %d", tid)
+			}
+		default:
+			return nil, usage
 		}
+		args = args[2:]
 	}
 
-	for i := l - 5; i <= l+5; i++ {
-		line, err := buf.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
+	var bp *proctl.BreakPoint
+	switch {
+	case len(args) == 0:
+		bp, err = p.Break(uintptr(pc))
+	case args[0] == "if" && len(args) > 1:
+		bp, err = p.BreakIf(uintptr(pc), strings.Join(args[1:], " "))
+	default:
+		return nil, usage
+	}
+	if err != nil {
+		return nil, err
+	}
+	bp.MaxHits = max
+	bp.ThreadID = tid
+
+	return bp, nil
+}
+
+func listBreakpoints(p *proctl.DebuggedProcess) error {
+	bps := userBreakpoints(p)
+
+	for _, wp := range p.Watchpoints {
+		if wp != nil {
+			fmt.Printf("Watchpoint %d at %#v for %s (%d bytes, hit %d times)\n", wp.ID, wp.Addr, wp.Expr, wp.Size, wp.HitCount)
+		}
+	}
+
+	for _, bp := range bps {
+		f, l, fn := p.PCToLine(bp.Addr)
+
+		name := "?"
+		if fn != nil {
+			name = fn.Name
+		}
+
+		kind := "Breakpoint"
+		switch {
+		case bp.LogMessage != "":
+			kind = "Logpoint"
+		case bp.Tracepoint:
+			kind = "Tracepoint"
+		case bp.OneShot:
+			kind = "Temporary breakpoint"
+		}
+
+		hits := fmt.Sprintf("hit %d times", bp.HitCount)
+		if bp.MaxHits > 0 {
+			hits += fmt.Sprintf(" of %d", bp.MaxHits)
+		}
+
+		fmt.Printf("%s %d at %#v for %s %s:%d (%s)\n", kind, bp.ID, bp.Addr, name, f, l, hits)
+		if bp.LogMessage != "" {
+			fmt.Printf("\tmessage: %q\n", bp.LogMessage)
+		}
+		if bp.Condition != "" {
+			fmt.Printf("\tcondition: %s\n", bp.Condition)
+		}
+		if bp.ThreadID != 0 {
+			fmt.Printf("\tthread: %d\n", bp.ThreadID)
+		}
+	}
+
+	return nil
+}
+
+func watch(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments to watch")
+	}
+
+	wp, err := p.Watch(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Watchpoint %d set at %#v for %s (%d bytes)\n", wp.ID, wp.Addr, wp.Expr, wp.Size)
+
+	return nil
+}
+
+func unwatch(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments to unwatch")
+	}
+
+	for _, wp := range p.Watchpoints {
+		if wp != nil && wp.Expr == args[0] {
+			err := p.ClearWatchpoint(wp)
+			if err != nil {
 				return err
 			}
 
-			if err == io.EOF {
-				break
+			fmt.Printf("Watchpoint %d cleared for %s\n", wp.ID, wp.Expr)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no watchpoint set for %s", args[0])
+}
+
+// Wraps a command resuming execution so that it reports the watchpoints
+// the debugger moved or cleared on its own in the meantime, as when the
+// frame of a watched variable returns.
+func reportWatchpointChanges(cmd cmdfunc) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		err := cmd(p, args...)
+
+		for _, c := range p.WatchpointChanges {
+			if c.Cleared {
+				fmt.Printf("Watchpoint %d for %s cleared, %s\n", c.Watchpoint.ID, c.Watchpoint.Expr, c.Reason)
+			} else {
+				fmt.Printf("Watchpoint %d for %s moved to %#v, %s\n", c.Watchpoint.ID, c.Watchpoint.Expr, c.Watchpoint.Addr, c.Reason)
 			}
 		}
+		p.WatchpointChanges = nil
+
+		return err
+	}
+}
+
+// Returns the breakpoints set by the user, ordered by ID.
+func userBreakpoints(p *proctl.DebuggedProcess) []*proctl.BreakPoint {
+	bps := make([]*proctl.BreakPoint, 0, len(p.BreakPoints))
+	for _, bp := range p.BreakPoints {
+		if bp.ID != 0 {
+			bps = append(bps, bp)
+		}
+	}
+	sort.Sort(byID(bps))
+
+	return bps
+}
+
+type byID []*proctl.BreakPoint
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+func (fc *frameContext) printVar(p *proctl.DebuggedProcess, args ...string) error {
+	format := ""
+	if len(args) > 1 && (args[0] == "-hexdump" || printBases[args[0]] != 0) {
+		format, args = args[0], args[1:]
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("Not enough arguments to print command")
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	if format == "-hexdump" {
+		data, err := p.ExpressionBytesInFrame(strings.Join(args, " "), frame)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(hex.Dump(data))
+		return nil
+	}
+
+	if base := printBases[format]; base != 0 {
+		defer func(base int) { p.IntegerBase = base }(p.IntegerBase)
+		p.IntegerBase = base
+	}
+
+	val, err := p.EvalExpressionInFrame(strings.Join(args, " "), frame)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(val.Formatted())
+	return nil
+}
+
+// Bases integers are printed in by print, selected with a flag
+// preceding the expression, as in "print -x flags".
+var printBases = map[string]int{
+	"-x": 16,
+	"-o": 8,
+	"-b": 2,
+	"-d": 10,
+}
+
+// Prints the type of an expression evaluated in the selected frame.
+func (fc *frameContext) whatis(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: whatis <expr>")
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	typ, err := p.ExpressionTypeInFrame(strings.Join(args, " "), frame)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(typ)
+	return nil
+}
+
+// Sets a variable of the selected frame: set <variable> = <value>.
+func (fc *frameContext) set(p *proctl.DebuggedProcess, args ...string) error {
+	lhs, rhs, ok := splitAssignment(strings.Join(args, " "))
+	if !ok {
+		return fmt.Errorf("usage: set <variable> = <value>")
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	return p.SetVariableInFrame(lhs, rhs, frame)
+}
+
+// Prints the package-level variables whose name matches
+// the regexp given as argument, or all of them without one.
+func packageVars(p *proctl.DebuggedProcess, args ...string) error {
+	var filter *regexp.Regexp
+	if len(args) > 0 {
+		var err error
+		filter, err = regexp.Compile(strings.Join(args, " "))
+		if err != nil {
+			return fmt.Errorf("invalid regexp: %s", err)
+		}
+	}
+
+	vs, err := p.PackageVariables(filter)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vs {
+		fmt.Printf("%s = %s\n", v.Name, v.Formatted())
+	}
+
+	return nil
+}
 
-		if i == l {
-			line = "\033[34m=>\033[0m" + line
+// Lists the types of the program, or those whose name matches
+// a regular expression, as in "types ^main\.".
+func types(p *proctl.DebuggedProcess, args ...string) error {
+	var filter *regexp.Regexp
+	if len(args) > 0 {
+		var err error
+		filter, err = regexp.Compile(strings.Join(args, " "))
+		if err != nil {
+			return fmt.Errorf("invalid regexp: %s", err)
 		}
+	}
 
-		line = "\033[34m" + strconv.Itoa(i) + "\033[0m" + ": " + line
-		context = append(context, line)
+	names, err := p.Types(filter)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println(strings.Join(context, ""))
+	for _, name := range names {
+		fmt.Println(name)
+	}
 
 	return nil
 }
+
+// Calls a function of the process, as in "call fmt.Sprintf("%v", x)",
+// and prints the values it returned.
+func call(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: call <function>(<args>)")
+	}
+
+	vs, err := p.Call(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vs {
+		fmt.Printf("%s = %s\n", v.Name, v.Formatted())
+	}
+
+	return nil
+}
+
+// Splits an assignment such as "s.Field = 42" into the
+// variable assigned to and the value assigned.
+func splitAssignment(s string) (string, string, bool) {
+	i := strings.Index(s, "=")
+	if i < 0 || strings.HasPrefix(s[i+1:], "=") {
+		return "", "", false
+	}
+
+	lhs, rhs := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	return lhs, rhs, lhs != "" && rhs != ""
+}
+
+func printVersion(p *proctl.DebuggedProcess, args ...string) error {
+	fmt.Println(version.String())
+
+	v, err := p.GoVersion()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Target built with", v)
+	return nil
+}
+
+func printcontext(p *proctl.DebuggedProcess) error {
+	regs, err := p.Registers()
+	if err != nil {
+		return err
+	}
+
+	f, l, _ := p.PCToLine(regs.PC())
+
+	fmt.Printf("Stopped at: %s:%d\n", f, l)
+
+	return printSource(f, l, l)
+}
+
+// Like printcontext, but also prints the PC, and copes with
+// stopping in code without Go symbol information.
+func printInstructionContext(p *proctl.DebuggedProcess) error {
+	pc, err := p.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	f, l, fn := p.PCToLine(pc)
+	if fn == nil {
+		fmt.Printf("Stopped at: %#x (no symbol information)\n", pc)
+		return nil
+	}
+
+	fmt.Printf("Stopped at: %#x in %s %s:%d\n", pc, fn.Name, f, l)
+
+	return printSource(f, l, l)
+}
@@ -1,9 +1,15 @@
 package command
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/derekparker/delve/helper"
 	"github.com/derekparker/delve/proctl"
 )
 
@@ -51,3 +57,528 @@ func TestCommandReplayWithoutPreviousCommand(t *testing.T) {
 		t.Error("Null command not returned", err)
 	}
 }
+
+func TestFindCycle(t *testing.T) {
+	pcs := []uint64{1, 2, 3, 2, 3, 2, 3, 4}
+	frames := make([]*proctl.StackFrame, len(pcs))
+	for i, pc := range pcs {
+		frames[i] = &proctl.StackFrame{PC: pc}
+	}
+
+	if period, repeats := findCycle(frames, 0); repeats != 0 {
+		t.Fatalf("Unexpected cycle at 0, period %d repeats %d", period, repeats)
+	}
+
+	period, repeats := findCycle(frames, 1)
+	if period != 2 || repeats != 3 {
+		t.Fatalf("Expected period 2 repeated 3 times, got period %d repeated %d times", period, repeats)
+	}
+}
+
+func TestGroupStacks(t *testing.T) {
+	stack := func(pcs ...uint64) []*proctl.StackFrame {
+		frames := make([]*proctl.StackFrame, len(pcs))
+		for i, pc := range pcs {
+			frames[i] = &proctl.StackFrame{PC: pc}
+		}
+		return frames
+	}
+
+	stacks := map[int][]*proctl.StackFrame{
+		1: stack(1, 2, 3),
+		2: stack(4, 5, 3),
+		3: stack(4, 5, 3),
+		4: stack(4, 5, 3),
+		5: stack(1, 2),
+	}
+
+	groups := groupStacks(stacks)
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 groups got %d", len(groups))
+	}
+
+	if fmt.Sprint(groups[0].ids) != "[2 3 4]" {
+		t.Fatalf("Wrong largest group %v", groups[0].ids)
+	}
+
+	if fmt.Sprint(groups[1].ids) != "[1]" || fmt.Sprint(groups[2].ids) != "[5]" {
+		t.Fatalf("Wrong group order %v %v", groups[1].ids, groups[2].ids)
+	}
+}
+
+func TestSourceResolverGOPATH(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "dlv-gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	local := filepath.Join(gopath, "src", "github.com", "foo", "bar", "bar.go")
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(local, []byte("package bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldgopath := os.Getenv("GOPATH")
+	os.Setenv("GOPATH", gopath)
+	defer os.Setenv("GOPATH", oldgopath)
+
+	sr := &sourceResolver{dirs: make(map[string]string), unresolved: make(map[string]bool)}
+
+	path, err := sr.resolve("/home/ci/go/src/github.com/foo/bar/bar.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path != local {
+		t.Fatalf("Expected %s got %s", local, path)
+	}
+
+	if sr.dirs["/home/ci/go/src/github.com/foo/bar/"] != filepath.Dir(local) {
+		t.Fatal("Resolved directory was not cached")
+	}
+}
+
+func TestParseFormatArgs(t *testing.T) {
+	format, exprs, err := parseFormatArgs(`"got req id=%d from %s\n" req.ID, f(a, "x,y"), s[1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format != "got req id=%d from %s\n" {
+		t.Fatalf("Wrong format %q", format)
+	}
+
+	if fmt.Sprintf("%q", exprs) != `["req.ID" "f(a, \"x,y\")" "s[1]"]` {
+		t.Fatalf("Wrong expressions %q", exprs)
+	}
+
+	for _, bad := range []string{`foo`, `"unterminated`, `"%d" a,`, `"%d" f(a`} {
+		if _, _, err := parseFormatArgs(bad); err == nil {
+			t.Fatalf("Expected error parsing %s", bad)
+		}
+	}
+}
+
+func TestSplitAssignment(t *testing.T) {
+	lhs, rhs, ok := splitAssignment(`s.Name = "a=b"`)
+	if !ok || lhs != "s.Name" || rhs != `"a=b"` {
+		t.Fatalf("splitAssignment() = %q %q %v", lhs, rhs, ok)
+	}
+
+	for _, bad := range []string{"x", "x == 1", "= 1", "x ="} {
+		if _, _, ok := splitAssignment(bad); ok {
+			t.Fatalf("splitAssignment(%q) accepted", bad)
+		}
+	}
+}
+
+func TestMatchSuffix(t *testing.T) {
+	funcs := []string{"main.sleepytime", "github.com/user/pkg.Func", "github.com/user/otherpkg.Func"}
+
+	tests := []struct {
+		loc  string
+		want []string
+	}{
+		{"main.sleepytime", []string{"main.sleepytime"}},
+		{"sleepytime", []string{"main.sleepytime"}},
+		{"user/pkg.Func", []string{"github.com/user/pkg.Func"}},
+		{"pkg.Func", []string{"github.com/user/pkg.Func"}},
+		{"Func", []string{"github.com/user/otherpkg.Func", "github.com/user/pkg.Func"}},
+		{"eepytime", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := matchSuffix(funcs, tt.loc, "/", ".")
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("matchSuffix(%q) = %v, want %v", tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestNearMatches(t *testing.T) {
+	funcs := []string{"main.sleepytime", "main.helloworld", "main.main", "runtime.main"}
+
+	got := nearMatches(funcs, "main.sleepytim")
+	if fmt.Sprint(got) != "[main.sleepytime]" {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+
+	got = nearMatches(funcs, "mian")
+	if fmt.Sprint(got) != "[main.main runtime.main]" {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+
+	got = nearMatches(funcs, "nothinglikeit")
+	if len(got) != 0 {
+		t.Errorf("unexpected suggestions %v", got)
+	}
+}
+
+func TestBreakpointSpec(t *testing.T) {
+	tests := []struct {
+		bp      *proctl.BreakPoint
+		atEntry bool
+		want    string
+	}{
+		{&proctl.BreakPoint{FunctionName: "main.foo", File: "/src/main.go", Line: 10}, true, "break main.foo"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", File: "/src/main.go", Line: 12}, false, "break /src/main.go:12"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", File: "/src/main.go", Line: 12, Condition: "i > 2"}, false, "break /src/main.go:12 if i > 2"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", OneShot: true}, true, "tbreak main.foo"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", Tracepoint: true}, true, "trace main.foo"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", Tracepoint: true, MaxHits: 3, Condition: "x"}, true, "trace main.foo -max 3 if x"},
+		{&proctl.BreakPoint{FunctionName: "main.foo", LogMessage: "id={id}", Condition: "id > 2"}, true, `logpoint main.foo "id={id}" if id > 2`},
+	}
+
+	for _, tt := range tests {
+		spec := breakpointSpec(tt.bp, tt.atEntry)
+		if spec != tt.want {
+			t.Errorf("breakpointSpec() = %q, want %q", spec, tt.want)
+		}
+
+		cmd, args, ok := parseBreakpointSpec(spec)
+		if !ok || cmd+" "+strings.Join(args, " ") != spec {
+			t.Errorf("parseBreakpointSpec(%q) = %q %q %v", spec, cmd, args, ok)
+		}
+	}
+
+	for _, line := range []string{"", "# break main.foo", "print x", "break"} {
+		if _, _, ok := parseBreakpointSpec(line); ok {
+			t.Errorf("parseBreakpointSpec(%q) accepted", line)
+		}
+	}
+}
+
+func TestSplitActions(t *testing.T) {
+	actions := splitActions("print x; bt;continue ; ")
+	if fmt.Sprint(actions) != "[print x bt continue]" {
+		t.Fatalf("unexpected actions %q", actions)
+	}
+
+	if len(splitActions(" ; ")) != 0 {
+		t.Fatal("expected no actions")
+	}
+}
+
+func TestHitActionsRun(t *testing.T) {
+	var ran []string
+	c := &Commands{map[string]cmdfunc{
+		"print": func(p *proctl.DebuggedProcess, args ...string) error {
+			ran = append(ran, "print "+strings.Join(args, " "))
+			return nil
+		},
+		"fail": func(p *proctl.DebuggedProcess, args ...string) error {
+			ran = append(ran, "fail")
+			return fmt.Errorf("failed")
+		},
+	}}
+	ha := &hitActions{commands: c}
+
+	if ha.run(nil, []string{"print x", "fail", "missing", "print y"}) {
+		t.Fatal("commands without continue should not resume")
+	}
+
+	if fmt.Sprint(ran) != "[print x fail print y]" {
+		t.Fatalf("unexpected commands run %q", ran)
+	}
+
+	ran = nil
+	if !ha.run(nil, []string{"print x", "continue", "print y"}) {
+		t.Fatal("continue should resume")
+	}
+
+	if fmt.Sprint(ran) != "[print x]" {
+		t.Fatalf("commands after continue should not run, ran %q", ran)
+	}
+}
+
+func TestHitActionsNext(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		cmds := DebugCommands()
+
+		hits := 0
+		cmds.Register("hit", func(p *proctl.DebuggedProcess, args ...string) error {
+			hits++
+			return nil
+		})
+
+		for _, c := range []string{"break testprog.go:18", "continue", "break main.sleepytime", "on 2 hit"} {
+			fields := strings.Fields(c)
+			if err := cmds.Find(fields[0])(p, fields[1:]...); err != nil {
+				t.Fatalf("%s: %s", c, err)
+			}
+		}
+
+		// Stepping over the call stops at the
+		// breakpoint in the function called.
+		if err := cmds.Find("next")(p); err != nil {
+			t.Fatal("next:", err)
+		}
+
+		if hits != 1 {
+			t.Fatalf("Expected the commands of the breakpoint hit during next to run once, ran %d times", hits)
+		}
+	})
+}
+
+func TestBreakpointMatches(t *testing.T) {
+	bp := &proctl.BreakPoint{FunctionName: "github.com/user/pkg.Func", File: "/src/github.com/user/pkg/file.go"}
+
+	for _, filter := range []string{"file.go", "pkg/file.go", "pkg.Func", "Func", "user/pkg.Func"} {
+		if !breakpointMatches(bp, filter) {
+			t.Errorf("%s did not match", filter)
+		}
+	}
+
+	for _, filter := range []string{"ile.go", "other.go", "unc", "main.Func"} {
+		if breakpointMatches(bp, filter) {
+			t.Errorf("%s matched", filter)
+		}
+	}
+}
+
+func TestRepeatCount(t *testing.T) {
+	n, err := repeatCount(nil)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected a count of 1 without arguments, got %d, %v", n, err)
+	}
+
+	n, err = repeatCount([]string{"5"})
+	if err != nil || n != 5 {
+		t.Fatalf("Expected a count of 5, got %d, %v", n, err)
+	}
+
+	for _, arg := range []string{"0", "-1", "five"} {
+		if _, err := repeatCount([]string{arg}); err == nil {
+			t.Fatalf("Expected an error for count %q", arg)
+		}
+	}
+}
+
+func TestLabelFilter(t *testing.T) {
+	opts, err := parseGoroutinesArgs([]string{"-l", "request_id=42", "-diff", "-l", "path=/a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !opts.diff {
+		t.Fatal("Expected -diff to be set")
+	}
+	filter := opts.labels
+
+	g := &proctl.Goroutine{Labels: map[string]string{"request_id": "42", "path": "/a=b", "user": "x"}}
+	if !hasLabels(g, filter) {
+		t.Fatalf("Expected %v to match %v", g.Labels, filter)
+	}
+
+	g.Labels["request_id"] = "43"
+	if hasLabels(g, filter) {
+		t.Fatalf("Expected %v not to match %v", g.Labels, filter)
+	}
+
+	if s := formatLabels(g.Labels); s != " {path=/a=b, request_id=43, user=x}" {
+		t.Fatalf("Unexpected labels %q", s)
+	}
+
+	for _, args := range [][]string{{"-l"}, {"-l", "novalue"}, {"request_id=42"}} {
+		if _, err := parseGoroutinesArgs(args); err == nil {
+			t.Fatalf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestUndisplay(t *testing.T) {
+	dl := &displayList{next: 3}
+	dl.exprs = []displayExpr{{1, "i"}, {2, "s.n"}, {3, "len(buf)"}}
+
+	err := dl.undisplay(nil, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dl.exprs) != 2 || dl.exprs[0].n != 1 || dl.exprs[1].n != 3 {
+		t.Fatalf("Unexpected display list %v", dl.exprs)
+	}
+
+	for _, args := range [][]string{{}, {"2"}, {"x"}} {
+		if err := dl.undisplay(nil, args...); err == nil {
+			t.Fatalf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestCommandFormat(t *testing.T) {
+	cmds := DebugCommands()
+	cmds.Register("foo", func(p *proctl.DebuggedProcess, args ...string) error {
+		return fmt.Errorf("%s", strings.Join(args, " "))
+	})
+
+	err := cmds.Find("foo/16xb")(nil, "buf")
+	if err == nil || err.Error() != "/16xb buf" {
+		t.Fatalf("Expected the format as first argument, got %v", err)
+	}
+
+	err = cmds.Find("")(nil, "buf")
+	if err == nil || err.Error() != "/16xb buf" {
+		t.Fatalf("Expected the format to be replayed, got %v", err)
+	}
+
+	if err := cmds.Find("bar/16xb")(nil); err == nil || err.Error() != "command not available" {
+		t.Fatalf("Expected an unknown command, got %v", err)
+	}
+}
+
+func TestParseExamineFormat(t *testing.T) {
+	testcases := []struct {
+		in   string
+		want examineFormat
+	}{
+		{"", examineFormat{1, 'x', 4}},
+		{"16xb", examineFormat{16, 'x', 1}},
+		{"4gx", examineFormat{4, 'x', 8}},
+		{"8d", examineFormat{8, 'd', 4}},
+		{"th", examineFormat{1, 't', 2}},
+	}
+
+	for _, tc := range testcases {
+		f, err := parseExamineFormat(tc.in)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.in, err)
+		}
+
+		if f != tc.want {
+			t.Fatalf("%q: expected %v got %v", tc.in, tc.want, f)
+		}
+	}
+
+	for _, in := range []string{"0x", "16q", "4xz"} {
+		if _, err := parseExamineFormat(in); err == nil {
+			t.Fatalf("Expected an error for %q", in)
+		}
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	data := []byte("dlv\x00\xff\xfe\x01\x02abcdefghij!")
+
+	testcases := []struct {
+		f    examineFormat
+		want []string
+	}{
+		{examineFormat{17, 'x', 1}, []string{
+			"0x1000: 0x64 0x6c 0x76 0x00 0xff 0xfe 0x01 0x02 0x61 0x62 0x63 0x64 0x65 0x66 0x67 0x68  |dlv.....abcdefgh|",
+			"0x1010: 0x69                                                                             |i|",
+		}},
+		{examineFormat{2, 'd', 2}, []string{
+			"0x1000:  27748    118" + strings.Repeat(" ", 6*7) + "  |dlv.|",
+		}},
+		{examineFormat{1, 'x', 8}, []string{
+			"0x1000: 0x0201feff00766c64                     |dlv.....|",
+		}},
+	}
+
+	for _, tc := range testcases {
+		lines := formatMemory(0x1000, data[:tc.f.count*tc.f.size], tc.f)
+		if strings.Join(lines, "\n") != strings.Join(tc.want, "\n") {
+			t.Fatalf("%v: expected\n%s\ngot\n%s", tc.f, strings.Join(tc.want, "\n"), strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestFormatInstruction(t *testing.T) {
+	inst := proctl.AsmInstruction{
+		PC:         0x49a140,
+		File:       "/src/main.go",
+		Line:       24,
+		Bytes:      []byte{0xe8, 0xbb, 0xff, 0xff, 0xff},
+		Text:       "CALL main.bump(SB)",
+		Breakpoint: true,
+	}
+
+	if s := formatInstruction(inst, true); s != "=>\tmain.go:24\t0x49a140*\te8bbffffff\tCALL main.bump(SB)" {
+		t.Fatalf("Unexpected instruction %q", s)
+	}
+
+	inst.Breakpoint = false
+	if s := formatInstruction(inst, false); s != "\tmain.go:24\t0x49a140\te8bbffffff\tCALL main.bump(SB)" {
+		t.Fatalf("Unexpected instruction %q", s)
+	}
+}
+
+func TestFormatFPRegisters(t *testing.T) {
+	fp := &proctl.FPRegisters{Fcw: 0x37f, Mxcsr: 0x1f80}
+	// 1.5 in float64, in the lowest lane of xmm0, and 1.0 in
+	// 80-bit extended precision in st0.
+	fp.Xmm[0] = [16]byte{6: 0xf8, 7: 0x3f}
+	fp.St[0] = [10]byte{7: 0x80, 8: 0xff, 9: 0x3f}
+
+	lines := formatFPRegisters(fp)
+	if len(lines) != 4+8+16 {
+		t.Fatalf("Expected %d registers got %d", 4+8+16, len(lines))
+	}
+
+	for i, want := range map[int]string{
+		0:  "fcw\t0x37f",
+		3:  "mxcsr\t0x1f80",
+		4:  "st0\t1",
+		12: "xmm0\t0x00000000000000003ff8000000000000\t[1.5 0]",
+	} {
+		if lines[i] != want {
+			t.Fatalf("Expected %q got %q", want, lines[i])
+		}
+	}
+
+	fp.HasAVX = true
+	if lines := formatFPRegisters(fp); !strings.HasPrefix(lines[12], "ymm0\t0x0000") || !strings.HasSuffix(lines[12], "[1.5 0 0 0]") {
+		t.Fatalf("Unexpected register %q", lines[12])
+	}
+}
+
+func TestParseWriteData(t *testing.T) {
+	testcases := []struct {
+		size int
+		args []string
+		want []byte
+	}{
+		{0, []string{"90", "90"}, []byte{0x90, 0x90}},
+		{0, []string{"c3cc"}, []byte{0xc3, 0xcc}},
+		{1, []string{"255"}, []byte{0xff}},
+		{2, []string{"-2"}, []byte{0xfe, 0xff}},
+		{4, []string{"0x1f80"}, []byte{0x80, 0x1f, 0, 0}},
+		{8, []string{"0xffffffffffffffff"}, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, tc := range testcases {
+		data, err := parseWriteData(tc.size, tc.args)
+		if err != nil {
+			t.Fatalf("%v: %s", tc.args, err)
+		}
+
+		if !bytes.Equal(data, tc.want) {
+			t.Fatalf("%v: expected % x got % x", tc.args, tc.want, data)
+		}
+	}
+
+	for _, tc := range []struct {
+		size int
+		args []string
+	}{
+		{0, []string{"909"}},
+		{0, []string{"zz"}},
+		{1, []string{"256"}},
+		{2, []string{"1", "2"}},
+	} {
+		if _, err := parseWriteData(tc.size, tc.args); err == nil {
+			t.Fatalf("%v: expected an error", tc.args)
+		}
+	}
+}
+
+func TestFormatRegion(t *testing.T) {
+	r := proctl.MemoryRegion{Start: 0x400000, End: 0x49b000, Perms: "r-xp", Path: "/tmp/prog"}
+	if s := formatRegion(r); s != "0x400000-0x49b000\tr-xp\t0x0\t620K\t/tmp/prog" {
+		t.Fatalf("Unexpected region %q", s)
+	}
+}
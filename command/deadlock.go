@@ -0,0 +1,87 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Packages whose frames are skipped when reporting where a goroutine
+// blocked, so that the line of user code which blocked is shown
+// rather than the runtime function which parked the goroutine.
+var blockingPackages = []string{"runtime.", "sync.", "internal/"}
+
+// Reports the goroutines blocked on channels or sync primitives which
+// no goroutine outside of the blocked ones can wake. Goroutines which
+// may wake one another are found by searching their stacks for
+// references to the channel or mutex, and those referenced by a
+// package-level variable may be woken by any goroutine. A reference
+// only held in the heap is missed, so the report can include
+// goroutines which would in fact be woken.
+func deadlock(p *proctl.DebuggedProcess, args ...string) error {
+	blocked, err := p.BlockedGoroutines()
+	if err != nil {
+		return err
+	}
+
+	deadlocked := proctl.Deadlocked(blocked)
+	if len(deadlocked) == 0 {
+		fmt.Printf("No deadlock found among %d blocked goroutines\n", len(blocked))
+		return nil
+	}
+
+	sort.Sort(byGoroutineID(deadlocked))
+
+	fmt.Printf("%d goroutines can not be woken by any running goroutine:\n", len(deadlocked))
+	for _, b := range deadlocked {
+		fmt.Printf("Goroutine %d [%s] %s\n", b.ID, b.State(), blockingLocation(p, b.Goroutine))
+
+		objs := make([]string, len(b.Objects))
+		for i, obj := range b.Objects {
+			objs[i] = fmt.Sprintf("%#x", obj)
+		}
+
+		holders := append([]int(nil), b.Holders...)
+		sort.Ints(holders)
+		fmt.Printf("\twaiting on %s, referenced by goroutines %s\n", strings.Join(objs, ", "), formatIDs(holders))
+	}
+
+	return nil
+}
+
+// Describes the innermost frame of a goroutine outside of the
+// runtime and the sync packages.
+func blockingLocation(p *proctl.DebuggedProcess, g *proctl.Goroutine) string {
+	frames, err := p.StacktraceOf(g, 32)
+	if err != nil {
+		return goroutineLocation(p, g)
+	}
+
+	for _, frame := range frames {
+		if frame.Fn == nil || hasAnyPrefix(frame.Fn.Name, blockingPackages) {
+			continue
+		}
+
+		return fmt.Sprintf("%s at %s:%d", frame.Fn.Name, frame.File, frame.Line)
+	}
+
+	return goroutineLocation(p, g)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type byGoroutineID []*proctl.BlockedGoroutine
+
+func (s byGoroutineID) Len() int           { return len(s) }
+func (s byGoroutineID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byGoroutineID) Less(i, j int) bool { return s[i].ID < s[j].ID }
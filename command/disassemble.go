@@ -0,0 +1,161 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Most bytes disassemble decodes at once.
+const maxDisassemble = 64 << 10
+
+// Disassembles code: disassemble [-s] [<function> | <start> <end>]. The
+// function of the selected frame is disassembled without arguments. Each
+// instruction is printed along with the line it was compiled from, the
+// instruction the frame is executing marked with =>, and instructions a
+// breakpoint is set on with *. With -s the source lines are printed
+// along with the instructions.
+func (fc *frameContext) disassemble(p *proctl.DebuggedProcess, args ...string) error {
+	withSource := len(args) > 0 && args[0] == "-s"
+	if withSource {
+		args = args[1:]
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	if frame == nil {
+		frames, err := p.Stacktrace(1)
+		if err != nil {
+			return err
+		}
+		if len(frames) == 0 {
+			return fmt.Errorf("no frame selected")
+		}
+		frame = frames[0]
+	}
+
+	var start, end uint64
+	switch len(args) {
+	case 0:
+		if frame.Fn == nil {
+			return fmt.Errorf("no function at %#x", frame.PC)
+		}
+		start, end = frame.Fn.Entry, frame.Fn.End
+	case 1:
+		pc, fn, err := findLocation(p, args[0])
+		if err != nil {
+			return err
+		}
+		if fn == nil {
+			_, _, fn = p.PCToLine(pc)
+		}
+		if fn == nil {
+			return fmt.Errorf("no function at %s", args[0])
+		}
+		start, end = fn.Entry, fn.End
+	case 2:
+		start, err = p.EvalAddressInFrame(args[0], frame)
+		if err != nil {
+			return err
+		}
+		end, err = p.EvalAddressInFrame(args[1], frame)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: disassemble [-s] [<function> | <start> <end>]")
+	}
+
+	if end <= start {
+		return fmt.Errorf("end %#x of range is not after its start %#x", end, start)
+	}
+
+	if end-start > maxDisassemble {
+		return fmt.Errorf("can not disassemble more than %d bytes at once", maxDisassemble)
+	}
+
+	insts, err := p.Disassemble(start, end)
+	if err != nil {
+		return err
+	}
+
+	src := &sourceLines{files: make(map[string][]string)}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for i, inst := range insts {
+		if withSource && (i == 0 || inst.File != insts[i-1].File || inst.Line != insts[i-1].Line) {
+			w.Flush()
+			fmt.Printf("%s:%d: %s\n", filepath.Base(inst.File), inst.Line, src.line(inst.File, inst.Line))
+		}
+
+		fmt.Fprintln(w, formatInstruction(inst, inst.PC == frame.PC))
+	}
+
+	return w.Flush()
+}
+
+// Formats an instruction as disassemble prints it, columns separated
+// by tabs, as in "=>\ttestglobals.go:24\t0x49a140*\te8bbffffff\tCALL main.bump(SB)".
+func formatInstruction(inst proctl.AsmInstruction, current bool) string {
+	marker := ""
+	if current {
+		marker = "=>"
+	}
+
+	bp := ""
+	if inst.Breakpoint {
+		bp = "*"
+	}
+
+	return fmt.Sprintf("%s\t%s:%d\t%#x%s\t%x\t%s", marker, filepath.Base(inst.File), inst.Line, inst.PC, bp, inst.Bytes, inst.Text)
+}
+
+// Reads lines of source files, each file once.
+type sourceLines struct {
+	files map[string][]string
+}
+
+// Returns line l of the source file recorded as f, or an empty
+// string if it can not be read.
+func (s *sourceLines) line(f string, l int) string {
+	lines, ok := s.files[f]
+	if !ok {
+		lines = readLines(f)
+		s.files[f] = lines
+	}
+
+	if l < 1 || l > len(lines) {
+		return ""
+	}
+
+	return strings.TrimSpace(lines[l-1])
+}
+
+func readLines(f string) []string {
+	path, err := sources.resolve(f)
+	if err != nil {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines
+}
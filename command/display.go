@@ -0,0 +1,105 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Expressions printed every time the process stops, numbered
+// in the order they were added.
+type displayList struct {
+	exprs []displayExpr
+	next  int
+}
+
+type displayExpr struct {
+	n    int
+	expr string
+}
+
+// Adds an expression to the list and prints it, or prints
+// every expression in the list without one.
+func (dl *displayList) display(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		dl.print(p)
+		return nil
+	}
+
+	expr := strings.Join(args, " ")
+	if _, err := p.EvalExpression(expr); err != nil {
+		return err
+	}
+
+	dl.next++
+	d := displayExpr{n: dl.next, expr: expr}
+	dl.exprs = append(dl.exprs, d)
+	d.print(p)
+
+	return nil
+}
+
+// Removes the expressions with the given numbers from the list.
+func (dl *displayList) undisplay(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: undisplay <n> ...")
+	}
+
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid display number %q", arg)
+		}
+
+		if !dl.remove(n) {
+			return fmt.Errorf("no display expression %d", n)
+		}
+	}
+
+	return nil
+}
+
+func (dl *displayList) remove(n int) bool {
+	for i, d := range dl.exprs {
+		if d.n == n {
+			dl.exprs = append(dl.exprs[:i], dl.exprs[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wraps a command resuming execution so that the expressions
+// in the list are printed once the process stops again.
+func (dl *displayList) stopping(cmd cmdfunc) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		err := cmd(p, args...)
+		if err == nil && !p.ProcessState.Exited() {
+			dl.print(p)
+		}
+
+		return err
+	}
+}
+
+func (dl *displayList) print(p *proctl.DebuggedProcess) {
+	for _, d := range dl.exprs {
+		d.print(p)
+	}
+}
+
+// Prints the value of the expression, or why it could not be
+// evaluated, such as a variable not being in scope where the
+// process stopped.
+func (d displayExpr) print(p *proctl.DebuggedProcess) {
+	v, err := p.EvalExpression(d.expr)
+	if err != nil {
+		fmt.Printf("%d: %s = (%s)\n", d.n, d.expr, err)
+		return
+	}
+
+	fmt.Printf("%d: %s = %s\n", d.n, d.expr, v.Formatted())
+}
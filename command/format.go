@@ -0,0 +1,125 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Prints a formatted string, as in
+//
+//	printf "got req id=%d from %s" req.ID, req.Addr
+//
+// Every argument is an expression evaluated in the current frame.
+func (fc *frameContext) printf(p *proctl.DebuggedProcess, args ...string) error {
+	format, exprs, err := parseFormatArgs(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	out, err := formatExpressions(p, frame, format, exprs)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+
+	fmt.Print(out)
+	return nil
+}
+
+// Renders format, a printf style format string, with the values
+// of the expressions in exprs evaluated in frame, nil for the innermost.
+func formatExpressions(p *proctl.DebuggedProcess, frame *proctl.StackFrame, format string, exprs []string) (string, error) {
+	vals := make([]interface{}, 0, len(exprs))
+	for _, expr := range exprs {
+		v, err := p.EvalExpressionInFrame(expr, frame)
+		if err != nil {
+			return "", err
+		}
+		vals = append(vals, v.GoValue())
+	}
+
+	return fmt.Sprintf(format, vals...), nil
+}
+
+// Splits `"format" expr1, expr2` into the unquoted format string and the
+// list of expressions. A comma after the format string is optional. Commas nested in parentheses, brackets or string
+// literals do not separate expressions.
+func parseFormatArgs(s string) (string, []string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "\"") {
+		return "", nil, fmt.Errorf("expected a quoted format string")
+	}
+
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid format string: %s", err)
+	}
+
+	format, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid format string: %s", err)
+	}
+
+	rest := strings.TrimSpace(s[len(quoted):])
+	if rest == "" {
+		return format, nil, nil
+	}
+
+	exprs, err := splitExpressions(strings.TrimPrefix(rest, ","))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return format, exprs, nil
+}
+
+func splitExpressions(s string) ([]string, error) {
+	var (
+		exprs = make([]string, 0)
+		depth int
+		quote rune
+		start int
+	)
+
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote && s[i-1] != '\\' {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			exprs = append(exprs, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("unbalanced expression list %q", s)
+	}
+
+	exprs = append(exprs, strings.TrimSpace(s[start:]))
+	for _, expr := range exprs {
+		if expr == "" {
+			return nil, fmt.Errorf("empty expression in %q", s)
+		}
+	}
+
+	return exprs, nil
+}
@@ -0,0 +1,337 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// The goroutines which existed when execution was last resumed,
+// that is at the previous stop, so that goroutines -diff can tell
+// which goroutines were created or exited since.
+type goroutineSnapshot struct {
+	goroutines map[int]*proctl.Goroutine
+}
+
+// Wraps a command resuming execution so that the goroutines are
+// recorded before it runs.
+func (gs *goroutineSnapshot) resuming(cmd cmdfunc) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		gs.record(p)
+		return cmd(p, args...)
+	}
+}
+
+func (gs *goroutineSnapshot) record(p *proctl.DebuggedProcess) {
+	goroutines, err := p.Goroutines()
+	if err != nil {
+		gs.goroutines = nil
+		return
+	}
+
+	gs.goroutines = make(map[int]*proctl.Goroutine, len(goroutines))
+	for _, g := range goroutines {
+		gs.goroutines[g.ID] = g
+	}
+}
+
+type goroutinesOptions struct {
+	labels map[string]string
+	diff   bool
+}
+
+// Lists every goroutine with its state, where it is executing and
+// its profiler labels. The goroutine running on the current thread is
+// marked with a *.
+//
+//	goroutines              list every goroutine
+//	goroutines -l key=value list the goroutines labelled key=value,
+//	                        may be repeated to require several labels
+//	goroutines -diff        list the goroutines created, marked +, and
+//	                        exited, marked -, since the previous stop
+func (gs *goroutineSnapshot) list(p *proctl.DebuggedProcess, args ...string) error {
+	opts, err := parseGoroutinesArgs(args)
+	if err != nil {
+		return err
+	}
+
+	goroutines, err := p.Goroutines()
+	if err != nil {
+		return err
+	}
+	goroutines = filterLabels(goroutines, opts.labels)
+
+	if opts.diff {
+		return gs.diff(p, goroutines, opts.labels)
+	}
+
+	var current uint64
+	if g, err := p.CurrentGoroutine(); err == nil {
+		current = g.Addr
+	}
+
+	threads, err := p.ThreadGoroutines()
+	if err != nil {
+		return err
+	}
+
+	onThread := make(map[uint64]int, len(threads))
+	for tid, g := range threads {
+		onThread[g.Addr] = tid
+	}
+
+	fmt.Printf("[%d goroutines]\n", len(goroutines))
+	for _, g := range goroutines {
+		mark := " "
+		if g.Addr == current {
+			mark = "*"
+		}
+
+		printGoroutine(p, mark, g, onThread[g.Addr])
+	}
+
+	return nil
+}
+
+// Prints the goroutines created and exited since the snapshot. The
+// location of an exited goroutine is where it was at the last stop.
+func (gs *goroutineSnapshot) diff(p *proctl.DebuggedProcess, goroutines []*proctl.Goroutine, labels map[string]string) error {
+	if gs.goroutines == nil {
+		return fmt.Errorf("no goroutines were recorded at the previous stop")
+	}
+
+	var created, exited []*proctl.Goroutine
+
+	live := make(map[int]bool, len(goroutines))
+	for _, g := range goroutines {
+		live[g.ID] = true
+		if _, ok := gs.goroutines[g.ID]; !ok {
+			created = append(created, g)
+		}
+	}
+
+	for _, g := range gs.goroutines {
+		if !live[g.ID] && hasLabels(g, labels) {
+			exited = append(exited, g)
+		}
+	}
+	sort.Sort(goroutinesByID(exited))
+
+	fmt.Printf("[%d goroutines created, %d exited since the previous stop]\n", len(created), len(exited))
+	for _, g := range created {
+		printGoroutine(p, "+", g, 0)
+	}
+	for _, g := range exited {
+		printGoroutine(p, "-", g, 0)
+	}
+
+	return nil
+}
+
+// Prints a line describing a goroutine, including the thread it is
+// running on unless tid is zero.
+func printGoroutine(p *proctl.DebuggedProcess, mark string, g *proctl.Goroutine, tid int) {
+	var thread string
+	if tid != 0 {
+		thread = fmt.Sprintf(" (thread %d)", tid)
+	}
+
+	fmt.Printf("%s Goroutine %d [%s] %s%s%s\n", mark, g.ID, g.State(), goroutineLocation(p, g), thread, formatLabels(g.Labels))
+}
+
+func parseGoroutinesArgs(args []string) (*goroutinesOptions, error) {
+	opts := &goroutinesOptions{labels: make(map[string]string)}
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-diff" {
+			opts.diff = true
+			continue
+		}
+
+		if args[i] != "-l" || i+1 == len(args) {
+			return nil, fmt.Errorf("usage: goroutines [-diff] [-l key=value]...")
+		}
+		i++
+
+		kv := strings.SplitN(args[i], "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", args[i])
+		}
+		opts.labels[kv[0]] = kv[1]
+	}
+
+	return opts, nil
+}
+
+func filterLabels(goroutines []*proctl.Goroutine, labels map[string]string) []*proctl.Goroutine {
+	if len(labels) == 0 {
+		return goroutines
+	}
+
+	matching := goroutines[:0]
+	for _, g := range goroutines {
+		if hasLabels(g, labels) {
+			matching = append(matching, g)
+		}
+	}
+
+	return matching
+}
+
+func hasLabels(g *proctl.Goroutine, filter map[string]string) bool {
+	for k, v := range filter {
+		if lv, ok := g.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Formats labels sorted by key, as in " {region=eu, request_id=42}".
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	kvs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+
+	return " {" + strings.Join(kvs, ", ") + "}"
+}
+
+// Describes the function, file and line a goroutine is executing.
+func goroutineLocation(p *proctl.DebuggedProcess, g *proctl.Goroutine) string {
+	pc, err := p.GoroutinePC(g)
+	if err != nil {
+		return err.Error()
+	}
+
+	f, l, fn := p.PCToLine(pc)
+	if fn == nil {
+		return fmt.Sprintf("%#x", pc)
+	}
+
+	return fmt.Sprintf("%s at %s:%d", fn.Name, f, l)
+}
+
+// Selects a goroutine for bt, frame, print and printf to work on,
+// or prints information about one.
+//
+//	goroutine                 print the selected goroutine
+//	goroutine <id>            select goroutine id
+//	goroutine <id> ancestors  print where the goroutine was created,
+//	                          and where each of the goroutines which
+//	                          led to it being created were
+func (fc *frameContext) goroutineCommand(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		gid, err := fc.goroutine(p)
+		if err != nil {
+			return err
+		}
+
+		if gid == 0 {
+			g, err := p.CurrentGoroutine()
+			if err != nil {
+				return err
+			}
+			gid = g.ID
+		}
+
+		return fc.selectGoroutine(p, gid)
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid goroutine id %q", args[0])
+	}
+
+	switch {
+	case len(args) == 1:
+		return fc.selectGoroutine(p, id)
+	case len(args) == 2 && args[1] == "ancestors":
+		return goroutineAncestors(p, id)
+	}
+
+	return fmt.Errorf("usage: goroutine [<id> [ancestors]]")
+}
+
+// Selects the innermost frame of goroutine id. A goroutine running on
+// a thread is selected by making that thread the current thread, so that
+// its registers are used and the execution commands step it. Variables
+// of a goroutine which is not running are read using the state saved
+// when it was last descheduled, and it can not be stepped.
+func (fc *frameContext) selectGoroutine(p *proctl.DebuggedProcess, id int) error {
+	g, err := p.FindGoroutine(id)
+	if err != nil {
+		return err
+	}
+
+	gid := id
+	if th := p.GoroutineThread(g); th != nil {
+		if th != p.CurrentThread {
+			err = p.SwitchThread(th.Id)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Switched to thread %d\n", th.Id)
+		}
+		gid = 0
+	}
+
+	regs, err := p.Registers()
+	if err != nil {
+		return err
+	}
+
+	*fc = frameContext{pc: regs.PC(), sp: regs.Rsp, gid: gid}
+	fmt.Printf("Goroutine %d [%s] %s\n", g.ID, g.State(), goroutineLocation(p, g))
+
+	return fc.selectFrame(p, 0)
+}
+
+func goroutineAncestors(p *proctl.DebuggedProcess, id int) error {
+	g, err := p.FindGoroutine(id)
+	if err != nil {
+		return err
+	}
+
+	ancestors, missing, err := p.GoroutineAncestors(id)
+	if err != nil {
+		return err
+	}
+
+	printCreatedBy(p, g)
+	for _, a := range ancestors {
+		printCreatedBy(p, a)
+	}
+
+	if missing != 0 {
+		fmt.Printf("goroutine %d, which has exited\n", missing)
+	}
+
+	return nil
+}
+
+func printCreatedBy(p *proctl.DebuggedProcess, g *proctl.Goroutine) {
+	// GoPC is a return address, which may belong to the next line.
+	f, l, fn := p.PCToLine(g.GoPC - 1)
+	if g.GoPC == 0 || fn == nil {
+		fmt.Printf("goroutine %d\n", g.ID)
+		return
+	}
+
+	fmt.Printf("goroutine %d created by %s at %s:%d\n", g.ID, fn.Name, f, l)
+}
+
+type goroutinesByID []*proctl.Goroutine
+
+func (s goroutinesByID) Len() int           { return len(s) }
+func (s goroutinesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s goroutinesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
@@ -0,0 +1,229 @@
+package command
+
+import (
+	"debug/gosym"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Maximum number of near matches suggested when a location can not be found.
+const maxSuggestions = 5
+
+// Returns the address of a location given either as file:line or as a
+// function name. Files may be given relative to the current directory or
+// by any suffix of their path, such as foo.go:42 or pkg/foo.go:42, and
+// functions by their full name or by any suffix of it starting at a path
+// element, such as main.sleepytime or pkg/path.Func.
+func findLocation(p *proctl.DebuggedProcess, loc string) (uint64, *gosym.Func, error) {
+	if i := strings.LastIndex(loc, ":"); i >= 0 {
+		l, err := strconv.Atoi(loc[i+1:])
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid line number in %s", loc)
+		}
+
+		f, err := findFile(p, loc[:i])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return p.LineToPC(f, l)
+	}
+
+	if fn := p.LookupFunc(loc); fn != nil {
+		return fn.Entry, fn, nil
+	}
+
+	names := functionNames(p)
+	matches := matchSuffix(names, loc, "/", ".")
+	switch len(matches) {
+	case 0:
+		return 0, nil, notFoundError("function", loc, names)
+	case 1:
+		fn := p.LookupFunc(matches[0])
+		return fn.Entry, fn, nil
+	}
+
+	return 0, nil, fmt.Errorf("%s is ambiguous, it matches %s", loc, strings.Join(matches, ", "))
+}
+
+// Returns the full path, as recorded in the symbol table, of the source
+// file named by f.
+func findFile(p *proctl.DebuggedProcess, f string) (string, error) {
+	files := sourceFiles(p)
+
+	abs, err := filepath.Abs(f)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		if file == abs {
+			return file, nil
+		}
+	}
+
+	matches := matchSuffix(files, filepath.Clean(f), "/")
+	switch len(matches) {
+	case 0:
+		return "", notFoundError("file", f, files)
+	case 1:
+		return matches[0], nil
+	}
+
+	return "", fmt.Errorf("%s is ambiguous, it matches %s", f, strings.Join(matches, ", "))
+}
+
+// Returns the names of all functions in the executable and its plugins.
+func functionNames(p *proctl.DebuggedProcess) []string {
+	names := make([]string, 0, len(p.GoSymTable.Funcs))
+	for _, fn := range p.GoSymTable.Funcs {
+		names = append(names, fn.Name)
+	}
+
+	for _, plugin := range p.Plugins {
+		for _, fn := range plugin.GoSymTable.Funcs {
+			names = append(names, fn.Name)
+		}
+	}
+
+	return names
+}
+
+// Returns the paths of all source files in the executable and its plugins.
+func sourceFiles(p *proctl.DebuggedProcess) []string {
+	files := make([]string, 0, len(p.GoSymTable.Files))
+	for f := range p.GoSymTable.Files {
+		files = append(files, f)
+	}
+
+	for _, plugin := range p.Plugins {
+		for f := range plugin.GoSymTable.Files {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// Returns the candidates which are equal to s or end with s preceded
+// by one of the separators.
+func matchSuffix(candidates []string, s string, separators ...string) []string {
+	matches := make([]string, 0)
+	for _, c := range candidates {
+		if c == s {
+			return []string{c}
+		}
+
+		for _, sep := range separators {
+			if strings.HasSuffix(c, sep+s) {
+				matches = append(matches, c)
+				break
+			}
+		}
+	}
+
+	sort.Strings(matches)
+	return matches
+}
+
+// Builds the error returned for an unknown location, suggesting the
+// candidates which most closely resemble it.
+func notFoundError(kind, loc string, candidates []string) error {
+	suggestions := nearMatches(candidates, loc)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("No %s named %s", kind, loc)
+	}
+
+	return fmt.Errorf("No %s named %s, did you mean %s?", kind, loc, strings.Join(suggestions, ", "))
+}
+
+// Returns up to maxSuggestions candidates whose last element is within
+// a few edits of the last element of s, closest first.
+func nearMatches(candidates []string, s string) []string {
+	want := strings.ToLower(lastElement(s))
+	maxDist := len(want)/3 + 1
+
+	scored := make([]suggestion, 0)
+	for _, c := range candidates {
+		d := editDistance(want, strings.ToLower(lastElement(c)))
+		if d <= maxDist {
+			scored = append(scored, suggestion{c, d})
+		}
+	}
+
+	sort.Sort(byDistance(scored))
+
+	matches := make([]string, 0, maxSuggestions)
+	for i := 0; i < len(scored) && i < maxSuggestions; i++ {
+		matches = append(matches, scored[i].name)
+	}
+
+	return matches
+}
+
+// Returns the part of a function name or path after the last / or .,
+// ignoring the extension of file names.
+func lastElement(s string) string {
+	s = strings.TrimSuffix(s, ".go")
+	if i := strings.LastIndexAny(s, "/."); i >= 0 {
+		return s[i+1:]
+	}
+
+	return s
+}
+
+// Returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min(n int, ns ...int) int {
+	for _, m := range ns {
+		if m < n {
+			n = m
+		}
+	}
+
+	return n
+}
+
+type suggestion struct {
+	name string
+	dist int
+}
+
+type byDistance []suggestion
+
+func (s byDistance) Len() int      { return len(s) }
+func (s byDistance) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byDistance) Less(i, j int) bool {
+	if s[i].dist != s[j].dist {
+		return s[i].dist < s[j].dist
+	}
+
+	return s[i].name < s[j].name
+}
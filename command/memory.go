@@ -0,0 +1,375 @@
+package command
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// How x prints memory: count units of size bytes each, in format,
+// one of x, d, u, o and t, for binary.
+type examineFormat struct {
+	count  int
+	format byte
+	size   int
+}
+
+// Without a format, x prints a single word in hexadecimal.
+var defaultExamineFormat = examineFormat{count: 1, format: 'x', size: 4}
+
+// Most bytes x reads at once.
+const maxExamine = 64 << 10
+
+// Sizes of units, as given in a format.
+var examineSizes = map[byte]int{
+	'b': 1,
+	'h': 2,
+	'w': 4,
+	'g': 8,
+}
+
+// Parses the format of x, as in 16xb: an optional count, followed by
+// a format and a size, in any order, either of which may be omitted.
+func parseExamineFormat(s string) (examineFormat, error) {
+	f := defaultExamineFormat
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	if i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n == 0 {
+			return f, fmt.Errorf("invalid count %s", s[:i])
+		}
+		f.count = n
+	}
+
+	for _, c := range []byte(s[i:]) {
+		if size, ok := examineSizes[c]; ok {
+			f.size = size
+			continue
+		}
+
+		switch c {
+		case 'x', 'd', 'u', 'o', 't':
+			f.format = c
+		default:
+			return f, fmt.Errorf("invalid format %q, expected one of x, d, u, o, t or a size b, h, w, g", c)
+		}
+	}
+
+	return f, nil
+}
+
+// Examines memory: x/<count><format><size> <address>, as in x/16xb &buf
+// or x/4xg $sp. Prints count units of memory, 16 bytes to a line, along
+// with the bytes of each line as text.
+func (fc *frameContext) examine(p *proctl.DebuggedProcess, args ...string) error {
+	f := defaultExamineFormat
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		var err error
+		f, err = parseExamineFormat(args[0][1:])
+		if err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: x/<count><format><size> <address>")
+	}
+
+	if f.count*f.size > maxExamine {
+		return fmt.Errorf("can not examine more than %d bytes at once", maxExamine)
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	addr, err := p.EvalAddressInFrame(strings.Join(args, " "), frame)
+	if err != nil {
+		return err
+	}
+
+	data, err := p.ReadMemory(addr, f.count*f.size)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range formatMemory(addr, data, f) {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+// Formats memory read at addr as x prints it, one line per 16 bytes,
+// as in "0xc000010000: 0x64 0x6c 0x76 0x00  |dlv.|".
+func formatMemory(addr uint64, data []byte, f examineFormat) []string {
+	perLine := 16 / f.size
+
+	// Units are padded to the width of the widest value: all bits
+	// set, or only the sign bit set for signed decimal ones.
+	ones, sign := make([]byte, f.size), make([]byte, f.size)
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	sign[f.size-1] = 0x80
+
+	width := len(formatUnit(ones, f))
+	if w := len(formatUnit(sign, f)); w > width {
+		width = w
+	}
+
+	lines := make([]string, 0, len(data)/16+1)
+	for off := 0; off < len(data); off += perLine * f.size {
+		end := off + perLine*f.size
+		if end > len(data) {
+			end = len(data)
+		}
+
+		units := make([]string, 0, perLine)
+		for i := off; i < end; i += f.size {
+			units = append(units, fmt.Sprintf("%*s", width, formatUnit(data[i:i+f.size], f)))
+		}
+		for len(units) < perLine {
+			units = append(units, strings.Repeat(" ", width))
+		}
+
+		lines = append(lines, fmt.Sprintf("%#x: %s  |%s|", addr+uint64(off), strings.Join(units, " "), printable(data[off:end])))
+	}
+
+	return lines
+}
+
+// Formats a unit of memory, stored least significant byte first.
+func formatUnit(b []byte, f examineFormat) string {
+	buf := make([]byte, 8)
+	copy(buf, b)
+	n := binary.LittleEndian.Uint64(buf)
+	bits := 8 * len(b)
+
+	switch f.format {
+	case 'd':
+		shift := uint(64 - bits)
+		return strconv.FormatInt(int64(n<<shift)>>shift, 10)
+	case 'u':
+		return strconv.FormatUint(n, 10)
+	case 'o':
+		return fmt.Sprintf("0%0*o", (bits+2)/3, n)
+	case 't':
+		return fmt.Sprintf("%0*b", bits, n)
+	}
+
+	return fmt.Sprintf("0x%0*x", 2*len(b), n)
+}
+
+// Returns bytes as text, with those which are not printable ASCII
+// characters replaced with dots.
+func printable(b []byte) string {
+	s := make([]byte, len(b))
+	for i, c := range b {
+		if c < 0x20 || c > 0x7e {
+			c = '.'
+		}
+		s[i] = c
+	}
+
+	return string(s)
+}
+
+// Writes raw memory: write[/<size>] [-n] <address> <bytes|value>, as in
+// write $pc 90 90 to replace an instruction with nops, or write/w &flags 5.
+// Without a size, bytes are given in hexadecimal, as in 9090 or 90 90;
+// with a size, b, h, w or g, an integer is written in that many bytes.
+// With -n nothing is written, the bytes that would be are printed.
+func (fc *frameContext) write(p *proctl.DebuggedProcess, args ...string) error {
+	size := 0
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		if len(args[0]) == 2 {
+			size = examineSizes[args[0][1]]
+		}
+		if size == 0 {
+			return fmt.Errorf("invalid size %q, expected one of b, h, w or g", args[0][1:])
+		}
+		args = args[1:]
+	}
+
+	dryRun := len(args) > 0 && args[0] == "-n"
+	if dryRun {
+		args = args[1:]
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: write[/<size>] [-n] <address> <bytes|value>")
+	}
+
+	data, err := parseWriteData(size, args[1:])
+	if err != nil {
+		return err
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	addr, err := p.EvalAddressInFrame(args[0], frame)
+	if err != nil {
+		return err
+	}
+
+	old, err := p.ReadMemory(addr, len(data))
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		if err := p.WriteMemory(addr, data); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%#x: % x -> % x\n", addr, old, data)
+	return nil
+}
+
+// Parses the data write is given: bytes in hexadecimal when size is 0,
+// or else a single integer, which is stored in size bytes, least
+// significant first.
+func parseWriteData(size int, args []string) ([]byte, error) {
+	if size == 0 {
+		data, err := hex.DecodeString(strings.Join(args, ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes %q, expected pairs of hexadecimal digits", strings.Join(args, " "))
+		}
+		return data, nil
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected a single value of %d bytes", size)
+	}
+
+	bits := 8 * size
+	var n uint64
+	if i, err := strconv.ParseInt(args[0], 0, bits); err == nil {
+		n = uint64(i)
+	} else if n, err = strconv.ParseUint(args[0], 0, bits); err != nil {
+		return nil, fmt.Errorf("invalid value %s for %d bytes", args[0], size)
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n)
+	return buf[:size], nil
+}
+
+// Lists the regions of memory mapped into the process, with their
+// permissions and the files mapped.
+func memoryMap(p *proctl.DebuggedProcess, args ...string) error {
+	regions, err := p.MemoryMap()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range regions {
+		fmt.Fprintln(w, formatRegion(r))
+	}
+
+	return w.Flush()
+}
+
+// Formats a mapped region as maps prints it, columns separated by tabs,
+// as in "0x400000-0x49b000\tr-xp\t0x0\t620K\t/tmp/prog". Anonymous
+// regions have no path.
+func formatRegion(r proctl.MemoryRegion) string {
+	return fmt.Sprintf("%#x-%#x\t%s\t%#x\t%dK\t%s", r.Start, r.End, r.Perms, r.Offset, (r.End-r.Start)>>10, r.Path)
+}
+
+// Most matches search prints.
+const maxSearchResults = 100
+
+// Searches memory: search[/<size>] <start> <end> <bytes|"string"|value>,
+// as in search 0xc000000000 0xc000400000 "hello" or search/g $sp $sp+4096
+// 0xdeadbeef. The pattern is given as write takes data, or as a quoted
+// string. Every address it is found at is printed, along with the
+// memory that address is in.
+func (fc *frameContext) search(p *proctl.DebuggedProcess, args ...string) error {
+	size := 0
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		if len(args[0]) == 2 {
+			size = examineSizes[args[0][1]]
+		}
+		if size == 0 {
+			return fmt.Errorf("invalid size %q, expected one of b, h, w or g", args[0][1:])
+		}
+		args = args[1:]
+	}
+
+	if len(args) < 3 {
+		return fmt.Errorf("usage: search[/<size>] <start> <end> <bytes|\"string\"|value>")
+	}
+
+	var pattern []byte
+	if rest := strings.Join(args[2:], " "); size == 0 && strings.HasPrefix(rest, `"`) {
+		s, err := strconv.Unquote(rest)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", rest)
+		}
+		pattern = []byte(s)
+	} else {
+		var err error
+		if pattern, err = parseWriteData(size, args[2:]); err != nil {
+			return err
+		}
+	}
+
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	start, err := p.EvalAddressInFrame(args[0], frame)
+	if err != nil {
+		return err
+	}
+
+	end, err := p.EvalAddressInFrame(args[1], frame)
+	if err != nil {
+		return err
+	}
+
+	found, err := p.SearchMemory(start, end, pattern, maxSearchResults+1)
+	if err != nil {
+		return err
+	}
+
+	for i, addr := range found {
+		if i == maxSearchResults {
+			fmt.Printf("(more than %d matches, search a smaller range)\n", maxSearchResults)
+			break
+		}
+
+		if desc := p.DescribeAddress(addr); desc != "" {
+			fmt.Printf("%#x <%s>\n", addr, desc)
+			continue
+		}
+		fmt.Printf("%#x\n", addr)
+	}
+
+	if len(found) == 0 {
+		fmt.Println("Pattern not found")
+	}
+
+	return nil
+}
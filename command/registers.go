@@ -0,0 +1,111 @@
+package command
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Prints the registers of the current thread: regs [-a]. With -a the
+// floating point and vector registers are printed as well.
+func regs(p *proctl.DebuggedProcess, args ...string) error {
+	all := len(args) > 0 && args[0] == "-a"
+	if len(args) > 1 || len(args) == 1 && !all {
+		return fmt.Errorf("usage: regs [-a]")
+	}
+
+	regs, err := p.Registers()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, r := range generalRegisters(regs) {
+		fmt.Fprintf(w, "%s\t%#016x\n", r.name, r.value)
+	}
+
+	if all {
+		fp, err := p.FPRegisters()
+		if err != nil {
+			return err
+		}
+
+		for _, line := range formatFPRegisters(fp) {
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	return w.Flush()
+}
+
+type register struct {
+	name  string
+	value uint64
+}
+
+func generalRegisters(regs *syscall.PtraceRegs) []register {
+	return []register{
+		{"rip", regs.Rip}, {"rsp", regs.Rsp}, {"rax", regs.Rax}, {"rbx", regs.Rbx},
+		{"rcx", regs.Rcx}, {"rdx", regs.Rdx}, {"rdi", regs.Rdi}, {"rsi", regs.Rsi},
+		{"rbp", regs.Rbp}, {"r8", regs.R8}, {"r9", regs.R9}, {"r10", regs.R10},
+		{"r11", regs.R11}, {"r12", regs.R12}, {"r13", regs.R13}, {"r14", regs.R14},
+		{"r15", regs.R15}, {"eflags", regs.Eflags}, {"cs", regs.Cs}, {"ss", regs.Ss},
+		{"fs_base", regs.Fs_base}, {"gs_base", regs.Gs_base},
+	}
+}
+
+// Formats floating point and vector registers as regs -a prints them,
+// columns separated by tabs. The x87 registers are given as numbers, the
+// SSE and AVX ones in hexadecimal, most significant byte first, along
+// with their contents read as float64 values, lowest first.
+func formatFPRegisters(fp *proctl.FPRegisters) []string {
+	lines := []string{
+		fmt.Sprintf("fcw\t%#x", fp.Fcw),
+		fmt.Sprintf("fsw\t%#x", fp.Fsw),
+		fmt.Sprintf("ftw\t%#x", fp.Ftw),
+		fmt.Sprintf("mxcsr\t%#x", fp.Mxcsr),
+	}
+
+	for i := range fp.St {
+		lines = append(lines, fmt.Sprintf("st%d\t%g", i, fp.StFloat(i)))
+	}
+
+	for i, xmm := range fp.Xmm {
+		b := xmm[:]
+		name := "xmm"
+		if fp.HasAVX {
+			b = append(b, fp.Ymmh[i][:]...)
+			name = "ymm"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%d\t%s\t%s", name, i, vectorHex(b), vectorFloats(b)))
+	}
+
+	return lines
+}
+
+// Formats the bytes of a vector register, stored least significant
+// first, as a single hexadecimal number.
+func vectorHex(b []byte) string {
+	s := "0x"
+	for i := len(b) - 1; i >= 0; i-- {
+		s += fmt.Sprintf("%02x", b[i])
+	}
+
+	return s
+}
+
+// Formats a vector register read as float64 values, as in "[1.5 0]".
+func vectorFloats(b []byte) string {
+	fs := make([]float64, len(b)/8)
+	for i := range fs {
+		fs[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+
+	return fmt.Sprint(fs)
+}
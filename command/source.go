@@ -0,0 +1,191 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Number of lines printed either side of the current line.
+const contextLines = 5
+
+// Maps the source file paths recorded in the line table at compile time
+// onto files on this machine. Binaries built on another machine, in CI
+// or in a container, usually record paths which do not exist locally.
+type sourceResolver struct {
+	// Compile time directories mapped to the local directory
+	// found to contain the same files.
+	dirs map[string]string
+	// Directories we have failed to find, so we only warn once.
+	unresolved map[string]bool
+}
+
+var sources = &sourceResolver{
+	dirs:       make(map[string]string),
+	unresolved: make(map[string]bool),
+}
+
+// Returns the local path of the source file recorded as path.
+func (sr *sourceResolver) resolve(path string) (string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	dir, base := filepath.Split(path)
+	if local, ok := sr.dirs[dir]; ok {
+		return filepath.Join(local, base), nil
+	}
+
+	for _, candidate := range sourceCandidates(path) {
+		if _, err := os.Stat(candidate); err == nil {
+			sr.dirs[dir] = filepath.Dir(candidate)
+			return candidate, nil
+		}
+	}
+
+	if !sr.unresolved[dir] {
+		sr.unresolved[dir] = true
+		fmt.Fprintf(os.Stderr, "Could not find sources for %s locally, searched GOROOT, GOPATH, the module cache and the current directory\n", dir)
+	}
+
+	return "", fmt.Errorf("could not find source file %s", path)
+}
+
+// Returns the local paths which may contain the file recorded as path,
+// in the order they should be tried.
+func sourceCandidates(path string) []string {
+	candidates := make([]string, 0)
+
+	// Module cache paths, such as /root/go/pkg/mod/github.com/foo/bar@v1.0.0/bar.go.
+	if i := strings.LastIndex(path, "/pkg/mod/"); i >= 0 {
+		rel := path[i+len("/pkg/mod/"):]
+		for _, cache := range moduleCaches() {
+			candidates = append(candidates, filepath.Join(cache, rel))
+		}
+	}
+
+	// GOROOT and GOPATH paths, such as /usr/local/go/src/runtime/proc.go.
+	if i := strings.LastIndex(path, "/src/"); i >= 0 {
+		rel := path[i+len("/src/"):]
+		candidates = append(candidates, filepath.Join(runtime.GOROOT(), "src", rel))
+		for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+			candidates = append(candidates, filepath.Join(gopath, "src", rel))
+		}
+	}
+
+	// A checkout of the same project at a different location,
+	// try ever shorter suffixes of path relative to the current
+	// directory.
+	if wd, err := os.Getwd(); err == nil {
+		parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		for i := 1; i < len(parts); i++ {
+			candidates = append(candidates, filepath.Join(wd, filepath.Join(parts[i:]...)))
+		}
+	}
+
+	return candidates
+}
+
+func moduleCaches() []string {
+	if cache := os.Getenv("GOMODCACHE"); cache != "" {
+		return []string{cache}
+	}
+
+	caches := make([]string, 0)
+	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+		caches = append(caches, filepath.Join(gopath, "pkg", "mod"))
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		caches = append(caches, filepath.Join(home, "go", "pkg", "mod"))
+	}
+
+	return caches
+}
+
+// Prints the source code around the current location,
+// or around the given function or file:line.
+func list(p *proctl.DebuggedProcess, args ...string) error {
+	var pc uint64
+
+	if len(args) == 0 {
+		regs, err := p.Registers()
+		if err != nil {
+			return err
+		}
+		pc = regs.PC()
+	} else {
+		var err error
+		pc, _, err = findLocation(p, args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	f, l, _ := p.PCToLine(pc)
+	if len(args) == 0 {
+		return printSource(f, l, l)
+	}
+
+	return printSource(f, l, -1)
+}
+
+// Prints the lines of the source file recorded as f around line l,
+// marking the line current.
+func printSource(f string, l, current int) error {
+	var context []string
+
+	path, err := sources.resolve(f)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := bufio.NewReader(file)
+	for i := 1; i < l-contextLines; i++ {
+		_, err := buf.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	for i := l - contextLines; i <= l+contextLines; i++ {
+		if i < 1 {
+			continue
+		}
+
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+
+			if err == io.EOF {
+				break
+			}
+		}
+
+		if i == current {
+			line = "\033[34m=>\033[0m" + line
+		}
+
+		line = "\033[34m" + strconv.Itoa(i) + "\033[0m" + ": " + line
+		context = append(context, line)
+	}
+
+	fmt.Println(strings.Join(context, ""))
+
+	return nil
+}
@@ -0,0 +1,612 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Limits on the amount of information printed by the bt command.
+const (
+	defaultStackDepth = 50
+	maxFrameLocals    = 32
+	maxValueLength    = 120
+	maxArgLength      = 40
+	maxCyclePeriod    = 8
+	minCycleRepeats   = 3
+)
+
+// Remembers where the last backtrace stopped so that it
+// can be continued with bt -cont.
+type backtraceContext struct {
+	pc, sp uint64
+	gid    int
+	depth  int
+	next   int
+	opts   frameOptions
+
+	// Selects the goroutine whose stack is printed.
+	fc *frameContext
+}
+
+// Controls what is printed for every frame of a backtrace.
+type frameOptions struct {
+	locals bool
+	defers bool
+	// Frames of functions matching filter are hidden.
+	filter *regexp.Regexp
+}
+
+// Prints a backtrace of the current thread.
+//
+//	bt [depth] [-full] [-defer]  print at most depth frames, with -full
+//	                             the local variables of each frame are
+//	                             printed too, with -defer the deferred
+//	                             calls registered by each frame
+//	bt -depth <depth>            same as bt depth
+//	bt -filter <regexp>          hide frames of functions matching
+//	                             regexp, such as ^runtime\.
+//	bt -cont                     print the next frames of a truncated
+//	                             backtrace
+func (bc *backtraceContext) backtrace(p *proctl.DebuggedProcess, args ...string) error {
+	var (
+		depth = defaultStackDepth
+		opts  frameOptions
+		cont  bool
+	)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-full":
+			opts.locals = true
+		case "-defer":
+			opts.defers = true
+		case "-cont":
+			cont = true
+		case "-depth", "-filter":
+			if i+1 == len(args) {
+				return fmt.Errorf("%s requires an argument", arg)
+			}
+			i++
+
+			if arg == "-filter" {
+				re, err := regexp.Compile(args[i])
+				if err != nil {
+					return fmt.Errorf("invalid filter: %s", err)
+				}
+				opts.filter = re
+				continue
+			}
+
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid depth %q", args[i])
+			}
+			depth = n
+		default:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("unknown argument to bt: %s", arg)
+			}
+			depth = n
+		}
+	}
+
+	regs, err := p.Registers()
+	if err != nil {
+		return err
+	}
+
+	gid, err := bc.fc.goroutine(p)
+	if err != nil {
+		return err
+	}
+
+	skip := 0
+	if cont {
+		if bc.next == 0 || bc.pc != regs.PC() || bc.sp != regs.Rsp || bc.gid != gid {
+			return fmt.Errorf("no truncated backtrace to continue")
+		}
+		skip, depth, opts = bc.next, bc.depth, bc.opts
+	}
+
+	// Unwind one frame further than needed to
+	// find out whether the trace is truncated.
+	frames, err := bc.fc.stacktrace(p, skip+depth+1)
+	if err != nil {
+		return err
+	}
+
+	*bc = backtraceContext{fc: bc.fc}
+	if len(frames) > skip+depth {
+		frames = frames[:skip+depth]
+		*bc = backtraceContext{pc: regs.PC(), sp: regs.Rsp, gid: gid, depth: depth, next: skip + depth, opts: opts, fc: bc.fc}
+	}
+
+	if skip >= len(frames) {
+		return nil
+	}
+
+	printFrames(p, frames, skip, opts)
+
+	if bc.next != 0 {
+		fmt.Println("(more frames follow, type bt -cont to see them)")
+	}
+
+	return nil
+}
+
+// Remembers the goroutine selected with goroutine and the frame selected
+// with frame, up and down, which bt, print and printf work on. Selecting
+// a goroutine running on a thread switches to that thread instead. The
+// selection only holds while the thread stays stopped where it was
+// made, once execution resumes the innermost frame of the goroutine
+// running on the current thread is selected again.
+type frameContext struct {
+	pc, sp uint64
+	n      int
+	// Zero for the goroutine running on the current thread, otherwise
+	// a goroutine which is not running on any thread.
+	gid int
+}
+
+// Returns the selected frame, or nil if it is the innermost
+// frame of the current thread.
+func (fc *frameContext) selected(p *proctl.DebuggedProcess) (*proctl.StackFrame, error) {
+	n, err := fc.index(p)
+	if err != nil || (n == 0 && fc.gid == 0) {
+		return nil, err
+	}
+
+	frames, err := fc.stacktrace(p, n+1)
+	if err != nil {
+		return nil, err
+	}
+
+	if n >= len(frames) {
+		*fc = frameContext{}
+		return nil, nil
+	}
+
+	return frames[n], nil
+}
+
+// Returns the number of the selected frame, counting from the innermost.
+func (fc *frameContext) index(p *proctl.DebuggedProcess) (int, error) {
+	regs, err := p.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	if fc.pc != regs.PC() || fc.sp != regs.Rsp {
+		*fc = frameContext{}
+	}
+
+	return fc.n, nil
+}
+
+// Returns the ID of the selected goroutine, zero
+// for the one running on the current thread.
+func (fc *frameContext) goroutine(p *proctl.DebuggedProcess) (int, error) {
+	_, err := fc.index(p)
+	return fc.gid, err
+}
+
+// Wraps a command stepping the current thread so that it is refused
+// while a goroutine which is not running on any thread is selected,
+// rather than stepping another goroutine than the one shown.
+func (fc *frameContext) stepping(cmd cmdfunc) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		gid, err := fc.goroutine(p)
+		if err != nil {
+			return err
+		}
+
+		if gid != 0 {
+			return fmt.Errorf("goroutine %d is not running on a thread and can not be stepped, select the current thread again with thread", gid)
+		}
+
+		return cmd(p, args...)
+	}
+}
+
+// Returns the stack of the selected goroutine.
+func (fc *frameContext) stacktrace(p *proctl.DebuggedProcess, depth int) ([]*proctl.StackFrame, error) {
+	gid, err := fc.goroutine(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if gid != 0 {
+		return p.GoroutineStacktrace(gid, depth)
+	}
+
+	return p.Stacktrace(depth)
+}
+
+// Selects frame n and prints it.
+func (fc *frameContext) selectFrame(p *proctl.DebuggedProcess, n int) error {
+	if n < 0 {
+		return fmt.Errorf("already at the innermost frame")
+	}
+
+	regs, err := p.Registers()
+	if err != nil {
+		return err
+	}
+
+	frames, err := fc.stacktrace(p, n+1)
+	if err != nil {
+		return err
+	}
+
+	if n >= len(frames) {
+		return fmt.Errorf("no frame %d, the stack has %d frames", n, len(frames))
+	}
+
+	*fc = frameContext{pc: regs.PC(), sp: regs.Rsp, n: n, gid: fc.gid}
+
+	// The deferred calls are what runs when the frame returns.
+	frame := frames[n]
+	printFrame(p, n, frame, frameOptions{defers: true})
+
+	return printSource(frame.File, frame.Line, frame.Line)
+}
+
+// Selects the frame with the given number, or
+// prints the selected frame without one.
+func (fc *frameContext) frame(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		n, err := fc.index(p)
+		if err != nil {
+			return err
+		}
+		return fc.selectFrame(p, n)
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid frame %q", args[0])
+	}
+
+	return fc.selectFrame(p, n)
+}
+
+// Selects the caller of the selected frame, or the
+// frame the given number of callers up the stack.
+func (fc *frameContext) up(p *proctl.DebuggedProcess, args ...string) error {
+	return fc.move(p, 1, args)
+}
+
+// Selects the frame called by the selected frame, or
+// the frame the given number of calls down the stack.
+func (fc *frameContext) down(p *proctl.DebuggedProcess, args ...string) error {
+	return fc.move(p, -1, args)
+}
+
+func (fc *frameContext) move(p *proctl.DebuggedProcess, dir int, args []string) error {
+	count, err := repeatCount(args)
+	if err != nil {
+		return err
+	}
+
+	n, err := fc.index(p)
+	if err != nil {
+		return err
+	}
+
+	return fc.selectFrame(p, n+dir*count)
+}
+
+// Prints frames[start:], collapsing sequences of
+// frames which repeat, as happens with deep recursion.
+func printFrames(p *proctl.DebuggedProcess, frames []*proctl.StackFrame, start int, opts frameOptions) {
+	hidden := 0
+	for i := start; i < len(frames); i++ {
+		if opts.filter != nil && opts.filter.MatchString(frames[i].Fn.Name) {
+			hidden++
+			continue
+		}
+
+		if hidden > 0 {
+			fmt.Printf("\t... %d frames hidden\n", hidden)
+			hidden = 0
+		}
+
+		period, repeats := findCycle(frames, i)
+		if repeats >= minCycleRepeats {
+			for j := i; j < i+period; j++ {
+				printFrame(p, j, frames[j], opts)
+			}
+
+			skipped := period * (repeats - 1)
+			fmt.Printf("\t... %d frames omitted (previous %d repeated %d more times)\n", skipped, period, repeats-1)
+			i += period + skipped - 1
+			continue
+		}
+
+		printFrame(p, i, frames[i], opts)
+	}
+
+	if hidden > 0 {
+		fmt.Printf("\t... %d frames hidden\n", hidden)
+	}
+}
+
+func printFrame(p *proctl.DebuggedProcess, i int, frame *proctl.StackFrame, opts frameOptions) {
+	fmt.Printf("#%d %#x in %s(%s)\n\tat %s:%d\n", i, frame.PC, frame.Fn.Name, frameArgs(p, frame), frame.File, frame.Line)
+	if opts.defers {
+		printFrameDefers(p, frame)
+	}
+	if opts.locals {
+		printFrameLocals(p, frame)
+	}
+}
+
+func printFrameDefers(p *proctl.DebuggedProcess, frame *proctl.StackFrame) {
+	defers, err := p.FrameDefers(frame)
+	if err != nil {
+		fmt.Printf("\t\t(could not read deferred calls: %s)\n", err)
+		return
+	}
+
+	for _, d := range defers {
+		name := "?"
+		if d.Fn != nil {
+			name = d.Fn.Name
+		}
+		fmt.Printf("\t\tdefer %s\n", name)
+	}
+}
+
+// Looks for a sequence of frames starting at i which is immediately
+// repeated. Returns the length of the sequence and the number of
+// times it occurs in a row, including the first occurrence.
+func findCycle(frames []*proctl.StackFrame, i int) (period, repeats int) {
+	for period = 1; period <= maxCyclePeriod; period++ {
+		repeats = 1
+		for i+period*(repeats+1) <= len(frames) && sameFrames(frames[i:i+period], frames[i+period*repeats:i+period*(repeats+1)]) {
+			repeats++
+		}
+
+		if repeats >= minCycleRepeats {
+			return period, repeats
+		}
+	}
+
+	return 0, 0
+}
+
+func sameFrames(a, b []*proctl.StackFrame) bool {
+	for i := range a {
+		if a[i].PC != b[i].PC {
+			return false
+		}
+	}
+
+	return true
+}
+
+func printFrameLocals(p *proctl.DebuggedProcess, frame *proctl.StackFrame) {
+	vars, err := p.LocalVariables(frame)
+	if err != nil {
+		fmt.Printf("\t\t(could not read locals: %s)\n", err)
+		return
+	}
+
+	for i, v := range vars {
+		if i == maxFrameLocals {
+			fmt.Printf("\t\t(%d more)\n", len(vars)-maxFrameLocals)
+			break
+		}
+
+		fmt.Printf("\t\t%s = %s\n", v.Name, truncate(v.Formatted(), maxValueLength))
+	}
+}
+
+// Prints the local variables of the selected frame with their values.
+func (fc *frameContext) locals(p *proctl.DebuggedProcess, args ...string) error {
+	return fc.printVariables(p, "locals", p.LocalVariables)
+}
+
+// Prints the arguments of the function executing
+// in the selected frame with their values.
+func (fc *frameContext) args(p *proctl.DebuggedProcess, args ...string) error {
+	return fc.printVariables(p, "arguments", p.FunctionArguments)
+}
+
+func (fc *frameContext) printVariables(p *proctl.DebuggedProcess, what string, read func(*proctl.StackFrame) ([]*proctl.Variable, error)) error {
+	frame, err := fc.selected(p)
+	if err != nil {
+		return err
+	}
+
+	if frame == nil {
+		frames, err := fc.stacktrace(p, 1)
+		if err != nil {
+			return err
+		}
+
+		if len(frames) == 0 {
+			return fmt.Errorf("could not find the current frame")
+		}
+		frame = frames[0]
+	}
+
+	vars, err := read(frame)
+	if err != nil {
+		return err
+	}
+
+	if len(vars) == 0 {
+		fmt.Printf("(no %s)\n", what)
+		return nil
+	}
+
+	for _, v := range vars {
+		fmt.Printf("%s = %s\n", v.Name, v.Formatted())
+	}
+
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n] + "..."
+}
+
+// Formats the arguments of the function executing in frame. Values are
+// truncated, a single large struct argument would otherwise bury the
+// file and line of the frame.
+func frameArgs(p *proctl.DebuggedProcess, frame *proctl.StackFrame) string {
+	vars, err := p.FunctionArguments(frame)
+	if err != nil {
+		return "..."
+	}
+
+	args := make([]string, 0, len(vars))
+	for _, v := range vars {
+		args = append(args, v.Name+"="+truncate(v.Formatted(), maxArgLength))
+	}
+
+	return strings.Join(args, ", ")
+}
+
+// A set of goroutines whose stacks are identical.
+type stackGroup struct {
+	ids    []int
+	frames []*proctl.StackFrame
+}
+
+// Prints the stack of every goroutine. Goroutines with identical
+// stacks are printed once, along with how many share that stack.
+//
+//	dump-stacks       group goroutines with identical stacks
+//	dump-stacks -all  print every goroutine on its own, with its
+//	                  state and the arguments of every frame, as
+//	                  in the dump printed on SIGQUIT
+func dumpStacks(p *proctl.DebuggedProcess, args ...string) error {
+	goroutines, err := p.Goroutines()
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		if args[0] != "-all" {
+			return fmt.Errorf("unknown argument to dump-stacks: %s", args[0])
+		}
+
+		for _, g := range goroutines {
+			printGoroutineStack(p, g)
+		}
+		return nil
+	}
+
+	stacks := make(map[int][]*proctl.StackFrame)
+	for _, g := range goroutines {
+		frames, err := p.StacktraceOf(g, defaultStackDepth)
+		if err != nil {
+			fmt.Printf("goroutine %d: %s\n", g.ID, err)
+			continue
+		}
+		stacks[g.ID] = frames
+	}
+
+	for _, group := range groupStacks(stacks) {
+		fmt.Printf("%d goroutine(s) %s:\n", len(group.ids), formatIDs(group.ids))
+		for i, frame := range group.frames {
+			fmt.Printf("#%d %#x in %s\n\tat %s:%d\n", i, frame.PC, frame.Fn.Name, frame.File, frame.Line)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func printGoroutineStack(p *proctl.DebuggedProcess, g *proctl.Goroutine) {
+	fmt.Printf("goroutine %d [%s]:\n", g.ID, g.State())
+
+	frames, err := p.StacktraceOf(g, defaultStackDepth)
+	if err != nil {
+		fmt.Printf("\t(could not unwind stack: %s)\n\n", err)
+		return
+	}
+
+	for i, frame := range frames {
+		printFrame(p, i, frame, frameOptions{})
+	}
+	fmt.Println()
+}
+
+// Groups goroutines by stack, comparing the PC of every frame. Groups
+// are ordered by size, largest first, and by lowest goroutine id.
+func groupStacks(stacks map[int][]*proctl.StackFrame) []*stackGroup {
+	var (
+		groups = make([]*stackGroup, 0)
+		bykey  = make(map[string]*stackGroup)
+	)
+
+	for id, frames := range stacks {
+		key := stackKey(frames)
+		group, ok := bykey[key]
+		if !ok {
+			group = &stackGroup{frames: frames}
+			bykey[key] = group
+			groups = append(groups, group)
+		}
+		group.ids = append(group.ids, id)
+	}
+
+	for _, group := range groups {
+		sort.Ints(group.ids)
+	}
+
+	sort.Sort(bySize(groups))
+
+	return groups
+}
+
+func stackKey(frames []*proctl.StackFrame) string {
+	pcs := make([]string, len(frames))
+	for i, frame := range frames {
+		pcs[i] = strconv.FormatUint(frame.PC, 16)
+	}
+
+	return strings.Join(pcs, ",")
+}
+
+// Formats a sorted list of goroutine ids, abbreviating long lists.
+func formatIDs(ids []int) string {
+	const max = 10
+
+	strs := make([]string, 0, max)
+	for i, id := range ids {
+		if i == max {
+			strs = append(strs, "...")
+			break
+		}
+		strs = append(strs, strconv.Itoa(id))
+	}
+
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+type bySize []*stackGroup
+
+func (s bySize) Len() int      { return len(s) }
+func (s bySize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySize) Less(i, j int) bool {
+	if len(s[i].ids) != len(s[j].ids) {
+		return len(s[i].ids) > len(s[j].ids)
+	}
+
+	return s[i].ids[0] < s[j].ids[0]
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+// Lists every thread of the process with where it is executing and
+// the goroutine it is running. The current thread is marked with a *.
+func threads(p *proctl.DebuggedProcess, args ...string) error {
+	goroutines, err := p.ThreadGoroutines()
+	if err != nil {
+		return err
+	}
+
+	tids := make([]int, 0, len(p.Threads))
+	for tid := range p.Threads {
+		tids = append(tids, tid)
+	}
+	sort.Ints(tids)
+
+	fmt.Printf("[%d threads]\n", len(tids))
+	for _, tid := range tids {
+		mark := " "
+		if tid == p.CurrentThread.Id {
+			mark = "*"
+		}
+
+		fmt.Printf("%s Thread %d %s", mark, tid, threadLocation(p, p.Threads[tid]))
+		if g, ok := goroutines[tid]; ok {
+			fmt.Printf(" [goroutine %d]", g.ID)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// Describes the function, file and line a thread is executing.
+func threadLocation(p *proctl.DebuggedProcess, th *proctl.ThreadContext) string {
+	regs, err := th.Registers()
+	if err != nil {
+		return err.Error()
+	}
+
+	f, l, fn := p.PCToLine(regs.PC())
+	if fn == nil {
+		return fmt.Sprintf("at %#x", regs.PC())
+	}
+
+	return fmt.Sprintf("%s at %s:%d", fn.Name, f, l)
+}
+
+// Makes a thread the current thread, so that registers, bt, print and
+// the execution commands work on it, or prints the current thread.
+func (fc *frameContext) thread(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: thread [tid]")
+	}
+
+	if len(args) == 1 {
+		tid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid thread id %q", args[0])
+		}
+
+		err = p.SwitchThread(tid)
+		if err != nil {
+			return err
+		}
+
+		// Frames were selected on the previous thread.
+		*fc = frameContext{}
+	}
+
+	fmt.Printf("Thread %d %s\n", p.CurrentThread.Id, threadLocation(p, p.CurrentThread))
+	return nil
+}
@@ -18,15 +18,23 @@ type parseContext struct {
 	Common  *CommonInformationEntry
 	Frame   *FrameDescriptionEntry
 	Length  uint32
+	Offset  uint64
 }
 
 // Parse takes in data (a byte slice) and returns a slice of
 // CommonInformationEntry structures. Each CommonInformationEntry
 // has a slice of FrameDescriptionEntry structures.
 func Parse(data []byte) *FrameDescriptionEntries {
+	return ParseWithOffset(data, 0)
+}
+
+// ParseWithOffset behaves like Parse, but relocates the address range of
+// every FrameDescriptionEntry by offset. This is used for objects, such as
+// plugins, which are not loaded at their link time address.
+func ParseWithOffset(data []byte, offset uint64) *FrameDescriptionEntries {
 	var (
 		buf  = bytes.NewBuffer(data)
-		pctx = &parseContext{Buf: buf, Entries: NewFrameIndex()}
+		pctx = &parseContext{Buf: buf, Entries: NewFrameIndex(), Offset: offset}
 	)
 
 	for fn := parseLength; buf.Len() != 0; {
@@ -57,7 +65,7 @@ func parseLength(ctx *parseContext) parsefunc {
 func parseFDE(ctx *parseContext) parsefunc {
 	r := ctx.Buf.Next(int(ctx.Length))
 
-	ctx.Frame.begin = binary.LittleEndian.Uint64(r[:8])
+	ctx.Frame.begin = binary.LittleEndian.Uint64(r[:8]) + ctx.Offset
 	ctx.Frame.end = binary.LittleEndian.Uint64(r[8:16])
 
 	// Insert into the tree after setting address range begin
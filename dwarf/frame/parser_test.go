@@ -27,3 +27,26 @@ func BenchmarkParse(b *testing.B) {
 		frame.Parse(data)
 	}
 }
+
+func TestParseWithOffset(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/frame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const offset = 0x7f0000000000
+	var (
+		fdes    = frame.Parse(data)
+		rfdes   = frame.ParseWithOffset(data, offset)
+		fde, _  = fdes.FDEForPC(0x400c00)
+		rfde, _ = rfdes.FDEForPC(0x400c00 + offset)
+	)
+
+	if fde == nil || rfde == nil {
+		t.Fatal("Could not find FDE")
+	}
+
+	if rfde.Begin() != fde.Begin()+offset {
+		t.Fatalf("Expected relocated begin %#v got %#v", fde.Begin()+offset, rfde.Begin())
+	}
+}
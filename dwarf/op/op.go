@@ -2,12 +2,14 @@ package op
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/derekparker/delve/dwarf/util"
 )
 
 const (
+	DW_OP_addr           = 0x03
 	DW_OP_call_frame_cfa = 0x9c
 	DW_OP_plus           = 0x22
 	DW_OP_consts         = 0x11
@@ -16,6 +18,7 @@ const (
 type stackfn func(*bytes.Buffer, []int64, int64) ([]int64, error)
 
 var oplut = map[byte]stackfn{
+	DW_OP_addr:           addr,
 	DW_OP_call_frame_cfa: callframecfa,
 	DW_OP_plus:           plus,
 	DW_OP_consts:         consts,
@@ -40,6 +43,17 @@ func ExecuteStackProgram(cfa int64, instructions []byte) (int64, error) {
 	return stack[len(stack)-1], nil
 }
 
+// Pushes the address which follows the instruction, as used
+// for the location of package-level variables.
+func addr(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
+	b := buf.Next(8)
+	if len(b) != 8 {
+		return nil, fmt.Errorf("truncated DW_OP_addr")
+	}
+
+	return append(stack, int64(binary.LittleEndian.Uint64(b))), nil
+}
+
 func callframecfa(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
 	return append(stack, int64(cfa)), nil
 }
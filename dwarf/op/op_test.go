@@ -16,3 +16,20 @@ func TestExecuteStackProgram(t *testing.T) {
 		t.Fatalf("actual %d != expected %d", actual, expected)
 	}
 }
+
+func TestExecuteStackProgramAddr(t *testing.T) {
+	instructions := []byte{DW_OP_addr, 0x40, 0x30, 0x20, 0x10, 0, 0, 0, 0}
+
+	actual, err := ExecuteStackProgram(0, instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != 0x10203040 {
+		t.Fatalf("actual %#x != expected %#x", actual, 0x10203040)
+	}
+
+	if _, err := ExecuteStackProgram(0, instructions[:5]); err == nil {
+		t.Fatal("expected an error for a truncated address")
+	}
+}
@@ -1,6 +1,8 @@
 package helper
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -24,13 +26,12 @@ func GetRegisters(p *proctl.DebuggedProcess, t *testing.T) *syscall.PtraceRegs {
 
 func WithTestProcess(name string, t *testing.T, fn testfunc) {
 	runtime.LockOSThread()
-	base, err := CompileTestProg(name)
+	path, err := CompileTestProg(name)
 	if err != nil {
 		t.Fatalf("Could not compile %s due to %s", name, err)
 	}
-	defer os.Remove("./" + base)
 
-	cmd, err := startTestProcess(base)
+	cmd, err := startTestProcess(path)
 	if err != nil {
 		t.Fatal("Starting test process:", err)
 	}
@@ -46,12 +47,49 @@ func WithTestProcess(name string, t *testing.T, fn testfunc) {
 	fn(p)
 }
 
+// Compiles the fixture source (a path without the .go extension) with
+// optimizations and inlining disabled, and returns the path of the
+// binary. Binaries are cached in a temporary directory specific to the
+// version of Go, and are only rebuilt when the source has changed.
 func CompileTestProg(source string) (string, error) {
-	base := filepath.Base(source)
-	return base, exec.Command("go", "build", "-gcflags=-N -l", "-o", base, source+".go").Run()
+	dir := filepath.Join(os.TempDir(), "dlv-fixtures-"+runtime.Version())
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		src = source + ".go"
+		bin = filepath.Join(dir, filepath.Base(source))
+	)
+
+	srcinfo, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	if bininfo, err := os.Stat(bin); err == nil && !bininfo.ModTime().Before(srcinfo.ModTime()) {
+		return bin, nil
+	}
+
+	// Build to a temporary file and rename it into place, test binaries
+	// of different packages may be compiling the same fixture at once.
+	tmp, err := ioutil.TempFile(dir, filepath.Base(source)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	out, err := exec.Command("go", "build", "-gcflags=-N -l", "-o", tmp.Name(), src).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s", err, out)
+	}
+
+	return bin, os.Rename(tmp.Name(), bin)
 }
 
-func startTestProcess(name string) (*exec.Cmd, error) {
-	cmd := exec.Command("./" + name)
+func startTestProcess(path string) (*exec.Cmd, error) {
+	cmd := exec.Command(path)
 	return cmd, cmd.Start()
 }
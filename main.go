@@ -1,24 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
-
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"syscall"
 
 	"github.com/derekparker/delve/command"
 	"github.com/derekparker/delve/goreadline"
 	"github.com/derekparker/delve/proctl"
+	"github.com/derekparker/delve/version"
 )
 
-type term struct {
-	stdin *bufio.Reader
-}
+type term struct{}
 
 const historyFile string = ".dbg_history"
 
@@ -30,33 +28,95 @@ func main() {
 
 	var (
 		pid     int
+		name    string
+		waitfor bool
 		proc    string
 		run     bool
+		printv  bool
+		kill    bool
+		detach  bool
+		onpanic bool
+		calls   bool
+		follow  bool
+		env     envFlag
+		wd      string
+		tty     string
+		stdin   string
+		stdout  string
+		stderr  string
+		target  string
 		err     error
 		dbgproc *proctl.DebuggedProcess
+		infs    []*proctl.DebuggedProcess
 		t       = newTerm()
 		cmds    = command.DebugCommands()
 	)
 
 	flag.IntVar(&pid, "pid", 0, "Pid of running process to attach to.")
+	flag.StringVar(&name, "attach-name", "", "Name of the executable of a running process to attach to.")
+	flag.BoolVar(&waitfor, "waitfor", false, "With -attach-name, wait for a process of that name to start and attach to it as soon as it does.")
 	flag.StringVar(&proc, "proc", "", "Path to process to run and debug.")
-	flag.BoolVar(&run, "run", false, "Compile program and begin debug session.")
-	flag.Parse()
+	flag.BoolVar(&run, "run", false, "Compile program and begin debug session. Takes an optional package import path or directory, defaulting to the current directory.")
+	flag.BoolVar(&printv, "version", false, "Print version information and exit.")
+	flag.BoolVar(&kill, "kill-on-exit", false, "Kill the process when the debugger exits. This is the default for processes started by the debugger.")
+	flag.BoolVar(&detach, "detach-on-exit", false, "Detach from the process, leaving it running, when the debugger exits. This is the default for processes attached to with -pid.")
+	flag.BoolVar(&onpanic, "break-on-panic", true, "Stop when the program panics, before the stack is unwound.")
+	flag.Var(&env, "env", "Set an environment variable of the program started, as in -env GODEBUG=gctrace=1. May be repeated.")
+	flag.StringVar(&wd, "wd", "", "Working directory of the program started, the current directory by default.")
+	flag.StringVar(&tty, "tty", "", "Terminal for the input and output of the program started, as in -tty /dev/pts/3, so that they do not interleave with the prompt.")
+	flag.StringVar(&stdin, "stdin", "", "File the program started reads its standard input from.")
+	flag.StringVar(&stdout, "stdout", "", "File the standard output of the program started is written to.")
+	flag.StringVar(&stderr, "stderr", "", "File the standard error of the program started is written to.")
+	flag.BoolVar(&calls, "allow-calls", false, "Allow the call command to run functions of the process. Calls run every thread of the program and may change its state.")
+	flag.BoolVar(&follow, "follow-fork", false, "Debug the processes the program forks as well, rather than detaching from them. Switch between them with inferior <n>.")
+	// Arguments following -- are passed to the program.
+	flags, progargs := splitArgs(os.Args[1:])
+	flag.CommandLine.Parse(flags)
 
 	if flag.NFlag() == 0 {
 		flag.Usage()
 		os.Exit(0)
 	}
 
+	if printv {
+		fmt.Println(version.String())
+		os.Exit(0)
+	}
+
+	if name != "" {
+		pid = findProcess(name, waitfor)
+	}
+
 	start := func(name string) *proctl.DebuggedProcess {
-		proc := exec.Command(name)
+		// Relative to the directory of the debugger
+		// rather than the one the program runs in.
+		target, err = filepath.Abs(name)
+		if err != nil {
+			die(1, "Could not start process:", err)
+		}
+
+		proc := exec.Command(target, progargs...)
 		proc.Stdout = os.Stdout
+		proc.Dir = wd
+		if len(env) > 0 {
+			proc.Env = append(os.Environ(), env...)
+		}
+
+		files, err := redirect(proc, tty, stdin, stdout, stderr)
+		if err != nil {
+			die(1, "Could not redirect input and output of process:", err)
+		}
 
 		err = proc.Start()
 		if err != nil {
 			die(1, "Could not start process:", err)
 		}
 
+		// The process has its own copies.
+		for _, f := range files {
+			f.Close()
+		}
+
 		dbgproc, err = proctl.NewDebugProcess(proc.Process.Pid)
 		if err != nil {
 			die(1, "Could not start debugging process:", err)
@@ -68,7 +128,13 @@ func main() {
 	switch {
 	case run:
 		const debugname = "debug"
-		cmd := exec.Command("go", "build", "-o", debugname, "-gcflags", "-N -l")
+		buildargs := []string{"build", "-o", debugname, "-gcflags", "-N -l"}
+		if flag.NArg() > 0 {
+			buildargs = append(buildargs, flag.Arg(0))
+		}
+
+		cmd := exec.Command("go", buildargs...)
+		cmd.Stderr = os.Stderr
 		err := cmd.Run()
 		if err != nil {
 			die(1, "Could not compile program:", err)
@@ -83,11 +149,32 @@ func main() {
 		}
 	case proc != "":
 		dbgproc = start(proc)
+	default:
+		die(1, "No program to debug, give one with -run, -proc, -pid or -attach-name")
+	}
+
+	checkGoVersion(dbgproc)
+	dbgproc.TraceHandler = printTrace
+	dbgproc.AllowCalls = calls
+	dbgproc.FollowForks = follow
+	infs = []*proctl.DebuggedProcess{dbgproc}
+
+	if onpanic {
+		_, err := dbgproc.BreakOnPanic()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: could not break on panic:", err)
+		}
+	}
+
+	if !kill && !detach {
+		kill = pid == 0
 	}
 
 	goreadline.LoadHistoryFromFile(historyFile)
 
 	for {
+		reportPluginErrors(dbgproc)
+
 		cmdstr, err := t.promptForInput()
 		if err != nil {
 			die(1, "Prompt for input failed.\n")
@@ -95,10 +182,63 @@ func main() {
 
 		cmdstr, args := parseCommand(cmdstr)
 
-		if cmdstr == "exit" {
+		if cmdstr == "exit" || cmdstr == "quit" {
 			err := goreadline.WriteHistoryToFile(historyFile)
 			fmt.Println(err)
-			handleExit(t, dbgproc, 0)
+
+			// quit -c leaves the process running.
+			killproc := kill && !(len(args) > 0 && args[0] == "-c")
+			handleExit(infs, dbgproc, killproc, 0)
+		}
+
+		if cmdstr == "restart" {
+			if target == "" {
+				fmt.Fprintln(os.Stderr, "Command failed: only a process started by the debugger can be restarted")
+				continue
+			}
+
+			// New arguments replace the previous ones,
+			// restart -- alone removes them.
+			if len(args) > 0 {
+				if args[0] == "--" {
+					args = args[1:]
+				}
+				progargs = args
+			}
+
+			old := dbgproc
+			for _, inf := range infs {
+				inf.Process.Kill()
+				inf.Process.Wait()
+			}
+
+			dbgproc = start(target)
+			checkGoVersion(dbgproc)
+
+			err := dbgproc.Inherit(old)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			}
+			infs = []*proctl.DebuggedProcess{dbgproc}
+			continue
+		}
+
+		if cmdstr == "inferiors" || cmdstr == "inferior" {
+			dbgproc, err = selectInferior(infs, dbgproc, args)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+			}
+			continue
+		}
+
+		// Settings of the program started, which take
+		// effect once it is restarted.
+		if cmdstr == "env" || cmdstr == "wd" {
+			err := launchSetting(cmdstr, args, &env, &wd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+			}
+			continue
 		}
 
 		cmd := cmds.Find(cmdstr)
@@ -106,23 +246,273 @@ func main() {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
 		}
+
+		infs = addForked(infs, dbgproc)
+	}
+}
+
+// Environment variables set for the program started, as KEY=VALUE.
+type envFlag []string
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
+// Sets a variable, replacing any earlier value.
+func (e *envFlag) Set(kv string) error {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid variable %q, expected KEY=VALUE", kv)
+	}
+
+	e.unset(kv[:i])
+	*e = append(*e, kv)
+	return nil
+}
+
+func (e *envFlag) unset(key string) {
+	vars := (*e)[:0]
+	for _, kv := range *e {
+		if !strings.HasPrefix(kv, key+"=") {
+			vars = append(vars, kv)
+		}
+	}
+	*e = vars
+}
+
+// Changes the environment or working directory of the program started:
+// env lists the variables set, env KEY=VALUE sets one and env -u KEY
+// removes it, wd prints the working directory and wd <dir> changes it.
+func launchSetting(cmdstr string, args []string, env *envFlag, wd *string) error {
+	if cmdstr == "wd" {
+		switch len(args) {
+		case 0:
+			dir := *wd
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+			fmt.Println(dir)
+		case 1:
+			dir, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", args[0])
+			}
+
+			*wd = dir
+			fmt.Println("Takes effect when the program is restarted")
+		default:
+			return fmt.Errorf("usage: wd [dir]")
+		}
+		return nil
+	}
+
+	switch {
+	case len(args) == 0:
+		for _, kv := range *env {
+			fmt.Println(kv)
+		}
+		return nil
+	case len(args) == 2 && args[0] == "-u":
+		env.unset(args[1])
+	case args[0] != "-u":
+		// Values may contain spaces.
+		err := env.Set(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: env [KEY=VALUE | -u KEY]")
+	}
+
+	fmt.Println("Takes effect when the program is restarted")
+	return nil
+}
+
+// Redirects the standard input and output of a program to files, or all
+// three of them to a terminal, other files given taking precedence. The
+// files opened are returned to be closed once the program has started.
+func redirect(cmd *exec.Cmd, tty, stdin, stdout, stderr string) ([]*os.File, error) {
+	var files []*os.File
+	open := func(path string, flag int) (*os.File, error) {
+		f, err := os.OpenFile(path, flag, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+		return f, nil
+	}
+
+	var err error
+	if tty != "" {
+		f, err := open(tty, os.O_RDWR)
+		if err != nil {
+			return files, err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = f, f, f
+	}
+
+	if stdin != "" {
+		if cmd.Stdin, err = open(stdin, os.O_RDONLY); err != nil {
+			return files, err
+		}
+	}
+
+	if stdout != "" {
+		if cmd.Stdout, err = open(stdout, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+			return files, err
+		}
 	}
+
+	// Both written to the same file share its offset.
+	if stderr != "" && stderr == stdout {
+		cmd.Stderr = cmd.Stdout
+	} else if stderr != "" {
+		if cmd.Stderr, err = open(stderr, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+			return files, err
+		}
+	}
+
+	return files, nil
 }
 
-func handleExit(t *term, dbp *proctl.DebuggedProcess, status int) {
-	fmt.Println("Would you like to kill the process? [y/n]")
-	answer, err := t.stdin.ReadString('\n')
+// Splits the arguments of the debugger at the first --, returning
+// the flags before it and the arguments of the program after it.
+func splitArgs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+
+	return args, nil
+}
+
+// Adds the processes the current one forked to the inferiors debugged,
+// numbered in the order they are added, starting from 1, and reports
+// those which were not followed.
+func addForked(infs []*proctl.DebuggedProcess, dbp *proctl.DebuggedProcess) []*proctl.DebuggedProcess {
+	for _, child := range dbp.Skipped {
+		fmt.Printf("Not following process %d: %s\n", child.Pid, child.Err)
+	}
+
+	for _, child := range dbp.Forked {
+		infs = append(infs, child)
+		fmt.Printf("[New inferior %d: process %d]\n", len(infs), child.Pid)
+	}
+
+	dbp.Skipped = nil
+	dbp.Forked = nil
+	return infs
+}
+
+// Prints the record of a tracepoint or logpoint hit.
+func printTrace(record *proctl.TraceRecord) {
+	fmt.Println(record)
+}
+
+// Warns about the shared objects which could not be
+// loaded since the last time this was called.
+func reportPluginErrors(dbp *proctl.DebuggedProcess) {
+	for _, err := range dbp.PluginErrors {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	}
+
+	dbp.PluginErrors = nil
+}
+
+// Lists the inferiors, the current one marked with a *, for inferiors,
+// or returns the one numbered n to switch to for inferior <n>.
+func selectInferior(infs []*proctl.DebuggedProcess, current *proctl.DebuggedProcess, args []string) (*proctl.DebuggedProcess, error) {
+	switch len(args) {
+	case 0:
+		for i, inf := range infs {
+			fmt.Println(formatInferior(i+1, inf, inf == current))
+		}
+		return current, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(infs) {
+			return current, fmt.Errorf("no inferior %s, there are %d", args[0], len(infs))
+		}
+
+		fmt.Printf("[Switching to inferior %d: process %d]\n", n, infs[n-1].Pid)
+		return infs[n-1], nil
+	}
+
+	return current, fmt.Errorf("usage: inferior <n>")
+}
+
+// Formats an inferior as inferiors lists it, as in
+// "* 1\tprocess 1234\t/tmp/prog" or "  2\tprocess 1240\t(exited)".
+func formatInferior(n int, dbp *proctl.DebuggedProcess, current bool) string {
+	mark := " "
+	if current {
+		mark = "*"
+	}
+
+	desc := "(exited)"
+	if ps := dbp.ProcessState; ps == nil || !(ps.Exited() || ps.Signaled()) {
+		desc, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", dbp.Pid))
+	}
+
+	return fmt.Sprintf("%s %d\tprocess %d\t%s", mark, n, dbp.Pid, desc)
+}
+
+// Returns the ID of the process to attach to whose executable is named
+// name, waiting for one to start if waitfor is set.
+func findProcess(name string, waitfor bool) int {
+	if waitfor {
+		fmt.Printf("Waiting for %s to start...\n", name)
+
+		pid, err := proctl.WaitForProcess(name)
+		if err != nil {
+			die(1, "Could not wait for process:", err)
+		}
+
+		return pid
+	}
+
+	pids, err := proctl.FindProcesses(name)
 	if err != nil {
-		die(2, err.Error())
+		die(1, "Could not find process:", err)
+	}
+
+	switch len(pids) {
+	case 0:
+		die(1, "No process named", name)
+	case 1:
+		return pids[0]
+	}
+
+	die(1, fmt.Sprintf("Several processes named %s: %v, use -pid to choose one", name, pids))
+	return 0
+}
+
+func handleExit(infs []*proctl.DebuggedProcess, dbp *proctl.DebuggedProcess, kill bool, status int) {
+	// Forked processes are let go as the current one is.
+	for _, inf := range infs {
+		if inf == dbp || inf.ProcessState.Exited() || inf.ProcessState.Signaled() {
+			continue
+		}
+
+		inf.Detach()
+		if kill {
+			inf.Process.Kill()
+		}
 	}
 
 	fmt.Println("Detaching from process...")
-	err = syscall.PtraceDetach(dbp.Process.Pid)
+	err := dbp.Detach()
 	if err != nil {
 		die(2, "Could not detach", err)
 	}
 
-	if answer == "y\n" {
+	if kill {
 		fmt.Println("Killing process", dbp.Process.Pid)
 
 		err := dbp.Process.Kill()
@@ -134,6 +524,28 @@ func handleExit(t *term, dbp *proctl.DebuggedProcess, status int) {
 	die(status, "Hope I was of service hunting your bug!")
 }
 
+// Warns when the target was built with a version of Go delve has not
+// been tested with, and refuses to continue when it is known not to work.
+func checkGoVersion(dbp *proctl.DebuggedProcess) {
+	v, err := dbp.GoVersion()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: could not determine Go version of target:", err)
+		return
+	}
+
+	err = version.CheckGoVersion(v)
+	if err == nil {
+		return
+	}
+
+	if ie, ok := err.(version.IncompatibleError); ok && ie.Fatal {
+		dbp.Process.Kill()
+		die(1, "Could not debug process:", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Warning:", err)
+}
+
 func die(status int, args ...interface{}) {
 	fmt.Fprint(os.Stderr, args)
 	fmt.Fprint(os.Stderr, "\n")
@@ -141,9 +553,7 @@ func die(status int, args ...interface{}) {
 }
 
 func newTerm() *term {
-	return &term{
-		stdin: bufio.NewReader(os.Stdin),
-	}
+	return &term{}
 }
 
 func parseCommand(cmdstr string) (string, []string) {
@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/derekparker/delve/helper"
+	"github.com/derekparker/delve/proctl"
 )
 
 func buildBinary(t *testing.T) {
@@ -33,12 +39,11 @@ func startDebugger(t *testing.T, pid int) *os.Process {
 }
 
 func startTestProg(t *testing.T, proc string) *os.Process {
-	err := exec.Command("go", "build", "-gcflags=-N -l", "-o", proc, proc+".go").Run()
+	path, err := helper.CompileTestProg(proc)
 	if err != nil {
 		t.Fatal("Could not compile", proc, err)
 	}
-	defer os.Remove(proc)
-	cmd := exec.Command(proc)
+	cmd := exec.Command(path)
 
 	err = cmd.Start()
 	if err != nil {
@@ -88,3 +93,120 @@ func TestCleanExit(t *testing.T) {
 		t.Fatal("timeout")
 	}
 }
+
+func TestNoTarget(t *testing.T) {
+	buildBinary(t)
+	defer os.Remove("dbg-test")
+
+	out, err := exec.Command("./dbg-test", "-allow-calls").CombinedOutput()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("Expected the debugger to fail without a program, got %v", err)
+	}
+
+	if !strings.Contains(string(out), "No program to debug") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	flags, args := splitArgs([]string{"-run", "./cmd/server", "--", "-config=prod.yaml", "serve", "--"})
+	if strings.Join(flags, " ") != "-run ./cmd/server" || strings.Join(args, " ") != "-config=prod.yaml serve --" {
+		t.Fatalf("Unexpected split %q %q", flags, args)
+	}
+
+	flags, args = splitArgs([]string{"-proc", "prog"})
+	if len(flags) != 2 || args != nil {
+		t.Fatalf("Unexpected split %q %q", flags, args)
+	}
+}
+
+func TestEnvFlag(t *testing.T) {
+	var env envFlag
+	for _, kv := range []string{"GODEBUG=gctrace=1", "CONFIG=/etc/app.yaml", "GODEBUG=madvdontneed=1"} {
+		if err := env.Set(kv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if env.String() != "CONFIG=/etc/app.yaml GODEBUG=madvdontneed=1" {
+		t.Fatalf("Unexpected environment %q", env.String())
+	}
+
+	env.unset("CONFIG")
+	if env.String() != "GODEBUG=madvdontneed=1" {
+		t.Fatalf("Unexpected environment %q", env.String())
+	}
+
+	if err := env.Set("=1"); err == nil {
+		t.Fatal("Expected an error for a variable without a name")
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlv-redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(in, []byte("input\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", "cat; echo error >&2")
+	files, err := redirect(cmd, "", in, out, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		f.Close()
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "input\nerror\n" {
+		t.Fatalf("Unexpected output %q", data)
+	}
+
+	if _, err := redirect(exec.Command("true"), filepath.Join(dir, "missing", "tty"), "", "", ""); err == nil {
+		t.Fatal("Expected an error for a terminal which does not exist")
+	}
+}
+
+func TestSelectInferior(t *testing.T) {
+	exited := syscall.WaitStatus(0)
+	infs := []*proctl.DebuggedProcess{
+		{Pid: os.Getpid()},
+		{Pid: 1 << 22, ProcessState: &exited},
+	}
+
+	exe, _ := os.Readlink("/proc/self/exe")
+	if s := formatInferior(1, infs[0], true); s != fmt.Sprintf("* 1\tprocess %d\t%s", os.Getpid(), exe) {
+		t.Fatalf("Unexpected inferior %q", s)
+	}
+
+	if s := formatInferior(2, infs[1], false); s != fmt.Sprintf("  2\tprocess %d\t(exited)", 1<<22) {
+		t.Fatalf("Unexpected inferior %q", s)
+	}
+
+	cur, err := selectInferior(infs, infs[0], []string{"2"})
+	if err != nil || cur != infs[1] {
+		t.Fatalf("Did not switch to inferior 2: %v", err)
+	}
+
+	for _, args := range [][]string{{"0"}, {"3"}, {"one"}, {"1", "2"}} {
+		cur, err := selectInferior(infs, infs[0], args)
+		if err == nil || cur != infs[0] {
+			t.Fatalf("Expected an error for inferior %v", args)
+		}
+	}
+}
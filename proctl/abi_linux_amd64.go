@@ -0,0 +1,283 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Go passes arguments and results of functions in registers, as far as
+// they go, and on the stack otherwise. These are the integer registers
+// used, in order; floating point values use X0 to X14 in order.
+func intArgRegs(regs *syscall.PtraceRegs) []*uint64 {
+	return []*uint64{&regs.Rax, &regs.Rbx, &regs.Rcx, &regs.Rdi, &regs.Rsi, &regs.R8, &regs.R9, &regs.R10, &regs.R11}
+}
+
+const (
+	numIntArgRegs   = 9
+	numFloatArgRegs = 15
+)
+
+// Where an argument or result of a function is passed: split over
+// registers, one part per register, or on the stack.
+type abiValue struct {
+	typ   dwarf.Type
+	parts []abiPart
+	// Offset from the stack pointer at the call of values
+	// passed on the stack.
+	off int64
+}
+
+// Part of a value passed in a register.
+type abiPart struct {
+	// Offset and size of the part within the value.
+	off, size int64
+	// Index of the register among the integer
+	// or floating point argument registers.
+	reg   int
+	float bool
+}
+
+// Assigns the arguments, then the results, of a function to registers
+// and stack slots in order, following the register based calling
+// convention of Go on amd64. A value which does not fit in the
+// remaining registers is passed on the stack as a whole.
+type abiAssigner struct {
+	ints, floats int
+	frame        int64
+	parts        []abiPart
+}
+
+func (a *abiAssigner) assign(t dwarf.Type) abiValue {
+	ints, floats := a.ints, a.floats
+
+	a.parts = nil
+	if a.regs(t, 0) {
+		return abiValue{typ: t, parts: a.parts}
+	}
+	a.ints, a.floats = ints, floats
+
+	a.frame = align(a.frame, alignof(t))
+	v := abiValue{typ: t, off: a.frame}
+	a.frame += sizeof(t)
+
+	return v
+}
+
+// Assigns the value of type t at offset off within the value being
+// assigned to registers, reporting whether there were enough of them.
+// Strings, slices and interfaces are described as structs, so they
+// are passed a field at a time like any other struct.
+func (a *abiAssigner) regs(t dwarf.Type, off int64) bool {
+	switch t := underlyingType(t).(type) {
+	case *dwarf.BoolType, *dwarf.IntType, *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType, *dwarf.PtrType:
+		if a.ints == numIntArgRegs || sizeof(t) > 8 {
+			return false
+		}
+		a.parts = append(a.parts, abiPart{off: off, size: sizeof(t), reg: a.ints})
+		a.ints++
+	case *dwarf.FloatType:
+		if a.floats == numFloatArgRegs {
+			return false
+		}
+		a.parts = append(a.parts, abiPart{off: off, size: t.Size(), reg: a.floats, float: true})
+		a.floats++
+	case *dwarf.ComplexType:
+		if a.floats+2 > numFloatArgRegs {
+			return false
+		}
+		half := t.Size() / 2
+		a.parts = append(a.parts,
+			abiPart{off: off, size: half, reg: a.floats, float: true},
+			abiPart{off: off + half, size: half, reg: a.floats + 1, float: true})
+		a.floats += 2
+	case *dwarf.StructType:
+		for _, field := range t.Field {
+			if !a.regs(field.Type, off+field.ByteOffset) {
+				return false
+			}
+		}
+	case *dwarf.ArrayType:
+		switch t.Count {
+		case 0:
+		case 1:
+			return a.regs(t.Type, off)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Returns the alignment of values of type t.
+func alignof(t dwarf.Type) int64 {
+	switch t := underlyingType(t).(type) {
+	case *dwarf.ComplexType:
+		return t.Size() / 2
+	case *dwarf.StructType:
+		max := int64(1)
+		for _, field := range t.Field {
+			if a := alignof(field.Type); a > max {
+				max = a
+			}
+		}
+		return max
+	case *dwarf.ArrayType:
+		return alignof(t.Type)
+	}
+
+	if size := sizeof(t); size > 0 && size < 8 {
+		return size
+	}
+
+	return 8
+}
+
+// Returns the size of values of type t. The size of pointers
+// is not always recorded in the debugging information.
+func sizeof(t dwarf.Type) int64 {
+	if _, ok := underlyingType(t).(*dwarf.PtrType); ok && t.Size() < 0 {
+		return 8
+	}
+
+	return t.Size()
+}
+
+func align(off, n int64) int64 {
+	return (off + n - 1) / n * n
+}
+
+// The layout of the stack frame of a call: where each argument and
+// result is passed, and the size of the frame. The frame holds the
+// arguments and results passed on the stack, followed by the space the
+// called function may spill its register arguments to, followed by
+// space to copy the results passed in registers to once the call has
+// returned, so that they can be read like any other value in memory.
+type callFrame struct {
+	args, results []abiValue
+	scratch       int64
+	size          int64
+}
+
+func newCallFrame(params, results []dwarf.Type) *callFrame {
+	var (
+		a  abiAssigner
+		cf callFrame
+	)
+
+	for _, t := range params {
+		cf.args = append(cf.args, a.assign(t))
+	}
+	a.frame = align(a.frame, 8)
+
+	a.ints, a.floats = 0, 0
+	for _, t := range results {
+		cf.results = append(cf.results, a.assign(t))
+	}
+	a.frame = align(a.frame, 8)
+
+	for _, v := range cf.args {
+		if v.parts != nil {
+			a.frame = align(a.frame, alignof(v.typ)) + sizeof(v.typ)
+		}
+	}
+	a.frame = align(a.frame, 8)
+
+	cf.scratch = a.frame
+	for i, v := range cf.results {
+		if v.parts != nil {
+			a.frame = align(a.frame, alignof(v.typ))
+			cf.results[i].off = a.frame
+			a.frame += sizeof(v.typ)
+		}
+	}
+	cf.size = align(a.frame, 8)
+
+	return &cf
+}
+
+// Places the value of an argument, as laid out in memory, in the
+// registers or on the stack, with sp the stack pointer at the call.
+// Floating point registers are set in fpregs, as saved by fxsave.
+func (dbp *DebuggedProcess) placeArg(v abiValue, data []byte, sp uint64, regs *syscall.PtraceRegs, fpregs []byte) error {
+	if v.parts == nil {
+		if len(data) == 0 {
+			return nil
+		}
+		return dbp.writeMemory(uintptr(sp+uint64(v.off)), data)
+	}
+
+	ints := intArgRegs(regs)
+	for _, p := range v.parts {
+		buf := make([]byte, 8)
+		copy(buf, data[p.off:p.off+p.size])
+
+		if p.float {
+			copy(fpregs[xmmOffset(p.reg):], buf)
+			continue
+		}
+		*ints[p.reg] = binary.LittleEndian.Uint64(buf)
+	}
+
+	return nil
+}
+
+// Copies a result passed in registers to its place in the scratch
+// space of the frame at sp, returning the address of the result.
+func (dbp *DebuggedProcess) collectResult(v abiValue, sp uint64, regs *syscall.PtraceRegs, fpregs []byte) (uint64, error) {
+	addr := sp + uint64(v.off)
+	if v.parts == nil {
+		return addr, nil
+	}
+
+	data := make([]byte, sizeof(v.typ))
+
+	ints := intArgRegs(regs)
+	for _, p := range v.parts {
+		buf := make([]byte, 8)
+		if p.float {
+			copy(buf, fpregs[xmmOffset(p.reg):])
+		} else {
+			binary.LittleEndian.PutUint64(buf, *ints[p.reg])
+		}
+		copy(data[p.off:p.off+p.size], buf)
+	}
+
+	if len(data) == 0 {
+		return addr, nil
+	}
+
+	return addr, dbp.writeMemory(uintptr(addr), data)
+}
+
+// Offset of register Xn in the area saved by fxsave.
+func xmmOffset(n int) int {
+	return 160 + 16*n
+}
+
+// Returns the floating point registers of a thread as saved by fxsave.
+func getFPRegs(tid int) ([]byte, error) {
+	buf := make([]byte, 512)
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_GETFPREGS, uintptr(tid), 0, uintptr(unsafe.Pointer(&buf[0])), 0, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("could not read floating point registers of thread %d: %s", tid, errno)
+	}
+
+	return buf, nil
+}
+
+func setFPRegs(tid int, buf []byte) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_SETFPREGS, uintptr(tid), 0, uintptr(unsafe.Pointer(&buf[0])), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("could not write floating point registers of thread %d: %s", tid, errno)
+	}
+
+	return nil
+}
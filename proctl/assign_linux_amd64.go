@@ -0,0 +1,188 @@
+package proctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"math"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Sets the variable named by lhs, such as "x", "s.Field" or "*p", to the
+// value of the expression rhs, as in "42", "\"foo\"", "nil" or "y + 1".
+func (dbp *DebuggedProcess) SetVariable(lhs, rhs string) error {
+	return dbp.SetVariableInFrame(lhs, rhs, nil)
+}
+
+// Sets a variable as seen from frame, one of the frames returned by
+// Stacktrace, or from the innermost frame if nil.
+func (dbp *DebuggedProcess) SetVariableInFrame(lhs, rhs string, frame *StackFrame) error {
+	scope := &evalScope{dbp: dbp, frame: frame, src: exprSource(lhs)}
+
+	t, err := parseExpression(lhs)
+	if err != nil {
+		return err
+	}
+
+	addr, typ, err := scope.evalAddress(t)
+	if err != nil {
+		return err
+	}
+
+	val, err := parseExpression(rhs)
+	if err != nil {
+		return err
+	}
+	scope.src = exprSource(rhs)
+
+	data, err := scope.encodeValue(val, typ)
+	if err != nil {
+		return fmt.Errorf("cannot assign %s to %s: %s", rhs, lhs, err)
+	}
+
+	return dbp.writeMemory(uintptr(addr), data)
+}
+
+// Encodes the value of expr as it is stored in memory for a variable
+// of type typ. Numbers must be representable in the type.
+func (s *evalScope) encodeValue(expr ast.Expr, typ dwarf.Type) ([]byte, error) {
+	typ = underlyingType(typ)
+
+	if id, ok := expr.(*ast.Ident); ok && id.Name == "nil" {
+		if !nillable(typ) {
+			return nil, fmt.Errorf("nil is not a valid %s", typ)
+		}
+		return make([]byte, typ.Size()), nil
+	}
+
+	if st, ok := typ.(*dwarf.StructType); ok && st.StructName == "string" {
+		return s.encodeString(expr)
+	}
+
+	v, err := s.evalAST(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := v.constant()
+	if err != nil {
+		return nil, err
+	}
+
+	size := typ.Size()
+	buf := make([]byte, 8)
+
+	switch typ.(type) {
+	case *dwarf.BoolType:
+		if c.Kind() != constant.Bool {
+			return nil, fmt.Errorf("%s is not a bool", v.Value)
+		}
+		if constant.BoolVal(c) {
+			buf[0] = 1
+		}
+	case *dwarf.IntType:
+		n, ok := constant.Int64Val(constant.ToInt(c))
+		if !ok || size < 8 && (n < -1<<uint(8*size-1) || n >= 1<<uint(8*size-1)) {
+			return nil, fmt.Errorf("%s overflows %s", v.Value, typ)
+		}
+		binary.LittleEndian.PutUint64(buf, uint64(n))
+	case *dwarf.UintType:
+		n, ok := constant.Uint64Val(constant.ToInt(c))
+		if !ok || size < 8 && n >= 1<<uint(8*size) {
+			return nil, fmt.Errorf("%s overflows %s", v.Value, typ)
+		}
+		binary.LittleEndian.PutUint64(buf, n)
+	case *dwarf.FloatType:
+		c = constant.ToFloat(c)
+		if c.Kind() != constant.Float && c.Kind() != constant.Int {
+			return nil, fmt.Errorf("%s is not a number", v.Value)
+		}
+		f, _ := constant.Float64Val(c)
+		if size == 4 {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+		}
+	default:
+		return nil, fmt.Errorf("variables of type %s can not be set", typ)
+	}
+
+	return buf[:size], nil
+}
+
+// Reports whether nil is a valid value for a variable of type
+// typ: a pointer, map, channel or function, a slice or an interface.
+func nillable(typ dwarf.Type) bool {
+	switch t := typ.(type) {
+	case *dwarf.PtrType:
+		return true
+	case *dwarf.StructType:
+		return strings.HasPrefix(t.StructName, "[]") ||
+			t.StructName == "runtime.iface" || t.StructName == "runtime.eface"
+	}
+
+	return false
+}
+
+// Encodes the string header of the value of expr. Strings can not be
+// allocated in the process, so the value must be one the program
+// already holds: that of another string variable, or a literal whose
+// bytes are found in the read-only data of the executable.
+func (s *evalScope) encodeString(expr ast.Expr) ([]byte, error) {
+	if addr, typ, err := s.evalAddress(expr); err == nil {
+		if st, ok := underlyingType(typ).(*dwarf.StructType); ok && st.StructName == "string" {
+			return s.dbp.readMemory(uintptr(addr), 16)
+		}
+	}
+
+	v, err := s.evalAST(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := v.constant()
+	if err != nil || c.Kind() != constant.String {
+		return nil, fmt.Errorf("%s is not a string", v.Value)
+	}
+
+	str := constant.StringVal(c)
+
+	var ptr uint64
+	if str != "" {
+		ptr, err = s.dbp.findReadOnlyData([]byte(str))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf[:8], ptr)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(str)))
+
+	return buf, nil
+}
+
+// Returns the address the given bytes are found at in the
+// read-only data of the executable.
+func (dbp *DebuggedProcess) findReadOnlyData(b []byte) (uint64, error) {
+	sec := dbp.Executable.Section(".rodata")
+	if sec == nil {
+		return 0, fmt.Errorf("could not find the read-only data of the executable")
+	}
+
+	data, err := sec.Data()
+	if err != nil {
+		return 0, err
+	}
+
+	i := bytes.Index(data, b)
+	if i < 0 {
+		return 0, fmt.Errorf("%q does not occur in the program, strings can only be set to ones it contains", b)
+	}
+
+	return sec.Addr + uint64(i), nil
+}
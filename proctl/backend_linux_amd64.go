@@ -0,0 +1,35 @@
+package proctl
+
+// Executes the process on behalf of Continue and Step. The process is
+// run directly through ptrace by PtraceBackend, unless another backend
+// is set, such as one replaying a recording of the process made with
+// rr, to which execution is then delegated.
+type Backend interface {
+	// Resumes the process until it stops at a breakpoint or
+	// watchpoint, or exits, as documented on Continue.
+	Continue(dbp *DebuggedProcess) error
+	// Steps the process to the next source line, as
+	// documented on Step.
+	Step(dbp *DebuggedProcess) error
+}
+
+// Runs the process directly through ptrace. Other backends may wrap
+// it to execute the process some of the time.
+type PtraceBackend struct{}
+
+func (PtraceBackend) Continue(dbp *DebuggedProcess) error {
+	return dbp.ptraceContinue()
+}
+
+func (PtraceBackend) Step(dbp *DebuggedProcess) error {
+	return dbp.ptraceStep()
+}
+
+// Returns the backend executing the process.
+func (dbp *DebuggedProcess) backend() Backend {
+	if dbp.Backend == nil {
+		return PtraceBackend{}
+	}
+
+	return dbp.Backend
+}
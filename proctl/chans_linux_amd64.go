@@ -0,0 +1,222 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Number of buffered elements of a channel read when printing it.
+const maxChanElements = 64
+
+// Reports whether t is the type of a channel header, which Go
+// describes as a struct named hchan<T>.
+func isChanHeader(t dwarf.Type) bool {
+	st, ok := t.(*dwarf.StructType)
+	return ok && strings.HasPrefix(st.StructName, "hchan<")
+}
+
+// Formats the channel whose header, of type hdr, is pointed to by the
+// pointer at addr, as in "chan int (buf 2/10 [1, 2], recvq: 1 goroutine
+// [7])": the elements queued in its buffer, oldest first, and the IDs
+// of the goroutines blocked receiving from or sending to it.
+func (dbp *DebuggedProcess) readChan(addr uintptr, hdr *dwarf.StructType) (string, error) {
+	name := "chan " + strings.TrimSuffix(strings.TrimPrefix(hdr.StructName, "hchan<"), ">")
+
+	hchan, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if hchan == 0 {
+		return name + " nil", nil
+	}
+
+	elemType, err := chanElemType(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	qcount, err := dbp.readUintField(hchan, hdr, "qcount")
+	if err != nil {
+		return "", err
+	}
+
+	size, err := dbp.readUintField(hchan, hdr, "dataqsiz")
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	if size == 0 {
+		parts = append(parts, "unbuffered")
+	} else {
+		elems, err := dbp.chanBuffer(hchan, hdr, elemType, qcount, size)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("buf %d/%d [%s]", qcount, size, strings.Join(elems, ", ")))
+	}
+
+	for _, queue := range []string{"recvq", "sendq"} {
+		ids, err := dbp.chanWaiters(hchan, hdr, queue)
+		if err != nil {
+			return "", err
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		noun := "goroutines"
+		if len(ids) == 1 {
+			noun = "goroutine"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d %s [%s]", queue, len(ids), noun, strings.Join(ids, ", ")))
+	}
+
+	field, err := structField(hdr, "closed")
+	if err != nil {
+		return "", err
+	}
+
+	closed, err := dbp.readMemory(uintptr(hchan+uint64(field.ByteOffset)), 4)
+	if err != nil {
+		return "", err
+	}
+
+	if binary.LittleEndian.Uint32(closed) != 0 {
+		parts = append(parts, "closed")
+	}
+
+	return fmt.Sprintf("%s (%s)", name, strings.Join(parts, ", ")), nil
+}
+
+// Formats the elements queued in the circular buffer of the channel
+// whose header is at hchan, starting from the next one to be received.
+func (dbp *DebuggedProcess) chanBuffer(hchan uint64, hdr *dwarf.StructType, elemType dwarf.Type, qcount, size uint64) ([]string, error) {
+	buf, err := dbp.readUintField(hchan, hdr, "buf")
+	if err != nil {
+		return nil, err
+	}
+
+	recvx, err := dbp.readUintField(hchan, hdr, "recvx")
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([]string, 0, qcount)
+	for i := uint64(0); i < qcount; i++ {
+		if i == maxChanElements {
+			elems = append(elems, "...")
+			break
+		}
+
+		addr := buf + (recvx+i)%size*uint64(elemType.Size())
+		v, err := dbp.extractValue(int64(addr), elemType)
+		if err != nil {
+			return nil, err
+		}
+
+		elems = append(elems, v)
+	}
+
+	return elems, nil
+}
+
+// Returns the IDs of the goroutines queued in the recvq or sendq
+// of the channel whose header is at hchan.
+func (dbp *DebuggedProcess) chanWaiters(hchan uint64, hdr *dwarf.StructType, queue string) ([]string, error) {
+	field, err := structField(hdr, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	qtype, ok := underlyingType(field.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type of %s %s", queue, field.Type)
+	}
+
+	stype, err := dbp.findStructType("runtime.sudog")
+	if err != nil {
+		return nil, err
+	}
+
+	sudog, err := dbp.readUintField(hchan+uint64(field.ByteOffset), qtype, "first")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for i := 0; sudog != 0 && i < maxWaiters; i++ {
+		gaddr, err := dbp.readUintField(sudog, stype, "g")
+		if err != nil {
+			return nil, err
+		}
+
+		g, err := dbp.readGoroutine(gaddr)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, strconv.Itoa(g.ID))
+
+		sudog, err = dbp.readUintField(sudog, stype, "next")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+// Returns the element type of channels whose header type is hdr. Go
+// only records it as the type pointed to by the elem field of the
+// sudog<T> structs heading the queues of the channel.
+func chanElemType(hdr *dwarf.StructType) (dwarf.Type, error) {
+	notFound := fmt.Errorf("could not find the element type of %s", hdr.StructName)
+
+	recvq, err := structField(hdr, "recvq")
+	if err != nil {
+		return nil, err
+	}
+
+	qtype, ok := underlyingType(recvq.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, notFound
+	}
+
+	first, err := structField(qtype, "first")
+	if err != nil {
+		return nil, err
+	}
+
+	stype, ok := underlyingType(pointee(first.Type)).(*dwarf.StructType)
+	if !ok {
+		return nil, notFound
+	}
+
+	elem, err := structField(stype, "elem")
+	if err != nil {
+		return nil, err
+	}
+
+	t := pointee(elem.Type)
+	if t == nil {
+		return nil, notFound
+	}
+
+	return t, nil
+}
+
+// Returns the type pointed to by a pointer type, or nil for other types.
+func pointee(t dwarf.Type) dwarf.Type {
+	ptr, ok := underlyingType(t).(*dwarf.PtrType)
+	if !ok {
+		return nil
+	}
+
+	return ptr.Type
+}
@@ -0,0 +1,181 @@
+package proctl
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// A constant declared with a named integer type, as in the const block
+// enumerating the values of a type State int.
+type namedConstant struct {
+	name  string
+	value int64
+}
+
+// Returns the constants declared with the integer type named name, sorted
+// by value. The constants of every type are read from the debugging
+// information of the main executable the first time, since finding them
+// requires a scan of the whole .debug_info section.
+func (dbp *DebuggedProcess) typeConstants(name string) ([]namedConstant, error) {
+	if dbp.constants != nil {
+		return dbp.constants[name], nil
+	}
+
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	constants := make(map[string][]namedConstant)
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Tag == dwarf.TagSubprogram {
+			reader.SkipChildren()
+			continue
+		}
+
+		if entry.Tag != dwarf.TagConstant {
+			continue
+		}
+
+		n, _ := entry.Val(dwarf.AttrName).(string)
+		value, ok := entry.Val(dwarf.AttrConstValue).(int64)
+		off, hasType := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if n == "" || !ok || !hasType {
+			continue
+		}
+
+		typ, err := data.Type(off)
+		if err != nil {
+			continue
+		}
+
+		// Untyped constants are given the type they default
+		// to, they do not enumerate the values of a type.
+		tn := typ.Common().Name
+		if !strings.Contains(tn, ".") {
+			continue
+		}
+
+		constants[tn] = append(constants[tn], namedConstant{name: n, value: value})
+	}
+
+	for _, cs := range constants {
+		sort.Sort(byValue(cs))
+	}
+
+	dbp.constants = constants
+
+	return constants[name], nil
+}
+
+type byValue []namedConstant
+
+func (cs byValue) Len() int           { return len(cs) }
+func (cs byValue) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+func (cs byValue) Less(i, j int) bool { return cs[i].value < cs[j].value }
+
+// Returns the integer of type typ at addr, formatted as val, followed by
+// the constants of its type it equals, as in "Running (1)", for values
+// printed as part of others, such as fields of structs.
+func (dbp *DebuggedProcess) describeConstant(addr uintptr, typ dwarf.Type, val string) (string, error) {
+	names, err := dbp.constantNames(addr, typ)
+	if err != nil || names == "" {
+		return val, err
+	}
+
+	return names + " (" + val + ")", nil
+}
+
+// Returns the constant of its type the integer of type typ at addr
+// equals, as in "Running". Values of types whose constants are distinct
+// bits, or masks not sharing any, are described as the combination of
+// flags they are, as in "Read|Exec", bits no constant stands for given
+// as a number. Empty for values no constant describes.
+func (dbp *DebuggedProcess) constantNames(addr uintptr, typ dwarf.Type) (string, error) {
+	name := typ.Common().Name
+	if !strings.Contains(name, ".") {
+		return "", nil
+	}
+
+	// Values are still printed when constants can not be read.
+	constants, err := dbp.typeConstants(name)
+	if err != nil || len(constants) == 0 {
+		return "", nil
+	}
+
+	n, err := dbp.readSized(addr, typ.Size())
+	if err != nil {
+		return "", err
+	}
+
+	mask := ^uint64(0)
+	if size := typ.Size(); size < 8 {
+		mask = 1<<uint(8*size) - 1
+	}
+
+	for _, c := range constants {
+		if uint64(c.value)&mask == n {
+			return constantName(c), nil
+		}
+	}
+
+	if n == 0 || !isFlagType(constants, mask) {
+		return "", nil
+	}
+
+	var names []string
+	rest := n
+	for _, c := range constants {
+		bits := uint64(c.value) & mask
+		if bits != 0 && rest&bits == bits {
+			names = append(names, constantName(c))
+			rest &^= bits
+		}
+	}
+
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	if rest != 0 {
+		names = append(names, dbp.formatUint(rest))
+	}
+
+	return strings.Join(names, "|"), nil
+}
+
+// Reports whether the nonzero constants of a type share no bits, so that
+// its values are combinations of them. Constants numbered in sequence, as
+// in an iota enumeration of three values 0, 1 and 2, are no flags even
+// though they happen to share no bits.
+func isFlagType(constants []namedConstant, mask uint64) bool {
+	var seen uint64
+	sequence := true
+	for i, c := range constants {
+		bits := uint64(c.value) & mask
+		if seen&bits != 0 {
+			return false
+		}
+		seen |= bits
+
+		if i > 0 && c.value != constants[i-1].value+1 {
+			sequence = false
+		}
+	}
+
+	return !sequence
+}
+
+// Returns the name of a constant without its package, which is the
+// package of its type.
+func constantName(c namedConstant) string {
+	return c.name[strings.LastIndex(c.name, ".")+1:]
+}
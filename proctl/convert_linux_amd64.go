@@ -0,0 +1,268 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Evaluates a conversion, as in int64(x) or (*main.FooBar)(0xc000123456).
+// Numbers convert between numeric types as in Go, wrapping around when
+// the value does not fit, and addresses, given as integers, uintptr or
+// unsafe.Pointer values, convert to pointers of any type, so that raw
+// addresses found in memory can be read as typed values.
+func (s *evalScope) evalConversion(node *ast.CallExpr) (*Variable, error) {
+	name, err := s.conversionType(node)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case name == "unsafe.Pointer":
+		addr, err := s.evalPointer(node.Args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Variable{Type: name, Value: fmt.Sprintf("%#x", addr)}, nil
+	case strings.HasPrefix(name, "*"):
+		addr, typ, err := s.convertPointer(node)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := s.dbp.extractValue(int64(addr), typ)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Variable{Type: name, Value: "*" + val}, nil
+	}
+
+	return s.convertNumber(name, node.Args[0])
+}
+
+// Returns the name of the type a conversion converts to, as written.
+func (s *evalScope) conversionType(node *ast.CallExpr) (string, error) {
+	name, ok := s.text(unparen(node.Fun))
+	if !ok {
+		return "", fmt.Errorf("expression %T not supported", node)
+	}
+
+	if len(node.Args) != 1 || node.Ellipsis.IsValid() {
+		return "", fmt.Errorf("%s is not a conversion, function calls are made with the call command", name)
+	}
+
+	return name, nil
+}
+
+// Returns the address a conversion to a pointer type points to,
+// along with the type pointed to.
+func (s *evalScope) convertPointer(node *ast.CallExpr) (uint64, dwarf.Type, error) {
+	name, err := s.conversionType(node)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !strings.HasPrefix(name, "*") {
+		return 0, nil, fmt.Errorf("%s is not a pointer type", name)
+	}
+
+	typ, err := s.dbp.findType(strings.TrimSpace(name[1:]))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	addr, err := s.evalPointer(node.Args[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if addr == 0 {
+		return 0, nil, fmt.Errorf("nil pointer dereference")
+	}
+
+	return addr, typ, nil
+}
+
+// Returns the address held by an expression: the value of a pointer
+// variable, the address of a variable, as in &x, a number, or a
+// conversion of any of those.
+func (s *evalScope) evalPointer(t ast.Expr) (uint64, error) {
+	switch x := unparen(t).(type) {
+	case *ast.CallExpr:
+		name, err := s.conversionType(x)
+		if err != nil {
+			return 0, err
+		}
+
+		if name == "unsafe.Pointer" || name == "uintptr" || strings.HasPrefix(name, "*") {
+			return s.evalPointer(x.Args[0])
+		}
+	case *ast.UnaryExpr:
+		if x.Op == token.AND {
+			addr, _, err := s.evalAddress(x.X)
+			return addr, err
+		}
+	}
+
+	if addr, typ, err := s.evalAddress(t); err == nil {
+		if _, ok := underlyingType(typ).(*dwarf.PtrType); ok {
+			val, err := s.dbp.readMemory(uintptr(addr), 8)
+			if err != nil {
+				return 0, err
+			}
+
+			return binary.LittleEndian.Uint64(val), nil
+		}
+	}
+
+	v, err := s.evalAST(t)
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := v.constant()
+	if err != nil {
+		return 0, err
+	}
+
+	addr, ok := constant.Uint64Val(constant.ToInt(c))
+	if !ok {
+		return 0, fmt.Errorf("%s is not an address", v.Value)
+	}
+
+	return addr, nil
+}
+
+// Converts the value of an expression to the numeric type named name,
+// either a predeclared type or a type declared as one, as in
+// main.Weekday(3).
+func (s *evalScope) convertNumber(name string, t ast.Expr) (*Variable, error) {
+	var basic string
+	bits, signed, float, ok := numericType(name)
+	if !ok {
+		typ, err := s.dbp.findType(name)
+		if err != nil {
+			return nil, err
+		}
+		basic = basicType(typ)
+
+		switch t := underlyingType(typ).(type) {
+		case *dwarf.IntType:
+			bits, signed = uint(8*t.ByteSize), true
+		case *dwarf.UintType:
+			bits = uint(8 * t.ByteSize)
+		case *dwarf.FloatType:
+			bits, float = uint(8*t.ByteSize), true
+		default:
+			return nil, fmt.Errorf("can not convert to %s", name)
+		}
+	}
+
+	v, err := s.evalAST(t)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := v.constant()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Kind() != constant.Int && c.Kind() != constant.Float {
+		return nil, fmt.Errorf("can not convert %s to %s", v.Type, name)
+	}
+
+	if float {
+		f, _ := constant.Float64Val(constant.ToFloat(c))
+		if bits == 32 {
+			f = float64(float32(f))
+		}
+
+		return basicVariable(constant.MakeFloat64(f), name, basic)
+	}
+
+	// Floating point values are truncated towards zero.
+	if c.Kind() == constant.Float {
+		f, _ := constant.Float64Val(c)
+		c = constant.MakeFloat64(math.Trunc(f))
+	}
+
+	var u uint64
+	if i, ok := constant.Int64Val(constant.ToInt(c)); ok {
+		u = uint64(i)
+	} else if u, ok = constant.Uint64Val(constant.ToInt(c)); !ok {
+		return nil, fmt.Errorf("%s overflows %s", v.Value, name)
+	}
+
+	if bits < 64 {
+		u &= 1<<bits - 1
+		if signed && u&(1<<(bits-1)) != 0 {
+			u |= ^uint64(0) << bits
+		}
+	}
+
+	if signed {
+		return basicVariable(constant.MakeInt64(int64(u)), name, basic)
+	}
+
+	return basicVariable(constant.MakeUint64(u), name, basic)
+}
+
+// Returns a variable holding c, of the type named name
+// declared as the predeclared type basic.
+func basicVariable(c constant.Value, name, basic string) (*Variable, error) {
+	v, err := typedVariable(c, name)
+	if err != nil {
+		return nil, err
+	}
+	v.basic = basic
+
+	return v, nil
+}
+
+// Returns the size in bits of the predeclared numeric type named name,
+// and whether it is signed or a floating point type.
+func numericType(name string) (bits uint, signed, float, ok bool) {
+	switch name {
+	case "int", "int64":
+		return 64, true, false, true
+	case "int32", "rune":
+		return 32, true, false, true
+	case "int16":
+		return 16, true, false, true
+	case "int8":
+		return 8, true, false, true
+	case "uint", "uint64", "uintptr":
+		return 64, false, false, true
+	case "uint32":
+		return 32, false, false, true
+	case "uint16":
+		return 16, false, false, true
+	case "uint8", "byte":
+		return 8, false, false, true
+	case "float64":
+		return 64, true, true, true
+	case "float32":
+		return 32, true, true, true
+	}
+
+	return 0, false, false, false
+}
+
+func unparen(t ast.Expr) ast.Expr {
+	for {
+		p, ok := t.(*ast.ParenExpr)
+		if !ok {
+			return t
+		}
+		t = p.X
+	}
+}
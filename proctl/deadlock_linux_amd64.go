@@ -0,0 +1,405 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+	"github.com/derekparker/delve/vendor/elf"
+)
+
+// A goroutine parked on channels, or on the semaphore of a sync
+// primitive such as a sync.Mutex.
+type BlockedGoroutine struct {
+	*Goroutine
+	// Addresses of the channels or semaphores the goroutine
+	// is waiting on.
+	Objects []uint64
+	// IDs of the other goroutines whose stack refers to one of
+	// the objects, and which may therefore be the ones to wake
+	// this goroutine.
+	Holders []int
+	// Package-level variables referring to one of the objects,
+	// as in "main.jobs", through which any goroutine may wake
+	// this one.
+	Globals []string
+}
+
+const (
+	// The number of semaphore roots in the runtime's semtable.
+	semTabSize = 251
+	// Bounds the walk of runtime lists, guarding against reading
+	// them while they are being modified.
+	maxWaiters = 1 << 16
+	// A stack word refers to an object when it points at most this
+	// many bytes before it, so that a pointer to a struct containing
+	// a sync.Mutex refers to the mutex's semaphore.
+	maxReferenceOffset = 64
+	// Stacks are only searched this far above their stack pointer.
+	maxStackScan = 1 << 20
+)
+
+// Returns the goroutines blocked on channels or sync primitives, along
+// with the goroutines and package-level variables through which each
+// of them may be woken. Stacks and the data and bss sections are
+// searched for references to the objects a goroutine waits on,
+// references held in the heap are not found.
+func (dbp *DebuggedProcess) BlockedGoroutines() ([]*BlockedGoroutine, error) {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+
+	// Runtimes without a semtable have nobody waiting in it.
+	sema, _ := dbp.semaWaiters()
+
+	var blocked []*BlockedGoroutine
+	for _, g := range goroutines {
+		if g.Status != Gwaiting {
+			continue
+		}
+
+		objects, err := dbp.channelWaits(g.Addr, gtype)
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, sema[g.Addr]...)
+		if len(objects) == 0 {
+			continue
+		}
+
+		blocked = append(blocked, &BlockedGoroutine{Goroutine: g, Objects: objects})
+	}
+
+	for _, g := range goroutines {
+		stack, err := dbp.stackWords(g, gtype)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range blocked {
+			if b.ID != g.ID && referencesAny(stack, b.Objects) {
+				b.Holders = append(b.Holders, g.ID)
+			}
+		}
+	}
+
+	err = dbp.findGlobalReferences(blocked)
+	if err != nil {
+		return nil, err
+	}
+
+	return blocked, nil
+}
+
+// Returns the blocked goroutines which can never be woken: the largest
+// set of goroutines only referenced by goroutines of the set itself.
+// Goroutines referenced by a package-level variable may be woken by any
+// goroutine, and those referenced by no goroutine may well be referenced
+// from the heap, which is not searched, so neither is part of the set.
+func Deadlocked(blocked []*BlockedGoroutine) []*BlockedGoroutine {
+	stuck := make(map[int]bool, len(blocked))
+	for _, b := range blocked {
+		stuck[b.ID] = len(b.Holders) > 0 && len(b.Globals) == 0
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range blocked {
+			if !stuck[b.ID] {
+				continue
+			}
+
+			for _, h := range b.Holders {
+				if !stuck[h] {
+					stuck[b.ID] = false
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var deadlocked []*BlockedGoroutine
+	for _, b := range blocked {
+		if stuck[b.ID] {
+			deadlocked = append(deadlocked, b)
+		}
+	}
+
+	return deadlocked
+}
+
+// Returns the channels the goroutine whose g struct is at gaddr is
+// parked on, from the list of sudogs the runtime keeps in g.waiting
+// while the goroutine is in a channel operation or a select.
+func (dbp *DebuggedProcess) channelWaits(gaddr uint64, gtype *dwarf.StructType) ([]uint64, error) {
+	sudog, err := dbp.readUintField(gaddr, gtype, "waiting")
+	if err != nil || sudog == 0 {
+		// Older runtimes do not record it.
+		return nil, nil
+	}
+
+	stype, err := dbp.findStructType("runtime.sudog")
+	if err != nil {
+		return nil, err
+	}
+
+	var chans []uint64
+	for i := 0; sudog != 0 && i < maxWaiters; i++ {
+		c, err := dbp.readUintField(sudog, stype, "c")
+		if err != nil {
+			return nil, err
+		}
+
+		if c != 0 {
+			chans = append(chans, c)
+		}
+
+		sudog, err = dbp.readUintField(sudog, stype, "waitlink")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chans, nil
+}
+
+// Returns the semaphore addresses goroutines are waiting on, by the
+// address of their g struct. Waiters are queued in the treaps of the
+// runtime's semtable, each node heading the list of the sudogs
+// waiting on one address.
+func (dbp *DebuggedProcess) semaWaiters() (map[uint64][]uint64, error) {
+	table, err := dbp.elfSymbol("runtime.semtable")
+	if err != nil {
+		return nil, err
+	}
+
+	rtype, err := dbp.findStructType("runtime.semaRoot")
+	if err != nil {
+		return nil, err
+	}
+
+	treap, err := structField(rtype, "treap")
+	if err != nil {
+		return nil, err
+	}
+
+	stype, err := dbp.findStructType("runtime.sudog")
+	if err != nil {
+		return nil, err
+	}
+
+	waiters := make(map[uint64][]uint64)
+	seen := make(map[uint64]bool)
+
+	// Every entry of the table begins with its semaRoot.
+	entrySize := table.Size / semTabSize
+	for i := uint64(0); i < semTabSize; i++ {
+		root, err := dbp.readUint64(uintptr(table.Value + i*entrySize + uint64(treap.ByteOffset)))
+		if err != nil {
+			return nil, err
+		}
+
+		nodes := []uint64{root}
+		for len(nodes) != 0 && len(seen) < maxWaiters {
+			node := nodes[len(nodes)-1]
+			nodes = nodes[:len(nodes)-1]
+			if node == 0 || seen[node] {
+				continue
+			}
+			seen[node] = true
+
+			addr, err := dbp.readUintField(node, stype, "elem")
+			if err != nil {
+				return nil, err
+			}
+
+			for s, n := node, 0; s != 0 && n < maxWaiters; n++ {
+				g, err := dbp.readUintField(s, stype, "g")
+				if err != nil {
+					return nil, err
+				}
+				waiters[g] = append(waiters[g], addr)
+
+				s, err = dbp.readUintField(s, stype, "waitlink")
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			// The children of a treap node.
+			for _, child := range []string{"prev", "next"} {
+				c, err := dbp.readUintField(node, stype, child)
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, c)
+			}
+		}
+	}
+
+	return waiters, nil
+}
+
+// Returns the words of the used part of a goroutine's stack.
+func (dbp *DebuggedProcess) stackWords(g *Goroutine, gtype *dwarf.StructType) ([]uint64, error) {
+	stack, err := structField(gtype, "stack")
+	if err != nil {
+		return nil, err
+	}
+
+	stype, ok := stack.Type.(*dwarf.StructType)
+	if !ok {
+		return nil, nil
+	}
+
+	hi, err := dbp.readUintField(g.Addr+uint64(stack.ByteOffset), stype, "hi")
+	if err != nil {
+		return nil, err
+	}
+
+	sp := g.SP
+	if cur, err := dbp.currentG(); err == nil && cur == g.Addr {
+		regs, err := dbp.Registers()
+		if err != nil {
+			return nil, err
+		}
+		sp = regs.Rsp
+	}
+
+	if sp == 0 || sp >= hi {
+		return nil, nil
+	}
+
+	n := hi - sp
+	if n > maxStackScan {
+		n = maxStackScan
+	}
+
+	data, err := dbp.readMemory(uintptr(sp), uintptr(n))
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]uint64, len(data)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+
+	return words, nil
+}
+
+// Records the package-level variables of the executable and of every
+// plugin which refer to the objects blocked goroutines wait on.
+func (dbp *DebuggedProcess) findGlobalReferences(blocked []*BlockedGoroutine) error {
+	waiters := make(map[uint64][]*BlockedGoroutine)
+	for _, b := range blocked {
+		for _, obj := range b.Objects {
+			waiters[obj] = append(waiters[obj], b)
+		}
+	}
+
+	if len(waiters) == 0 {
+		return nil
+	}
+
+	objects := make([]uint64, 0, len(waiters))
+	for obj := range waiters {
+		objects = append(objects, obj)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i] < objects[j] })
+
+	type segment struct {
+		exe  *elf.File
+		bias uint64
+	}
+
+	segments := []segment{{dbp.Executable, 0}}
+	for _, p := range dbp.Plugins {
+		segments = append(segments, segment{p.Executable, p.Bias})
+	}
+
+	for _, seg := range segments {
+		for _, name := range []string{".data", ".bss", ".noptrdata", ".noptrbss"} {
+			sec := seg.exe.Section(name)
+			if sec == nil {
+				continue
+			}
+			start := sec.Addr + seg.bias
+
+			// Objects which are variables themselves, such
+			// as a sync.Mutex declared at package level.
+			for _, obj := range objects {
+				if obj >= start && obj < start+sec.Size {
+					for _, b := range waiters[obj] {
+						b.addGlobal(dbp.describeGlobal(obj))
+					}
+				}
+			}
+
+			// Variables without pointers are in .noptrdata
+			// and .noptrbss, which need not be searched.
+			if name != ".data" && name != ".bss" {
+				continue
+			}
+
+			data, err := dbp.readMemory(uintptr(start), uintptr(sec.Size))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i+8 <= len(data); i += 8 {
+				w := binary.LittleEndian.Uint64(data[i:])
+
+				// The objects the word may point into.
+				j := sort.Search(len(objects), func(j int) bool { return objects[j] >= w })
+				for ; j < len(objects) && objects[j]-w <= maxReferenceOffset; j++ {
+					for _, b := range waiters[objects[j]] {
+						b.addGlobal(dbp.describeGlobal(start + uint64(i)))
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Names the package-level variable at addr, as in "main.config+0x8".
+func (dbp *DebuggedProcess) describeGlobal(addr uint64) string {
+	if v := dbp.dataSymbolAt(addr); v != "" {
+		return v
+	}
+
+	return fmt.Sprintf("%#x", addr)
+}
+
+func (b *BlockedGoroutine) addGlobal(v string) {
+	for _, g := range b.Globals {
+		if g == v {
+			return
+		}
+	}
+
+	b.Globals = append(b.Globals, v)
+}
+
+func referencesAny(words, objects []uint64) bool {
+	for _, w := range words {
+		for _, obj := range objects {
+			if w <= obj && obj-w <= maxReferenceOffset {
+				return true
+			}
+		}
+	}
+
+	return false
+}
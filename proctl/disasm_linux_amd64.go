@@ -0,0 +1,62 @@
+package proctl
+
+import "golang.org/x/arch/x86/x86asm"
+
+// A machine instruction of the process, along with
+// the source line it was compiled from.
+type AsmInstruction struct {
+	PC    uint64
+	File  string
+	Line  int
+	Bytes []byte
+	// The instruction in Go assembly syntax, as in "CALL main.bump(SB)",
+	// or "?" for bytes which do not decode to an instruction.
+	Text string
+	// Whether a breakpoint is set on the instruction. The
+	// instruction is the one the breakpoint replaced.
+	Breakpoint bool
+}
+
+// Returns the instructions of the process from start up to end,
+// decoded as x86-64 code.
+func (dbp *DebuggedProcess) Disassemble(start, end uint64) ([]AsmInstruction, error) {
+	mem, err := dbp.ReadMemory(start, int(end-start))
+	if err != nil {
+		return nil, err
+	}
+
+	insts := make([]AsmInstruction, 0, len(mem)/4)
+	for off := 0; off < len(mem); {
+		pc := start + uint64(off)
+		f, l, _ := dbp.PCToLine(pc)
+
+		_, bp := dbp.BreakPoints[pc]
+		inst := AsmInstruction{PC: pc, File: f, Line: l, Breakpoint: bp}
+
+		decoded, err := x86asm.Decode(mem[off:], 64)
+		if err != nil {
+			inst.Bytes, inst.Text = mem[off:off+1], "?"
+			insts = append(insts, inst)
+			off++
+			continue
+		}
+
+		inst.Bytes = mem[off : off+decoded.Len]
+		inst.Text = x86asm.GoSyntax(decoded, pc, dbp.symbolAt)
+		insts = append(insts, inst)
+		off += decoded.Len
+	}
+
+	return insts, nil
+}
+
+// Returns the name and start of the function addr is in, so that
+// the targets of calls and jumps are disassembled as functions.
+func (dbp *DebuggedProcess) symbolAt(addr uint64) (string, uint64) {
+	_, _, fn := dbp.PCToLine(addr)
+	if fn == nil {
+		return "", 0
+	}
+
+	return fn.Name, fn.Entry
+}
@@ -0,0 +1,393 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Continuing from a breakpoint requires executing the instruction the
+// breakpoint replaced. Rather than restoring it in place, which leaves a
+// window during which other threads run through the location without
+// stopping, and requires keeping them stopped while the thread steps,
+// the instruction is copied to a page of its own and executed there:
+// displaced stepping. The breakpoint stays in memory, and the thread is
+// single stepped out of line while every other thread runs.
+//
+// Once the copy has executed, the program counter is moved back into the
+// function, relative jumps and calls being taken into account, and
+// return addresses pushed by calls are corrected. Instructions reading
+// memory relative to the program counter have their displacement
+// adjusted to address the same memory from the copy.
+
+// Size of the page displaced instructions are executed from.
+const displacedPageSize = 4096
+
+// A thread stepping over a breakpoint by executing a copy of the
+// instruction the breakpoint replaced.
+type displacedStep struct {
+	// Address of the breakpoint, and of the copy of its instruction.
+	addr, copy uint64
+	inst       x86asm.Inst
+	// Signal received before the copy was executed, delivered once
+	// it has been.
+	sig int
+}
+
+// Prepares the current thread, stopped at the breakpoint bp, to step over
+// it out of line the next time it is resumed. Returns false when the
+// instruction can not be executed out of line, and the thread must step
+// over the breakpoint in place, with StepInstruction.
+func (dbp *DebuggedProcess) displaceStep(bp *BreakPoint) (bool, error) {
+	th := dbp.CurrentThread
+
+	code, err := dbp.readMemory(uintptr(bp.Addr), maxInstructionLength)
+	if err != nil {
+		return false, nil
+	}
+	code[0] = bp.OriginalData[0]
+
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil || !displaceable(inst) {
+		return false, nil
+	}
+
+	page, err := dbp.displacedPage()
+	if err != nil {
+		// Stepping in place still works.
+		return false, nil
+	}
+
+	code = code[:inst.Len]
+	if isMemPCRel(inst) {
+		// The memory operand is addressed relative to the
+		// end of the instruction, which moves with it.
+		at := code[inst.PCRelOff:]
+		disp := int64(int32(binary.LittleEndian.Uint32(at))) + int64(bp.Addr) - int64(page)
+		if disp != int64(int32(disp)) {
+			return false, nil
+		}
+		binary.LittleEndian.PutUint32(at, uint32(int32(disp)))
+	}
+
+	err = dbp.writeMemory(uintptr(page), code)
+	if err != nil {
+		return false, err
+	}
+
+	regs, err := th.Registers()
+	if err != nil {
+		return false, err
+	}
+
+	regs.SetPC(page)
+	err = syscall.PtraceSetRegs(th.Id, regs)
+	if err != nil {
+		return false, err
+	}
+
+	th.displaced = &displacedStep{addr: bp.Addr, copy: page, inst: inst}
+
+	return true, nil
+}
+
+// Reports whether inst behaves the same executed from another address,
+// once fixed up. System calls are left out: they may block, or, as
+// clone does, start other threads at the address following the copy.
+func displaceable(inst x86asm.Inst) bool {
+	switch inst.Op {
+	case x86asm.SYSCALL, x86asm.SYSENTER, x86asm.INT, x86asm.INTO, x86asm.UD1, x86asm.UD2, x86asm.HLT:
+		return false
+	}
+
+	// Program counter relative operands other than the memory
+	// operands and branch targets handled are not expected.
+	return inst.PCRel == 0 || isMemPCRel(inst) && inst.PCRel == 4 || isRelBranch(inst)
+}
+
+// Reports whether inst has a memory operand addressed
+// relative to the program counter.
+func isMemPCRel(inst x86asm.Inst) bool {
+	for _, arg := range inst.Args {
+		if m, ok := arg.(x86asm.Mem); ok && m.Base == x86asm.RIP {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether inst is a jump or call to a
+// target relative to the program counter.
+func isRelBranch(inst x86asm.Inst) bool {
+	for _, arg := range inst.Args {
+		if _, ok := arg.(x86asm.Rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handles the thread stopping while it steps over a breakpoint out of
+// line. Once the copy of the instruction has executed, its registers and
+// stack are fixed up, and sig is the signal to resume it with, one which
+// arrived meanwhile. A signal arriving before that is deferred, except
+// for faults raised by the instruction, which are reported from the
+// address of the breakpoint. Report is true when the stop must be
+// reported rather than the thread resumed: the instruction faulted, or
+// wrote to watched memory.
+func (dbp *DebuggedProcess) displacedStepStopped(th *ThreadContext, ps *syscall.WaitStatus) (sig int, report bool, err error) {
+	d := th.displaced
+
+	regs, err := th.Registers()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch s := ps.StopSignal(); {
+	case s == syscall.SIGTRAP:
+		err = dbp.finishDisplacedStep(th, regs)
+		if err != nil {
+			return 0, false, err
+		}
+
+		hit, err := dbp.threadWatchpointHit(th)
+		return d.sig, hit, err
+
+	case regs.PC() == d.copy && (s == syscall.SIGSEGV || s == syscall.SIGBUS || s == syscall.SIGFPE || s == syscall.SIGILL):
+		th.displaced = nil
+		regs.SetPC(d.addr)
+		return int(s), true, syscall.PtraceSetRegs(th.Id, regs)
+
+	case s == syscall.SIGSTOP && th.stops > 0:
+		th.stops--
+
+	default:
+		d.sig = int(s)
+	}
+
+	return 0, false, nil
+}
+
+// Moves the thread back into the function once the copy of the
+// instruction it stepped over has executed.
+func (dbp *DebuggedProcess) finishDisplacedStep(th *ThreadContext, regs *syscall.PtraceRegs) error {
+	d := th.displaced
+	th.displaced = nil
+
+	next := d.copy + uint64(d.inst.Len)
+	ret := d.addr + uint64(d.inst.Len)
+
+	pc := regs.PC()
+	switch {
+	case pc == next:
+		regs.SetPC(ret)
+	case isRelBranch(d.inst):
+		// The target was computed relative to the copy.
+		regs.SetPC(pc - d.copy + d.addr)
+	}
+
+	if d.inst.Op == x86asm.CALL {
+		// The thread may not be the current thread, which
+		// may be running: its memory is accessed through it.
+		buf, err := dbp.readThreadMemory(th.Id, uintptr(regs.Rsp), 8)
+		if err != nil {
+			return err
+		}
+
+		if binary.LittleEndian.Uint64(buf) == next {
+			binary.LittleEndian.PutUint64(buf, ret)
+			_, err = syscall.PtracePokeData(th.Id, uintptr(regs.Rsp), buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return syscall.PtraceSetRegs(th.Id, regs)
+}
+
+// Single steps the stopped threads which have yet to step over their
+// breakpoint out of line, so that no thread is left stopped in the copy
+// of an instruction. Signals they receive meanwhile are delivered when
+// they are next resumed.
+func (dbp *DebuggedProcess) finishDisplacedSteps() error {
+	for _, th := range dbp.Threads {
+		for th.displaced != nil {
+			err := syscall.PtraceSingleStep(th.Id)
+			if err != nil {
+				return err
+			}
+
+			ps, err := dbp.waitThread(th.Id)
+			if err != nil {
+				return err
+			}
+
+			if ps == nil || ps.Exited() || ps.Signaled() {
+				dbp.removeThread(th.Id)
+				break
+			}
+			th.Status = ps
+
+			sig, _, err := dbp.displacedStepStopped(th, ps)
+			if err != nil {
+				return err
+			}
+
+			if sig != 0 {
+				th.sig = sig
+			}
+		}
+	}
+
+	return nil
+}
+
+// Resumes a stopped thread, delivering sig, or a signal deferred earlier
+// when sig is zero. A thread stepping over a breakpoint out of line is
+// single stepped instead, and the signal deferred until it is done.
+func (dbp *DebuggedProcess) resumeThread(th *ThreadContext, sig int) error {
+	if th.displaced != nil {
+		if sig != 0 {
+			th.displaced.sig = sig
+		}
+
+		return syscall.PtraceSingleStep(th.Id)
+	}
+
+	if sig == 0 {
+		sig, th.sig = th.sig, 0
+	}
+
+	return syscall.PtraceCont(th.Id, sig)
+}
+
+// Returns the address of the page instructions are executed out of line
+// from, mapping it into the process the first time. The page is placed
+// just below the executable where possible, so that the memory operands
+// of most instructions remain in reach once their displacement has been
+// adjusted.
+func (dbp *DebuggedProcess) displacedPage() (uint64, error) {
+	if dbp.displacedAddr != 0 || dbp.displacedErr != nil {
+		return dbp.displacedAddr, dbp.displacedErr
+	}
+
+	// The kernel picks another address when the hint is taken.
+	var hint uint64
+	if regions, err := dbp.MemoryMap(); err == nil {
+		for _, r := range regions {
+			if r.Start <= dbp.Executable.Entry && dbp.Executable.Entry < r.End && r.Start > displacedPageSize {
+				hint = r.Start - displacedPageSize
+			}
+		}
+	}
+
+	dbp.displacedAddr, dbp.displacedErr = dbp.mmapCode(hint)
+
+	return dbp.displacedAddr, dbp.displacedErr
+}
+
+// Maps a page of memory which may be executed into the process, at hint
+// if that address is free, by having the current thread make the mmap
+// system call. The page can not be written to by the process, ptrace
+// writes to it regardless. The instruction making it is written over the entry point
+// of the executable, which only runs when the process starts, while
+// every thread is stopped, then restored along with the registers.
+func (dbp *DebuggedProcess) mmapCode(hint uint64) (uint64, error) {
+	th := dbp.CurrentThread
+	entry := uintptr(dbp.Executable.Entry)
+
+	saved, err := th.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	orig := make([]byte, 2)
+	_, err = syscall.PtracePeekData(th.Id, entry, orig)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = syscall.PtracePokeData(th.Id, entry, []byte{0x0f, 0x05}) // SYSCALL
+	if err != nil {
+		return 0, err
+	}
+
+	regs := *saved
+	regs.SetPC(uint64(entry))
+	regs.Orig_rax = ^uint64(0)
+	regs.Rax = syscall.SYS_MMAP
+	regs.Rdi = hint
+	regs.Rsi = displacedPageSize
+	regs.Rdx = syscall.PROT_READ | syscall.PROT_EXEC
+	regs.R10 = syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	regs.R8 = ^uint64(0)
+	regs.R9 = 0
+
+	addr, err := dbp.runSyscall(th, &regs)
+
+	_, perr := syscall.PtracePokeData(th.Id, entry, orig)
+	serr := syscall.PtraceSetRegs(th.Id, saved)
+	switch {
+	case err != nil:
+		return 0, err
+	case perr != nil:
+		return 0, perr
+	case serr != nil:
+		return 0, serr
+	}
+
+	// Errors are returned as negated error numbers.
+	if errno := -int64(addr); errno > 0 && errno < 4096 {
+		return 0, fmt.Errorf("could not map memory to step over breakpoints: %s", syscall.Errno(errno))
+	}
+
+	return addr, nil
+}
+
+// Single steps the thread over the system call instruction its registers
+// point to, returning the result of the call. Signals arriving meanwhile
+// are delivered when the thread is next resumed.
+func (dbp *DebuggedProcess) runSyscall(th *ThreadContext, regs *syscall.PtraceRegs) (uint64, error) {
+	err := syscall.PtraceSetRegs(th.Id, regs)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		err = syscall.PtraceSingleStep(th.Id)
+		if err != nil {
+			return 0, err
+		}
+
+		ps, err := dbp.waitThread(th.Id)
+		if err != nil {
+			return 0, err
+		}
+
+		if ps == nil || ps.Exited() || ps.Signaled() {
+			return 0, fmt.Errorf("thread %d exited", th.Id)
+		}
+
+		if ps.StopSignal() == syscall.SIGTRAP {
+			break
+		}
+
+		if ps.StopSignal() != syscall.SIGSTOP || th.stops == 0 {
+			th.sig = int(ps.StopSignal())
+		} else {
+			th.stops--
+		}
+	}
+
+	result, err := th.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Rax, nil
+}
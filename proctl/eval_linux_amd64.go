@@ -0,0 +1,1082 @@
+package proctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+	"syscall"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Register names are not valid Go identifiers, so before parsing an
+// expression the "$" of every one is replaced with this prefix.
+const registerPrefix = "__dlv_reg_"
+
+// Likewise a variable shadowed by another of the same name, referred to
+// as in a@1 for the one declared a scope out, is parsed as an identifier
+// with the "@" replaced with this separator.
+const shadowedSeparator = "__dlv_outer_"
+
+// Largest shift of an untyped constant, as in the go/types package.
+const maxConstantShift = 1023
+
+// Evaluates a Go expression in the context of the current frame.
+// Expressions may reference variables, fields and elements of them as
+// in a.b[2], package-level variables as main.config or
+// net/http.DefaultServeMux, registers as $rax, $pc or $sp, constants,
+// and combine them with arithmetic, comparison and logical operators.
+func (dbp *DebuggedProcess) EvalExpression(expr string) (*Variable, error) {
+	return dbp.evalExpression(expr, nil, nil)
+}
+
+// Evaluates expr with variables read relative to frame, one of the frames
+// returned by Stacktrace. Registers are always those of the current thread.
+func (dbp *DebuggedProcess) EvalExpressionInFrame(expr string, frame *StackFrame) (*Variable, error) {
+	return dbp.evalExpression(expr, frame, nil)
+}
+
+// Holds what an expression is evaluated against: the process, the frame
+// variables are read from, nil for the innermost one, and any pseudo
+// variables, such as the hit count of a breakpoint, visible to the
+// expression in addition to the variables of the program, along with
+// the source of the expression being evaluated.
+type evalScope struct {
+	dbp    *DebuggedProcess
+	frame  *StackFrame
+	idents map[string]*Variable
+	src    string
+}
+
+// Evaluates expr in frame with the given pseudo variables in scope. Pseudo
+// variables take precedence over program variables of the same name.
+func (dbp *DebuggedProcess) evalExpression(expr string, frame *StackFrame, idents map[string]*Variable) (*Variable, error) {
+	t, err := parseExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &evalScope{dbp: dbp, frame: frame, idents: idents, src: exprSource(expr)}
+	v, err := scope.evalAST(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Name == "" {
+		v.Name = expr
+	}
+
+	// Integers computed by the expression are printed
+	// in the same base as those read from memory.
+	if c, err := v.constant(); err == nil && c.Kind() == constant.Int && dbp.IntegerBase != 0 {
+		if n, ok := constant.Int64Val(c); ok {
+			v.Value = dbp.formatInt(n)
+		} else if n, ok := constant.Uint64Val(c); ok {
+			v.Value = dbp.formatUint(n)
+		}
+	}
+
+	return v, nil
+}
+
+func parseExpression(expr string) (ast.Expr, error) {
+	t, err := parser.ParseExpr(exprSource(expr))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse expression %q: %s", expr, err)
+	}
+
+	return t, nil
+}
+
+// Returns the source actually parsed for expr, which
+// positions in the parsed expression refer to. Only the "$"
+// tokens of register names and the "@" tokens of shadowed
+// variables are replaced, string and character literals are
+// left as they are.
+func exprSource(expr string) string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(expr))
+
+	// Errors are left for the parser to report.
+	var s scanner.Scanner
+	s.Init(file, []byte(expr), nil, 0)
+
+	var (
+		src  bytes.Buffer
+		last int
+		// The end of the previous token, and the offset of an
+		// "@" right after an identifier.
+		prevEnd int
+		prevTok token.Token
+		at      = -1
+	)
+	for {
+		pos, tok, lit := s.Scan()
+		off := file.Offset(pos)
+
+		// a@1 is written without spaces.
+		if at >= 0 && tok == token.INT && off == at+1 {
+			src.WriteString(expr[last:at])
+			src.WriteString(shadowedSeparator)
+			last = at + 1
+		}
+		at = -1
+
+		if tok == token.EOF {
+			break
+		}
+
+		switch {
+		case tok == token.ILLEGAL && lit == "$":
+			src.WriteString(expr[last:off])
+			src.WriteString(registerPrefix)
+			last = off + len(lit)
+		case tok == token.ILLEGAL && lit == "@" && prevTok == token.IDENT && prevEnd == off:
+			at = off
+		}
+
+		prevTok, prevEnd = tok, off+len(lit)
+	}
+	src.WriteString(expr[last:])
+
+	return src.String()
+}
+
+func (s *evalScope) evalAST(t ast.Expr) (*Variable, error) {
+	switch node := t.(type) {
+	case *ast.ParenExpr:
+		return s.evalAST(node.X)
+	case *ast.Ident:
+		return s.evalIdent(node.Name)
+	case *ast.BasicLit:
+		return constantVariable(constant.MakeFromLiteral(node.Value, node.Kind, 0))
+	case *ast.UnaryExpr:
+		if node.Op == token.AND {
+			return s.evalAddressOf(node)
+		}
+		return s.evalUnary(node)
+	case *ast.BinaryExpr:
+		// A package path parses as a chain of divisions.
+		if node.Op == token.QUO {
+			if v, err := s.evalLocation(node); err == nil {
+				return v, nil
+			}
+		}
+		return s.evalBinary(node)
+	case *ast.SelectorExpr, *ast.StarExpr, *ast.IndexExpr:
+		return s.evalLocation(node)
+	case *ast.SliceExpr:
+		return s.evalSlice(node)
+	case *ast.CallExpr:
+		return s.evalConversion(node)
+	}
+
+	return nil, fmt.Errorf("expression %T not supported", t)
+}
+
+func (s *evalScope) evalIdent(name string) (*Variable, error) {
+	if strings.HasPrefix(name, registerPrefix) {
+		return s.dbp.registerVariable(strings.TrimPrefix(name, registerPrefix))
+	}
+
+	switch name {
+	case "true", "false":
+		return &Variable{Name: name, Type: "bool", Value: name}, nil
+	}
+
+	if v, ok := s.idents[name]; ok {
+		return v, nil
+	}
+
+	return s.dbp.EvalSymbolInFrame(name, s.frame)
+}
+
+// Evaluates the address of what an expression designates, as in &x or
+// &a.b[2], to a pointer printed as the address it holds.
+func (s *evalScope) evalAddressOf(node *ast.UnaryExpr) (*Variable, error) {
+	addr, typ, err := s.evalAddress(node.X)
+	if err != nil {
+		name, _ := s.text(node.X)
+		return nil, fmt.Errorf("can not take the address of %s: %s", name, err)
+	}
+
+	return &Variable{Type: "*" + goTypeName(typ), Value: fmt.Sprintf("%#x", addr)}, nil
+}
+
+func (s *evalScope) evalUnary(node *ast.UnaryExpr) (*Variable, error) {
+	x, err := s.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+
+	cx, err := x.constant()
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Op == token.NOT && cx.Kind() != constant.Bool {
+		return nil, fmt.Errorf("operator ! not defined on %s", x.Type)
+	}
+
+	var v constant.Value
+	func() {
+		defer func() {
+			if recover() != nil {
+				err = fmt.Errorf("operator %s not defined on %s", node.Op, x.Type)
+			}
+		}()
+		v = constant.UnaryOp(node.Op, cx, 0)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	return basicVariable(v, x.Type, x.basic)
+}
+
+func (s *evalScope) evalBinary(node *ast.BinaryExpr) (*Variable, error) {
+	x, err := s.evalAST(node.X)
+	if err != nil {
+		return nil, err
+	}
+
+	cx, err := x.constant()
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit logical operators as Go does.
+	if node.Op == token.LAND || node.Op == token.LOR {
+		if cx.Kind() != constant.Bool {
+			return nil, fmt.Errorf("operator %s not defined on %s", node.Op, x.Type)
+		}
+
+		if constant.BoolVal(cx) == (node.Op == token.LOR) {
+			return constantVariable(cx)
+		}
+	}
+
+	y, err := s.evalAST(node.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	cy, err := y.constant()
+	if err != nil {
+		return nil, err
+	}
+
+	// Integer division truncates, as it does in Go.
+	op := node.Op
+	if op == token.QUO && cx.Kind() == constant.Int && cy.Kind() == constant.Int {
+		op = token.QUO_ASSIGN
+	}
+
+	var shift uint64
+	switch op {
+	case token.QUO, token.QUO_ASSIGN, token.REM:
+		if k := cy.Kind(); (k == constant.Int || k == constant.Float) && constant.Sign(cy) == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+	case token.SHL, token.SHR:
+		n := constant.ToInt(cy)
+		if n.Kind() != constant.Int || constant.Sign(n) < 0 {
+			return nil, fmt.Errorf("invalid shift count %s", cy)
+		}
+
+		shift, _ = constant.Uint64Val(n)
+		if !x.untyped() {
+			// Typed operands are at most 64 bits wide: shifted by
+			// 64 bits or more, all their bits are shifted out.
+			if shift > 64 {
+				shift = 64
+			}
+		} else if constant.Compare(n, token.GTR, constant.MakeUint64(maxConstantShift)) {
+			return nil, fmt.Errorf("shift count %s too large", cy)
+		}
+	}
+
+	var v constant.Value
+	func() {
+		defer func() {
+			if recover() != nil {
+				err = fmt.Errorf("operator %s not defined on %s and %s", node.Op, x.Type, y.Type)
+			}
+		}()
+
+		switch op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			v = constant.MakeBool(constant.Compare(cx, op, cy))
+		case token.SHL, token.SHR:
+			v = constant.Shift(cx, op, uint(shift))
+			if op == token.SHL && shift == 64 && !x.untyped() {
+				v = constant.MakeInt64(0)
+			}
+		default:
+			v = constant.BinaryOp(cx, op, cy)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	typ, basic := x.Type, x.basic
+	switch {
+	case x.untyped() && !y.untyped():
+		typ, basic = y.Type, y.basic
+	case x.untyped() && y.Type == "untyped float":
+		typ, basic = y.Type, y.basic
+	}
+
+	r, err := typedVariable(v, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comparisons are of type bool whatever
+	// the type of their operands.
+	if r.Type == typ {
+		r.basic = basic
+	}
+
+	return r, nil
+}
+
+// Evaluates an expression designating a value in memory, such as
+// a field of a struct or an element of an array or slice.
+func (s *evalScope) evalLocation(t ast.Expr) (*Variable, error) {
+	addr, typ, err := s.evalAddress(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.dbp.newVariable("", int64(addr), typ)
+}
+
+// Returns the address and type of what an expression designates: a
+// variable, a field of a struct, an element of an array or slice, or
+// the value a pointer points to. Pointers to structs, arrays and slices
+// are followed when selecting fields or indexing, however many there
+// are on the way, as in a.b.c where a and b are pointers.
+func (s *evalScope) evalAddress(t ast.Expr) (uint64, dwarf.Type, error) {
+	switch node := t.(type) {
+	case *ast.ParenExpr:
+		return s.evalAddress(node.X)
+	case *ast.Ident:
+		if strings.HasPrefix(node.Name, registerPrefix) {
+			return 0, nil, fmt.Errorf("registers have no address")
+		}
+
+		entry, data, cfa, err := s.dbp.lookupSymbol(node.Name, s.frame)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		addr, typ, err := variableLocation(entry, data, cfa)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return uint64(addr), typ, nil
+	case *ast.StarExpr:
+		switch x := unparen(node.X).(type) {
+		case *ast.CallExpr:
+			return s.convertPointer(x)
+		case *ast.UnaryExpr:
+			if x.Op == token.AND {
+				return s.evalAddress(x.X)
+			}
+		}
+
+		addr, typ, err := s.evalAddress(node.X)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		ptr, ok := underlyingType(typ).(*dwarf.PtrType)
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid indirect of %s", typ)
+		}
+
+		return s.dbp.deref(addr, ptr)
+	case *ast.SelectorExpr:
+		addr, typ, err := s.evalAddress(node.X)
+		if err != nil {
+			// Not a field of a variable, but perhaps
+			// a package-level variable, as in main.config.
+			if addr, typ, gerr := s.evalGlobal(node); gerr == nil {
+				return addr, typ, nil
+			}
+			return 0, nil, err
+		}
+
+		addr, typ, err = s.dbp.derefAll(addr, typ)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		st, ok := underlyingType(typ).(*dwarf.StructType)
+		if !ok {
+			return 0, nil, fmt.Errorf("%s has no field %s", typ, node.Sel.Name)
+		}
+
+		return s.dbp.selectField(addr, st, node.Sel.Name)
+	case *ast.IndexExpr:
+		addr, typ, err := s.evalAddress(node.X)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		addr, typ, err = s.dbp.derefAll(addr, typ)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		i, err := s.evalIndex(node.Index)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch t := underlyingType(typ).(type) {
+		case *dwarf.ArrayType:
+			if i >= uint64(t.Count) {
+				return 0, nil, fmt.Errorf("index %d out of range for %s", i, typ)
+			}
+			return addr + i*uint64(t.Type.Size()), t.Type, nil
+		case *dwarf.StructType:
+			if strings.HasPrefix(t.StructName, "[]") {
+				return s.dbp.sliceElem(addr, t, i)
+			}
+		}
+
+		return 0, nil, fmt.Errorf("%s can not be indexed", typ)
+	case *ast.BinaryExpr:
+		if node.Op == token.QUO {
+			return s.evalGlobal(node)
+		}
+	case *ast.CallExpr:
+		// Like pointers, a conversion to a pointer designates
+		// the value pointed to when selecting fields or indexing.
+		return s.convertPointer(node)
+	}
+
+	return 0, nil, fmt.Errorf("expression %T does not designate a variable", t)
+}
+
+// Returns the address and type of the package-level variable named by
+// an expression, such as main.config, or net/http.DefaultServeMux which
+// parses as a division. Package paths never contain spaces, divisions
+// written with them are not looked up.
+func (s *evalScope) evalGlobal(t ast.Expr) (uint64, dwarf.Type, error) {
+	name, ok := s.text(t)
+	if !ok {
+		return 0, nil, fmt.Errorf("expression %T does not designate a variable", t)
+	}
+
+	if strings.ContainsAny(name, " \t\n") || strings.Contains(name, registerPrefix) {
+		return 0, nil, fmt.Errorf("%s does not designate a variable", name)
+	}
+
+	return s.dbp.globalAddress(name)
+}
+
+// Returns the source text of part of the expression being evaluated.
+func (s *evalScope) text(t ast.Expr) (string, bool) {
+	start, end := int(t.Pos())-1, int(t.End())-1
+	if start < 0 || end > len(s.src) || start > end {
+		return "", false
+	}
+
+	return s.src[start:end], true
+}
+
+// Evaluates a slice expression, such as buf[100:132], on an array, a
+// slice or a string. The bounds are checked against the length of the
+// array or string, or against the capacity of the slice, as in Go.
+func (s *evalScope) evalSlice(node *ast.SliceExpr) (*Variable, error) {
+	if node.Slice3 {
+		return nil, fmt.Errorf("3-index slices are not supported")
+	}
+
+	addr, typ, err := s.evalAddress(node.X)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, typ, err = s.dbp.derefAll(addr, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		base, n, max uint64
+		elem         dwarf.Type
+		str          bool
+		name         = typ.String()
+	)
+
+	switch t := underlyingType(typ).(type) {
+	case *dwarf.ArrayType:
+		base, n, max, elem = addr, uint64(t.Count), uint64(t.Count), t.Type
+		name = "[]" + t.Type.String()
+	case *dwarf.StructType:
+		switch {
+		case t.StructName == "string":
+			str = true
+			base, err = s.dbp.readUintField(addr, t, "str")
+			if err != nil {
+				return nil, err
+			}
+
+			n, err = s.dbp.readUintField(addr, t, "len")
+			if err != nil {
+				return nil, err
+			}
+			max = n
+		case strings.HasPrefix(t.StructName, "[]"):
+			array, err := structField(t, "array")
+			if err != nil {
+				return nil, err
+			}
+			elem = pointee(array.Type)
+
+			base, err = s.dbp.readUintField(addr, t, "array")
+			if err != nil {
+				return nil, err
+			}
+
+			n, err = s.dbp.readUintField(addr, t, "len")
+			if err != nil {
+				return nil, err
+			}
+
+			max, err = s.dbp.readUintField(addr, t, "cap")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if elem == nil && !str {
+		return nil, fmt.Errorf("%s can not be sliced", typ)
+	}
+
+	low, high := uint64(0), n
+	if node.Low != nil {
+		low, err = s.evalIndex(node.Low)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if node.High != nil {
+		high, err = s.evalIndex(node.High)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if low > high || high > max {
+		return nil, fmt.Errorf("slice bounds [%d:%d] out of range for %s of length %d and capacity %d", low, high, typ, n, max)
+	}
+
+	if str {
+		var val []byte
+		if high > low {
+			val, err = s.dbp.readMemory(uintptr(base+low), uintptr(high-low))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &Variable{Type: name, Value: string(val)}, nil
+	}
+
+	elems := make([]string, 0, high-low)
+	for i := low; i < high; i++ {
+		v, err := s.dbp.extractValue(int64(base+i*uint64(elem.Size())), elem)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, v)
+	}
+
+	return &Variable{Type: name, Value: fmt.Sprintf("len: %d cap: %d [%s]", high-low, max-low, strings.Join(elems, " "))}, nil
+}
+
+// Returns the address of the memory an expression designates, to be
+// examined raw: the address held by a pointer, an integer or a register,
+// as in p, 0xc000010000 or $sp, or the address of a variable of any other
+// type, as in buf for an array, evaluated in frame, nil for the innermost.
+func (dbp *DebuggedProcess) EvalAddressInFrame(expr string, frame *StackFrame) (uint64, error) {
+	t, err := parseExpression(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	scope := &evalScope{dbp: dbp, frame: frame, src: exprSource(expr)}
+	if addr, typ, err := scope.evalAddress(t); err == nil {
+		switch underlyingType(typ).(type) {
+		case *dwarf.PtrType, *dwarf.IntType, *dwarf.UintType:
+		default:
+			return addr, nil
+		}
+	}
+
+	return scope.evalPointer(t)
+}
+
+// Returns the bytes of what an expression evaluated in frame designates:
+// the contents of a string, a slice or an array, or the memory of any
+// other value, such as a struct. Only the first maxStringLen bytes are
+// returned.
+func (dbp *DebuggedProcess) ExpressionBytesInFrame(expr string, frame *StackFrame) ([]byte, error) {
+	t, err := parseExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &evalScope{dbp: dbp, frame: frame, src: exprSource(expr)}
+	addr, typ, err := scope.evalAddress(t)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, typ, err = dbp.derefAll(addr, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	base, n := addr, uint64(sizeof(typ))
+	if st, ok := underlyingType(typ).(*dwarf.StructType); ok {
+		switch {
+		case st.StructName == "string":
+			base, err = dbp.readUintField(addr, st, "str")
+			if err != nil {
+				return nil, err
+			}
+
+			n, err = dbp.readUintField(addr, st, "len")
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(st.StructName, "[]"):
+			array, err := structField(st, "array")
+			if err != nil {
+				return nil, err
+			}
+
+			elem := pointee(array.Type)
+			if elem == nil {
+				return nil, fmt.Errorf("unexpected type of slice array %s", array.Type)
+			}
+
+			base, err = dbp.readUintField(addr, st, "array")
+			if err != nil {
+				return nil, err
+			}
+
+			n, err = dbp.readUintField(addr, st, "len")
+			if err != nil {
+				return nil, err
+			}
+			n *= uint64(sizeof(elem))
+		}
+	}
+
+	if n > maxStringLen {
+		n = maxStringLen
+	}
+
+	if n == 0 {
+		return []byte{}, nil
+	}
+
+	return dbp.readMemory(uintptr(base), uintptr(n))
+}
+
+// Evaluates the index of an index expression.
+func (s *evalScope) evalIndex(t ast.Expr) (uint64, error) {
+	v, err := s.evalAST(t)
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := v.constant()
+	if err != nil {
+		return 0, err
+	}
+
+	i, ok := constant.Uint64Val(constant.ToInt(c))
+	if !ok {
+		return 0, fmt.Errorf("invalid index %s", v.Value)
+	}
+
+	return i, nil
+}
+
+// Returns the address and type of element i of the slice,
+// whose header of type t is at addr.
+func (dbp *DebuggedProcess) sliceElem(addr uint64, t *dwarf.StructType, i uint64) (uint64, dwarf.Type, error) {
+	array, err := structField(t, "array")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	elem := pointee(array.Type)
+	if elem == nil {
+		return 0, nil, fmt.Errorf("unexpected type of slice array %s", array.Type)
+	}
+
+	n, err := dbp.readUintField(addr, t, "len")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if i >= n {
+		return 0, nil, fmt.Errorf("index %d out of range for slice of length %d", i, n)
+	}
+
+	base, err := dbp.readUintField(addr, t, "array")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return base + i*uint64(elem.Size()), elem, nil
+}
+
+// Returns the address stored in the pointer at addr, and the type
+// it points to.
+func (dbp *DebuggedProcess) deref(addr uint64, ptr *dwarf.PtrType) (uint64, dwarf.Type, error) {
+	val, err := dbp.readMemory(uintptr(addr), 8)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	target := binary.LittleEndian.Uint64(val)
+	if target == 0 {
+		return 0, nil, fmt.Errorf("nil pointer dereference")
+	}
+
+	return target, ptr.Type, nil
+}
+
+// Follows pointers from the value of type typ at addr until reaching
+// a value which is not one, returning its address and type.
+func (dbp *DebuggedProcess) derefAll(addr uint64, typ dwarf.Type) (uint64, dwarf.Type, error) {
+	for {
+		ptr, ok := underlyingType(typ).(*dwarf.PtrType)
+		if !ok || isMapHeader(ptr.Type) || isChanHeader(ptr.Type) {
+			return addr, typ, nil
+		}
+
+		var err error
+		addr, typ, err = dbp.deref(addr, ptr)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// A struct embedded, however deeply, in the struct a field is selected
+// from. Embedded through a nil pointer it has no address.
+type embeddedStruct struct {
+	addr  uint64
+	st    *dwarf.StructType
+	isNil bool
+}
+
+// Returns the address and type of the field named name of the struct of
+// type st at addr. As in Go, fields of embedded structs are promoted to
+// the struct embedding them, those embedded least deeply first, so that
+// srv.Addr selects the Addr field of a net/http.Server embedded in srv,
+// following the pointer if a *net/http.Server is embedded.
+func (dbp *DebuggedProcess) selectField(addr uint64, st *dwarf.StructType, name string) (uint64, dwarf.Type, error) {
+	level := []embeddedStruct{{addr: addr, st: st}}
+	for len(level) > 0 {
+		var (
+			found []*dwarf.StructField
+			in    embeddedStruct
+			next  []embeddedStruct
+		)
+
+		for _, e := range level {
+			for _, field := range e.st.Field {
+				if field.Name == name {
+					found = append(found, field)
+					in = e
+					continue
+				}
+
+				if !isEmbedded(field) {
+					continue
+				}
+
+				emb := embeddedStruct{addr: e.addr + uint64(field.ByteOffset), isNil: e.isNil}
+				typ := underlyingType(field.Type)
+
+				if ptr, ok := typ.(*dwarf.PtrType); ok {
+					typ = underlyingType(ptr.Type)
+					if !emb.isNil {
+						target, err := dbp.readUint64(uintptr(emb.addr))
+						if err != nil {
+							return 0, nil, err
+						}
+						emb.addr, emb.isNil = target, target == 0
+					}
+				}
+
+				if st, ok := typ.(*dwarf.StructType); ok {
+					emb.st = st
+					next = append(next, emb)
+				}
+			}
+		}
+
+		switch {
+		case len(found) > 1:
+			return 0, nil, fmt.Errorf("ambiguous selector %s of %s", name, st)
+		case len(found) == 1 && in.isNil:
+			return 0, nil, fmt.Errorf("nil pointer dereference selecting %s of %s", name, st)
+		case len(found) == 1:
+			return in.addr + uint64(found[0].ByteOffset), found[0].Type, nil
+		}
+
+		level = next
+	}
+
+	return 0, nil, fmt.Errorf("%s has no field %s", st, name)
+}
+
+// Reports whether a field is an embedded one, as in struct{ http.Server }.
+// Embedded fields are named after their type, without its package or
+// type arguments.
+func isEmbedded(field *dwarf.StructField) bool {
+	typ := field.Type
+	if ptr, ok := typ.(*dwarf.PtrType); ok {
+		typ = ptr.Type
+	}
+
+	name := typ.Common().Name
+	if st, ok := typ.(*dwarf.StructType); ok {
+		name = st.StructName
+	}
+
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+
+	return name != "" && name[strings.LastIndex(name, ".")+1:] == field.Name
+}
+
+// Returns the type a user defined type is declared as.
+func underlyingType(typ dwarf.Type) dwarf.Type {
+	for {
+		tt, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = tt.Type
+	}
+}
+
+// Converts the value of the variable into a constant,
+// for use as an operand in an expression.
+func (v *Variable) constant() (constant.Value, error) {
+	switch typ := v.underlying(); {
+	case typ == "bool":
+		return constant.MakeBool(v.Value == "true"), nil
+	case typ == "struct string" || typ == "string" || typ == "untyped string":
+		return constant.MakeString(v.Value), nil
+	case typ == "uintptr", typ == "unsafe.Pointer":
+		// Addresses may be followed by the function
+		// they point into, as in 0x49a150 <main.double>.
+		addr := strings.SplitN(v.Value, " ", 2)[0]
+		return parseConstant(addr, token.INT)
+	case strings.HasPrefix(typ, "int"), strings.HasPrefix(typ, "uint"), typ == "untyped int":
+		return parseConstant(v.Value, token.INT)
+	case strings.HasPrefix(typ, "float"), typ == "untyped float":
+		return parseConstant(v.Value, token.FLOAT)
+	}
+
+	return nil, fmt.Errorf("values of type %s can not be used in expressions", v.Type)
+}
+
+// Returns the predeclared type the value of the variable is represented
+// as: its type, or the type a type declared in a package is declared as,
+// as in int64 for main.State.
+func (v *Variable) underlying() string {
+	if v.basic != "" {
+		return v.basic
+	}
+
+	return v.Type
+}
+
+// Parses a number as formatted by the variable printer, which unlike
+// a Go literal may carry a sign.
+func parseConstant(s string, kind token.Token) (constant.Value, error) {
+	neg := strings.HasPrefix(s, "-")
+
+	c := constant.MakeFromLiteral(strings.TrimPrefix(s, "-"), kind, 0)
+	if c.Kind() == constant.Unknown {
+		return nil, fmt.Errorf("could not read %s as a number", s)
+	}
+
+	if neg {
+		c = constant.UnaryOp(token.SUB, c, 0)
+	}
+
+	return c, nil
+}
+
+func (v *Variable) untyped() bool {
+	return strings.HasPrefix(v.Type, "untyped ")
+}
+
+// Returns a variable holding an untyped constant.
+func constantVariable(c constant.Value) (*Variable, error) {
+	switch c.Kind() {
+	case constant.Bool:
+		return typedVariable(c, "bool")
+	case constant.String:
+		return typedVariable(c, "untyped string")
+	case constant.Int:
+		return typedVariable(c, "untyped int")
+	case constant.Float:
+		return typedVariable(c, "untyped float")
+	}
+
+	return nil, fmt.Errorf("unsupported constant %s", c)
+}
+
+func typedVariable(c constant.Value, typ string) (*Variable, error) {
+	if c.Kind() == constant.Bool {
+		typ = "bool"
+	}
+
+	switch c.Kind() {
+	case constant.String:
+		return &Variable{Type: typ, Value: constant.StringVal(c)}, nil
+	case constant.Float:
+		f, _ := constant.Float64Val(c)
+		return &Variable{Type: typ, Value: fmt.Sprint(f)}, nil
+	}
+
+	return &Variable{Type: typ, Value: c.ExactString()}, nil
+}
+
+// Returns the value of the named register of the current thread.
+// The aliases pc and sp name the instruction and stack pointers.
+func (dbp *DebuggedProcess) registerVariable(name string) (*Variable, error) {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return nil, err
+	}
+
+	val, ok := registerValue(regs, name)
+	if !ok {
+		return nil, fmt.Errorf("unknown register $%s", name)
+	}
+
+	return &Variable{Name: "$" + name, Type: "uint64", Value: fmt.Sprintf("%#x", val)}, nil
+}
+
+func registerValue(regs *syscall.PtraceRegs, name string) (uint64, bool) {
+	switch strings.ToLower(name) {
+	case "rax":
+		return regs.Rax, true
+	case "rbx":
+		return regs.Rbx, true
+	case "rcx":
+		return regs.Rcx, true
+	case "rdx":
+		return regs.Rdx, true
+	case "rsi":
+		return regs.Rsi, true
+	case "rdi":
+		return regs.Rdi, true
+	case "rbp", "fp":
+		return regs.Rbp, true
+	case "rsp", "sp":
+		return regs.Rsp, true
+	case "r8":
+		return regs.R8, true
+	case "r9":
+		return regs.R9, true
+	case "r10":
+		return regs.R10, true
+	case "r11":
+		return regs.R11, true
+	case "r12":
+		return regs.R12, true
+	case "r13":
+		return regs.R13, true
+	case "r14":
+		return regs.R14, true
+	case "r15":
+		return regs.R15, true
+	case "rip", "pc":
+		return regs.Rip, true
+	case "eflags", "flags":
+		return regs.Eflags, true
+	case "cs":
+		return regs.Cs, true
+	case "ss":
+		return regs.Ss, true
+	case "ds":
+		return regs.Ds, true
+	case "es":
+		return regs.Es, true
+	case "fs":
+		return regs.Fs, true
+	case "gs":
+		return regs.Gs, true
+	case "fs_base":
+		return regs.Fs_base, true
+	case "gs_base":
+		return regs.Gs_base, true
+	}
+
+	return 0, false
+}
+
+// Returns the value of the variable as a Go value suitable for use with
+// the fmt package. Numbers, booleans and strings are returned as int64,
+// uint64, float64, bool and string, anything else as the printed
+// representation of the variable.
+func (v *Variable) GoValue() interface{} {
+	c, err := v.constant()
+	if err != nil {
+		return v.Value
+	}
+
+	switch c.Kind() {
+	case constant.Bool:
+		return constant.BoolVal(c)
+	case constant.String:
+		return constant.StringVal(c)
+	case constant.Float:
+		f, _ := constant.Float64Val(c)
+		return f
+	case constant.Int:
+		if strings.HasPrefix(v.underlying(), "uint") {
+			if u, ok := constant.Uint64Val(c); ok {
+				return u
+			}
+		}
+		if i, ok := constant.Int64Val(c); ok {
+			return i
+		}
+		if u, ok := constant.Uint64Val(c); ok {
+			return u
+		}
+	}
+
+	return v.Value
+}
@@ -0,0 +1,103 @@
+package proctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often WaitForProcess looks for the process it waits for.
+const waitForInterval = time.Millisecond
+
+// Returns the IDs of the processes whose executable is named name, as in
+// "myserver" for /usr/bin/myserver, other than the debugger itself.
+func FindProcesses(name string) ([]int, error) {
+	pids, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []int
+	for _, pid := range pids {
+		if isProcessNamed(pid, name) {
+			found = append(found, pid)
+		}
+	}
+
+	return found, nil
+}
+
+// Waits for a process whose executable is named name to start, and
+// returns its ID as soon as it does. Processes already running are
+// not waited for.
+func WaitForProcess(name string) (int, error) {
+	pids, err := listProcesses()
+	if err != nil {
+		return 0, err
+	}
+
+	running := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		running[pid] = true
+	}
+
+	for {
+		pids, err := listProcesses()
+		if err != nil {
+			return 0, err
+		}
+
+		// Processes started since are looked at until they exec
+		// the executable, or exit.
+		for _, pid := range pids {
+			if !running[pid] && isProcessNamed(pid, name) {
+				return pid, nil
+			}
+		}
+
+		time.Sleep(waitForInterval)
+	}
+}
+
+// Returns the IDs of every process, other than the debugger itself.
+func listProcesses() ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// Reports whether the executable of a process is named name. The
+// executable of processes of other users can not be read, for those the
+// name the kernel keeps is compared, which is cut to 15 characters.
+func isProcessNamed(pid int, name string) bool {
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		return filepath.Base(strings.TrimSuffix(exe, " (deleted)")) == name
+	}
+
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return false
+	}
+
+	if len(name) > 15 {
+		name = name[:15]
+	}
+
+	return strings.TrimSpace(string(comm)) == name
+}
@@ -0,0 +1,919 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"strings"
+	"syscall"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+	"github.com/derekparker/delve/version"
+)
+
+// Stack space the runtime requires to be free on the goroutine
+// a call is injected on.
+const callStackMargin = 256
+
+// Calls a function of the process on the goroutine running on the
+// current thread, as in "fmt.Sprintf(\"%v\", x)" or "s.Len()", and
+// returns its results. Arguments are evaluated in the innermost frame.
+//
+// The call is made through the runtime, which checks that the goroutine
+// is stopped at a point where calls are safe and grows its stack if
+// need be. Every thread runs until the function returns, breakpoints
+// being suspended in the meantime, so the state of the program may
+// change in ways it does not expect; calls are therefore refused unless
+// AllowCalls is set, as they are in binaries built with a release of Go
+// older than version.MinCallGoVersion.
+func (dbp *DebuggedProcess) Call(expr string) ([]*Variable, error) {
+	if !dbp.AllowCalls {
+		return nil, fmt.Errorf("function calls are disabled")
+	}
+
+	v, err := dbp.GoVersion()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the version of Go the program was built with: %s", err)
+	}
+
+	err = version.CheckCallSupport(v)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := parseExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	call, ok := t.(*ast.CallExpr)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function call", expr)
+	}
+
+	scope := &evalScope{dbp: dbp, src: exprSource(expr)}
+
+	fn, recv, err := scope.callTarget(call.Fun)
+	if err != nil {
+		return nil, err
+	}
+
+	params, results, err := dbp.functionParams(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	mem := &callMemory{}
+	args, err := scope.callArgs(call, recv, params, mem)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbp.placeCallMemory(mem)
+	if err != nil {
+		return nil, err
+	}
+
+	var vars []*Variable
+	err = dbp.callFunction(fn, paramTypes(params), args, paramTypes(results), func(addrs []uint64) error {
+		for i, p := range results {
+			v, err := dbp.newVariable(p.name, int64(addrs[i]), p.typ)
+			if err != nil {
+				return err
+			}
+			vars = append(vars, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// A parameter or result of a function.
+type funcParam struct {
+	name string
+	typ  dwarf.Type
+}
+
+func paramTypes(params []funcParam) []dwarf.Type {
+	types := make([]dwarf.Type, len(params))
+	for i, p := range params {
+		types[i] = p.typ
+	}
+
+	return types
+}
+
+// Returns the function called by a call expression, and for a method
+// called on a variable, as in s.Len(), the receiver to pass it. Methods
+// with a pointer receiver are passed the address of the variable, or
+// the variable itself when it is a pointer, and methods with a value
+// receiver the value of the variable or the value it points to.
+func (s *evalScope) callTarget(t ast.Expr) (*gosym.Func, []byte, error) {
+	if sel, ok := t.(*ast.SelectorExpr); ok {
+		if addr, typ, err := s.evalAddress(sel.X); err == nil {
+			return s.method(addr, typ, sel.Sel.Name)
+		}
+	}
+
+	name, ok := s.text(t)
+	if !ok {
+		return nil, nil, fmt.Errorf("expression %T does not designate a function", t)
+	}
+
+	if fn := s.dbp.findFunction(name); fn != nil {
+		return fn, nil, nil
+	}
+
+	// Functions of the main package may be named without it.
+	if !strings.Contains(name, ".") {
+		if fn := s.dbp.findFunction("main." + name); fn != nil {
+			return fn, nil, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("could not find function %s", name)
+}
+
+// Returns the method called name of the variable of type typ at addr,
+// and the receiver to pass it.
+func (s *evalScope) method(addr uint64, typ dwarf.Type, name string) (*gosym.Func, []byte, error) {
+	base := typ
+	ptr, isPtr := underlyingType(typ).(*dwarf.PtrType)
+	if isPtr {
+		base = ptr.Type
+	}
+
+	tname := base.String()
+	i := strings.LastIndex(tname, ".")
+	if i < 0 {
+		return nil, nil, fmt.Errorf("%s has no method %s", typ, name)
+	}
+	pkg, tname := tname[:i], tname[i+1:]
+
+	if fn := s.dbp.LookupFunc(fmt.Sprintf("%s.(*%s).%s", pkg, tname, name)); fn != nil {
+		if isPtr {
+			recv, err := s.dbp.readMemory(uintptr(addr), 8)
+			return fn, recv, err
+		}
+
+		recv := make([]byte, 8)
+		binary.LittleEndian.PutUint64(recv, addr)
+		return fn, recv, nil
+	}
+
+	if fn := s.dbp.LookupFunc(fmt.Sprintf("%s.%s.%s", pkg, tname, name)); fn != nil {
+		if isPtr {
+			var err error
+			addr, _, err = s.dbp.deref(addr, ptr)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		recv, err := s.dbp.readMemory(uintptr(addr), uintptr(sizeof(base)))
+		return fn, recv, err
+	}
+
+	return nil, nil, fmt.Errorf("%s has no method %s", typ, name)
+}
+
+// Looks up a function by its full name, or by the name of its
+// package without the path, as in http.Get.
+func (dbp *DebuggedProcess) findFunction(name string) *gosym.Func {
+	if fn := dbp.LookupFunc(name); fn != nil {
+		return fn
+	}
+
+	for i := range dbp.GoSymTable.Funcs {
+		fn := &dbp.GoSymTable.Funcs[i]
+		if strings.HasSuffix(fn.Name, "/"+name) {
+			return fn
+		}
+	}
+
+	return nil
+}
+
+// Returns the parameters, including the receiver of methods, and the
+// results of a function, in order, from its debug information.
+func (dbp *DebuggedProcess) functionParams(fn *gosym.Func) (params, results []funcParam, err error) {
+	data, err := dbp.dwarfForPC(fn.Entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := fn.Entry - dbp.bias(fn.Entry)
+
+	reader := data.Reader()
+	for e, err := reader.Next(); e != nil; e, err = reader.Next() {
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		if lowpc, ok := e.Val(dwarf.AttrLowpc).(uint64); !ok || lowpc != entry {
+			reader.SkipChildren()
+			continue
+		}
+
+		if !e.Children {
+			return nil, nil, nil
+		}
+
+		for {
+			e, err := reader.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if e == nil || e.Tag == 0 {
+				return params, results, nil
+			}
+
+			if e.Children {
+				reader.SkipChildren()
+			}
+
+			if e.Tag != dwarf.TagFormalParameter {
+				continue
+			}
+
+			offset, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+			if !ok {
+				return nil, nil, fmt.Errorf("no type information for the parameters of %s", fn.Name)
+			}
+
+			typ, err := data.Type(offset)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			n, _ := e.Val(dwarf.AttrName).(string)
+			p := funcParam{name: n, typ: typ}
+
+			if out, _ := e.Val(dwarf.AttrVarParam).(bool); out {
+				results = append(results, p)
+			} else {
+				params = append(params, p)
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("could not find debug information for function %s", fn.Name)
+}
+
+// Evaluates the arguments of a call, as laid out in memory for each
+// parameter. Arguments to a final ...T parameter are gathered in a
+// slice, unless passed as one with s...
+func (s *evalScope) callArgs(call *ast.CallExpr, recv []byte, params []funcParam, mem *callMemory) ([][]byte, error) {
+	args := make([][]byte, len(params))
+
+	if recv != nil {
+		if len(params) == 0 {
+			return nil, fmt.Errorf("no receiver in the debug information of the method")
+		}
+		args[0] = recv
+		params = params[1:]
+	}
+	first := len(args) - len(params)
+
+	// The debug information does not tell variadic functions apart,
+	// a final slice parameter is taken to be ...T unless given a slice.
+	exprs := call.Args
+	variadic := false
+	if n := len(params); n > 0 && !call.Ellipsis.IsValid() && isSlice(params[n-1].typ) {
+		variadic = len(exprs) != n || !s.hasType(exprs[n-1], params[n-1].typ)
+	}
+
+	switch {
+	case variadic && len(exprs) < len(params)-1:
+		return nil, fmt.Errorf("not enough arguments, %d given where at least %d are expected", len(exprs), len(params)-1)
+	case !variadic && len(exprs) != len(params):
+		return nil, fmt.Errorf("wrong number of arguments, %d given where %d are expected", len(exprs), len(params))
+	}
+
+	for i, p := range params {
+		if variadic && i == len(params)-1 {
+			b, err := s.packVariadic(exprs[i:], p.typ, mem, &args[first+i])
+			if err != nil {
+				return nil, err
+			}
+			args[first+i] = b
+			break
+		}
+
+		b, err := s.argValue(exprs[i], p.typ, mem, &args[first+i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %s", p.name, err)
+		}
+		args[first+i] = b
+	}
+
+	return args, nil
+}
+
+func isSlice(t dwarf.Type) bool {
+	st, ok := underlyingType(t).(*dwarf.StructType)
+	return ok && strings.HasPrefix(st.StructName, "[]")
+}
+
+// Reports whether expr designates a variable of type typ.
+func (s *evalScope) hasType(expr ast.Expr, typ dwarf.Type) bool {
+	_, t, err := s.evalAddress(expr)
+	return err == nil && t.String() == typ.String()
+}
+
+// Returns the value of expr, as laid out in memory, passed for a
+// parameter of type typ. Variables of the type of the parameter are
+// passed as they are, whatever their type, as are values boxed in an
+// empty interface. Other values may be numbers, booleans, strings and
+// nil, as with SetVariable. Pointers into the memory allocated for
+// the call are recorded in mem, to be fixed up in dst once the memory
+// has been allocated.
+func (s *evalScope) argValue(expr ast.Expr, typ dwarf.Type, mem *callMemory, dst *[]byte) ([]byte, error) {
+	if addr, t, err := s.evalAddress(expr); err == nil && t.String() == typ.String() {
+		return s.dbp.readMemory(uintptr(addr), uintptr(sizeof(typ)))
+	}
+
+	st, ok := underlyingType(typ).(*dwarf.StructType)
+	switch {
+	case ok && st.StructName == "runtime.eface":
+		return s.boxValue(expr, mem, dst)
+	case ok && st.StructName == "string":
+		v, err := s.evalAST(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := v.constant()
+		if err != nil || c.Kind() != constant.String {
+			return nil, fmt.Errorf("%s is not a string", v.Value)
+		}
+
+		str := constant.StringVal(c)
+		buf := make([]byte, 16)
+		binary.LittleEndian.PutUint64(buf[8:], uint64(len(str)))
+		if str != "" {
+			mem.pointer(dst, 0, mem.add([]byte(str), 1))
+		}
+
+		return buf, nil
+	}
+
+	return s.encodeValue(expr, typ)
+}
+
+// Boxes the value of expr in an empty interface. Values which are not
+// pointers are copied to the memory allocated for the call, as the
+// runtime does. Untyped constants get their default type.
+func (s *evalScope) boxValue(expr ast.Expr, mem *callMemory, dst *[]byte) ([]byte, error) {
+	var (
+		typ  dwarf.Type
+		data []byte
+	)
+
+	// Pointers held by data are recorded as stored in it, and
+	// moved to wherever it is copied.
+	if addr, t, err := s.evalAddress(expr); err == nil {
+		typ = t
+		data, err = s.dbp.readMemory(uintptr(addr), uintptr(sizeof(t)))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		v, err := s.evalAST(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(v.Type, "untyped ")
+		switch name {
+		case "float":
+			name = "float64"
+		case "struct string":
+			name = "string"
+		}
+
+		typ, err = s.dbp.findType(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "string" {
+			data, err = s.argValue(expr, typ, mem, &data)
+		} else {
+			data, err = s.encodeValue(expr, typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tdesc, err := s.dbp.runtimeTypeAddr(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf, tdesc)
+
+	if pointerShaped(typ) {
+		copy(buf[8:], data)
+		mem.move(&data, dst, 8)
+		return buf, nil
+	}
+
+	off := mem.add(data, int(alignof(typ)))
+	mem.move(&data, &mem.data, off)
+	mem.pointer(dst, 8, off)
+
+	return buf, nil
+}
+
+// Gathers the values of exprs in a slice of type typ, whose
+// elements are stored in the memory allocated for the call.
+func (s *evalScope) packVariadic(exprs []ast.Expr, typ dwarf.Type, mem *callMemory, dst *[]byte) ([]byte, error) {
+	st := underlyingType(typ).(*dwarf.StructType)
+
+	array, err := structField(st, "array")
+	if err != nil {
+		return nil, err
+	}
+
+	elem := pointee(array.Type)
+	if elem == nil {
+		return nil, fmt.Errorf("unexpected type of slice array %s", array.Type)
+	}
+
+	buf := make([]byte, 24)
+	if len(exprs) == 0 {
+		return buf, nil
+	}
+
+	size := uint64(sizeof(elem))
+	elems := make([]byte, 0, uint64(len(exprs))*size)
+	dsts := make([]*[]byte, len(exprs))
+	for i, expr := range exprs {
+		var b []byte
+		b, err = s.argValue(expr, elem, mem, &b)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		elems = append(elems, b...)
+		dsts[i] = &b
+	}
+
+	off := mem.add(elems, int(alignof(elem)))
+	for i, b := range dsts {
+		mem.move(b, &mem.data, off+uint64(i)*size)
+	}
+	mem.pointer(dst, 0, off)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(exprs)))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(len(exprs)))
+
+	return buf, nil
+}
+
+// Returns the address of the runtime type descriptor of type t.
+func (dbp *DebuggedProcess) runtimeTypeAddr(t dwarf.Type) (uint64, error) {
+	if dbp.runtimeTypes == nil {
+		err := dbp.loadRuntimeTypes()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for addr, rt := range dbp.runtimeTypes {
+		if rt.String() == t.String() {
+			return addr, nil
+		}
+	}
+
+	sym, err := dbp.elfSymbol("type." + t.String())
+	if err != nil {
+		return 0, fmt.Errorf("could not find the runtime type of %s", t)
+	}
+
+	return sym.Value, nil
+}
+
+// Memory needed by the arguments of a call, such as the bytes of a
+// string or the elements of a variadic slice. It is laid out in a
+// single block, allocated in the process just before the call, with the pointers to it filled in once its address is known.
+type callMemory struct {
+	data     []byte
+	pointers []callPointer
+}
+
+// A pointer to offset off in the memory of a call,
+// stored at offset at of the bytes in dst.
+type callPointer struct {
+	dst *[]byte
+	at  int
+	off uint64
+}
+
+// Appends b to the memory, aligned to n bytes, returning its offset.
+func (m *callMemory) add(b []byte, n int) uint64 {
+	for len(m.data)%n != 0 {
+		m.data = append(m.data, 0)
+	}
+
+	off := len(m.data)
+	m.data = append(m.data, b...)
+
+	return uint64(off)
+}
+
+// Records a pointer to offset off stored at offset at of *dst.
+func (m *callMemory) pointer(dst *[]byte, at int, off uint64) {
+	m.pointers = append(m.pointers, callPointer{dst: dst, at: at, off: off})
+}
+
+// Moves the pointers stored in *from to *to, at the given offset, once
+// the bytes of from have been copied there.
+func (m *callMemory) move(from, to *[]byte, off uint64) {
+	for i, p := range m.pointers {
+		if p.dst == from {
+			m.pointers[i].dst = to
+			m.pointers[i].at += int(off)
+		}
+	}
+}
+
+// Allocates the memory of a call, fills in the pointers to it
+// and copies it to the process.
+func (dbp *DebuggedProcess) placeCallMemory(mem *callMemory) error {
+	if len(mem.data) == 0 {
+		return nil
+	}
+
+	base, err := dbp.allocate(uint64(len(mem.data)))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range mem.pointers {
+		binary.LittleEndian.PutUint64((*p.dst)[p.at:], base+p.off)
+	}
+
+	return dbp.writeMemory(uintptr(base), mem.data)
+}
+
+// Allocates size bytes of zeroed memory in the process by calling
+// runtime.persistentalloc, the allocator the runtime uses for its own
+// long lived data. The allocation is made in a call of its own, and
+// memory from runtime.mallocgc would be unreachable, and could be freed
+// by the garbage collector, until the next call has been passed
+// pointers to it. Memory from persistentalloc is never freed instead,
+// so the few bytes allocated for the arguments of each call are leaked.
+func (dbp *DebuggedProcess) allocate(size uint64) (uint64, error) {
+	fn := dbp.LookupFunc("runtime.persistentalloc")
+	if fn == nil {
+		return 0, fmt.Errorf("could not find runtime.persistentalloc")
+	}
+
+	params, results, err := dbp.functionParams(fn)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(params) != 3 || len(results) != 1 {
+		return 0, fmt.Errorf("unexpected signature of runtime.persistentalloc")
+	}
+
+	// The memory is accounted to memstats.other_sys, as the runtime
+	// does for allocations which belong nowhere else.
+	stat, err := dbp.otherSysStat()
+	if err != nil {
+		return 0, err
+	}
+
+	args := [][]byte{make([]byte, 8), make([]byte, 8), make([]byte, 8)}
+	binary.LittleEndian.PutUint64(args[0], size)
+	binary.LittleEndian.PutUint64(args[1], 8)
+	binary.LittleEndian.PutUint64(args[2], stat)
+
+	var ptr uint64
+	err = dbp.callFunction(fn, paramTypes(params), args, paramTypes(results), func(addrs []uint64) error {
+		var err error
+		ptr, err = dbp.readUint64(uintptr(addrs[0]))
+		return err
+	})
+	if err == nil && ptr == 0 {
+		err = fmt.Errorf("could not allocate %d bytes for the call", size)
+	}
+
+	return ptr, err
+}
+
+// Returns the address of runtime.memstats.other_sys.
+func (dbp *DebuggedProcess) otherSysStat() (uint64, error) {
+	addr, err := dbp.symbolAddr("runtime.memstats")
+	if err != nil {
+		return 0, err
+	}
+
+	st, err := dbp.findStructType("runtime.mstats")
+	if err != nil {
+		return 0, err
+	}
+
+	field, err := structField(st, "other_sys")
+	if err != nil {
+		return 0, err
+	}
+
+	return addr + uint64(field.ByteOffset), nil
+}
+
+// Values the runtime sets R12 to before stopping at an INT3 during a
+// call made through runtime.debugCallV2, to tell the debugger what to
+// do next.
+const (
+	debugCallReady    = 0  // write the arguments and call the function
+	debugCallReturned = 1  // read the results
+	debugCallPanicked = 2  // the function panicked
+	debugCallRefused  = 8  // the call can not be made
+	debugCallRestore  = 16 // restore the registers and carry on
+)
+
+// Calls fn with the given arguments, as laid out in memory for each
+// parameter, on the goroutine running on the current thread. Once the
+// function has returned, done is called with the address of each of
+// its results.
+func (dbp *DebuggedProcess) callFunction(fn *gosym.Func, params []dwarf.Type, args [][]byte, results []dwarf.Type, done func([]uint64) error) error {
+	debugCall := dbp.LookupFunc("runtime.debugCallV2")
+	if debugCall == nil {
+		return fmt.Errorf("the runtime of the program does not support function calls")
+	}
+
+	g, err := dbp.currentG()
+	if err != nil {
+		return err
+	}
+
+	err = dbp.checkCallStack(g)
+	if err != nil {
+		return err
+	}
+
+	cf := newCallFrame(params, results)
+	tid := dbp.CurrentThread.Id
+
+	saved, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	savedFP, err := getFPRegs(dbp.CurrentThread.Id)
+	if err != nil {
+		return err
+	}
+
+	// Breakpoints are suspended during the call, the instruction
+	// under the one we are stopped at, if any, is run on return.
+	bp, atBreakpoint := dbp.BreakPoints[saved.PC()-1]
+	err = dbp.suspendBreakpoints()
+	if err != nil {
+		return err
+	}
+	defer dbp.resumeBreakpoints()
+
+	pc := saved.PC()
+	if atBreakpoint {
+		pc = bp.Addr
+	}
+
+	// Push the PC, as a call instruction would,
+	// and tell the runtime the size of the frame.
+	regs := *saved
+	regs.Rsp -= 8
+	err = dbp.writeUint64(regs.Rsp, pc)
+	if err != nil {
+		return err
+	}
+
+	err = dbp.writeUint64(regs.Rsp-16, uint64(cf.size))
+	if err != nil {
+		return err
+	}
+
+	regs.SetPC(debugCall.Entry)
+	err = syscall.PtraceSetRegs(dbp.CurrentThread.Id, &regs)
+	if err != nil {
+		return err
+	}
+
+	var callErr error
+	for {
+		err := dbp.resumeAll()
+		if err != nil {
+			return err
+		}
+
+		if dbp.ProcessState.Exited() {
+			return fmt.Errorf("process exited during the call of %s", fn.Name)
+		}
+
+		// The runtime runs the function on a new goroutine locked
+		// to the thread of the one it was called on, every stop of
+		// the call protocol is therefore on the same thread.
+		if dbp.CurrentThread.Id != tid || dbp.CurrentThread.Status.StopSignal() != syscall.SIGTRAP {
+			continue
+		}
+
+		regs, err := dbp.Registers()
+		if err != nil {
+			return err
+		}
+
+		fpregs, err := getFPRegs(dbp.CurrentThread.Id)
+		if err != nil {
+			return err
+		}
+
+		switch regs.R12 {
+		case debugCallReady:
+			for i, v := range cf.args {
+				err = dbp.placeArg(v, args[i], regs.Rsp, regs, fpregs)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = setFPRegs(dbp.CurrentThread.Id, fpregs)
+			if err != nil {
+				return err
+			}
+
+			regs.Rsp -= 8
+			err = dbp.writeUint64(regs.Rsp, regs.PC())
+			if err != nil {
+				return err
+			}
+			regs.SetPC(fn.Entry)
+			regs.Rdx = 0
+		case debugCallReturned:
+			addrs := make([]uint64, len(cf.results))
+			for i, v := range cf.results {
+				addrs[i], err = dbp.collectResult(v, regs.Rsp, regs, fpregs)
+				if err != nil {
+					return err
+				}
+			}
+			callErr = done(addrs)
+		case debugCallPanicked:
+			eface, err := dbp.findStructType("runtime.eface")
+			if err != nil {
+				return err
+			}
+
+			val, err := dbp.readInterface(uintptr(regs.Rsp), eface)
+			if err != nil {
+				return err
+			}
+			callErr = fmt.Errorf("%s panicked: %s", fn.Name, val)
+		case debugCallRefused:
+			reason, err := dbp.readGoString(uintptr(regs.Rsp))
+			if err != nil {
+				return err
+			}
+			callErr = fmt.Errorf("can not call %s: %s", fn.Name, reason)
+		case debugCallRestore:
+			err = dbp.restoreCallRegisters(saved, savedFP, regs, debugCall)
+			if err != nil {
+				return err
+			}
+
+			if atBreakpoint {
+				err = dbp.setPC(bp.Addr + 1)
+				if err != nil {
+					return err
+				}
+			}
+			return callErr
+		default:
+			return fmt.Errorf("unexpected stop during the call of %s", fn.Name)
+		}
+
+		err = syscall.PtraceSetRegs(dbp.CurrentThread.Id, regs)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Checks that the goroutine whose g struct is at g has
+// enough free stack for a call to be injected.
+func (dbp *DebuggedProcess) checkCallStack(g uint64) error {
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return err
+	}
+
+	lo, err := dbp.readUintField(g, gtype, "stack")
+	if err != nil {
+		return err
+	}
+
+	regs, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	if regs.Rsp < lo+callStackMargin {
+		return fmt.Errorf("not enough stack left to call a function")
+	}
+
+	return nil
+}
+
+// Restores the registers saved before a call, as the runtime asks once
+// the call is over, and steps out of runtime.debugCallV2 back to where
+// the call was made. The stack pointer and the registers the runtime
+// restores itself are left alone, as the stack may have moved during
+// the call, and so are the thread pointers, in case the goroutine has
+// moved to another thread.
+func (dbp *DebuggedProcess) restoreCallRegisters(saved *syscall.PtraceRegs, savedFP []byte, cur *syscall.PtraceRegs, debugCall *gosym.Func) error {
+	regs := *saved
+	regs.SetPC(cur.PC())
+	regs.Rsp = cur.Rsp
+	regs.Fs_base, regs.Gs_base = cur.Fs_base, cur.Gs_base
+
+	err := syscall.PtraceSetRegs(dbp.CurrentThread.Id, &regs)
+	if err != nil {
+		return err
+	}
+
+	err = setFPRegs(dbp.CurrentThread.Id, savedFP)
+	if err != nil {
+		return err
+	}
+
+	for {
+		pc, err := dbp.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		if pc < debugCall.Entry || pc >= debugCall.End {
+			return nil
+		}
+
+		err = dbp.StepInstruction()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Restores the instructions breakpoints are set on.
+func (dbp *DebuggedProcess) suspendBreakpoints() error {
+	for _, bp := range dbp.BreakPoints {
+		err := dbp.writeMemory(uintptr(bp.Addr), bp.OriginalData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sets the breakpoints suspended by suspendBreakpoints again.
+func (dbp *DebuggedProcess) resumeBreakpoints() error {
+	for _, bp := range dbp.BreakPoints {
+		err := dbp.writeMemory(uintptr(bp.Addr), []byte{0xCC})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (dbp *DebuggedProcess) writeUint64(addr, val uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, val)
+
+	return dbp.writeMemory(uintptr(addr), buf)
+}
+
+func (dbp *DebuggedProcess) setPC(pc uint64) error {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	regs.SetPC(pc)
+	return syscall.PtraceSetRegs(dbp.CurrentThread.Id, regs)
+}
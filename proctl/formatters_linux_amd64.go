@@ -0,0 +1,342 @@
+package proctl
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Formats a value of the type a formatter is registered for, as in
+// "2014-09-01T10:00:00Z" for a time.Time, in place of its fields.
+type Formatter func(v *Value) (string, error)
+
+// A value in the memory of the process, as handed to formatters.
+type Value struct {
+	Addr uint64
+	Type dwarf.Type
+
+	dbp *DebuggedProcess
+}
+
+// Formatters of well-known types of the standard library, used unless
+// the process has a formatter of its own registered for the type.
+var defaultFormatters = map[string]Formatter{
+	"time.Time":     formatTime,
+	"time.Duration": formatDuration,
+	"math/big.Int":  formatBigInt,
+	"net.IP":        formatIP,
+	"sync.Mutex":    formatMutex,
+}
+
+// Registers f to format values of the type named name, as in
+// "main.Celsius" or "net/url.URL", wherever they are printed, fields of
+// structs and values pointed to included. Values f fails to format are
+// printed as if no formatter was registered. A nil f removes the
+// formatter registered for the type, including the default one.
+func (dbp *DebuggedProcess) RegisterFormatter(name string, f Formatter) {
+	dbp.formatters[name] = f
+}
+
+// Returns the formatter for values of type typ, if any.
+func (dbp *DebuggedProcess) formatter(typ interface{}) Formatter {
+	// Named types may be described as typedefs, or as
+	// the type they are declared as carrying their name.
+	var name string
+	switch t := typ.(type) {
+	case *dwarf.StructType:
+		name = t.StructName
+	case dwarf.Type:
+		name = t.Common().Name
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	if f, ok := dbp.formatters[name]; ok {
+		return f
+	}
+
+	return defaultFormatters[name]
+}
+
+// Returns the value as it is printed without a formatter for its type.
+// Fields of the value are still formatted by the formatters of theirs.
+func (v *Value) Format() (string, error) {
+	return v.dbp.extractDefaultValue(int64(v.Addr), v.Type)
+}
+
+// Returns the field of the struct value named name.
+func (v *Value) Field(name string) (*Value, error) {
+	st, ok := underlyingType(v.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct", v.Type)
+	}
+
+	field, err := structField(st, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Value{Addr: v.Addr + uint64(field.ByteOffset), Type: field.Type, dbp: v.dbp}, nil
+}
+
+// Returns the value of an integer, sign extended if its type is signed.
+func (v *Value) Int() (int64, error) {
+	n, err := v.Uint()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := underlyingType(v.Type).(*dwarf.IntType); ok {
+		shift := uint(64 - 8*v.Type.Size())
+		return int64(n<<shift) >> shift, nil
+	}
+
+	return int64(n), nil
+}
+
+// Returns the value of an integer, a boolean or a pointer.
+func (v *Value) Uint() (uint64, error) {
+	size := sizeof(v.Type)
+	switch underlyingType(v.Type).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.BoolType, *dwarf.PtrType:
+	default:
+		return 0, fmt.Errorf("%s is not an integer", v.Type)
+	}
+
+	return v.dbp.readSized(uintptr(v.Addr), size)
+}
+
+// Returns the value a pointer points to, or nil for a nil pointer.
+func (v *Value) Deref() (*Value, error) {
+	ptr, ok := underlyingType(v.Type).(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a pointer", v.Type)
+	}
+
+	addr, err := v.Uint()
+	if err != nil || addr == 0 {
+		return nil, err
+	}
+
+	return &Value{Addr: addr, Type: ptr.Type, dbp: v.dbp}, nil
+}
+
+// Returns the elements of an array or a slice, of which only
+// the first maxStringLen are read.
+func (v *Value) Elems() ([]*Value, error) {
+	var (
+		base, n uint64
+		elem    dwarf.Type
+	)
+
+	switch t := underlyingType(v.Type).(type) {
+	case *dwarf.ArrayType:
+		base, n, elem = v.Addr, uint64(t.Count), t.Type
+	case *dwarf.StructType:
+		array, err := structField(t, "array")
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an array or a slice", v.Type)
+		}
+		elem = pointee(array.Type)
+
+		base, err = v.dbp.readUintField(v.Addr, t, "array")
+		if err != nil {
+			return nil, err
+		}
+
+		n, err = v.dbp.readUintField(v.Addr, t, "len")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if elem == nil {
+		return nil, fmt.Errorf("%s is not an array or a slice", v.Type)
+	}
+
+	if n > maxStringLen {
+		n = maxStringLen
+	}
+
+	elems := make([]*Value, n)
+	for i := range elems {
+		elems[i] = &Value{Addr: base + uint64(i)*uint64(sizeof(elem)), Type: elem, dbp: v.dbp}
+	}
+
+	return elems, nil
+}
+
+// Number of seconds from January 1 of year 1, which times count from,
+// to January 1 1885, from which times with a monotonic reading count,
+// and to January 1 1970.
+const (
+	wallToInternal = 59453308800
+	unixToInternal = 62135596800
+)
+
+// Formats a time as in RFC 3339, in UTC, as in "2014-09-01T10:00:00Z".
+func formatTime(v *Value) (string, error) {
+	var sec, nsec int64
+
+	if wall, err := v.Field("wall"); err == nil {
+		// Since Go 1.9 times may carry a monotonic clock reading,
+		// in which case the wall time is packed in wall.
+		w, err := wall.Uint()
+		if err != nil {
+			return "", err
+		}
+
+		ext, err := v.Field("ext")
+		if err != nil {
+			return "", err
+		}
+
+		sec, err = ext.Int()
+		if err != nil {
+			return "", err
+		}
+
+		nsec = int64(w & (1<<30 - 1))
+		if w&(1<<63) != 0 {
+			sec = wallToInternal + int64(w<<1>>31)
+		}
+	} else {
+		s, err := v.Field("sec")
+		if err != nil {
+			return "", err
+		}
+
+		sec, err = s.Int()
+		if err != nil {
+			return "", err
+		}
+
+		ns, err := v.Field("nsec")
+		if err != nil {
+			return "", err
+		}
+
+		nsec, err = ns.Int()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return time.Unix(sec-unixToInternal, nsec).UTC().Format(time.RFC3339Nano), nil
+}
+
+// Formats a duration as in "1m30s".
+func formatDuration(v *Value) (string, error) {
+	n, err := v.Int()
+	if err != nil {
+		return "", err
+	}
+
+	return time.Duration(n).String(), nil
+}
+
+// Formats a big integer in decimal.
+func formatBigInt(v *Value) (string, error) {
+	neg, err := v.Field("neg")
+	if err != nil {
+		return "", err
+	}
+
+	sign, err := neg.Uint()
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := v.Field("abs")
+	if err != nil {
+		return "", err
+	}
+
+	words, err := abs.Elems()
+	if err != nil {
+		return "", err
+	}
+
+	// Words are stored least significant first.
+	n := new(big.Int)
+	for i := len(words) - 1; i >= 0; i-- {
+		w, err := words[i].Uint()
+		if err != nil {
+			return "", err
+		}
+
+		n.Lsh(n, 64)
+		n.Or(n, new(big.Int).SetUint64(w))
+	}
+
+	if sign != 0 {
+		n.Neg(n)
+	}
+
+	return n.String(), nil
+}
+
+// Formats an IP address as in "10.0.0.1" or "2001:db8::1".
+func formatIP(v *Value) (string, error) {
+	elems, err := v.Elems()
+	if err != nil {
+		return "", err
+	}
+
+	ip := make(net.IP, len(elems))
+	for i, e := range elems {
+		b, err := e.Uint()
+		if err != nil {
+			return "", err
+		}
+		ip[i] = byte(b)
+	}
+
+	return ip.String(), nil
+}
+
+// Bits of the state of a sync.Mutex.
+const (
+	mutexLocked      = 1
+	mutexStarving    = 4
+	mutexWaiterShift = 3
+)
+
+// Formats a mutex as in "locked, 2 waiters". The goroutine holding a
+// mutex is not recorded by the runtime, only whether it is held.
+func formatMutex(v *Value) (string, error) {
+	// Since Go 1.24 the state lives in an internal mutex.
+	if mu, err := v.Field("mu"); err == nil {
+		v = mu
+	}
+
+	state, err := v.Field("state")
+	if err != nil {
+		return "", err
+	}
+
+	s, err := state.Uint()
+	if err != nil {
+		return "", err
+	}
+
+	str := "unlocked"
+	if s&mutexLocked != 0 {
+		str = "locked"
+	}
+
+	if waiters := s >> mutexWaiterShift; waiters > 0 {
+		str += fmt.Sprintf(", %d waiters", waiters)
+	}
+
+	if s&mutexStarving != 0 {
+		str += ", starving"
+	}
+
+	return str, nil
+}
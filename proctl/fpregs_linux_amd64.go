@@ -0,0 +1,126 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"syscall"
+	"unsafe"
+)
+
+// The floating point and vector registers of a thread: the x87 floating
+// point unit, the SSE registers and, on CPUs supporting AVX, the upper
+// halves of the AVX registers.
+type FPRegisters struct {
+	// x87 control, status and abridged tag words.
+	Fcw, Fsw uint16
+	Ftw      uint8
+	// SSE control and status register.
+	Mxcsr uint32
+	// The x87 register stack, ST(0) to ST(7), in 80-bit
+	// extended precision, as stored in memory.
+	St [8][10]byte
+	// XMM0 to XMM15, the lower halves of YMM0 to YMM15.
+	Xmm [16][16]byte
+	// The upper halves of YMM0 to YMM15, only read
+	// when HasAVX.
+	Ymmh   [16][16]byte
+	HasAVX bool
+}
+
+const (
+	ptraceGetRegset = 0x4204
+	ntX86Xstate     = 0x202
+
+	// Offsets within the area saved by xsave: the header, which tells
+	// the state components saved, and the upper halves of the YMM
+	// registers, in the standard layout the kernel hands out.
+	xsaveHeaderOffset = 512
+	xsaveYmmhOffset   = 576
+	xstateAVX         = 1 << 2
+)
+
+// Returns the floating point and vector registers of the current thread.
+func (dbp *DebuggedProcess) FPRegisters() (*FPRegisters, error) {
+	buf, err := getXState(dbp.CurrentThread.Id)
+	if err != nil {
+		// Without xsave, as on CPUs without AVX, only the
+		// area saved by fxsave is there to be read.
+		buf, err = getFPRegs(dbp.CurrentThread.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	regs := &FPRegisters{
+		Fcw:   binary.LittleEndian.Uint16(buf[0:]),
+		Fsw:   binary.LittleEndian.Uint16(buf[2:]),
+		Ftw:   buf[4],
+		Mxcsr: binary.LittleEndian.Uint32(buf[24:]),
+	}
+
+	for i := range regs.St {
+		copy(regs.St[i][:], buf[32+16*i:])
+	}
+
+	for i := range regs.Xmm {
+		copy(regs.Xmm[i][:], buf[xmmOffset(i):])
+	}
+
+	if len(buf) >= xsaveYmmhOffset+16*16 {
+		bv := binary.LittleEndian.Uint64(buf[xsaveHeaderOffset:])
+		regs.HasAVX = bv&xstateAVX != 0
+
+		if regs.HasAVX {
+			for i := range regs.Ymmh {
+				copy(regs.Ymmh[i][:], buf[xsaveYmmhOffset+16*i:])
+			}
+		}
+	}
+
+	return regs, nil
+}
+
+// Returns the value of ST(i) as a float64, to which it is rounded.
+func (r *FPRegisters) StFloat(i int) float64 {
+	st := r.St[i]
+	mant := binary.LittleEndian.Uint64(st[:8])
+	exp := int(binary.LittleEndian.Uint16(st[8:]) & 0x7fff)
+	neg := st[9]&0x80 != 0
+
+	var f float64
+	switch {
+	case exp == 0x7fff && mant<<1 == 0:
+		f = math.Inf(1)
+	case exp == 0x7fff:
+		return math.NaN()
+	case exp == 0:
+		// Denormals have the exponent of the smallest normal.
+		f = math.Ldexp(float64(mant), 1-16383-63)
+	default:
+		f = math.Ldexp(float64(mant), exp-16383-63)
+	}
+
+	if neg {
+		f = -f
+	}
+
+	return f
+}
+
+// Returns the state saved by xsave of a thread.
+func getXState(tid int) ([]byte, error) {
+	buf := make([]byte, 4096)
+	iov := syscall.Iovec{Base: &buf[0], Len: uint64(len(buf))}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceGetRegset, uintptr(tid), ntX86Xstate, uintptr(unsafe.Pointer(&iov)), 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	if iov.Len < xsaveHeaderOffset {
+		return nil, fmt.Errorf("short xsave area of %d bytes", iov.Len)
+	}
+
+	return buf[:iov.Len], nil
+}
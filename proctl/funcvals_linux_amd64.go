@@ -0,0 +1,126 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Go records, in the entries of the variables a closure captures,
+// their offset within the closure.
+const attrGoClosureOffset dwarf.Attr = 0x2907
+
+// Reads a value of function type, a pointer to a closure whose first
+// word is the address of the code of the function. The function is
+// named along with where it is defined, as in
+// "main.makeAdder.func1 at /src/main.go:10", followed by the variables
+// the closure captured, if any, as in "{base: 40}".
+func (dbp *DebuggedProcess) readFunc(addr uintptr) (string, error) {
+	closure, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if closure == 0 {
+		return "nil", nil
+	}
+
+	pc, err := dbp.readUint64(uintptr(closure))
+	if err != nil {
+		return "", err
+	}
+
+	_, _, fn := dbp.PCToLine(pc)
+	if fn == nil {
+		return fmt.Sprintf("%#x", pc), nil
+	}
+
+	file, line, _ := dbp.PCToLine(fn.Entry)
+	str := fmt.Sprintf("%s at %s:%d", fn.Name, file, line)
+
+	captured, err := dbp.closureVariables(fn.Entry, closure)
+	if err != nil {
+		return "", err
+	}
+
+	if len(captured) > 0 {
+		str += " {" + strings.Join(captured, ", ") + "}"
+	}
+
+	return str, nil
+}
+
+// Returns the variables captured by the closure at addr of the function
+// starting at entry, formatted as "name: value", in the order they are
+// laid out in the closure.
+func (dbp *DebuggedProcess) closureVariables(entry, addr uint64) ([]string, error) {
+	data, err := dbp.dwarfForPC(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := data.Reader()
+	if _, err := seekToFunctionEntry(reader, entry-dbp.bias(entry)); err != nil {
+		return nil, err
+	}
+
+	captured := make(map[int64]string)
+	for depth := 1; depth > 0; {
+		e, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if e == nil {
+			break
+		}
+
+		if e.Tag == 0 {
+			depth--
+			continue
+		}
+
+		if e.Children {
+			depth++
+		}
+
+		off, ok := e.Val(attrGoClosureOffset).(int64)
+		if !ok || e.Tag != dwarf.TagVariable {
+			continue
+		}
+
+		n, _ := e.Val(dwarf.AttrName).(string)
+
+		toff, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			continue
+		}
+
+		t, err := data.Type(toff)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := dbp.extractValue(int64(addr)+off, t)
+		if err != nil {
+			val = fmt.Sprintf("(unreadable: %s)", err)
+		}
+
+		captured[off] = fmt.Sprintf("%s: %s", n, val)
+	}
+
+	offsets := make([]int, 0, len(captured))
+	for off := range captured {
+		offsets = append(offsets, int(off))
+	}
+	sort.Ints(offsets)
+
+	vars := make([]string, 0, len(offsets))
+	for _, off := range offsets {
+		vars = append(vars, captured[int64(off)])
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,145 @@
+package proctl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Returns the address and type of the package-level variable named
+// name, as in "main.config" or "net/http.DefaultServeMux". The package
+// may be named without its path, as in "http.DefaultServeMux", in
+// which case the first package of that name declaring the variable is
+// used.
+func (dbp *DebuggedProcess) globalAddress(name string) (uint64, dwarf.Type, error) {
+	if !strings.Contains(name, ".") {
+		return 0, nil, fmt.Errorf("%s is not qualified by a package", name)
+	}
+
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Package-level variables are the children of compile units,
+		// anything declared within functions is local to them.
+		if entry.Tag == dwarf.TagSubprogram {
+			reader.SkipChildren()
+			continue
+		}
+
+		if entry.Tag != dwarf.TagVariable {
+			continue
+		}
+
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok || n != name && !strings.HasSuffix(n, "/"+name) {
+			continue
+		}
+
+		addr, typ, err := variableLocation(entry, data, 0)
+		if _, ok := err.(noLocationError); ok {
+			return dbp.symbolAddress(entry, data, n)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return uint64(addr), typ, nil
+	}
+
+	return 0, nil, fmt.Errorf("could not find package variable %s", name)
+}
+
+// Returns the address of a package-level variable whose debug
+// information entry lacks a location, as found in the symbol table,
+// along with the type recorded in the entry.
+func (dbp *DebuggedProcess) symbolAddress(entry *dwarf.Entry, data *dwarf.Data, name string) (uint64, dwarf.Type, error) {
+	offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return 0, nil, noLocationError{name}
+	}
+
+	typ, err := data.Type(offset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sym, err := dbp.elfSymbol(name)
+	if err != nil {
+		return 0, nil, noLocationError{name}
+	}
+
+	return sym.Value, typ, nil
+}
+
+// Returns the package-level variables whose name matches filter, or all
+// of them if filter is nil, sorted by name. Variables whose value cannot
+// be read are reported with the reason in place of their value.
+func (dbp *DebuggedProcess) PackageVariables(filter *regexp.Regexp) ([]*Variable, error) {
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make([]*Variable, 0)
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Tag == dwarf.TagSubprogram {
+			reader.SkipChildren()
+			continue
+		}
+
+		if entry.Tag != dwarf.TagVariable {
+			continue
+		}
+
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok || filter != nil && !filter.MatchString(n) {
+			continue
+		}
+
+		loc, typ, err := variableLocation(entry, data, 0)
+		addr := uint64(loc)
+		if _, ok := err.(noLocationError); ok {
+			addr, typ, err = dbp.symbolAddress(entry, data, n)
+		}
+		if _, ok := err.(noLocationError); ok {
+			continue
+		}
+
+		var v *Variable
+		if err == nil {
+			v, err = dbp.newVariable(n, int64(addr), typ)
+		}
+		if err != nil {
+			v = &Variable{Name: n, Value: fmt.Sprintf("(unreadable: %s)", err)}
+		}
+
+		vars = append(vars, v)
+	}
+
+	sort.Sort(byName(vars))
+
+	return vars, nil
+}
+
+type byName []*Variable
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,503 @@
+package proctl
+
+import (
+	"fmt"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+	"github.com/derekparker/delve/vendor/elf"
+)
+
+// Goroutine status values, as defined by the runtime.
+const (
+	Gidle = iota
+	Grunnable
+	Grunning
+	Gsyscall
+	Gwaiting
+	Gmoribund
+	Gdead
+)
+
+// The runtime sets this bit in the status of a goroutine
+// while its stack is being scanned by the garbage collector.
+const gscan = 0x1000
+
+// Represents a goroutine of the debugged process, as read from
+// its g struct. PC, SP and BP are the values saved by the scheduler
+// the last time the goroutine was descheduled. BP is zero with
+// runtimes which do not save the frame pointer.
+type Goroutine struct {
+	ID         int
+	Addr       uint64
+	Status     uint64
+	WaitReason string
+	PC         uint64
+	SP         uint64
+	BP         uint64
+	// Return address of the call made by the go statement
+	// which created the goroutine.
+	GoPC uint64
+	// ID of the goroutine which created this one, zero if
+	// the runtime does not record it.
+	ParentID int
+	// Profiler labels set with runtime/pprof, nil when the
+	// goroutine has none.
+	Labels map[string]string
+	// Bounds of the stack of the goroutine, which it grows
+	// down from StackHi to StackLo.
+	StackLo, StackHi uint64
+}
+
+// Describes the state of the goroutine as the runtime does in
+// tracebacks, such as "runnable" or "chan receive".
+func (g *Goroutine) State() string {
+	switch g.Status {
+	case Gidle:
+		return "idle"
+	case Grunnable:
+		return "runnable"
+	case Grunning:
+		return "running"
+	case Gsyscall:
+		return "syscall"
+	case Gwaiting:
+		if g.WaitReason != "" {
+			return g.WaitReason
+		}
+		return "waiting"
+	case Gmoribund:
+		return "moribund"
+	case Gdead:
+		return "dead"
+	}
+
+	return fmt.Sprintf("status %d", g.Status)
+}
+
+// Returns every goroutine of the debugged process that has not exited,
+// found by walking the runtime's allgs slice.
+func (dbp *DebuggedProcess) Goroutines() ([]*Goroutine, error) {
+	addr, err := dbp.symbolAddr("runtime.allgs")
+	if err != nil {
+		return nil, err
+	}
+
+	// allgs is a []*g, read the slice header.
+	ptr, err := dbp.readUint64(uintptr(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := dbp.readUint64(uintptr(addr + 8))
+	if err != nil {
+		return nil, err
+	}
+
+	goroutines := make([]*Goroutine, 0, n)
+	for i := uint64(0); i < n; i++ {
+		gaddr, err := dbp.readUint64(uintptr(ptr + i*8))
+		if err != nil {
+			return nil, err
+		}
+
+		g, err := dbp.readGoroutine(gaddr)
+		if err != nil {
+			return nil, err
+		}
+
+		if g.Status == Gdead {
+			continue
+		}
+
+		goroutines = append(goroutines, g)
+	}
+
+	return goroutines, nil
+}
+
+// Returns the goroutine running on the current thread.
+func (dbp *DebuggedProcess) CurrentGoroutine() (*Goroutine, error) {
+	g, err := dbp.currentG()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbp.readGoroutine(g)
+}
+
+// Returns the address the goroutine is executing at: for the goroutine
+// running on the current thread its program counter, for any other the
+// PC saved by the scheduler, which is stale while it is running.
+func (dbp *DebuggedProcess) GoroutinePC(g *Goroutine) (uint64, error) {
+	if cur, err := dbp.currentG(); err == nil && cur == g.Addr {
+		return dbp.CurrentPC()
+	}
+
+	return g.PC, nil
+}
+
+// Returns the goroutine with the given ID.
+func (dbp *DebuggedProcess) FindGoroutine(id int) (*Goroutine, error) {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range goroutines {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no goroutine with id %d", id)
+}
+
+// Returns the goroutines which created the goroutine with the given ID,
+// starting with its parent, as far back as they are still running. If
+// the chain is broken by a goroutine which has exited, its ID is
+// returned as missing, otherwise missing is zero. Requires a runtime
+// which records the parent of every goroutine.
+func (dbp *DebuggedProcess) GoroutineAncestors(id int) (ancestors []*Goroutine, missing int, err error) {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[int]*Goroutine, len(goroutines))
+	for _, g := range goroutines {
+		byID[g.ID] = g
+	}
+
+	g, ok := byID[id]
+	if !ok {
+		return nil, 0, fmt.Errorf("no goroutine with id %d", id)
+	}
+
+	for g.ParentID != 0 {
+		parent, ok := byID[g.ParentID]
+		if !ok {
+			return ancestors, g.ParentID, nil
+		}
+
+		// IDs are never reused, but guard against reading
+		// a g struct while the runtime recycles it.
+		if len(ancestors) == len(goroutines) {
+			break
+		}
+
+		ancestors = append(ancestors, parent)
+		g = parent
+	}
+
+	return ancestors, 0, nil
+}
+
+// Returns the stack trace of the goroutine with the given ID, containing
+// at most depth frames. If the goroutine is running on a thread its stack
+// is unwound from the thread's registers, otherwise from the scheduling
+// state saved in its g struct, which is stale while it runs.
+func (dbp *DebuggedProcess) GoroutineStacktrace(id, depth int) ([]*StackFrame, error) {
+	g, err := dbp.FindGoroutine(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbp.StacktraceOf(g, depth)
+}
+
+// Returns the stack trace of a goroutine already read, as
+// GoroutineStacktrace does, without looking the goroutine up again
+// among all of them.
+func (dbp *DebuggedProcess) StacktraceOf(g *Goroutine, depth int) ([]*StackFrame, error) {
+	if th := dbp.GoroutineThread(g); th != nil {
+		return dbp.threadStacktrace(th, depth)
+	}
+
+	return dbp.stacktrace(g.PC, g.SP, g.BP, depth)
+}
+
+// Reads the g struct at addr.
+func (dbp *DebuggedProcess) readGoroutine(addr uint64) (*Goroutine, error) {
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := dbp.readUintField(addr, gtype, "goid")
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := dbp.readStatusField(addr, gtype)
+	if err != nil {
+		return nil, err
+	}
+
+	sched, err := structField(gtype, "sched")
+	if err != nil {
+		return nil, err
+	}
+
+	schedtype, ok := sched.Type.(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for runtime.g.sched %s", sched.Type)
+	}
+
+	schedaddr := addr + uint64(sched.ByteOffset)
+
+	pc, err := dbp.readUintField(schedaddr, schedtype, "pc")
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := dbp.readUintField(schedaddr, schedtype, "sp")
+	if err != nil {
+		return nil, err
+	}
+
+	// Not every runtime saves these.
+	bp, _ := dbp.readUintField(schedaddr, schedtype, "bp")
+	gopc, _ := dbp.readUintField(addr, gtype, "gopc")
+	parent, _ := dbp.readUintField(addr, gtype, "parentGoid")
+
+	g := &Goroutine{
+		ID:       int(id),
+		Addr:     addr,
+		Status:   status &^ gscan,
+		PC:       pc,
+		SP:       sp,
+		BP:       bp,
+		GoPC:     gopc,
+		ParentID: int(parent),
+	}
+
+	if g.Status == Gwaiting {
+		g.WaitReason = dbp.readWaitReason(addr, gtype)
+	}
+
+	if stack, err := structField(gtype, "stack"); err == nil {
+		if st, ok := stack.Type.(*dwarf.StructType); ok {
+			g.StackLo, _ = dbp.readUintField(addr+uint64(stack.ByteOffset), st, "lo")
+			g.StackHi, _ = dbp.readUintField(addr+uint64(stack.ByteOffset), st, "hi")
+		}
+	}
+
+	if labels, _ := dbp.readUintField(addr, gtype, "labels"); labels != 0 {
+		g.Labels, _ = dbp.readLabels(labels)
+	}
+
+	return g, nil
+}
+
+// Reads the runtime/pprof labelMap at addr. Older releases of Go
+// define it as a map[string]string, newer ones as a slice of key
+// value pairs.
+func (dbp *DebuggedProcess) readLabels(addr uint64) (map[string]string, error) {
+	lmtype, err := dbp.findType("runtime/pprof.labelMap")
+	if err != nil {
+		return nil, err
+	}
+
+	if tt, ok := lmtype.(*dwarf.TypedefType); ok {
+		lmtype = tt.Type
+	}
+
+	st, ok := lmtype.(*dwarf.StructType)
+	if !ok {
+		return dbp.readStringMap(addr, lmtype)
+	}
+
+	// The list may be a field of an embedded LabelSet.
+	if set, err := structField(st, "LabelSet"); err == nil {
+		if setType, ok := set.Type.(*dwarf.StructType); ok {
+			addr += uint64(set.ByteOffset)
+			st = setType
+		}
+	}
+
+	list, err := structField(st, "list")
+	if err != nil {
+		return nil, err
+	}
+
+	ptr, err := dbp.readUint64(uintptr(addr + uint64(list.ByteOffset)))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := dbp.readUint64(uintptr(addr + uint64(list.ByteOffset) + 8))
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, n)
+	for i := uint64(0); i < n && i < maxLabels; i++ {
+		// Each label is a key string followed by a value string.
+		label := uintptr(ptr + i*32)
+
+		k, err := dbp.readGoString(label)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := dbp.readGoString(label + 16)
+		if err != nil {
+			return nil, err
+		}
+
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
+const maxLabels = 64
+
+// Reads the map[string]string of type typ at addr.
+func (dbp *DebuggedProcess) readStringMap(addr uint64, typ dwarf.Type) (map[string]string, error) {
+	pt, ok := underlyingType(typ).(*dwarf.PtrType)
+	if !ok || !isMapHeader(pt.Type) {
+		return nil, fmt.Errorf("unexpected type of labels %s", typ)
+	}
+
+	hmap, err := dbp.readUint64(uintptr(addr))
+	if err != nil || hmap == 0 {
+		return nil, err
+	}
+
+	m := make(map[string]string)
+	err = dbp.mapEntries(hmap, pt.Type.(*dwarf.StructType), maxLabels, func(key, value uint64, _, _ dwarf.Type) error {
+		k, err := dbp.readGoString(uintptr(key))
+		if err != nil {
+			return err
+		}
+
+		v, err := dbp.readGoString(uintptr(value))
+		if err != nil {
+			return err
+		}
+
+		m[k] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// The status field is 32 bits wide in recent runtimes.
+func (dbp *DebuggedProcess) readStatusField(addr uint64, gtype *dwarf.StructType) (uint64, error) {
+	field, err := structField(gtype, "atomicstatus", "status")
+	if err != nil {
+		return 0, err
+	}
+
+	status, err := dbp.readUint64(uintptr(addr + uint64(field.ByteOffset)))
+	if err != nil {
+		return 0, err
+	}
+
+	if field.Type.Size() == 4 {
+		status &= 0xffffffff
+	}
+
+	return status, nil
+}
+
+// Older runtimes store the reason a goroutine is waiting as a string,
+// newer ones as a waitReason enumeration we do not attempt to decode.
+func (dbp *DebuggedProcess) readWaitReason(addr uint64, gtype *dwarf.StructType) string {
+	field, err := structField(gtype, "waitreason")
+	if err != nil {
+		return ""
+	}
+
+	faddr := addr + uint64(field.ByteOffset)
+	if st, ok := field.Type.(*dwarf.StructType); ok && st.StructName == "string" {
+		reason, err := dbp.readGoString(uintptr(faddr))
+		if err != nil {
+			return ""
+		}
+		return reason
+	}
+
+	data, err := dbp.readMemory(uintptr(faddr), 1)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("wait reason %d", data[0])
+}
+
+// Reads a Go string header at addr, followed by its contents.
+func (dbp *DebuggedProcess) readGoString(addr uintptr) (string, error) {
+	const maxLen = 1024
+
+	ptr, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := dbp.readUint64(addr + 8)
+	if err != nil {
+		return "", err
+	}
+
+	if n == 0 {
+		return "", nil
+	}
+
+	if n > maxLen {
+		n = maxLen
+	}
+
+	data, err := dbp.readMemory(uintptr(ptr), uintptr(n))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Returns the address of the named symbol from the ELF symbol table.
+func (dbp *DebuggedProcess) symbolAddr(name string) (uint64, error) {
+	sym, err := dbp.elfSymbol(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return sym.Value, nil
+}
+
+// Returns the named symbol from the ELF symbol table.
+func (dbp *DebuggedProcess) elfSymbol(name string) (*elf.Symbol, error) {
+	err := dbp.loadSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range dbp.Symbols {
+		if dbp.Symbols[i].Name == name {
+			return &dbp.Symbols[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find symbol %s", name)
+}
+
+// Reads the ELF symbol table into Symbols, unless already done.
+func (dbp *DebuggedProcess) loadSymbols() error {
+	if dbp.Symbols != nil {
+		return nil
+	}
+
+	syms, err := dbp.Executable.Symbols()
+	if err != nil {
+		return err
+	}
+	dbp.Symbols = syms
+
+	return nil
+}
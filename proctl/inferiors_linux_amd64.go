@@ -0,0 +1,203 @@
+package proctl
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/derekparker/delve/vendor/elf"
+)
+
+// A process forked by the process which was let go rather than
+// followed, and the reason why.
+type SkippedChild struct {
+	Pid int
+	Err error
+}
+
+// Keeps a process forked by the process traced, as a DebuggedProcess
+// added to Forked and left stopped. A forked process starts with a copy
+// of the memory of the process, breakpoints included, which it keeps.
+// A process created by vfork shares the memory of the process instead,
+// which stays suspended until the child executes a program or exits, so
+// the child is run until it does. Processes which do not run a Go
+// program are not followed, and are added to Skipped instead.
+func (dbp *DebuggedProcess) followChild(pid int, vfork bool) error {
+	ps, err := dbp.waitNew(pid)
+	if err != nil {
+		return err
+	}
+
+	if vfork {
+		ps, err = dbp.runToExec(pid)
+		if err != nil || ps == nil {
+			return err
+		}
+	}
+
+	if err := checkGoExecutable(pid); err != nil {
+		dbp.Skipped = append(dbp.Skipped, SkippedChild{Pid: pid, Err: err})
+		if vfork {
+			return syscall.PtraceDetach(pid)
+		}
+		return dbp.releaseChild(pid, false)
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	child := newDebuggedProcess(proc, ps)
+	child.inheritSettings(dbp)
+
+	err = child.LoadInformation()
+	if err != nil {
+		return err
+	}
+
+	for addr, bp := range dbp.BreakPoints {
+		if vfork {
+			break
+		}
+
+		// Those used while stepping are of no use to the child.
+		if bp.ID == 0 {
+			_, err = syscall.PtracePokeData(pid, uintptr(addr), bp.OriginalData)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		cbp := *bp
+		cbp.OriginalData = append([]byte(nil), bp.OriginalData...)
+		cbp.HitCount = 0
+		child.BreakPoints[addr] = &cbp
+	}
+
+	dbp.Forked = append(dbp.Forked, child)
+	return nil
+}
+
+// Runs a child created by vfork until it executes a program, returning
+// the status it stopped with then, or nil if it exits first. Signals it
+// receives are delivered. The child shares the memory of the process,
+// so breakpoints it reaches are stepped over, by removing them for as
+// long as it takes, during which other threads of the process running
+// may miss them.
+func (dbp *DebuggedProcess) runToExec(pid int) (*syscall.WaitStatus, error) {
+	sig := 0
+	for {
+		err := syscall.PtraceCont(pid, sig)
+		if err != nil {
+			return nil, err
+		}
+
+		ps, err := wait(pid)
+		if err != nil {
+			return nil, err
+		}
+
+		sig = 0
+		switch {
+		case ps.Exited() || ps.Signaled():
+			return nil, nil
+		case ps.StopSignal() == syscall.SIGTRAP && ps.TrapCause() == syscall.PTRACE_EVENT_EXEC:
+			return ps, nil
+		case ps.StopSignal() == syscall.SIGTRAP:
+			err = dbp.stepOverShared(pid)
+			if err != nil {
+				return nil, err
+			}
+		case ps.StopSignal() != syscall.SIGSTOP:
+			sig = int(ps.StopSignal())
+		}
+	}
+}
+
+// Steps a process sharing the memory of the process over the breakpoint
+// it has just executed, if any.
+func (dbp *DebuggedProcess) stepOverShared(pid int) error {
+	var regs syscall.PtraceRegs
+	err := syscall.PtraceGetRegs(pid, &regs)
+	if err != nil {
+		return err
+	}
+
+	bp, ok := dbp.BreakPoints[regs.PC()-1]
+	if !ok {
+		return nil
+	}
+
+	regs.SetPC(bp.Addr)
+	err = syscall.PtraceSetRegs(pid, &regs)
+	if err != nil {
+		return err
+	}
+
+	_, err = syscall.PtracePokeData(pid, uintptr(bp.Addr), bp.OriginalData)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.PtraceSingleStep(pid)
+	if err == nil {
+		_, err = wait(pid)
+	}
+
+	_, perr := syscall.PtracePokeData(pid, uintptr(bp.Addr), []byte{0xCC})
+	if err != nil {
+		return err
+	}
+
+	return perr
+}
+
+// Takes over the program the process executed, in place of the one it
+// ran: breakpoints and the debugging information of the old program are
+// gone along with it, and so are the other threads, which the kernel
+// ends. The thread which executed the program carries on as the thread
+// group leader.
+func (dbp *DebuggedProcess) handleExec(th *ThreadContext) error {
+	exec := newDebuggedProcess(dbp.Process, th.Status)
+	exec.inheritSettings(dbp)
+	exec.Forked = dbp.Forked
+	exec.Skipped = dbp.Skipped
+
+	th.Id = dbp.Pid
+	exec.Threads = map[int]*ThreadContext{th.Id: th}
+	exec.CurrentThread = th
+
+	*dbp = *exec
+
+	err := checkGoExecutable(dbp.Pid)
+	if err != nil {
+		return err
+	}
+
+	return dbp.LoadInformation()
+}
+
+// Checks that the executable a process runs can be debugged, which
+// takes the Go symbol table and call frame information.
+func checkGoExecutable(pid int) error {
+	path, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exe, err := elf.NewFile(f)
+	if err != nil {
+		return fmt.Errorf("%s is not an ELF executable", path)
+	}
+
+	if exe.Section(".gopclntab") == nil || exe.Section(".debug_frame") == nil {
+		return fmt.Errorf("%s is not a Go program built with debugging information", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package proctl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Reports whether t is the type of an interface value: runtime.iface
+// for interfaces with methods, runtime.eface for the empty interface.
+func isInterface(t *dwarf.StructType) bool {
+	return t.StructName == "runtime.iface" || t.StructName == "runtime.eface"
+}
+
+// Formats the interface value of type t at addr as its dynamic type
+// followed by its dynamic value, as in "(*main.FooBar) *main.FooBar
+// {Baz: 5, Bur: strum}", or "nil" for a nil interface.
+func (dbp *DebuggedProcess) readInterface(addr uintptr, t *dwarf.StructType) (string, error) {
+	typeaddr, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if typeaddr == 0 {
+		return "nil", nil
+	}
+
+	// Interfaces with methods point to an itab, which
+	// points to the type descriptor in turn.
+	if t.StructName == "runtime.iface" {
+		itab, err := dbp.findStructType("runtime.itab")
+		if err != nil {
+			return "", err
+		}
+
+		typeaddr, err = dbp.readUintField(typeaddr, itab, "_type", "Type")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	typ, err := dbp.runtimeType(typeaddr)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := structField(t, "data")
+	if err != nil {
+		return "", err
+	}
+
+	// Values which are a single pointer are stored in the data word
+	// itself, anything else is stored elsewhere and pointed to by it.
+	valaddr := uint64(addr) + uint64(data.ByteOffset)
+	if !pointerShaped(typ) {
+		valaddr, err = dbp.readUint64(uintptr(valaddr))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	val, err := dbp.extractValue(int64(valaddr), typ)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("(%s) %s", typ, val), nil
+}
+
+// Go records the address of the runtime type descriptor
+// of each type in the type's debug information entry.
+const attrGoRuntimeType dwarf.Attr = 0x2904
+
+// Returns the type whose runtime type descriptor is at addr. Linkers
+// which do not record descriptors in the debug information name the
+// symbol of each descriptor after its type instead, as in type.main.T.
+func (dbp *DebuggedProcess) runtimeType(addr uint64) (dwarf.Type, error) {
+	if dbp.runtimeTypes == nil {
+		err := dbp.loadRuntimeTypes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if t, ok := dbp.runtimeTypes[addr]; ok {
+		return t, nil
+	}
+
+	err := dbp.loadSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sym := range dbp.Symbols {
+		if sym.Value == addr && strings.HasPrefix(sym.Name, "type.") {
+			return dbp.findType(strings.TrimPrefix(sym.Name, "type."))
+		}
+	}
+
+	return nil, fmt.Errorf("could not find the type described at %#v", addr)
+}
+
+// Maps the address of every runtime type descriptor recorded in the
+// debug information of the main executable to its type.
+func (dbp *DebuggedProcess) loadRuntimeTypes() error {
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return err
+	}
+
+	types := make(map[uint64]dwarf.Type)
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return err
+		}
+
+		addr, ok := entry.Val(attrGoRuntimeType).(uint64)
+		if !ok {
+			continue
+		}
+
+		t, err := data.Type(entry.Offset)
+		if err != nil {
+			continue
+		}
+
+		types[addr] = t
+	}
+
+	dbp.runtimeTypes = types
+	return nil
+}
+
+// Reports whether values of type t consist of a single pointer, such
+// as pointers, maps, channels and structs or arrays holding only one
+// of those, which the runtime stores directly in interface values.
+func pointerShaped(t dwarf.Type) bool {
+	switch t := underlyingType(t).(type) {
+	case *dwarf.PtrType:
+		return true
+	case *dwarf.StructType:
+		return len(t.Field) == 1 && pointerShaped(t.Field[0].Type)
+	case *dwarf.ArrayType:
+		return t.Count == 1 && pointerShaped(t.Type)
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Number of entries of a map read when printing it, unless
+// DebuggedProcess.MaxMapEntries says otherwise.
+const defaultMaxMapEntries = 64
+
+// Reports whether t is the type of a map header, which Go
+// describes as a struct named hash<K,V>.
+func isMapHeader(t dwarf.Type) bool {
+	st, ok := t.(*dwarf.StructType)
+	return ok && strings.HasPrefix(st.StructName, "hash<")
+}
+
+// Formats the map whose header, of type hdr, is pointed to by the
+// pointer at addr, as in "len: 2 [a: 1, b: 2]". Entries are sorted by
+// key, and only the first MaxMapEntries of them found are read.
+func (dbp *DebuggedProcess) readMap(addr uintptr, hdr *dwarf.StructType) (string, error) {
+	hmap, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if hmap == 0 {
+		return "nil", nil
+	}
+
+	count, err := dbp.readUintField(hmap, hdr, "count")
+	if err != nil {
+		return "", err
+	}
+
+	max := dbp.MaxMapEntries
+	if max <= 0 {
+		max = defaultMaxMapEntries
+	}
+
+	var entries []string
+	err = dbp.mapEntries(hmap, hdr, max, func(key, value uint64, keyType, valueType dwarf.Type) error {
+		k, err := dbp.extractValue(int64(key), keyType)
+		if err != nil {
+			return err
+		}
+
+		v, err := dbp.extractValue(int64(value), valueType)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, k+": "+v)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(entries)
+
+	if uint64(len(entries)) < count {
+		entries = append(entries, "...")
+	}
+
+	return fmt.Sprintf("len: %d [%s]", count, strings.Join(entries, ", ")), nil
+}
+
+// Calls fn with the addresses and types of the key and value of up to
+// max entries of the map whose header is at hmap, found by walking the
+// buckets of the runtime's hash table. Entries still in the old buckets
+// of a map which is being grown are not found.
+func (dbp *DebuggedProcess) mapEntries(hmap uint64, hdr *dwarf.StructType, max int, fn func(key, value uint64, keyType, valueType dwarf.Type) error) error {
+	// tophash values of empty slots, anything above is the
+	// top byte of the hash of the slot's key.
+	const emptyOne = 1
+
+	field, err := structField(hdr, "B")
+	if err != nil {
+		return err
+	}
+
+	b, err := dbp.readMemory(uintptr(hmap+uint64(field.ByteOffset)), 1)
+	if err != nil {
+		return err
+	}
+
+	buckets, err := dbp.readUintField(hmap, hdr, "buckets")
+	if err != nil {
+		return err
+	}
+
+	field, err = structField(hdr, "buckets")
+	if err != nil {
+		return err
+	}
+
+	bucketType, err := bucketStruct(field.Type)
+	if err != nil {
+		return err
+	}
+
+	tophashes, err := structField(bucketType, "tophash")
+	if err != nil {
+		return err
+	}
+
+	keys, err := structField(bucketType, "keys")
+	if err != nil {
+		return err
+	}
+
+	values, err := structField(bucketType, "values")
+	if err != nil {
+		return err
+	}
+
+	overflow, err := structField(bucketType, "overflow")
+	if err != nil {
+		return err
+	}
+
+	keyType, valueType := arrayElem(keys.Type), arrayElem(values.Type)
+	if keyType == nil || valueType == nil {
+		return fmt.Errorf("unexpected layout of %s", bucketType.StructName)
+	}
+	bucketCnt := tophashes.Type.Size()
+
+	n := 0
+	for i := uint64(0); i < 1<<b[0] && n < max; i++ {
+		bucket := buckets + i*uint64(bucketType.Size())
+
+		for bucket != 0 && n < max {
+			tophash, err := dbp.readMemory(uintptr(bucket+uint64(tophashes.ByteOffset)), uintptr(bucketCnt))
+			if err != nil {
+				return err
+			}
+
+			for j, h := range tophash {
+				if n == max {
+					break
+				}
+
+				if h <= emptyOne {
+					continue
+				}
+
+				key := bucket + uint64(keys.ByteOffset+int64(j)*keyType.Size())
+				value := bucket + uint64(values.ByteOffset+int64(j)*valueType.Size())
+				err = fn(key, value, keyType, valueType)
+				if err != nil {
+					return err
+				}
+				n++
+			}
+
+			bucket, err = dbp.readUint64(uintptr(bucket + uint64(overflow.ByteOffset)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the bucket struct the buckets field of a map header points to.
+func bucketStruct(t dwarf.Type) (*dwarf.StructType, error) {
+	if ptr, ok := underlyingType(t).(*dwarf.PtrType); ok {
+		if st, ok := underlyingType(ptr.Type).(*dwarf.StructType); ok {
+			return st, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected type of map buckets %s", t)
+}
+
+// Returns the element type of an array type, or nil for other types.
+func arrayElem(t dwarf.Type) dwarf.Type {
+	at, ok := underlyingType(t).(*dwarf.ArrayType)
+	if !ok {
+		return nil
+	}
+
+	return at.Type
+}
@@ -0,0 +1,92 @@
+package proctl
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const sysProcessVMReadv = 310
+
+// Reads size bytes of the memory of the thread tid at addr, copying them
+// in a single system call with process_vm_readv where possible. Memory it
+// can not read, such as pages without read permission, is read from
+// /proc/<tid>/mem instead, and failing that a word at a time with ptrace,
+// which is slow for anything but a few words.
+func (dbp *DebuggedProcess) readThreadMemory(tid int, addr uintptr, size uintptr) ([]byte, error) {
+	buf := make([]byte, size)
+	if size == 0 {
+		return buf, nil
+	}
+
+	if !dbp.noProcessVMReadv {
+		err := processVMReadv(tid, addr, buf)
+		if err == nil {
+			return buf, nil
+		}
+
+		// Kernels without it, or which forbid it, are not
+		// asked again.
+		if err == syscall.ENOSYS || err == syscall.EPERM {
+			dbp.noProcessVMReadv = true
+		}
+	}
+
+	if err := readProcMem(tid, addr, buf); err == nil {
+		return buf, nil
+	}
+
+	if _, err := syscall.PtracePeekData(tid, addr, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Reads the memory of a process at addr into buf with process_vm_readv.
+// Reads which are cut short, by an unmapped page, fail.
+func processVMReadv(pid int, addr uintptr, buf []byte) error {
+	local := syscall.Iovec{Base: &buf[0], Len: uint64(len(buf))}
+	remote := struct {
+		base uintptr
+		len  uint64
+	}{addr, uint64(len(buf))}
+
+	n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid), uintptr(unsafe.Pointer(&local)), 1, uintptr(unsafe.Pointer(&remote)), 1, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	if int(n) != len(buf) {
+		return fmt.Errorf("short read of %d bytes out of %d", n, len(buf))
+	}
+
+	return nil
+}
+
+// Reads the memory of a process at addr into buf from /proc/<pid>/mem,
+// through which a tracer can read pages the process can not.
+func readProcMem(pid int, addr uintptr, buf []byte) error {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Addresses above 1<<63 do not fit the offset of a read.
+	if int64(addr) < 0 {
+		return fmt.Errorf("address %#x out of range", addr)
+	}
+
+	n, err := f.ReadAt(buf, int64(addr))
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("short read of %d bytes out of %d", n, len(buf))
+	}
+
+	return err
+}
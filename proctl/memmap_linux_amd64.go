@@ -0,0 +1,195 @@
+package proctl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/elf"
+)
+
+// A region of memory mapped into the process, as listed in
+// /proc/<pid>/maps.
+type MemoryRegion struct {
+	Start, End uint64
+	// Permissions, as in "r-xp": readable, writable, executable,
+	// and private or shared.
+	Perms  string
+	Offset uint64
+	// The file mapped, a name between brackets for special regions
+	// such as "[heap]" and "[stack]", or empty for anonymous memory.
+	Path string
+}
+
+// Returns the regions of memory mapped into the process, ordered by
+// address.
+func (dbp *DebuggedProcess) MemoryMap() ([]MemoryRegion, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", dbp.Pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var regions []MemoryRegion
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address perms offset dev inode pathname
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid mapping %q", scanner.Text())
+		}
+
+		r := MemoryRegion{Perms: fields[1]}
+		if r.Start, err = strconv.ParseUint(bounds[0], 16, 64); err != nil {
+			return nil, err
+		}
+		if r.End, err = strconv.ParseUint(bounds[1], 16, 64); err != nil {
+			return nil, err
+		}
+		if r.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, err
+		}
+
+		// Paths may contain spaces.
+		if len(fields) > 5 {
+			r.Path = strings.Join(fields[5:], " ")
+		}
+
+		regions = append(regions, r)
+	}
+
+	return regions, scanner.Err()
+}
+
+// Describes the memory at addr for annotating addresses: the variable of
+// the executable it is in, as in "main.config+0x8", the goroutine whose
+// stack it is on, as in "stack of goroutine 7", or else the region it
+// falls in, either the file mapped there, a special region such as
+// "heap" or "stack", or "anonymous" for anonymous memory, where Go
+// allocates its heap. Empty for addresses not mapped.
+func (dbp *DebuggedProcess) DescribeAddress(addr uint64) string {
+	if name := dbp.dataSymbolAt(addr); name != "" {
+		return name
+	}
+
+	regions, err := dbp.MemoryMap()
+	if err != nil {
+		return ""
+	}
+
+	for _, r := range regions {
+		if addr < r.Start || addr >= r.End {
+			continue
+		}
+
+		switch {
+		case r.Path == "":
+			// Goroutine stacks are allocated from the heap.
+			gs, _ := dbp.Goroutines()
+			for _, g := range gs {
+				if addr >= g.StackLo && addr < g.StackHi {
+					return fmt.Sprintf("stack of goroutine %d", g.ID)
+				}
+			}
+			return "anonymous"
+		case strings.HasPrefix(r.Path, "["):
+			return strings.Trim(r.Path, "[]")
+		}
+
+		return filepath.Base(r.Path)
+	}
+
+	return ""
+}
+
+// Returns the name of the data symbol of the executable addr is in,
+// along with the offset of addr within it, if any.
+func (dbp *DebuggedProcess) dataSymbolAt(addr uint64) string {
+	if err := dbp.loadSymbols(); err != nil {
+		return ""
+	}
+
+	for _, sym := range dbp.Symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_OBJECT || addr < sym.Value || addr >= sym.Value+sym.Size {
+			continue
+		}
+
+		if off := addr - sym.Value; off > 0 {
+			return fmt.Sprintf("%s+%#x", sym.Name, off)
+		}
+		return sym.Name
+	}
+
+	return ""
+}
+
+// Memory is searched this many bytes at a time.
+const searchChunk = 1 << 20
+
+// Returns the addresses from start up to end at which pattern is found in
+// the memory of the process, at most max of them. Regions which are not
+// readable, or not mapped, are skipped.
+func (dbp *DebuggedProcess) SearchMemory(start, end uint64, pattern []byte, max int) ([]uint64, error) {
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	regions, err := dbp.MemoryMap()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []uint64
+	for _, r := range regions {
+		if r.End <= start || r.Start >= end || !strings.HasPrefix(r.Perms, "r") {
+			continue
+		}
+
+		from, to := r.Start, r.End
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+
+		// Chunks overlap by the length of the pattern, less one,
+		// so that matches across chunks are found.
+		for at := from; at < to; at += searchChunk {
+			n := to - at
+			if n > searchChunk+uint64(len(pattern)-1) {
+				n = searchChunk + uint64(len(pattern)-1)
+			}
+
+			data, err := dbp.ReadMemory(at, int(n))
+			if err != nil {
+				// Such as the vvar page of the kernel.
+				break
+			}
+
+			for i := 0; ; i++ {
+				j := bytes.Index(data[i:], pattern)
+				if j < 0 || i+j >= searchChunk {
+					break
+				}
+
+				i += j
+				found = append(found, at+uint64(i))
+				if len(found) == max {
+					return found, nil
+				}
+			}
+		}
+	}
+
+	return found, nil
+}
@@ -0,0 +1,75 @@
+package proctl
+
+import "fmt"
+
+// Reads of memory larger than this are split into several.
+const memoryBatch = 4096
+
+// Reads size bytes of the memory of the process starting at addr,
+// a batch at a time. Memory is returned as the process sees it, with
+// the instructions breakpoints replaced in place of the breakpoints.
+func (dbp *DebuggedProcess) ReadMemory(addr uint64, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		n := size - len(data)
+		if n > memoryBatch {
+			n = memoryBatch
+		}
+
+		at := addr + uint64(len(data))
+		buf, err := dbp.readMemory(uintptr(at), uintptr(n))
+		if err != nil {
+			return nil, fmt.Errorf("could not read memory at %#x: %s", at, err)
+		}
+
+		data = append(data, buf...)
+	}
+
+	for bpaddr, bp := range dbp.BreakPoints {
+		if bpaddr >= addr && bpaddr < addr+uint64(size) {
+			copy(data[bpaddr-addr:], bp.OriginalData)
+		}
+	}
+
+	return data, nil
+}
+
+// Writes data to the memory of the process starting at addr, a batch at
+// a time, regardless of its protection. Breakpoints within the memory
+// written stay set, the bytes written in their place become the ones
+// restored when they are cleared.
+func (dbp *DebuggedProcess) WriteMemory(addr uint64, data []byte) error {
+	data = append([]byte(nil), data...)
+
+	var hidden []*BreakPoint
+	for bpaddr, bp := range dbp.BreakPoints {
+		if bpaddr >= addr && bpaddr < addr+uint64(len(data)) {
+			hidden = append(hidden, bp)
+		}
+	}
+
+	// Breakpoints replace a single byte with an int3 instruction.
+	original := make([]byte, len(hidden))
+	for i, bp := range hidden {
+		original[i] = data[bp.Addr-addr]
+		data[bp.Addr-addr] = 0xCC
+	}
+
+	for off := 0; off < len(data); off += memoryBatch {
+		end := off + memoryBatch
+		if end > len(data) {
+			end = len(data)
+		}
+
+		at := addr + uint64(off)
+		if err := dbp.writeMemory(uintptr(at), data[off:end]); err != nil {
+			return fmt.Errorf("could not write memory at %#x: %s", at, err)
+		}
+	}
+
+	for i, bp := range hidden {
+		bp.OriginalData[0] = original[i]
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"fmt"
+)
+
+// The runtime function implementing the go statement.
+const newprocFunction = "runtime.newproc"
+
+// Sets a breakpoint on the runtime function called by every go
+// statement, so that execution stops whenever a goroutine is created.
+func (dbp *DebuggedProcess) BreakOnGo() (*BreakPoint, error) {
+	fn := dbp.LookupFunc(newprocFunction)
+	if fn == nil {
+		return nil, fmt.Errorf("could not find %s", newprocFunction)
+	}
+
+	return dbp.Break(uintptr(fn.Entry))
+}
+
+// Reports whether bp is the breakpoint set by BreakOnGo.
+func (bp *BreakPoint) IsGo() bool {
+	return bp.FunctionName == newprocFunction
+}
+
+// When stopped at the breakpoint set by BreakOnGo, returns the function
+// the new goroutine will run and the frame of the go statement.
+func (dbp *DebuggedProcess) GoStatement() (*gosym.Func, *StackFrame, error) {
+	frames, err := dbp.Stacktrace(2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if frames[0].Fn.Name != newprocFunction || len(frames) < 2 {
+		return nil, nil, fmt.Errorf("not stopped at a go statement")
+	}
+
+	// fn is a *funcval, the first word of
+	// which is the address of the code.
+	addr, err := dbp.frameVariableAddr(frames[0], "fn")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fv, err := dbp.readUint64(uintptr(addr))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pc, err := dbp.readUint64(uintptr(fv))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, _, fn := dbp.PCToLine(pc)
+	if fn == nil {
+		return nil, nil, fmt.Errorf("no function at %#v", pc)
+	}
+
+	return fn, frames[1], nil
+}
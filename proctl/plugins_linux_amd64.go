@@ -0,0 +1,242 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/derekparker/delve/dwarf/frame"
+	"github.com/derekparker/delve/vendor/dwarf"
+	"github.com/derekparker/delve/vendor/elf"
+)
+
+// Represents a Go plugin (a shared object built with -buildmode=plugin)
+// which has been loaded into the address space of the debugged process.
+// Bias is the difference between the address the plugin was loaded at
+// and the address it was linked at.
+type Plugin struct {
+	Path         string
+	Bias         uint64
+	Executable   *elf.File
+	GoSymTable   *gosym.Table
+	FrameEntries *frame.FrameDescriptionEntries
+}
+
+// Reports whether pc falls within the text of the plugin.
+func (p *Plugin) Cover(pc uint64) bool {
+	text := p.Executable.Section(".text")
+	if text == nil {
+		return false
+	}
+
+	start := text.Addr + p.Bias
+	return pc >= start && pc < start+text.Size
+}
+
+// Scans the memory map for shared objects that have been loaded since
+// the last time we looked, and loads symbol and frame information for
+// every one of them that is a Go plugin. Objects which can not be read,
+// such as those deleted since they were loaded, are skipped and recorded
+// in PluginErrors rather than failing every stop.
+func (dbp *DebuggedProcess) updatePlugins() error {
+	mappings, err := dbp.sharedObjectMappings()
+	if err != nil {
+		return err
+	}
+
+	for path, base := range mappings {
+		if _, ok := dbp.sharedObjects[path]; ok {
+			continue
+		}
+		dbp.sharedObjects[path] = struct{}{}
+
+		plugin, err := loadPlugin(path, base)
+		if err != nil {
+			dbp.PluginErrors = append(dbp.PluginErrors, fmt.Errorf("skipping shared object %s: %s", path, err))
+			continue
+		}
+
+		if plugin != nil {
+			dbp.Plugins = append(dbp.Plugins, plugin)
+		}
+	}
+
+	return nil
+}
+
+// Returns the plugin which contains pc, or nil if pc belongs
+// to the main executable or to non Go code.
+func (dbp *DebuggedProcess) pluginForPC(pc uint64) *Plugin {
+	for _, p := range dbp.Plugins {
+		if p.Cover(pc) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Returns the load bias of the object containing pc, used to translate
+// run time addresses into the link time addresses found in its
+// debug information.
+func (dbp *DebuggedProcess) bias(pc uint64) uint64 {
+	if p := dbp.pluginForPC(pc); p != nil {
+		return p.Bias
+	}
+
+	return 0
+}
+
+// Returns the file, line and function of the given pc, consulting
+// loaded plugins if the main executable does not know about it.
+func (dbp *DebuggedProcess) PCToLine(pc uint64) (string, int, *gosym.Func) {
+	if p := dbp.pluginForPC(pc); p != nil {
+		f, l, fn := p.GoSymTable.PCToLine(pc - p.Bias)
+		return f, l, relocateFunc(fn, p.Bias)
+	}
+
+	return dbp.GoSymTable.PCToLine(pc)
+}
+
+// Looks up the named function in the main executable
+// and then in every loaded plugin.
+func (dbp *DebuggedProcess) LookupFunc(name string) *gosym.Func {
+	if fn := dbp.GoSymTable.LookupFunc(name); fn != nil {
+		return fn
+	}
+
+	for _, p := range dbp.Plugins {
+		if fn := p.GoSymTable.LookupFunc(name); fn != nil {
+			return relocateFunc(fn, p.Bias)
+		}
+	}
+
+	return nil
+}
+
+// Returns the pc for the given file and line, searching the main
+// executable and then every loaded plugin.
+func (dbp *DebuggedProcess) LineToPC(file string, line int) (uint64, *gosym.Func, error) {
+	pc, fn, err := dbp.GoSymTable.LineToPC(file, line)
+	if err == nil {
+		return pc, fn, nil
+	}
+
+	for _, p := range dbp.Plugins {
+		ppc, pfn, perr := p.GoSymTable.LineToPC(file, line)
+		if perr == nil {
+			return ppc + p.Bias, relocateFunc(pfn, p.Bias), nil
+		}
+	}
+
+	return 0, nil, err
+}
+
+// Returns the FrameDescriptionEntry for pc from either the
+// main executable or the plugin that contains it.
+func (dbp *DebuggedProcess) FDEForPC(pc uint64) (*frame.FrameDescriptionEntry, error) {
+	if p := dbp.pluginForPC(pc); p != nil {
+		return p.FrameEntries.FDEForPC(pc)
+	}
+
+	return dbp.FrameEntries.FDEForPC(pc)
+}
+
+// Returns the debug information of the object containing pc.
+func (dbp *DebuggedProcess) dwarfForPC(pc uint64) (*dwarf.Data, error) {
+	if p := dbp.pluginForPC(pc); p != nil {
+		return p.Executable.DWARF()
+	}
+
+	return dbp.Executable.DWARF()
+}
+
+func relocateFunc(fn *gosym.Func, bias uint64) *gosym.Func {
+	if fn == nil || bias == 0 {
+		return fn
+	}
+
+	rfn := *fn
+	sym := *fn.Sym
+	rfn.Sym = &sym
+	rfn.Entry += bias
+	rfn.End += bias
+	rfn.Value += bias
+
+	return &rfn
+}
+
+// Opens the shared object at path and, if it contains Go symbol
+// information, returns a Plugin describing it. Returns nil for
+// shared objects which are not Go plugins, such as libc.
+func loadPlugin(path string, base uint64) (*Plugin, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := elf.NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+
+	if exe.Section(".gopclntab") == nil {
+		f.Close()
+		return nil, nil
+	}
+
+	var linkBase uint64
+	for _, prog := range exe.Progs {
+		if prog.Type == elf.PT_LOAD {
+			linkBase = prog.Vaddr &^ (uint64(os.Getpagesize()) - 1)
+			break
+		}
+	}
+
+	plugin := &Plugin{Path: path, Bias: base - linkBase, Executable: exe}
+
+	plugin.GoSymTable, err = parseGoSymbols(exe)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not load symbols for plugin %s: %s", path, err)
+	}
+
+	if sec := exe.Section(".debug_frame"); sec != nil {
+		debugFrame, err := sec.Data()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not get .debug_frame section for plugin %s: %s", path, err)
+		}
+		plugin.FrameEntries = frame.ParseWithOffset(debugFrame, plugin.Bias)
+	} else {
+		plugin.FrameEntries = frame.NewFrameIndex()
+	}
+
+	return plugin, nil
+}
+
+// Returns the base address of every file backed shared object
+// mapped into the process.
+func (dbp *DebuggedProcess) sharedObjectMappings() (map[string]uint64, error) {
+	regions, err := dbp.MemoryMap()
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]uint64)
+	for _, r := range regions {
+		if !strings.Contains(r.Path, ".so") || r.Offset != 0 {
+			continue
+		}
+
+		if _, ok := mappings[r.Path]; ok {
+			continue
+		}
+
+		mappings[r.Path] = r.Start
+	}
+
+	return mappings, nil
+}
@@ -7,17 +7,20 @@ import (
 	"debug/gosym"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
-	"unsafe"
 
 	"github.com/derekparker/delve/dwarf/frame"
 	"github.com/derekparker/delve/dwarf/op"
 	"github.com/derekparker/delve/vendor/dwarf"
 	"github.com/derekparker/delve/vendor/elf"
+
+	"golang.org/x/arch/x86/x86asm"
 )
 
 // Struct representing a debugged process. Holds onto pid, register values,
@@ -31,24 +34,145 @@ type DebuggedProcess struct {
 	Symbols      []elf.Symbol
 	GoSymTable   *gosym.Table
 	FrameEntries *frame.FrameDescriptionEntries
-	BreakPoints  map[uint64]*BreakPoint
+	// Breakpoints belong to the process rather than to a thread: the
+	// INT3 is written to memory shared by every thread, so a trap taken
+	// by any thread must be recognized through this map, and the
+	// original instruction is restored exactly once when clearing.
+	BreakPoints map[uint64]*BreakPoint
+	Plugins     []*Plugin
+	// Shared objects which could not be loaded while looking for
+	// plugins since the caller last reset this.
+	PluginErrors []error
+	Watchpoints  [maxWatchpoints]*Watchpoint
+	// Watchpoints the debugger moved or cleared on its own since the
+	// caller last reset this, because the variable they watched moved
+	// or went out of scope.
+	WatchpointChanges []WatchpointChange
+
+	// Every traced thread of the process, by thread ID, and the
+	// thread registers are read from and execution is stepped on.
+	// CurrentThread is the thread which last stopped.
+	Threads       map[int]*ThreadContext
+	CurrentThread *ThreadContext
+
+	// Executes the process for Continue and Step, in place of
+	// PtraceBackend when not nil.
+	Backend Backend
+
+	// Called with the record of every tracepoint hit. When nil
+	// the record is dropped.
+	TraceHandler func(*TraceRecord)
+
+	// Patterns naming functions Step never stops in, such as
+	// "runtime.*". A * matches any sequence of characters,
+	// including the slashes of a package path.
+	SkipFunctions []string
+
+	// Number of entries read from a map when printing it,
+	// defaultMaxMapEntries when zero.
+	MaxMapEntries int
+
+	// Allow Call to run functions of the process.
+	AllowCalls bool
+
+	// Base integers are printed in: 2, 8, 10 or 16. Decimal
+	// when zero.
+	IntegerBase int
+
+	// Keep the processes the process forks traced, rather than
+	// detaching from them, so that they can be debugged as well.
+	FollowForks bool
+	// Processes forked by the process and followed, which have not
+	// been taken over yet. Each is debugged through its own
+	// DebuggedProcess, and left stopped until resumed through it.
+	Forked []*DebuggedProcess
+	// Processes forked by the process which were not followed,
+	// since the caller last reset this.
+	Skipped []SkippedChild
+
+	breakpointIDCounter int
+	sharedObjects       map[string]struct{}
+	types               map[string]dwarf.Type
+	formatters          map[string]Formatter
+	constants           map[string][]namedConstant
+	runtimeTypes        map[uint64]dwarf.Type
+
+	// Set once process_vm_readv turns out to be unavailable.
+	noProcessVMReadv bool
+
+	// Page instructions replaced by breakpoints are executed from when
+	// stepping over them, or why it could not be mapped.
+	displacedAddr uint64
+	displacedErr  error
+
+	// Initial stops of new threads and forked processes seen before
+	// the event reporting their creation, by ID.
+	newStops map[int]*syscall.WaitStatus
 }
 
 // Represents a single breakpoint. Stores information on the break
 // point including the byte of data that originally was stored at that
 // address.
 type BreakPoint struct {
+	// Identifies the breakpoint to the user. Zero for the
+	// temporary breakpoints used internally while stepping.
+	ID           int
 	FunctionName string
 	File         string
 	Line         int
 	Addr         uint64
 	OriginalData []byte
+	// Go boolean expression which must evaluate to true, in the
+	// frame the breakpoint was hit in, for execution to stop.
+	// Empty for an unconditional breakpoint. The number of times the
+	// breakpoint has been hit, including this one, is available to the
+	// condition as hitcount, as in "hitcount % 10 == 0".
+	Condition string
+	// Number of times execution has reached the breakpoint,
+	// whether or not its condition was met.
+	HitCount uint64
+	// Clear the breakpoint the first time it stops execution.
+	OneShot bool
+	// When non zero, clear the breakpoint once it has been hit
+	// this many times, whether or not its condition was met.
+	MaxHits uint64
+	// When non zero, only the OS thread with this ID stops at the
+	// breakpoint, as for code locked to a thread with
+	// runtime.LockOSThread. Other threads pass over it without
+	// it counting as a hit.
+	ThreadID int
+	// Record each hit of the breakpoint and resume rather than
+	// stopping execution, see TraceRecord.
+	Tracepoint bool
+	// Makes the breakpoint a logpoint: a message built from this
+	// template is recorded on each hit and execution resumed.
+	// Expressions between braces, as in "id={req.ID}", are evaluated
+	// and replaced by their value.
+	LogMessage string
 }
 
 type Variable struct {
 	Name  string
 	Value string
 	Type  string
+	// Constants of the type of an integer which its value equals,
+	// as in "Running" or "Read|Exec", printed along with the value
+	// by Formatted. Empty when no constant describes the value.
+	Constant string
+
+	// Predeclared type the values of a type declared in a package
+	// are represented as, as in int64 for main.State, see basicType.
+	basic string
+}
+
+// Returns the value of the variable as printed, preceded by the
+// constants of its type it equals, as in "Running (1)".
+func (v *Variable) Formatted() string {
+	if v.Constant == "" {
+		return v.Value
+	}
+
+	return v.Constant + " (" + v.Value + ")"
 }
 
 type BreakPointExistsError struct {
@@ -78,12 +202,16 @@ func NewDebugProcess(pid int) (*DebuggedProcess, error) {
 		return nil, err
 	}
 
-	debuggedProc := DebuggedProcess{
-		Pid:          pid,
-		Regs:         new(syscall.PtraceRegs),
-		Process:      proc,
-		ProcessState: ps,
-		BreakPoints:  make(map[uint64]*BreakPoint),
+	err = syscall.PtraceSetOptions(pid, traceOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	debuggedProc := newDebuggedProcess(proc, ps)
+
+	err = debuggedProc.attachThreads()
+	if err != nil {
+		return nil, err
 	}
 
 	err = debuggedProc.LoadInformation()
@@ -91,7 +219,30 @@ func NewDebugProcess(pid int) (*DebuggedProcess, error) {
 		return nil, err
 	}
 
-	return &debuggedProc, nil
+	return debuggedProc, nil
+}
+
+// Returns a DebuggedProcess for a traced process stopped with status ps,
+// whose only thread known yet is its thread group leader.
+func newDebuggedProcess(proc *os.Process, ps *syscall.WaitStatus) *DebuggedProcess {
+	dbp := &DebuggedProcess{
+		Pid:          proc.Pid,
+		Regs:         new(syscall.PtraceRegs),
+		Process:      proc,
+		ProcessState: ps,
+		BreakPoints:  make(map[uint64]*BreakPoint),
+		Threads:      make(map[int]*ThreadContext),
+
+		sharedObjects: make(map[string]struct{}),
+		types:         make(map[string]dwarf.Type),
+		formatters:    make(map[string]Formatter),
+		newStops:      make(map[int]*syscall.WaitStatus),
+	}
+
+	dbp.CurrentThread = &ThreadContext{Id: proc.Pid, Status: ps}
+	dbp.Threads[proc.Pid] = dbp.CurrentThread
+
+	return dbp
 }
 
 // Finds the executable from /proc/<pid>/exe and then
@@ -99,6 +250,8 @@ func NewDebugProcess(pid int) (*DebuggedProcess, error) {
 // * Dwarf .debug_frame section
 // * Dwarf .debug_line section
 // * Go symbol table.
+// It also loads the same information for any Go plugins
+// already mapped into the process.
 func (dbp *DebuggedProcess) LoadInformation() error {
 	var (
 		wg  sync.WaitGroup
@@ -116,12 +269,12 @@ func (dbp *DebuggedProcess) LoadInformation() error {
 
 	wg.Wait()
 
-	return nil
+	return dbp.updatePlugins()
 }
 
 // Obtains register values from the debugged process.
 func (dbp *DebuggedProcess) Registers() (*syscall.PtraceRegs, error) {
-	err := syscall.PtraceGetRegs(dbp.Pid, dbp.Regs)
+	err := syscall.PtraceGetRegs(dbp.CurrentThread.Id, dbp.Regs)
 	if err != nil {
 		return nil, fmt.Errorf("Registers():", err)
 	}
@@ -139,9 +292,27 @@ func (iae InvalidAddressError) Error() string {
 
 // Sets a breakpoint in the running process.
 func (dbp *DebuggedProcess) Break(addr uintptr) (*BreakPoint, error) {
+	bp, err := dbp.setBreakpoint(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dbp.breakpointIDCounter++
+	bp.ID = dbp.breakpointIDCounter
+
+	return bp, nil
+}
+
+// Sets a breakpoint without assigning it an ID, for use by
+// the stepping functions which remove it again once hit.
+func (dbp *DebuggedProcess) setTempBreakpoint(addr uintptr) (*BreakPoint, error) {
+	return dbp.setBreakpoint(addr)
+}
+
+func (dbp *DebuggedProcess) setBreakpoint(addr uintptr) (*BreakPoint, error) {
 	var (
 		int3         = []byte{0xCC}
-		f, l, fn     = dbp.GoSymTable.PCToLine(uint64(addr))
+		f, l, fn     = dbp.PCToLine(uint64(addr))
 		originalData = make([]byte, 1)
 	)
 
@@ -149,7 +320,7 @@ func (dbp *DebuggedProcess) Break(addr uintptr) (*BreakPoint, error) {
 		return nil, InvalidAddressError{address: addr}
 	}
 
-	_, err := syscall.PtracePeekData(dbp.Pid, addr, originalData)
+	_, err := syscall.PtracePeekData(dbp.CurrentThread.Id, addr, originalData)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +329,7 @@ func (dbp *DebuggedProcess) Break(addr uintptr) (*BreakPoint, error) {
 		return nil, BreakPointExistsError{f, l, addr}
 	}
 
-	_, err = syscall.PtracePokeData(dbp.Pid, addr, int3)
+	_, err = syscall.PtracePokeData(dbp.CurrentThread.Id, addr, int3)
 	if err != nil {
 		return nil, err
 	}
@@ -176,6 +347,70 @@ func (dbp *DebuggedProcess) Break(addr uintptr) (*BreakPoint, error) {
 	return breakpoint, nil
 }
 
+// Functions the runtime calls when a goroutine panics. Breaking on them
+// stops the process at the panic site, before the stack is unwound.
+var panicFunctions = []string{"runtime.gopanic", "runtime.fatalpanic"}
+
+// Sets breakpoints on the runtime functions called on panic, so that
+// execution stops with the stack of the panicking goroutine intact
+// rather than the process exiting.
+func (dbp *DebuggedProcess) BreakOnPanic() ([]*BreakPoint, error) {
+	bps := make([]*BreakPoint, 0, len(panicFunctions))
+	for _, name := range panicFunctions {
+		fn := dbp.LookupFunc(name)
+		if fn == nil {
+			// Not every runtime has every function.
+			continue
+		}
+
+		bp, err := dbp.Break(uintptr(fn.Entry))
+		if err != nil {
+			return nil, err
+		}
+
+		bps = append(bps, bp)
+	}
+
+	if len(bps) == 0 {
+		return nil, fmt.Errorf("could not find the runtime panic functions")
+	}
+
+	return bps, nil
+}
+
+// Reports whether bp is one of the breakpoints set by BreakOnPanic.
+func (bp *BreakPoint) IsPanic() bool {
+	return isPanicFunction(bp.FunctionName)
+}
+
+func isPanicFunction(name string) bool {
+	for _, n := range panicFunctions {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets a breakpoint which only stops execution when cond, a Go boolean
+// expression such as "i > 100" or "err != nil", evaluates to true.
+func (dbp *DebuggedProcess) BreakIf(addr uintptr, cond string) (*BreakPoint, error) {
+	_, err := parseExpression(cond)
+	if err != nil {
+		return nil, err
+	}
+
+	bp, err := dbp.Break(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.Condition = cond
+
+	return bp, nil
+}
+
 // Clears a breakpoint.
 func (dbp *DebuggedProcess) Clear(pc uint64) (*BreakPoint, error) {
 	bp, ok := dbp.BreakPoints[pc]
@@ -183,147 +418,1083 @@ func (dbp *DebuggedProcess) Clear(pc uint64) (*BreakPoint, error) {
 		return nil, fmt.Errorf("No breakpoint currently set for %#v", pc)
 	}
 
-	_, err := syscall.PtracePokeData(dbp.Pid, uintptr(bp.Addr), bp.OriginalData)
+	_, err := syscall.PtracePokeData(dbp.CurrentThread.Id, uintptr(bp.Addr), bp.OriginalData)
 	if err != nil {
 		return nil, err
 	}
 
 	delete(dbp.BreakPoints, pc)
 
+	// If we are stopped just past the breakpoint, rewind the
+	// PC so that the restored instruction is executed.
+	regs, err := dbp.Registers()
+	if err != nil {
+		return nil, err
+	}
+
+	if regs.PC()-1 == bp.Addr {
+		regs.SetPC(bp.Addr)
+		err = syscall.PtraceSetRegs(dbp.CurrentThread.Id, regs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return bp, nil
 }
 
-// Steps through process.
-func (dbp *DebuggedProcess) Step() (err error) {
-	regs, err := dbp.Registers()
+// Returns the breakpoint with the given ID, or nil if there is none.
+func (dbp *DebuggedProcess) FindBreakpointByID(id int) *BreakPoint {
+	for _, bp := range dbp.BreakPoints {
+		if bp.ID == id {
+			return bp
+		}
+	}
+
+	return nil
+}
+
+// Clears the breakpoint with the given ID.
+func (dbp *DebuggedProcess) ClearByID(id int) (*BreakPoint, error) {
+	bp := dbp.FindBreakpointByID(id)
+	if bp == nil || id == 0 {
+		return nil, fmt.Errorf("No breakpoint with id %d", id)
+	}
+
+	return dbp.Clear(bp.Addr)
+}
+
+// Removes every breakpoint and watchpoint, restoring the original
+// instructions and clearing the debug registers of every thread, and
+// detaches from the process leaving it running.
+func (dbp *DebuggedProcess) Detach() error {
+	// Only stopped threads can be detached from, and threads
+	// stopping at a breakpoint are rewound to execute the
+	// instruction it replaced.
+	err := dbp.stopThreads()
 	if err != nil {
 		return err
 	}
 
-	bp, ok := dbp.BreakPoints[regs.PC()-1]
-	if ok {
-		// Clear the breakpoint so that we can continue execution.
-		_, err = dbp.Clear(bp.Addr)
+	for addr := range dbp.BreakPoints {
+		_, err := dbp.Clear(addr)
 		if err != nil {
 			return err
 		}
+	}
 
-		// Reset program counter to our restored instruction.
-		regs.SetPC(bp.Addr)
-		err = syscall.PtraceSetRegs(dbp.Pid, regs)
+	for _, wp := range dbp.Watchpoints {
+		if wp == nil {
+			continue
+		}
+
+		err := dbp.ClearWatchpoint(wp)
 		if err != nil {
 			return err
 		}
+	}
 
-		// Restore breakpoint now that we have passed it.
-		defer func() {
-			_, err = dbp.Break(uintptr(bp.Addr))
-		}()
+	// The thread group leader may have exited before
+	// the process, and no longer be among the threads.
+	for _, th := range dbp.Threads {
+		// Threads which were running as a watchpoint was
+		// cleared may still have it set.
+		err := clearDebugRegisters(th.Id)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+
+		err = syscall.PtraceDetach(th.Id)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
 	}
 
-	err = dbp.handleResult(syscall.PtraceSingleStep(dbp.Pid))
-	if err != nil {
-		return fmt.Errorf("step failed: ", err.Error())
+	return nil
+}
+
+// Takes over the breakpoints and settings of old, an earlier run of the
+// same program, as when it is restarted. Breakpoints are set at the same
+// addresses with the same IDs and options, their hit counts reset.
+// Watchpoints are not, the variables they watch need not be at the same
+// addresses. Breakpoints which can not be set, such as those in plugins
+// not loaded yet, are left out and reported.
+func (dbp *DebuggedProcess) Inherit(old *DebuggedProcess) error {
+	dbp.inheritSettings(old)
+
+	var failed []string
+	for _, bp := range old.BreakPoints {
+		if bp.ID == 0 {
+			continue
+		}
+
+		nbp, err := dbp.setBreakpoint(uintptr(bp.Addr))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%d at %#x: %s", bp.ID, bp.Addr, err))
+			continue
+		}
+
+		nbp.ID = bp.ID
+		nbp.Condition = bp.Condition
+		nbp.OneShot = bp.OneShot
+		nbp.MaxHits = bp.MaxHits
+		nbp.ThreadID = bp.ThreadID
+		nbp.Tracepoint = bp.Tracepoint
+		nbp.LogMessage = bp.LogMessage
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("could not set breakpoints %s", strings.Join(failed, ", "))
 	}
 
 	return nil
 }
 
-// Step over function calls.
-func (dbp *DebuggedProcess) Next() error {
+// Takes over the settings of another process, such as the functions
+// skipped when stepping, and carries on numbering breakpoints after it.
+func (dbp *DebuggedProcess) inheritSettings(old *DebuggedProcess) {
+	dbp.TraceHandler = old.TraceHandler
+	dbp.SkipFunctions = old.SkipFunctions
+	dbp.MaxMapEntries = old.MaxMapEntries
+	dbp.AllowCalls = old.AllowCalls
+	dbp.IntegerBase = old.IntegerBase
+	dbp.FollowForks = old.FollowForks
+	dbp.breakpointIDCounter = old.breakpointIDCounter
+}
+
+// Steps through process. Calls into code without Go symbol
+// information, such as C functions reached through cgo, are treated
+// as opaque and executed until they return to Go code. Use
+// StepInstruction to step into them. Functions matching
+// SkipFunctions are stepped out of as soon as they are entered.
+// Execution is delegated to Backend when it is set.
+func (dbp *DebuggedProcess) Step() error {
+	return dbp.backend().Step(dbp)
+}
+
+func (dbp *DebuggedProcess) ptraceStep() error {
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	_, _, fn := dbp.PCToLine(pc)
+
+	err = dbp.StepInstruction()
+	if err != nil {
+		return err
+	}
+
+	if fn == nil || dbp.ProcessState.Exited() {
+		return nil
+	}
+
+	err = dbp.stepOverForeignCall()
+	if err != nil || dbp.ProcessState.Exited() {
+		return err
+	}
+
+	return dbp.stepOutOfSkipped(fn)
+}
+
+// If the last step took us from the function from into one matching
+// SkipFunctions, keep finishing functions until we are back in one
+// that does not match, or execution stops at a user breakpoint.
+func (dbp *DebuggedProcess) stepOutOfSkipped(from *gosym.Func) error {
+	for {
+		pc, err := dbp.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		if bp, ok := dbp.BreakPoints[pc-1]; ok && bp.ID != 0 {
+			return nil
+		}
+
+		_, _, fn := dbp.PCToLine(pc)
+		if fn == nil || fn.Entry == from.Entry || !dbp.skipped(fn.Name) {
+			return nil
+		}
+
+		_, err = dbp.Finish()
+		if err != nil || dbp.ProcessState.Exited() {
+			return err
+		}
+	}
+}
+
+// Reports whether the named function matches SkipFunctions.
+func (dbp *DebuggedProcess) skipped(name string) bool {
+	for _, pattern := range dbp.SkipFunctions {
+		if globMatch(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+
+	if last == 0 {
+		return name == ""
+	}
+
+	return strings.HasSuffix(name, parts[last])
+}
+
+// If we have just entered code with no Go symbol information from Go
+// code, run until it returns. Since we are at the first instruction of
+// the callee, the return address is at the top of the stack.
+func (dbp *DebuggedProcess) stepOverForeignCall() error {
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	if _, _, fn := dbp.PCToLine(pc); fn != nil {
+		return nil
+	}
+
+	regs, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	ret := dbp.ReturnAddressFromOffset(0)
+	if _, _, fn := dbp.PCToLine(ret); fn == nil {
+		// Not a call from Go code, so there is nowhere
+		// sensible to return to. Stay where we are.
+		return nil
+	}
+
+	return dbp.continueToAddress(ret, regs.Rsp+8)
+}
+
+// Continues until the function executing in the innermost frame returns,
+// stopping at the instruction following the call in its caller. Returns
+// the values the function returned, or nil if execution stopped
+// elsewhere first, such as at a breakpoint.
+func (dbp *DebuggedProcess) Finish() ([]*Variable, error) {
+	frames, err := dbp.Stacktrace(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frames) < 2 {
+		return nil, fmt.Errorf("%s is the outermost frame, there is nothing to return to", frames[0].Fn.Name)
+	}
+
+	frame, ret := frames[0], frames[1].PC
+
+	// Once the function has returned the stack pointer is back at
+	// its canonical frame address.
+	err = dbp.continueToAddress(ret, uint64(frame.CFA))
+	if err != nil {
+		return nil, err
+	}
+
+	if dbp.ProcessState.Exited() {
+		return nil, nil
+	}
+
 	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return nil, err
+	}
+
+	// We stop just past the instruction if the user had
+	// set a breakpoint on the return address as well.
+	if pc != ret && pc-1 != ret {
+		return nil, nil
+	}
+
+	return dbp.ReturnValues(frame)
+}
+
+// Executes a single machine instruction. If the instruction is a call
+// the whole call is executed, stopping at the instruction following it.
+func (dbp *DebuggedProcess) NextInstruction() error {
+	regs, err := dbp.Registers()
 	if err != nil {
 		return err
 	}
 
+	pc, sp := regs.PC(), regs.Rsp
 	if _, ok := dbp.BreakPoints[pc-1]; ok {
-		// Decrement the PC to be before
-		// the breakpoint instruction.
 		pc--
 	}
 
-	_, l, _ := dbp.GoSymTable.PCToLine(pc)
-	fde, err := dbp.FrameEntries.FDEForPC(pc)
+	code, err := dbp.ReadMemory(pc, maxInstructionLength)
+	if err != nil {
+		return err
+	}
+
+	// Bytes which do not decode are left for the
+	// processor to fault on as it steps.
+	inst, derr := x86asm.Decode(code, 64)
+
+	err = dbp.StepInstruction()
+	if err != nil {
+		return err
+	}
+
+	if derr != nil || inst.Op != x86asm.CALL || dbp.ProcessState.Exited() {
+		return nil
+	}
+
+	return dbp.continueToAddress(pc+uint64(inst.Len), sp)
+}
+
+// The longest valid x86 instruction is 15 bytes.
+const maxInstructionLength = 15
+
+// Continues until addr is reached by any goroutine, through a temporary
+// breakpoint which is removed however execution stops: at addr, at
+// another breakpoint first, or because the process exited. If the user
+// already has a breakpoint at addr it is left in place.
+func (dbp *DebuggedProcess) ContinueTo(addr uint64) error {
+	_, err := dbp.setTempBreakpoint(uintptr(addr))
+	if err != nil {
+		if _, ok := err.(BreakPointExistsError); !ok {
+			return err
+		}
+
+		return dbp.Continue()
+	}
+
+	err = dbp.Continue()
+	if dbp.ProcessState.Exited() {
+		// There is no memory left to restore.
+		delete(dbp.BreakPoints, addr)
+		return err
+	}
+
+	_, cerr := dbp.Clear(addr)
 	if err != nil {
 		return err
 	}
 
-	step := func() (uint64, error) {
-		err = dbp.Step()
-		if err != nil {
-			return 0, fmt.Errorf("next stepping failed: ", err.Error())
+	return cerr
+}
+
+// Continues until the instruction at addr is reached with the stack
+// pointer at or above sp, that is by the frame owning sp rather than by
+// a deeper recursive call. Execution also stops if any other breakpoint
+// is hit or the process exits. The breakpoint used is removed before
+// returning, unless it had already been set by the user.
+func (dbp *DebuggedProcess) continueToAddress(addr, sp uint64) error {
+	// Other goroutines may run the same code, with their own stacks.
+	// A zero g, before the scheduler is running, matches any goroutine.
+	g, _ := dbp.currentG()
+
+	_, err := dbp.setTempBreakpoint(uintptr(addr))
+	if err != nil {
+		if _, ok := err.(BreakPointExistsError); !ok {
+			return err
+		}
+
+		return dbp.Continue()
+	}
+
+	for {
+		err = dbp.Continue()
+		if err != nil {
+			return err
+		}
+
+		if dbp.ProcessState.Exited() {
+			return nil
+		}
+
+		regs, err := dbp.Registers()
+		if err != nil {
+			return err
+		}
+
+		if regs.PC()-1 != addr {
+			// Stopped somewhere else, leave
+			// the user to decide what to do.
+			_, err = dbp.Clear(addr)
+			return err
+		}
+
+		if regs.Rsp >= sp && dbp.onGoroutine(g) {
+			return dbp.clearTempBreakpoint(addr)
+		}
+	}
+}
+
+// Reports whether the goroutine whose g struct is at g is the one
+// running on the current thread. A zero g matches any goroutine, as
+// does any goroutine when the current one can not be determined.
+func (dbp *DebuggedProcess) onGoroutine(g uint64) bool {
+	if g == 0 {
+		return true
+	}
+
+	cur, err := dbp.currentG()
+
+	return err != nil || cur == g
+}
+
+// Executes a single machine instruction, stepping
+// into any function that is called.
+func (dbp *DebuggedProcess) StepInstruction() (err error) {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	bp, ok := dbp.BreakPoints[regs.PC()-1]
+	if ok {
+		// Restore the original instruction so that we can continue
+		// execution. The breakpoint itself is kept, along with its
+		// condition and hit count.
+		_, err = syscall.PtracePokeData(dbp.CurrentThread.Id, uintptr(bp.Addr), bp.OriginalData)
+		if err != nil {
+			return err
+		}
+
+		// Reset program counter to our restored instruction.
+		regs.SetPC(bp.Addr)
+		err = syscall.PtraceSetRegs(dbp.CurrentThread.Id, regs)
+		if err != nil {
+			return err
+		}
+
+		// Reinsert the breakpoint now that we have passed it.
+		defer func() {
+			if err == nil && !dbp.ProcessState.Exited() {
+				_, err = syscall.PtracePokeData(dbp.CurrentThread.Id, uintptr(bp.Addr), []byte{0xCC})
+			}
+		}()
+	}
+
+	th := dbp.CurrentThread
+	for {
+		err = dbp.handleResult(syscall.PtraceSingleStep(th.Id))
+		if err != nil {
+			return fmt.Errorf("step failed: ", err.Error())
+		}
+
+		// The instruction created a thread or forked,
+		// step again to complete it.
+		handled, err := dbp.handleEvent(th, th.Status)
+		if err != nil || !handled {
+			return err
+		}
+	}
+}
+
+// Step over function calls. Only the goroutine Next started on is
+// followed, so we do not end up on another goroutine which happened to
+// run the same code while stepping over a call. Functions deferred by
+// the current function are stepped into when they run, whether through
+// a return or a panic, since control never comes back to the line.
+// Recursive calls are stepped over like any other.
+func (dbp *DebuggedProcess) Next() error {
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	g, _ := dbp.currentG()
+
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		// Decrement the PC to be before
+		// the breakpoint instruction.
+		pc--
+	}
+
+	_, l, fn := dbp.PCToLine(pc)
+	if fn == nil {
+		return fmt.Errorf("cannot next at %#v, no Go symbol information available", pc)
+	}
+
+	if isPanicFunction(fn.Name) {
+		// Stopped on a panic, the next Go code to run
+		// is whatever the panicking goroutine deferred.
+		return dbp.continueToDeferred(nil, g)
+	}
+
+	// Without a frame deferred calls are simply stepped over.
+	var frame *StackFrame
+	if frames, err := dbp.Stacktrace(1); err == nil {
+		frame = frames[0]
+	}
+
+	fde, err := dbp.FDEForPC(pc)
+	if err != nil {
+		return err
+	}
+
+	step := func() (uint64, error) {
+		err = dbp.StepInstruction()
+		if err != nil {
+			return 0, fmt.Errorf("next stepping failed: ", err.Error())
+		}
+
+		return dbp.CurrentPC()
+	}
+
+	ret, err := dbp.ReturnAddress()
+	if err != nil {
+		return err
+	}
+
+	for {
+		pc, err = step()
+		if err != nil {
+			return err
+		}
+
+		// Reaching the entry of the function again is a recursive call.
+		if (!fde.Cover(pc) || pc == fde.Begin()) && pc != ret {
+			err = dbp.continueToReturnAddress(pc, fde, g, frame)
+			if err != nil {
+				if ierr, ok := err.(InvalidAddressError); ok {
+					return ierr
+				}
+			}
+
+			if dbp.ProcessState.Exited() {
+				return nil
+			}
+
+			pc, _ = dbp.CurrentPC()
+		}
+
+		_, nl, _ := dbp.PCToLine(pc)
+		if nl != l {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Steps into the call to the function starting at entry made by the
+// current line, stepping over any other calls made before it, as for
+// h in f(g(), h()). If the line completes without calling the function
+// execution stops at the next line and an error is returned.
+func (dbp *DebuggedProcess) StepInto(entry uint64) error {
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		pc--
+	}
+
+	_, l, fn := dbp.PCToLine(pc)
+	if fn == nil {
+		return fmt.Errorf("cannot step at %#v, no Go symbol information available", pc)
+	}
+
+	fde, err := dbp.FDEForPC(pc)
+	if err != nil {
+		return err
+	}
+
+	g, _ := dbp.currentG()
+	ret, err := dbp.ReturnAddress()
+	if err != nil {
+		return err
+	}
+
+	for {
+		err = dbp.StepInstruction()
+		if err != nil || dbp.ProcessState.Exited() {
+			return err
+		}
+
+		pc, err = dbp.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		if pc == entry {
+			return nil
+		}
+
+		if (!fde.Cover(pc) || pc == fde.Begin()) && pc != ret {
+			// Some other call, step over it.
+			err = dbp.continueToReturnAddress(pc, fde, g, nil)
+			if err != nil || dbp.ProcessState.Exited() {
+				return err
+			}
+
+			pc, err = dbp.CurrentPC()
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, nl, _ := dbp.PCToLine(pc); nl != l {
+			_, _, target := dbp.PCToLine(entry)
+			return fmt.Errorf("line %d did not call %s", l, target.Name)
+		}
+	}
+}
+
+// Continues until the instruction at addr is reached by the current
+// goroutine in the current frame or one of its callers, or until a
+// breakpoint is hit.
+func (dbp *DebuggedProcess) Until(addr uint64) error {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return err
+	}
+
+	return dbp.continueToAddress(addr, regs.Rsp)
+}
+
+// Continues until the function starting at entry is entered by the
+// current goroutine, or the function executing in the innermost frame
+// returns, whichever comes first.
+func (dbp *DebuggedProcess) Advance(entry uint64) error {
+	g, _ := dbp.currentG()
+
+	frames, err := dbp.Stacktrace(2)
+	if err != nil {
+		return err
+	}
+
+	addrs := make([]uint64, 0, 2)
+	if _, err := dbp.setTempBreakpoint(uintptr(entry)); err == nil {
+		addrs = append(addrs, entry)
+	} else if _, ok := err.(BreakPointExistsError); !ok {
+		return err
+	}
+
+	var ret, sp uint64
+	if len(frames) > 1 {
+		ret, sp = frames[1].PC, uint64(frames[0].CFA)
+
+		_, err := dbp.setTempBreakpoint(uintptr(ret))
+		if err == nil {
+			addrs = append(addrs, ret)
+		} else if _, ok := err.(BreakPointExistsError); !ok {
+			dbp.clearBreakpoints(addrs)
+			return err
+		}
+	}
+
+	return dbp.continueToAny(addrs, g, ret, sp)
+}
+
+// Steps over lines like Next, but without stopping at a line before the
+// one we started on in the same function. At the end of a loop body
+// this runs the loop to completion, stopping at the first line after it.
+func (dbp *DebuggedProcess) UntilLoopExit() error {
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		pc--
+	}
+
+	_, l, fn := dbp.PCToLine(pc)
+	if fn == nil {
+		return fmt.Errorf("cannot until at %#v, no Go symbol information available", pc)
+	}
+
+	for {
+		err = dbp.Next()
+		if err != nil || dbp.ProcessState.Exited() {
+			return err
+		}
+
+		pc, err = dbp.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		_, nl, nfn := dbp.PCToLine(pc)
+		if nfn == nil || nfn.Entry != fn.Entry || nl > l {
+			return nil
+		}
+	}
+}
+
+// Continues until the call we have just stepped into, at pc, returns to
+// the function described by fde. The call may be a recursive one, into
+// that same function. Only the goroutine g is followed and returns from
+// deeper recursive invocations of the function are passed over.
+func (dbp *DebuggedProcess) continueToReturnAddress(pc uint64, fde *frame.FrameDescriptionEntry, g uint64, deferring *StackFrame) error {
+	for {
+		regs, err := dbp.Registers()
+		if err != nil {
+			return err
+		}
+
+		// Once the call returns the stack pointer is back above the
+		// return address, a deeper invocation returns below it.
+		sp := regs.Rsp + 8
+
+		addr, err := dbp.ReturnAddress()
+		if err != nil {
+			// Without call frame information for the callee, rely on
+			// having stepped into its first instruction, before it
+			// had a chance to move the stack pointer.
+			addr = dbp.ReturnAddressFromOffset(0)
+		}
+
+		bp, err := dbp.setTempBreakpoint(uintptr(addr))
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); !ok {
+				return err
+			}
+		}
+
+		// If the call is runtime.deferreturn, or panics, the functions
+		// deferred by the frame we are stepping in run before, or
+		// instead of, the return to it.
+		// Defers we fail to read are treated as if there were none.
+		if deferring != nil {
+			// We are at the first instruction of the callee, so the
+			// stack pointer of the deferring frame is just above the
+			// return address, whatever it was when we started.
+			frame := *deferring
+			frame.SP = sp
+
+			deferred, err := dbp.setDeferBreakpoints(&frame)
+			if err == nil && len(deferred) > 0 {
+				if bp != nil {
+					deferred = append(deferred, bp.Addr)
+				}
+				return dbp.continueToAny(deferred, g, addr, sp)
+			}
+		}
+
+		for {
+			err = dbp.Continue()
+			if err != nil {
+				return err
+			}
+
+			if dbp.ProcessState.Exited() {
+				return nil
+			}
+
+			// Another goroutine, or a deeper invocation, may reach our
+			// breakpoint first, carry on until the one we are stepping does.
+			regs, err := dbp.Registers()
+			if err != nil {
+				return err
+			}
+
+			if bp == nil || regs.PC()-1 != addr || (dbp.onGoroutine(g) && regs.Rsp >= sp) {
+				break
+			}
+		}
+
+		// Leave any breakpoint the user had already set there in place.
+		if bp != nil {
+			err = dbp.clearTempBreakpoint(bp.Addr)
+			if err != nil {
+				return err
+			}
+		}
+
+		pc, _ = dbp.CurrentPC()
+		if fde.Cover(pc) {
+			return nil
+		}
+	}
+}
+
+// Sets temporary breakpoints on the entry of every function deferred
+// by frame, or by the whole goroutine if frame is nil, returning their
+// addresses. Functions with a breakpoint already are left alone.
+func (dbp *DebuggedProcess) setDeferBreakpoints(frame *StackFrame) ([]uint64, error) {
+	var (
+		defers []*Defer
+		err    error
+	)
+	if frame != nil {
+		defers, err = dbp.FrameDefers(frame)
+	} else {
+		defers, err = dbp.goroutineDefers()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]uint64, 0, len(defers))
+	for _, d := range defers {
+		if d.Fn == nil {
+			continue
+		}
+
+		_, err := dbp.setTempBreakpoint(uintptr(d.Fn.Entry))
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); ok {
+				continue
+			}
+			dbp.clearBreakpoints(addrs)
+			return nil, err
+		}
+
+		addrs = append(addrs, d.Fn.Entry)
+	}
+
+	return addrs, nil
+}
+
+// Continues to the first of the functions deferred by frame, or by the
+// whole goroutine if frame is nil. Without any, simply continues.
+func (dbp *DebuggedProcess) continueToDeferred(frame *StackFrame, g uint64) error {
+	addrs, err := dbp.setDeferBreakpoints(frame)
+	if err != nil {
+		return err
+	}
+
+	return dbp.continueToAny(addrs, g, 0, 0)
+}
+
+// Continues until the goroutine g reaches one of the temporary
+// breakpoints at addrs, or execution stops anywhere else, then
+// removes the temporary breakpoints. The breakpoint at ret, if any,
+// only counts once the stack pointer is back at sp, so returns from
+// deeper recursive invocations are passed over.
+func (dbp *DebuggedProcess) continueToAny(addrs []uint64, g, ret, sp uint64) error {
+	for {
+		err := dbp.Continue()
+		if err != nil || dbp.ProcessState.Exited() {
+			return err
+		}
+
+		regs, err := dbp.Registers()
+		if err != nil {
+			return err
+		}
+
+		pc := regs.PC() - 1
+		if !containsAddr(addrs, pc) {
+			break
+		}
+
+		if dbp.onGoroutine(g) && (pc != ret || regs.Rsp >= sp) {
+			break
+		}
+	}
+
+	return dbp.clearBreakpoints(addrs)
+}
+
+// Clears the breakpoints at addrs. Clear rewinds the PC
+// if we are stopped at one of them.
+func (dbp *DebuggedProcess) clearBreakpoints(addrs []uint64) error {
+	for _, addr := range addrs {
+		if _, ok := dbp.BreakPoints[addr]; !ok {
+			continue
+		}
+
+		_, err := dbp.Clear(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsAddr(addrs []uint64, addr uint64) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Continue process until next breakpoint or watchpoint, or until the
+// frame of a watched variable returns. Breakpoints whose condition is
+// not met are silently passed over. Execution is delegated to Backend
+// when it is set.
+func (dbp *DebuggedProcess) Continue() error {
+	return dbp.backend().Continue(dbp)
+}
+
+func (dbp *DebuggedProcess) ptraceContinue() error {
+	for {
+		err := dbp.resetDebugStatus()
+		if err != nil {
+			return err
+		}
+
+		// Stepping first will ensure we are able to continue past
+		// a breakpoint if that's currently where we are stopped. The
+		// instruction the breakpoint replaced is executed out of line
+		// where possible, as every thread runs, see displaceStep.
+		// Otherwise we only step then: with every other thread stopped,
+		// stepping a thread blocked in a system call could wait forever.
+		bp, err := dbp.CurrentBreakpoint()
+		if err != nil {
+			return err
+		}
+
+		displaced := false
+		if bp != nil {
+			displaced, err = dbp.displaceStep(bp)
+			if err != nil {
+				return err
+			}
+		}
+
+		if bp != nil && !displaced {
+			err = dbp.StepInstruction()
+			if err != nil {
+				return err
+			}
+
+			// The instruction we stepped over may have written
+			// to watched memory.
+			hit, err := dbp.watchpointHit()
+			if err != nil || hit {
+				return err
+			}
+		}
+
+		err = dbp.resumeAll()
+		if err != nil {
+			return err
+		}
+
+		if dbp.ProcessState.Exited() {
+			return nil
+		}
+
+		// Plugins are only looked for when execution stops here
+		// rather than at every single step, since that reads the
+		// memory map of the process. A plugin loaded while stepping
+		// is found the next time the process is continued.
+		err = dbp.updatePlugins()
+		if err != nil {
+			return err
+		}
+
+		left, resume, err := dbp.checkWatchpointScopes()
+		if err != nil || left {
+			return err
+		}
+
+		if resume {
+			continue
 		}
 
-		return dbp.CurrentPC()
-	}
+		hit, err := dbp.watchpointHit()
+		if err != nil || hit {
+			return err
+		}
 
-	ret := dbp.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
-	for {
-		pc, err = step()
+		stop, err := dbp.breakpointConditionMet()
 		if err != nil {
 			return err
 		}
 
-		if !fde.Cover(pc) && pc != ret {
-			dbp.continueToReturnAddress(pc, fde)
+		if stop {
+			traced, err := dbp.trace()
 			if err != nil {
-				if ierr, ok := err.(InvalidAddressError); ok {
-					return ierr
-				}
+				return err
 			}
-
-			pc, _ = dbp.CurrentPC()
+			stop = !traced
 		}
 
-		_, nl, _ := dbp.GoSymTable.PCToLine(pc)
-		if nl != l {
-			break
+		err = dbp.clearExhaustedBreakpoint(stop)
+		if err != nil || stop {
+			return err
 		}
 	}
+}
+
+// Clears the breakpoint the process is at if it is a one shot breakpoint
+// which has stopped execution, or if it has reached its maximum number
+// of hits. The PC is left at the address of the breakpoint.
+func (dbp *DebuggedProcess) clearExhaustedBreakpoint(stopped bool) error {
+	bp, err := dbp.CurrentBreakpoint()
+	if err != nil {
+		return err
+	}
+
+	if bp == nil {
+		return nil
+	}
+
+	if bp.OneShot && stopped || bp.MaxHits > 0 && bp.HitCount >= bp.MaxHits {
+		return dbp.clearTempBreakpoint(bp.Addr)
+	}
 
 	return nil
 }
 
-func (dbp *DebuggedProcess) continueToReturnAddress(pc uint64, fde *frame.FrameDescriptionEntry) error {
-	for !fde.Cover(pc) {
-		// Our offset here is be 0 because we
-		// have stepped into the first instruction
-		// of this function. Therefore the function
-		// has not had a chance to modify its' stack
-		// and change our offset.
-		addr := dbp.ReturnAddressFromOffset(0)
-		bp, err := dbp.Break(uintptr(addr))
-		if err != nil {
-			if _, ok := err.(BreakPointExistsError); !ok {
-				return err
-			}
-		}
+// Reports whether execution should stop where it is. This is the case
+// unless we are at a breakpoint whose condition evaluates to false, which
+// is restricted to another thread, or which detects the frame of a watched
+// variable returning. The hit count of the breakpoint
+// is incremented either way, unless it was hit by another thread.
+func (dbp *DebuggedProcess) breakpointConditionMet() (bool, error) {
+	bp, err := dbp.CurrentBreakpoint()
+	if err != nil {
+		return false, err
+	}
 
-		err = dbp.Continue()
-		if err != nil {
-			return err
-		}
-		err = dbp.clearTempBreakpoint(bp.Addr)
-		if err != nil {
-			return err
-		}
+	if bp == nil {
+		return true, nil
+	}
 
-		pc, _ = dbp.CurrentPC()
+	if bp.ThreadID != 0 && bp.ThreadID != dbp.CurrentThread.Id || dbp.isScopeBreakpoint(bp) {
+		return false, nil
 	}
 
-	return nil
+	bp.HitCount++
+	if bp.Condition == "" {
+		return true, nil
+	}
+
+	hitcount := &Variable{Name: "hitcount", Type: "uint64", Value: strconv.FormatUint(bp.HitCount, 10)}
+	v, err := dbp.evalExpression(bp.Condition, nil, map[string]*Variable{"hitcount": hitcount})
+	if err != nil {
+		return true, fmt.Errorf("could not evaluate condition %q: %s", bp.Condition, err)
+	}
+
+	if v.Type != "bool" {
+		return true, fmt.Errorf("condition %q is not a boolean expression", bp.Condition)
+	}
+
+	return v.Value == "true", nil
 }
 
-// Continue process until next breakpoint.
-func (dbp *DebuggedProcess) Continue() error {
-	// Stepping first will ensure we are able to continue
-	// past a breakpoint if that's currently where we are stopped.
-	err := dbp.Step()
+// Returns the breakpoint the process is stopped at,
+// or nil if it is not stopped at a breakpoint.
+func (dbp *DebuggedProcess) CurrentBreakpoint() (*BreakPoint, error) {
+	pc, err := dbp.CurrentPC()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return dbp.handleResult(syscall.PtraceCont(dbp.Pid, 0))
+	return dbp.BreakPoints[pc-1], nil
 }
 
 func (dbp *DebuggedProcess) CurrentPC() (uint64, error) {
@@ -349,7 +1520,7 @@ func (dbp *DebuggedProcess) clearTempBreakpoint(pc uint64) error {
 		}
 
 		regs.SetPC(bp.Addr)
-		return syscall.PtraceSetRegs(dbp.Pid, regs)
+		return syscall.PtraceSetRegs(dbp.CurrentThread.Id, regs)
 	}
 
 	return nil
@@ -357,16 +1528,57 @@ func (dbp *DebuggedProcess) clearTempBreakpoint(pc uint64) error {
 
 // Returns the value of the named symbol.
 func (dbp *DebuggedProcess) EvalSymbol(name string) (*Variable, error) {
-	data, err := dbp.Executable.DWARF()
+	return dbp.EvalSymbolInFrame(name, nil)
+}
+
+// Returns the value of the named symbol as seen from frame, one of the
+// frames returned by Stacktrace, or from the innermost frame if nil.
+// Package-level variables are named along with their package, as in
+// main.config or net/http.DefaultServeMux.
+func (dbp *DebuggedProcess) EvalSymbolInFrame(name string, frame *StackFrame) (*Variable, error) {
+	entry, data, cfa, err := dbp.lookupSymbol(name, frame)
 	if err != nil {
-		return nil, err
+		addr, typ, gerr := dbp.globalAddress(name)
+		if gerr != nil {
+			return nil, err
+		}
+
+		return dbp.newVariable(name, int64(addr), typ)
+	}
+
+	return dbp.extractVariableFromEntry(entry, data, cfa)
+}
+
+// Finds the debug information entry of the named variable and the
+// canonical frame address its location is relative to. Arguments and
+// locals of the function executing in frame, the innermost frame if
+// nil, are preferred to variables of the same name declared elsewhere.
+func (dbp *DebuggedProcess) lookupSymbol(name string, frame *StackFrame) (*dwarf.Entry, *dwarf.Data, int64, error) {
+	if frame == nil {
+		var err error
+		frame, err = dbp.currentFrame()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	if frame.Fn != nil {
+		entry, data, err := dbp.frameEntry(frame, name)
+		if err == nil && entry != nil {
+			return entry, data, frame.CFA, nil
+		}
+	}
+
+	data, err := dbp.dwarfForPC(frame.PC)
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
 	reader := data.Reader()
 
 	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
 		if err != nil {
-			return nil, err
+			return nil, nil, 0, err
 		}
 
 		if entry.Tag != dwarf.TagVariable && entry.Tag != dwarf.TagFormalParameter {
@@ -378,58 +1590,166 @@ func (dbp *DebuggedProcess) EvalSymbol(name string) (*Variable, error) {
 			continue
 		}
 
-		offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
-		if !ok {
-			continue
+		if _, _, err := variableLocation(entry, data, frame.CFA); err != nil {
+			if _, ok := err.(noLocationError); ok {
+				continue
+			}
+			return nil, nil, 0, err
 		}
 
-		t, err := data.Type(offset)
-		if err != nil {
-			return nil, err
-		}
+		return entry, data, frame.CFA, nil
+	}
 
-		instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
-		if !ok {
-			continue
-		}
+	return nil, nil, 0, fmt.Errorf("could not find symbol value for %s", strings.Replace(name, shadowedSeparator, "@", 1))
+}
 
-		val, err := dbp.extractValue(instructions, 0, t)
-		if err != nil {
-			return nil, err
+type noLocationError struct {
+	name string
+}
+
+func (nle noLocationError) Error() string {
+	return fmt.Sprintf("no location or type information for %s", nle.name)
+}
+
+// Builds a Variable from a DW_TAG_variable or DW_TAG_formal_parameter
+// entry. Its location is computed relative to the given canonical
+// frame address.
+func (dbp *DebuggedProcess) extractVariableFromEntry(entry *dwarf.Entry, data *dwarf.Data, cfa int64) (*Variable, error) {
+	n, _ := entry.Val(dwarf.AttrName).(string)
+
+	addr, t, err := variableLocation(entry, data, cfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbp.newVariable(n, addr, t)
+}
+
+// Reads the variable of type typ at addr. The value of an integer is
+// the number alone, the constants it equals being kept apart, so that
+// it can be used in expressions.
+func (dbp *DebuggedProcess) newVariable(name string, addr int64, typ dwarf.Type) (*Variable, error) {
+	v := &Variable{Name: name, Type: variableType(typ), basic: basicType(typ)}
+
+	val, ok, err := dbp.readInteger(uintptr(addr), typ)
+	if ok && err == nil && dbp.formatter(typ) == nil {
+		v.Value = val
+		v.Constant, err = dbp.constantNames(uintptr(addr), typ)
+	} else if err == nil {
+		v.Value, err = dbp.extractValue(addr, typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Reads the integer of type typ at addr, reporting false
+// for types other than integers.
+func (dbp *DebuggedProcess) readInteger(addr uintptr, typ dwarf.Type) (string, bool, error) {
+	switch t := underlyingType(typ).(type) {
+	case *dwarf.IntType:
+		val, err := dbp.readInt(addr, t.ByteSize)
+		return val, true, err
+	case *dwarf.UintType:
+		if t.Name == "uintptr" {
+			return "", false, nil
 		}
 
-		return &Variable{Name: n, Type: t.String(), Value: val}, nil
+		val, err := dbp.readUint(addr, t.ByteSize)
+		return val, true, err
+	}
+
+	return "", false, nil
+}
+
+// Returns the address and type of the variable described by entry.
+func variableLocation(entry *dwarf.Entry, data *dwarf.Data, cfa int64) (int64, dwarf.Type, error) {
+	n, _ := entry.Val(dwarf.AttrName).(string)
+
+	offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return 0, nil, noLocationError{n}
+	}
+
+	t, err := data.Type(offset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
+	if !ok {
+		return 0, nil, noLocationError{n}
+	}
+
+	addr, err := op.ExecuteStackProgram(cfa, instructions)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	return nil, fmt.Errorf("could not find symbol value for %s", name)
+	return addr, t, nil
 }
 
-// Extracts the value from the instructions given in the DW_AT_location entry.
-// We execute the stack program described in the DW_OP_* instruction stream, and
-// then grab the value from the other processes memory.
-func (dbp *DebuggedProcess) extractValue(instructions []byte, off int64, typ interface{}) (string, error) {
+// Returns the innermost frame of the current thread. Unlike the frames
+// returned by Stacktrace, Fn is nil in code without symbol information.
+func (dbp *DebuggedProcess) currentFrame() (*StackFrame, error) {
 	regs, err := dbp.Registers()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	pc := regs.PC()
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		pc--
 	}
 
-	fde, err := dbp.FrameEntries.FDEForPC(regs.PC())
+	cfa, err := dbp.currentCFA()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	f, l, fn := dbp.PCToLine(pc)
+
+	return &StackFrame{PC: pc, SP: regs.Rsp, CFA: cfa, File: f, Line: l, Fn: fn}, nil
+}
+
+// Returns the canonical frame address of the innermost frame, that is
+// the value of the stack pointer before the current function was called.
+func (dbp *DebuggedProcess) currentCFA() (int64, error) {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	fde, err := dbp.FDEForPC(regs.PC())
+	if err != nil {
+		return 0, err
 	}
 
 	fctx := fde.EstablishFrame(regs.PC())
-	cfaOffset := fctx.CFAOffset()
 
-	offset := off
-	if off == 0 {
-		offset, err = op.ExecuteStackProgram(cfaOffset, instructions)
-		if err != nil {
-			return "", err
+	return int64(regs.Rsp) + fctx.CFAOffset(), nil
+}
+
+// Extracts the value of type typ stored at offset in the memory of
+// the debugged process. The offset of a variable is obtained by
+// executing the stack program described in the DW_OP_* instruction
+// stream of its DW_AT_location entry.
+func (dbp *DebuggedProcess) extractValue(offset int64, typ interface{}) (string, error) {
+	if f := dbp.formatter(typ); f != nil {
+		val, err := f(&Value{Addr: uint64(offset), Type: typ.(dwarf.Type), dbp: dbp})
+		if err == nil {
+			return val, nil
 		}
-		offset = int64(regs.Rsp) + offset
 	}
 
+	return dbp.extractDefaultValue(offset, typ)
+}
+
+// Extracts the value of type typ at offset without
+// the formatter registered for the type, if any.
+func (dbp *DebuggedProcess) extractDefaultValue(offset int64, typ interface{}) (string, error) {
 	// If we have a user defined type, find the
 	// underlying concrete type and use that.
 	if tt, ok := typ.(*dwarf.TypedefType); ok {
@@ -439,12 +1759,24 @@ func (dbp *DebuggedProcess) extractValue(instructions []byte, off int64, typ int
 	offaddr := uintptr(offset)
 	switch t := typ.(type) {
 	case *dwarf.PtrType:
+		if _, ok := t.Type.(*dwarf.VoidType); ok {
+			return dbp.readAddress(offaddr)
+		}
+
+		if st, ok := t.Type.(*dwarf.StructType); ok && isMapHeader(st) {
+			return dbp.readMap(offaddr, st)
+		}
+
+		if st, ok := t.Type.(*dwarf.StructType); ok && isChanHeader(st) {
+			return dbp.readChan(offaddr, st)
+		}
+
 		addr, err := dbp.readMemory(offaddr, 8)
 		if err != nil {
 			return "", err
 		}
 		adr := binary.LittleEndian.Uint64(addr)
-		val, err := dbp.extractValue(nil, int64(adr), t.Type)
+		val, err := dbp.extractValue(int64(adr), t.Type)
 		if err != nil {
 			return "", err
 		}
@@ -452,6 +1784,10 @@ func (dbp *DebuggedProcess) extractValue(instructions []byte, off int64, typ int
 		retstr := fmt.Sprintf("*%s", val)
 		return retstr, nil
 	case *dwarf.StructType:
+		if isInterface(t) {
+			return dbp.readInterface(offaddr, t)
+		}
+
 		switch t.StructName {
 		case "string":
 			return dbp.readString(offaddr)
@@ -462,7 +1798,7 @@ func (dbp *DebuggedProcess) extractValue(instructions []byte, off int64, typ int
 			// the value of all the members of the struct.
 			fields := make([]string, 0, len(t.Field))
 			for _, field := range t.Field {
-				val, err := dbp.extractValue(nil, field.ByteOffset+offset, field.Type)
+				val, err := dbp.extractValue(field.ByteOffset+offset, field.Type)
 				if err != nil {
 					return "", err
 				}
@@ -475,31 +1811,57 @@ func (dbp *DebuggedProcess) extractValue(instructions []byte, off int64, typ int
 	case *dwarf.ArrayType:
 		return dbp.readIntArray(offaddr, t)
 	case *dwarf.IntType:
-		return dbp.readInt(offaddr)
+		val, err := dbp.readInt(offaddr, t.ByteSize)
+		if err != nil {
+			return "", err
+		}
+		return dbp.describeConstant(offaddr, t, val)
+	case *dwarf.UintType:
+		if t.Name == "uintptr" {
+			return dbp.readAddress(offaddr)
+		}
+
+		val, err := dbp.readUint(offaddr, t.ByteSize)
+		if err != nil {
+			return "", err
+		}
+		return dbp.describeConstant(offaddr, t, val)
 	case *dwarf.FloatType:
-		return dbp.readFloat64(offaddr)
+		return dbp.readFloat(offaddr, t.ByteSize)
+	case *dwarf.ComplexType:
+		return dbp.readComplex(offaddr, t.ByteSize)
+	case *dwarf.FuncType:
+		return dbp.readFunc(offaddr)
 	}
 
 	return "", fmt.Errorf("could not find value for type %s", typ)
 }
 
+// Strings longer than this are truncated when read.
+const maxStringLen = 64 << 10
+
 func (dbp *DebuggedProcess) readString(addr uintptr) (string, error) {
-	val, err := dbp.readMemory(addr, 8)
+	val, err := dbp.readMemory(addr, 16)
 	if err != nil {
 		return "", err
 	}
 
-	// deref the pointer to the string
-	addr = uintptr(binary.LittleEndian.Uint64(val))
-	val, err = dbp.readMemory(addr, 16)
+	// The string header holds a pointer to the bytes and their number.
+	str := uintptr(binary.LittleEndian.Uint64(val[:8]))
+	n := binary.LittleEndian.Uint64(val[8:])
+	if n == 0 {
+		return "", nil
+	}
+	if n > maxStringLen {
+		n = maxStringLen
+	}
+
+	val, err = dbp.readMemory(str, uintptr(n))
 	if err != nil {
 		return "", err
 	}
 
-	i := bytes.IndexByte(val, 0x0)
-	val = val[:i]
-	str := *(*string)(unsafe.Pointer(&val))
-	return str, nil
+	return string(val), nil
 }
 
 func (dbp *DebuggedProcess) readIntSlice(addr uintptr) (string, error) {
@@ -561,38 +1923,138 @@ func (dbp *DebuggedProcess) readIntArray(addr uintptr, t *dwarf.ArrayType) (stri
 	return str, nil
 }
 
-func (dbp *DebuggedProcess) readInt(addr uintptr) (string, error) {
-	val, err := dbp.readMemory(addr, 8)
+// Reads a signed integer of the given size in bytes.
+func (dbp *DebuggedProcess) readInt(addr uintptr, size int64) (string, error) {
+	n, err := dbp.readSized(addr, size)
 	if err != nil {
 		return "", err
 	}
 
-	n := binary.LittleEndian.Uint64(val)
+	// Sign extend from the top bit of the integer.
+	shift := uint(64 - 8*size)
 
-	return strconv.Itoa(int(n)), nil
+	return dbp.formatInt(int64(n<<shift) >> shift), nil
 }
 
-func (dbp *DebuggedProcess) readFloat64(addr uintptr) (string, error) {
-	var n float64
-	val, err := dbp.readMemory(addr, 8)
+// Reads an unsigned integer of the given size in bytes.
+func (dbp *DebuggedProcess) readUint(addr uintptr, size int64) (string, error) {
+	n, err := dbp.readSized(addr, size)
 	if err != nil {
 		return "", err
 	}
-	buf := bytes.NewBuffer(val)
-	binary.Read(buf, binary.LittleEndian, &n)
 
-	return strconv.FormatFloat(n, 'f', -1, 64), nil
+	return dbp.formatUint(n), nil
 }
 
-func (dbp *DebuggedProcess) readMemory(addr uintptr, size uintptr) ([]byte, error) {
-	buf := make([]byte, size)
+// Formats an integer in IntegerBase, prefixed as a Go literal
+// in that base would be.
+func (dbp *DebuggedProcess) formatInt(n int64) string {
+	if n < 0 {
+		return "-" + dbp.formatUint(uint64(-n))
+	}
+
+	return dbp.formatUint(uint64(n))
+}
+
+func (dbp *DebuggedProcess) formatUint(n uint64) string {
+	switch dbp.IntegerBase {
+	case 2:
+		return "0b" + strconv.FormatUint(n, 2)
+	case 8:
+		return fmt.Sprintf("%#o", n)
+	case 16:
+		return fmt.Sprintf("%#x", n)
+	}
+
+	return strconv.FormatUint(n, 10)
+}
 
-	_, err := syscall.PtracePeekData(dbp.Pid, addr, buf)
+// Reads a little endian integer of up to 8 bytes.
+func (dbp *DebuggedProcess) readSized(addr uintptr, size int64) (uint64, error) {
+	if size < 1 || size > 8 {
+		return 0, fmt.Errorf("unsupported integer size %d", size)
+	}
+
+	val, err := dbp.readMemory(addr, uintptr(size))
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	buf := make([]byte, 8)
+	copy(buf, val)
+
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Reads a floating point number of the given size in bytes.
+func (dbp *DebuggedProcess) readFloat(addr uintptr, size int64) (string, error) {
+	n, err := dbp.readSized(addr, size)
+	if err != nil {
+		return "", err
+	}
+
+	if size == 4 {
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(n))), 'f', -1, 32), nil
+	}
+
+	return strconv.FormatFloat(math.Float64frombits(n), 'f', -1, 64), nil
+}
+
+// Reads a complex number of the given size in bytes, made of its real
+// and imaginary parts, as in "(1.5-2i)".
+func (dbp *DebuggedProcess) readComplex(addr uintptr, size int64) (string, error) {
+	re, err := dbp.readFloat(addr, size/2)
+	if err != nil {
+		return "", err
+	}
+
+	im, err := dbp.readFloat(addr+uintptr(size/2), size/2)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(im, "-") {
+		im = "+" + im
+	}
+
+	return "(" + re + im + "i)", nil
+}
+
+// Reads an address held by a uintptr or an unsafe.Pointer, printed in
+// hexadecimal along with the function it points into, as in
+// "0x49a150 <main.double+0x10>", or else what the memory there is, as in
+// "0xc000042f58 <stack of goroutine 1>".
+func (dbp *DebuggedProcess) readAddress(addr uintptr) (string, error) {
+	n, err := dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+
+	str := fmt.Sprintf("%#x", n)
+
+	if _, _, fn := dbp.PCToLine(n); fn != nil && n != 0 {
+		if off := n - fn.Entry; off > 0 {
+			return fmt.Sprintf("%s <%s+%#x>", str, fn.Name, off), nil
+		}
+		return fmt.Sprintf("%s <%s>", str, fn.Name), nil
+	}
+
+	if desc := dbp.DescribeAddress(n); desc != "" {
+		return fmt.Sprintf("%s <%s>", str, desc), nil
 	}
 
-	return buf, nil
+	return str, nil
+}
+
+func (dbp *DebuggedProcess) readMemory(addr uintptr, size uintptr) ([]byte, error) {
+	return dbp.readThreadMemory(dbp.CurrentThread.Id, addr, size)
+}
+
+// Writes data to the memory of the process at addr. The memory is
+// written regardless of its protection.
+func (dbp *DebuggedProcess) writeMemory(addr uintptr, data []byte) error {
+	_, err := syscall.PtracePokeData(dbp.CurrentThread.Id, addr, data)
+	return err
 }
 
 func (dbp *DebuggedProcess) handleResult(err error) error {
@@ -600,11 +2062,27 @@ func (dbp *DebuggedProcess) handleResult(err error) error {
 		return err
 	}
 
-	ps, err := wait(dbp.Process.Pid)
+	ps, err := wait(dbp.CurrentThread.Id)
 	if err != nil && err != syscall.ECHILD {
 		return err
 	}
 
+	if ps != nil {
+		dbp.CurrentThread.Status = ps
+
+		if (ps.Exited() || ps.Signaled()) && dbp.CurrentThread.Id != dbp.Pid {
+			// Only the thread has exited, not the process.
+			dbp.removeThread(dbp.CurrentThread.Id)
+			return nil
+		}
+	}
+
+	return dbp.handleStatus(ps)
+}
+
+// Records the status of the thread which stopped, reporting stops
+// by signals other than the traps we cause.
+func (dbp *DebuggedProcess) handleStatus(ps *syscall.WaitStatus) error {
 	if ps != nil {
 		dbp.ProcessState = ps
 		if ps.TrapCause() == -1 && !ps.Exited() {
@@ -653,40 +2131,92 @@ func (dbp *DebuggedProcess) parseDebugFrame(wg *sync.WaitGroup) {
 func (dbp *DebuggedProcess) obtainGoSymbols(wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	tab, err := parseGoSymbols(dbp.Executable)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	dbp.GoSymTable = tab
+}
+
+// Builds a Go symbol table from the .gosymtab and
+// .gopclntab sections of the given executable.
+func parseGoSymbols(exe *elf.File) (*gosym.Table, error) {
 	var (
 		symdat  []byte
 		pclndat []byte
 		err     error
 	)
 
-	if sec := dbp.Executable.Section(".gosymtab"); sec != nil {
+	if sec := exe.Section(".gosymtab"); sec != nil {
 		symdat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gosymtab section", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("could not get .gosymtab section: %s", err)
 		}
 	}
 
-	if sec := dbp.Executable.Section(".gopclntab"); sec != nil {
+	if sec := exe.Section(".gopclntab"); sec != nil {
 		pclndat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gopclntab section", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("could not get .gopclntab section: %s", err)
 		}
 	}
 
-	pcln := gosym.NewLineTable(pclndat, dbp.Executable.Section(".text").Addr)
+	pcln := gosym.NewLineTable(pclndat, textStart(exe))
 	tab, err := gosym.NewTable(symdat, pcln)
 	if err != nil {
-		fmt.Println("could not get initialize line table", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("could not get initialize line table: %s", err)
 	}
 
-	dbp.GoSymTable = tab
+	return tab, nil
+}
+
+// Returns the address the program counters of the line table of exe are
+// relative to. That is the start of Go code, runtime.text, which the
+// external linker used for cgo and plugins places after C code at the
+// start of the .text section.
+func textStart(exe *elf.File) uint64 {
+	if syms, err := exe.Symbols(); err == nil {
+		for _, sym := range syms {
+			if sym.Name == "runtime.text" {
+				return sym.Value
+			}
+		}
+	}
+
+	return exe.Section(".text").Addr
+}
+
+// Returns the address of the instruction the current function is going
+// to return to. The return address is located from the canonical frame
+// address computed with the .debug_frame rules for the current PC, so
+// it is found wherever in the function we are stopped.
+func (dbp *DebuggedProcess) ReturnAddress() (uint64, error) {
+	regs, err := dbp.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	pc := regs.PC()
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		// The breakpoint instruction has executed, but not
+		// the instruction it replaced.
+		pc--
+	}
+
+	fde, err := dbp.FDEForPC(pc)
+	if err != nil {
+		return 0, err
+	}
+
+	return dbp.readUint64(uintptr(int64(regs.Rsp) + fde.ReturnAddressOffset(pc)))
 }
 
 // Takes an offset from RSP and returns the address of the
 // instruction the currect function is going to return to.
+// Only meaningful where the offset is known without call frame
+// information, such as 0 at the first instruction of a function.
 func (dbp *DebuggedProcess) ReturnAddressFromOffset(offset int64) uint64 {
 	regs, err := dbp.Registers()
 	if err != nil {
@@ -695,7 +2225,7 @@ func (dbp *DebuggedProcess) ReturnAddressFromOffset(offset int64) uint64 {
 
 	retaddr := int64(regs.Rsp) + offset
 	data := make([]byte, 8)
-	syscall.PtracePeekText(dbp.Pid, uintptr(retaddr), data)
+	syscall.PtracePeekText(dbp.CurrentThread.Id, uintptr(retaddr), data)
 	return binary.LittleEndian.Uint64(data)
 }
 
@@ -703,7 +2233,7 @@ func wait(pid int) (*syscall.WaitStatus, error) {
 	var status syscall.WaitStatus
 	var rusage syscall.Rusage
 
-	_, e := syscall.Wait4(pid, &status, 0, &rusage)
+	_, e := syscall.Wait4(pid, &status, syscall.WALL, &rusage)
 	if e != nil {
 		return nil, e
 	}
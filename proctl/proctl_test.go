@@ -2,9 +2,18 @@ package proctl_test
 
 import (
 	"bytes"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/derekparker/delve/helper"
 	"github.com/derekparker/delve/proctl"
@@ -263,3 +272,2805 @@ func TestVariableEvaluation(t *testing.T) {
 		}
 	})
 }
+
+func TestMapEvaluation(t *testing.T) {
+	executablePath := "../_fixtures/testmaps"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 11)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		testcases := []struct {
+			name, value, varType string
+		}{
+			{"m1", "len: 3 [1: 10, 2: 20, 3: 30]", "map[int]int"},
+			{"m2", "nil", "map[int]int"},
+		}
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name)
+			assertNoError(err, t, "EvalSymbol()")
+
+			if variable.Type != tc.varType {
+				t.Fatalf("Expected %s got %s\n", tc.varType, variable.Type)
+			}
+
+			if variable.Value != tc.value {
+				t.Fatalf("Expected %#v got %#v\n", tc.value, variable.Value)
+			}
+		}
+
+		p.MaxMapEntries = 2
+
+		variable, err := p.EvalSymbol("m1")
+		assertNoError(err, t, "EvalSymbol()")
+
+		if !strings.HasPrefix(variable.Value, "len: 3 [") || !strings.HasSuffix(variable.Value, ", ...]") || strings.Count(variable.Value, ":") != 3 {
+			t.Fatalf("Expected two of three entries, got %#v\n", variable.Value)
+		}
+	})
+}
+
+func TestChanEvaluation(t *testing.T) {
+	executablePath := "../_fixtures/testchans"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 27)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		c1, err := p.EvalSymbol("c1")
+		assertNoError(err, t, "EvalSymbol()")
+
+		if c1.Value != "chan int (buf 5/10 [8, 9, 10, 11, 12])" {
+			t.Fatalf("Unexpected value of c1 %#v", c1.Value)
+		}
+
+		c2, err := p.EvalSymbol("c2")
+		assertNoError(err, t, "EvalSymbol()")
+
+		if !strings.HasPrefix(c2.Value, "chan int (unbuffered, recvq: 2 goroutines [") {
+			t.Fatalf("Unexpected value of c2 %#v", c2.Value)
+		}
+	})
+}
+
+func TestInterfaceEvaluation(t *testing.T) {
+	executablePath := "../_fixtures/testinterfaces"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name, value, varType string
+	}{
+		{"i1", "(int) 42", "interface {}"},
+		{"i2", "(*main.FooBar) *main.FooBar {Baz: 5, Qux: 6}", "interface {}"},
+		{"i3", "nil", "fmt.Stringer"},
+		{"e1", "(main.code) 3", "error"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 23)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name)
+			assertNoError(err, t, "EvalSymbol()")
+
+			if variable.Type != tc.varType {
+				t.Fatalf("Expected %s got %s\n", tc.varType, variable.Type)
+			}
+
+			if variable.Value != tc.value {
+				t.Fatalf("Expected %#v got %#v\n", tc.value, variable.Value)
+			}
+		}
+	})
+}
+
+func TestEvalSelectors(t *testing.T) {
+	executablePath := "../_fixtures/testnested"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value, typ string
+	}{
+		{"o.M.In.N", "4", "int"},
+		{"p.M.In.Vals[1]", "2", "int"},
+		{"(*p).M.In.Vals[2]", "3", "int"},
+		{"o.M.In.Vals[0] + p.M.In.N", "5", "int"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 23)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Type != tc.typ {
+				t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+			}
+		}
+
+		for _, bad := range []string{"o.M.In.Vals[3]", "o.M.Nope", "o.M.In.N[0]", "*o"} {
+			if _, err := p.EvalExpression(bad); err == nil {
+				t.Fatalf("Expected %s to fail", bad)
+			}
+		}
+	})
+}
+
+func TestEvalGlobals(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value, typ string
+	}{
+		{"main.counter", "1", "int"},
+		{"main.config.Retries", "4", "int"},
+		{"main.config.Name", "delve", "struct string"},
+		{"main.counter + main.config.Retries", "5", "int"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Type != tc.typ {
+				t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+			}
+		}
+
+		for _, expr := range []string{"path/filepath.SkipDir", "filepath.SkipDir"} {
+			v, err := p.EvalExpression(expr)
+			assertNoError(err, t, expr)
+
+			if !strings.Contains(v.Value, "skip this directory") {
+				t.Fatalf("%s: unexpected value %s", expr, v.Value)
+			}
+		}
+
+		v, err := p.EvalSymbol("main.counter")
+		assertNoError(err, t, "EvalSymbol()")
+
+		if v.Value != "1" {
+			t.Fatalf("Expected main.counter to be 1 got %s", v.Value)
+		}
+
+		if _, err := p.EvalExpression("main.nope"); err == nil {
+			t.Fatal("Expected main.nope to fail")
+		}
+	})
+}
+
+func TestEvalConversions(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value, typ string
+	}{
+		{"int64(main.counter)", "1", "int64"},
+		{"int8(main.counter + 127)", "-128", "int8"},
+		{"uint8(main.counter - 2)", "255", "uint8"},
+		{"float64(main.counter) / 2", "0.5", "float64"},
+		{"int(7.9)", "7", "int"},
+		{"(*main.Config)(unsafe.Pointer(main.current)).Retries", "4", "int"},
+		{"(*main.Config)(uintptr(unsafe.Pointer(main.current))).Name", "delve", "struct string"},
+		{"(*(*main.Config)(unsafe.Pointer(main.current))).Retries", "4", "int"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Type != tc.typ {
+				t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+			}
+		}
+
+		v, err := p.EvalExpression("(*main.Config)(unsafe.Pointer(main.current))")
+		assertNoError(err, t, "EvalExpression()")
+
+		if v.Type != "*main.Config" || !strings.HasPrefix(v.Value, "*main.Config {") {
+			t.Fatalf("unexpected value %s %s", v.Value, v.Type)
+		}
+
+		if _, err := p.EvalExpression("(*main.Config)(0)"); err == nil {
+			t.Fatal("expected converting 0 to a pointer to fail")
+		}
+	})
+}
+
+func TestAddressOf(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		addr, err := p.EvalExpression("&main.config")
+		assertNoError(err, t, "&main.config")
+
+		current, err := p.EvalExpression("unsafe.Pointer(main.current)")
+		assertNoError(err, t, "unsafe.Pointer(main.current)")
+
+		if addr.Type != "*main.Config" || addr.Value != current.Value {
+			t.Fatalf("expected *main.Config %s got %s %s", current.Value, addr.Type, addr.Value)
+		}
+
+		retries, err := p.EvalExpression("&main.config.Retries")
+		assertNoError(err, t, "&main.config.Retries")
+
+		testcases := []struct {
+			expr, value string
+		}{
+			{"(*&main.config).Retries", "4"},
+			{fmt.Sprintf("(*main.Config)(%s).Retries", addr.Value), "4"},
+			{fmt.Sprintf("*(*int)(%s)", retries.Value), "4"},
+			{"(*main.Config)(&main.config).Name", "delve"},
+		}
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value {
+				t.Fatalf("%s: expected %q got %q", tc.expr, tc.value, v.Value)
+			}
+		}
+
+		if _, err := p.EvalExpression("&main.counter + 1"); err == nil {
+			t.Fatal("expected arithmetic on an address to fail")
+		}
+
+		if _, err := p.EvalExpression("&$rax"); err == nil {
+			t.Fatal("expected taking the address of a register to fail")
+		}
+	})
+}
+
+func TestReadMemory(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		config, err := p.EvalAddressInFrame("main.config", nil)
+		assertNoError(err, t, "EvalAddressInFrame(main.config)")
+
+		current, err := p.EvalAddressInFrame("main.current", nil)
+		assertNoError(err, t, "EvalAddressInFrame(main.current)")
+
+		if config != current {
+			t.Fatalf("expected main.current to point to %#x got %#x", config, current)
+		}
+
+		retries, err := p.EvalAddressInFrame("&main.config.Retries", nil)
+		assertNoError(err, t, "EvalAddressInFrame(&main.config.Retries)")
+
+		data, err := p.ReadMemory(retries, 8)
+		assertNoError(err, t, "ReadMemory()")
+
+		if n := binary.LittleEndian.Uint64(data); n != 4 {
+			t.Fatalf("expected 4 got %d", n)
+		}
+
+		if _, err := p.ReadMemory(0, 8); err == nil {
+			t.Fatal("expected reading address 0 to fail")
+		}
+	})
+}
+
+func TestIntegerBase(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		base        int
+		expr, value string
+	}{
+		{16, "main.counter", "0x1"},
+		{16, "main.counter + 254", "0xff"},
+		{16, "-main.counter - 30", "-0x1f"},
+		{8, "main.config.Retries + 4", "010"},
+		{2, "main.config", "main.Config {Name: delve, Retries: 0b100}"},
+		{10, "main.counter", "1"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			p.IntegerBase = tc.base
+
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value {
+				t.Fatalf("%s in base %d: expected %s got %s", tc.expr, tc.base, tc.value, v.Value)
+			}
+		}
+
+		data, err := p.ExpressionBytesInFrame("main.config.Name", nil)
+		assertNoError(err, t, "ExpressionBytesInFrame()")
+
+		if string(data) != "delve" {
+			t.Fatalf("Expected the bytes of main.config.Name to be delve got %q", data)
+		}
+	})
+}
+
+func TestExpressionType(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, typ string
+	}{
+		{"main.config", "main.Config"},
+		{"main.config.Name", "string"},
+		{"main.current", "*main.Config"},
+		{"main.current.Retries", "int"},
+		{"main.counter + 1", "int"},
+		{"uint8(main.counter)", "uint8"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			typ, err := p.ExpressionTypeInFrame(tc.expr, nil)
+			assertNoError(err, t, tc.expr)
+
+			if typ != tc.typ {
+				t.Fatalf("%s: expected type %s got %s", tc.expr, tc.typ, typ)
+			}
+		}
+	})
+}
+
+func TestTypes(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testglobals", t, func(p *proctl.DebuggedProcess) {
+		types, err := p.Types(regexp.MustCompile(`^main\.`))
+		assertNoError(err, t, "Types()")
+
+		found := false
+		for _, typ := range types {
+			if !strings.HasPrefix(typ, "main.") {
+				t.Fatalf("Unexpected type %s", typ)
+			}
+			found = found || typ == "main.Config"
+		}
+
+		if !found {
+			t.Fatalf("Expected main.Config among %v", types)
+		}
+	})
+}
+
+func TestPackageVariables(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		vars, err := p.PackageVariables(regexp.MustCompile(`^main\.`))
+		assertNoError(err, t, "PackageVariables()")
+
+		values := make(map[string]string)
+		for _, v := range vars {
+			values[v.Name] = v.Value
+		}
+
+		if _, ok := values["main.config"]; !ok {
+			t.Fatalf("Expected main.config among %v", values)
+		}
+
+		if values["main.counter"] != "1" {
+			t.Fatalf("Expected main.counter to be 1 got %q", values["main.counter"])
+		}
+	})
+}
+
+func TestCall(t *testing.T) {
+	executablePath := "../_fixtures/testcall"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 30)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		_, err = p.Call("main.double(21)")
+		if err == nil {
+			t.Fatal("Expected Call() to fail unless AllowCalls is set")
+		}
+
+		p.AllowCalls = true
+
+		testcases := []struct {
+			expr   string
+			result string
+		}{
+			{"main.double(21)", "42"},
+			{"main.double(x)", "42"},
+			{"double(x + 1)", "44"},
+			{"main.total.Add(2)", "42"},
+			{`join("-", "a", "b")`, "a-b"},
+		}
+
+		for _, tc := range testcases {
+			vs, err := p.Call(tc.expr)
+			assertNoError(err, t, "Call("+tc.expr+")")
+
+			if len(vs) != 1 {
+				t.Fatalf("Expected one result of %s got %d", tc.expr, len(vs))
+			}
+
+			if vs[0].Value != tc.result {
+				t.Fatalf("Expected %s to return %q got %q", tc.expr, tc.result, vs[0].Value)
+			}
+		}
+
+		v, err := p.EvalExpression("main.total.n")
+		assertNoError(err, t, "EvalExpression()")
+
+		if v.Value != "42" {
+			t.Fatalf("Expected main.total.n to be 42 after calls got %q", v.Value)
+		}
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+	})
+}
+
+func TestFormatters(t *testing.T) {
+	executablePath := "../_fixtures/testformatters"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value string
+	}{
+		{"main.timeout", "1m30s"},
+		{"main.start", "2014-09-01T10:00:00Z"},
+		{"main.addr", "10.0.0.1"},
+		{"main.huge", "*-1267650600228229401496703205376"},
+		{"main.mu", "locked"},
+		{"main.temp", "21°C"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 24)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		p.RegisterFormatter("main.Celsius", func(v *proctl.Value) (string, error) {
+			n, err := v.Int()
+			return fmt.Sprintf("%d°C", n), err
+		})
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value {
+				t.Fatalf("%s: expected %q got %q", tc.expr, tc.value, v.Value)
+			}
+		}
+
+		p.RegisterFormatter("time.Duration", nil)
+
+		v, err := p.EvalExpression("main.timeout")
+		assertNoError(err, t, "main.timeout")
+
+		if v.Value != "90000000000" {
+			t.Fatalf("Expected main.timeout to be printed as an integer once its formatter is removed, got %q", v.Value)
+		}
+	})
+}
+
+func TestSetVariable(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name  string
+		expr  string
+		value string
+	}{
+		{"a2", "42", "42"},
+		{"a2", "a2 * 2 + 1", "85"},
+		{"a3", "1.5", "1.5"},
+		{"a6.Baz", "-3", "main.FooBar {Baz: -3, Bur: word}"},
+		{"a7.Bur", "a1", "*main.FooBar {Baz: 5, Bur: foo}"},
+		{"a1", `"strum"`, "strum"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 21)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			assertNoError(p.SetVariable(tc.name, tc.expr), t, "SetVariable()")
+
+			name := strings.Split(tc.name, ".")[0]
+			variable, err := p.EvalSymbol(name)
+			assertNoError(err, t, "EvalSymbol()")
+
+			if variable.Value != tc.value {
+				t.Fatalf("%s = %s: expected %#v got %#v\n", tc.name, tc.expr, tc.value, variable.Value)
+			}
+		}
+
+		for _, bad := range []string{"a2 = 1.5", "a2 = \"foo\"", "a3 = nil", "a6.Nope = 1", "a1 = \"not in the program\""} {
+			i := strings.Index(bad, " = ")
+			if err := p.SetVariable(bad[:i], bad[i+3:]); err == nil {
+				t.Fatalf("Expected %s to fail", bad)
+			}
+		}
+
+		assertNoError(p.SetVariable("a7", "nil"), t, "SetVariable()")
+		if err := p.SetVariable("a7.Baz", "1"); err == nil {
+			t.Fatal("Expected setting a field through a nil pointer to fail")
+		}
+	})
+}
+
+func TestEvalSliceExpressions(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value, typ string
+	}{
+		{"a5[1:3]", "len: 2 cap: 4 [2 3]", "struct []int"},
+		{"a5[:2]", "len: 2 cap: 5 [1 2]", "struct []int"},
+		{"a5[5:]", "len: 0 cap: 0 []", "struct []int"},
+		{"a1[1:3]", "oo", "struct string"},
+		{"baz[:3]", "baz", "struct string"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 21)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Type != tc.typ {
+				t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+			}
+		}
+
+		for _, bad := range []string{"a5[2:6]", "a5[3:2]", "a1[0:4]", "a2[0:1]"} {
+			if _, err := p.EvalExpression(bad); err == nil {
+				t.Fatalf("Expected %s to fail", bad)
+			}
+		}
+	})
+}
+
+func TestStepOverCgoCall(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/cgotest", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.main")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		for i := 0; i < 200 && !p.ProcessState.Exited(); i++ {
+			assertNoError(p.Step(), t, "Step()")
+			if p.ProcessState.Exited() {
+				break
+			}
+
+			pc := currentPC(p, t)
+			if _, _, fn := p.PCToLine(pc); fn == nil {
+				t.Fatalf("Step() stopped in code without symbols at %#v", pc)
+			}
+		}
+	})
+}
+
+func TestStacktrace(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 21)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(10)
+		assertNoError(err, t, "Stacktrace()")
+
+		if len(frames) < 2 {
+			t.Fatalf("Expected at least 2 frames got %d", len(frames))
+		}
+
+		if frames[0].Fn.Name != "main.foobar" || frames[0].Line != 21 {
+			t.Fatalf("Wrong innermost frame %s:%d", frames[0].Fn.Name, frames[0].Line)
+		}
+
+		if frames[1].Fn.Name != "main.main" || frames[1].Line != 25 {
+			t.Fatalf("Wrong caller frame %s:%d", frames[1].Fn.Name, frames[1].Line)
+		}
+
+		args, err := p.FunctionArguments(frames[0])
+		assertNoError(err, t, "FunctionArguments()")
+
+		if len(args) != 1 || args[0].Name != "baz" || args[0].Value != "bazburzum" {
+			t.Fatalf("Wrong arguments for main.foobar: %#v", args)
+		}
+	})
+}
+
+func TestLocalVariables(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 21)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(1)
+		assertNoError(err, t, "Stacktrace()")
+
+		locals, err := p.LocalVariables(frames[0])
+		assertNoError(err, t, "LocalVariables()")
+
+		values := make(map[string]string)
+		for _, v := range locals {
+			values[v.Name] = v.Value
+		}
+
+		if values["a2"] != "6" || values["a3"] != "7.23" {
+			t.Fatalf("Wrong local variables: %#v", values)
+		}
+
+		if _, ok := values["baz"]; ok {
+			t.Fatal("Arguments should not be reported as local variables")
+		}
+	})
+}
+
+func TestFrameDefers(t *testing.T) {
+	executablePath := "../_fixtures/testdefers"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 11)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+
+		for i, frame := range frames {
+			defers, err := p.FrameDefers(frame)
+			assertNoError(err, t, "FrameDefers()")
+
+			if len(defers) != 1 || defers[0].Fn == nil || defers[0].Fn.Name != "main.cleanup" {
+				t.Fatalf("Wrong deferred calls for frame %d: %#v", i, defers)
+			}
+		}
+	})
+}
+
+func TestGoroutineStacktrace(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testgoroutines", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.stop")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		goroutines, err := p.Goroutines()
+		assertNoError(err, t, "Goroutines()")
+
+		workers := 0
+		for _, g := range goroutines {
+			frames, err := p.GoroutineStacktrace(g.ID, 20)
+			if err != nil {
+				continue
+			}
+
+			for _, frame := range frames {
+				if frame.Fn.Name == "main.worker" {
+					workers++
+					break
+				}
+			}
+		}
+
+		if workers != 10 {
+			t.Fatalf("Expected 10 goroutines stopped in main.worker, found %d", workers)
+		}
+	})
+}
+
+func TestEvalConstantExpression(t *testing.T) {
+	var p proctl.DebuggedProcess
+
+	testcases := []struct {
+		expr, value, typ string
+	}{
+		{"1 + 2*3", "7", "untyped int"},
+		{"7 / 2", "3", "untyped int"},
+		{"-7 % 3", "-1", "untyped int"},
+		{"0x10 | 1", "17", "untyped int"},
+		{"1.5 * 2", "3", "untyped float"},
+		{"1 < 2 && !(2 == 3)", "true", "bool"},
+		{`"foo" == "bar"`, "false", "bool"},
+		{`"cost $5" == "cost \x245"`, "true", "bool"},
+		{`"user1@2" == "user1\x402"`, "true", "bool"},
+	}
+
+	for _, tc := range testcases {
+		v, err := p.EvalExpression(tc.expr)
+		assertNoError(err, t, tc.expr)
+
+		if v.Value != tc.value || v.Type != tc.typ {
+			t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+		}
+	}
+
+	if _, err := p.EvalExpression("1 / 0"); err == nil {
+		t.Fatal("Expected division by zero to fail")
+	}
+}
+
+func TestEvalRegisters(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		regs := helper.GetRegisters(p, t)
+
+		v, err := p.EvalExpression("$pc")
+		assertNoError(err, t, "EvalExpression($pc)")
+
+		if v.Value != fmt.Sprintf("%#x", regs.PC()) {
+			t.Fatalf("Expected $pc %#x got %s", regs.PC(), v.Value)
+		}
+
+		v, err = p.EvalExpression("$sp == $rsp && $rip == $pc")
+		assertNoError(err, t, "EvalExpression()")
+
+		if v.Value != "true" {
+			t.Fatalf("Register aliases do not match: %s", v.Value)
+		}
+
+		for _, expr := range []string{"$rsp / 0", "$rsp % 0", "$rsp / ($rsp - $sp)"} {
+			_, err := p.EvalExpression(expr)
+			if err == nil || !strings.Contains(err.Error(), "division by zero") {
+				t.Fatalf("%s: expected division by zero got %v", expr, err)
+			}
+		}
+
+		for _, expr := range []string{"$rsp << 100000", "$rsp >> 100000", "$rsp << 64"} {
+			v, err := p.EvalExpression(expr)
+			assertNoError(err, t, expr)
+
+			if v.Value != "0" {
+				t.Fatalf("%s: expected 0 got %s", expr, v.Value)
+			}
+		}
+
+		if _, err := p.EvalExpression("1 << 100000"); err == nil {
+			t.Fatal("Expected shifting an untyped constant by 100000 to fail")
+		}
+	})
+}
+
+func TestNextInstruction(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.main")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		for i := 0; i < 20; i++ {
+			assertNoError(p.NextInstruction(), t, "NextInstruction()")
+
+			pc := currentPC(p, t)
+			if _, _, f := p.PCToLine(pc); f == nil || f.Name != "main.main" {
+				t.Fatalf("NextInstruction() left main.main, now at %#v", pc)
+			}
+		}
+	})
+}
+
+func TestDetach(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.sleepytime")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Detach(), t, "Detach()")
+
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("Breakpoints not removed before detaching")
+		}
+
+		// The process must keep running without tripping over a
+		// leftover breakpoint instruction.
+		time.Sleep(100 * time.Millisecond)
+		if err := syscall.Kill(p.Pid, 0); err != nil {
+			t.Fatal("Process did not survive detaching:", err)
+		}
+	})
+}
+
+func TestDetachAtBreakpoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.Detach(), t, "Detach()")
+
+		// Stopped just past the breakpoint, the thread must
+		// resume at the instruction it replaced.
+		time.Sleep(100 * time.Millisecond)
+		if err := syscall.Kill(p.Pid, 0); err != nil {
+			t.Fatal("Process did not survive detaching:", err)
+		}
+
+		var ws syscall.WaitStatus
+		if pid, _ := syscall.Wait4(p.Pid, &ws, syscall.WNOHANG, nil); pid == p.Pid {
+			t.Fatalf("Process exited after detaching: %v", ws)
+		}
+	})
+}
+
+func TestConditionalBreakPoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "testnextprog.go"), 24)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.BreakIf(uintptr(pc), "i == 2")
+		assertNoError(err, t, "BreakIf()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		v, err := p.EvalSymbol("i")
+		assertNoError(err, t, "EvalSymbol()")
+
+		if v.Value != "2" {
+			t.Fatalf("Stopped with i = %s, expected condition i == 2 to hold", v.Value)
+		}
+	})
+}
+
+func TestInvalidBreakPointCondition(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.testnext")
+
+		_, err := p.BreakIf(uintptr(fn.Entry), "i ==")
+		if err == nil {
+			t.Fatal("Expected an error for an unparsable condition")
+		}
+
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("Breakpoint set despite invalid condition")
+		}
+	})
+}
+
+func TestBreakPointHitCount(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.helloworld")
+		bp, err := p.BreakIf(uintptr(fn.Entry), "hitcount % 3 == 0")
+		assertNoError(err, t, "BreakIf()")
+
+		for i := 1; i <= 2; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			if bp.HitCount != uint64(3*i) {
+				t.Fatalf("Stopped after %d hits, expected %d", bp.HitCount, 3*i)
+			}
+		}
+	})
+}
+
+func TestOneShotBreakPoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.helloworld")
+		bp, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		bp.OneShot = true
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		if pc != fn.Entry {
+			t.Fatalf("Expected to stop at %#v, stopped at %#v", fn.Entry, pc)
+		}
+
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("One shot breakpoint was not cleared")
+		}
+	})
+}
+
+func TestBreakPointKeptAfterContinue(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.helloworld")
+		bp, err := p.BreakIf(uintptr(fn.Entry), "true")
+		assertNoError(err, t, "BreakIf()")
+
+		for i := 0; i < 2; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+		}
+
+		if p.BreakPoints[fn.Entry] != bp {
+			t.Fatal("Breakpoint was replaced when continuing past it")
+		}
+
+		if bp.ID != 1 || bp.HitCount != 2 || bp.Condition != "true" {
+			t.Fatalf("Breakpoint state lost: %#v", bp)
+		}
+	})
+}
+
+func TestBreakPointOnCall(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		// Continuing from a breakpoint on a call executes the call out
+		// of line, its target and return address must be the original.
+		testnext := p.LookupFunc("main.testnext")
+		insts, err := p.Disassemble(testnext.Entry, testnext.End)
+		assertNoError(err, t, "Disassemble()")
+
+		var call uint64
+		for _, inst := range insts {
+			if inst.Line == 31 && strings.HasPrefix(inst.Text, "CALL main.helloworld") {
+				call = inst.PC
+			}
+		}
+
+		if call == 0 {
+			t.Fatal("Call to main.helloworld not found")
+		}
+
+		fn := p.LookupFunc("main.helloworld")
+		callbp, err := p.Break(uintptr(call))
+		assertNoError(err, t, "Break()")
+		fnbp, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		for i := 1; i <= 2; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			if pc := currentPC(p, t); pc != call+1 {
+				t.Fatalf("Expected to stop at the call %#v, stopped at %#v", call, pc-1)
+			}
+
+			assertNoError(p.Continue(), t, "Continue()")
+			if pc := currentPC(p, t); pc != fn.Entry+1 {
+				t.Fatalf("Expected to stop at %#v, stopped at %#v", fn.Entry, pc-1)
+			}
+		}
+
+		if callbp.HitCount != 2 || fnbp.HitCount != 2 {
+			t.Fatalf("Breakpoints hit %d and %d times, expected 2", callbp.HitCount, fnbp.HitCount)
+		}
+	})
+}
+
+func TestWatchpoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "testnextprog.go"), 24)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		wp, err := p.Watch("j")
+		assertNoError(err, t, "Watch()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		cur, err := p.CurrentWatchpoint()
+		assertNoError(err, t, "CurrentWatchpoint()")
+
+		if cur != wp || wp.HitCount != 1 {
+			t.Fatalf("Expected to stop at watchpoint for j, got %#v", cur)
+		}
+
+		// j * (j ^ 3) / 100 is 0 while j is 1, so the value written is unchanged.
+		old, val, err := p.WatchpointValues(wp)
+		assertNoError(err, t, "WatchpointValues()")
+
+		if old != 1 || val != 1 {
+			t.Fatalf("Unexpected values for j, old: %d new: %d", old, val)
+		}
+
+		assertNoError(p.ClearWatchpoint(wp), t, "ClearWatchpoint()")
+	})
+}
+
+func TestWatchpointScope(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "testnextprog.go"), 24)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		wp, err := p.Watch("j")
+		assertNoError(err, t, "Watch()")
+
+		// j is written to by the loop, then testnext returns.
+		for i := 0; i < 10 && len(p.WatchpointChanges) == 0; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+		}
+
+		if len(p.WatchpointChanges) != 1 || !p.WatchpointChanges[0].Cleared || p.WatchpointChanges[0].Watchpoint != wp {
+			t.Fatalf("Expected the watchpoint for j to be cleared as testnext returns, got %#v", p.WatchpointChanges)
+		}
+
+		for _, w := range p.Watchpoints {
+			if w == wp {
+				t.Fatal("Expected the watchpoint for j to be removed")
+			}
+		}
+
+		_, _, fn := p.PCToLine(currentPC(p, t))
+		if fn == nil || fn.Name != "main.main" {
+			t.Fatalf("Expected to stop in main.main once testnext returned, got %#v", fn)
+		}
+	})
+}
+
+func TestBreakOnPanic(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/panicprog", t, func(p *proctl.DebuggedProcess) {
+		_, err := p.BreakOnPanic()
+		assertNoError(err, t, "BreakOnPanic()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		bp, err := p.CurrentBreakpoint()
+		assertNoError(err, t, "CurrentBreakpoint()")
+
+		if bp == nil || !bp.IsPanic() {
+			t.Fatalf("Expected to stop on panic, stopped at %#v", bp)
+		}
+
+		frames, err := p.Stacktrace(10)
+		assertNoError(err, t, "Stacktrace()")
+
+		for _, frame := range frames {
+			if frame.Fn.Name == "main.explode" {
+				return
+			}
+		}
+		t.Fatal("main.explode not found in the stack of the panic")
+	})
+}
+
+func TestFinish(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testreturns", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.square")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(fn.Entry)
+		assertNoError(err, t, "Clear()")
+
+		vals, err := p.Finish()
+		assertNoError(err, t, "Finish()")
+
+		if len(vals) != 1 || vals[0].Value != "49" {
+			t.Fatalf("Unexpected return values %#v", vals)
+		}
+
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		if _, _, fn := p.PCToLine(pc); fn.Name != "main.main" {
+			t.Fatalf("Expected to return to main.main, stopped in %s", fn.Name)
+		}
+	})
+}
+
+func TestTracepoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testreturns", t, func(p *proctl.DebuggedProcess) {
+		square := p.LookupFunc("main.square")
+		tp, err := p.Break(uintptr(square.Entry))
+		assertNoError(err, t, "Break()")
+		tp.Tracepoint = true
+
+		records := make([]*proctl.TraceRecord, 0)
+		p.TraceHandler = func(r *proctl.TraceRecord) {
+			records = append(records, r)
+		}
+
+		// square is called once before every call to fmt.Println.
+		println := p.LookupFunc("fmt.Println")
+		_, err = p.BreakIf(uintptr(println.Entry), "hitcount == 3")
+		assertNoError(err, t, "BreakIf()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if len(records) != 3 {
+			t.Fatalf("Expected 3 trace records, got %d", len(records))
+		}
+
+		r := records[0]
+		if r.Function != "main.square" || len(r.Args) == 0 || r.Args[0].Value != "7" {
+			t.Fatalf("Unexpected trace record %s", r)
+		}
+	})
+}
+
+func TestLogpoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testreturns", t, func(p *proctl.DebuggedProcess) {
+		square := p.LookupFunc("main.square")
+		lp, err := p.Break(uintptr(square.Entry))
+		assertNoError(err, t, "Break()")
+		lp.LogMessage = "n={n} n*2={n * 2} {{literal}} {nosuchvar}"
+
+		records := make([]*proctl.TraceRecord, 0)
+		p.TraceHandler = func(r *proctl.TraceRecord) {
+			records = append(records, r)
+		}
+
+		println := p.LookupFunc("fmt.Println")
+		_, err = p.BreakIf(uintptr(println.Entry), "hitcount == 2")
+		assertNoError(err, t, "BreakIf()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if len(records) != 2 {
+			t.Fatalf("Expected 2 log records, got %d", len(records))
+		}
+
+		msg := records[0].Message
+		if !strings.HasPrefix(msg, "n=7 n*2=14 {literal} <") {
+			t.Fatalf("Unexpected log message %q", msg)
+		}
+	})
+}
+
+func TestBreakPointMaxHits(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testreturns", t, func(p *proctl.DebuggedProcess) {
+		square := p.LookupFunc("main.square")
+		tp, err := p.Break(uintptr(square.Entry))
+		assertNoError(err, t, "Break()")
+		tp.Tracepoint = true
+		tp.MaxHits = 2
+
+		records := 0
+		p.TraceHandler = func(r *proctl.TraceRecord) {
+			records++
+		}
+
+		println := p.LookupFunc("fmt.Println")
+		_, err = p.BreakIf(uintptr(println.Entry), "hitcount == 3")
+		assertNoError(err, t, "BreakIf()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if records != 2 {
+			t.Fatalf("Expected 2 trace records, got %d", records)
+		}
+
+		if _, ok := p.BreakPoints[square.Entry]; ok {
+			t.Fatal("Breakpoint not cleared after reaching its maximum hits")
+		}
+	})
+}
+
+func TestBreakOnGo(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testgoroutines", t, func(p *proctl.DebuggedProcess) {
+		_, err := p.BreakOnGo()
+		assertNoError(err, t, "BreakOnGo()")
+
+		// The runtime starts goroutines of its own before main runs.
+		for i := 0; i < 20; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			fn, site, err := p.GoStatement()
+			assertNoError(err, t, "GoStatement()")
+
+			if fn.Name != "main.worker" {
+				continue
+			}
+
+			if site.Fn.Name != "main.main" || site.Line != 26 {
+				t.Fatalf("Unexpected go statement location %s:%d in %s", site.File, site.Line, site.Fn.Name)
+			}
+			return
+		}
+
+		t.Fatal("Never stopped at the go statement launching main.worker")
+	})
+}
+
+func TestUntilLoopExit(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		f := filepath.Join(pwd, "testnextprog.go")
+
+		// The last line of the loop body.
+		pc, _, err := p.LineToPC(f, 31)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		assertNoError(p.UntilLoopExit(), t, "UntilLoopExit()")
+
+		pc, err = p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		_, l, _ := p.PCToLine(pc)
+		if l <= 31 {
+			t.Fatalf("Expected to leave the loop, stopped at line %d", l)
+		}
+	})
+}
+
+func TestUntil(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		f := filepath.Join(pwd, "testnextprog.go")
+
+		fn := p.LookupFunc("main.testnext")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(fn.Entry)
+		assertNoError(err, t, "Clear()")
+
+		pc, _, err := p.LineToPC(f, 34)
+		assertNoError(err, t, "LineToPC()")
+
+		assertNoError(p.Until(pc), t, "Until()")
+
+		cur, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		if cur != pc {
+			t.Fatalf("Expected to stop at %#v, stopped at %#v", pc, cur)
+		}
+
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("Temporary breakpoint was not cleared")
+		}
+	})
+}
+
+func TestStepInto(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/teststepcall", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "teststepcall.go"), 19)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		h := p.LookupFunc("main.h")
+		assertNoError(p.StepInto(h.Entry), t, "StepInto()")
+
+		pc, err = p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		if pc != h.Entry {
+			t.Fatalf("Expected to stop at the entry of main.h, stopped at %#v", pc)
+		}
+
+		// Only stepping into calls made by the current line.
+		g := p.LookupFunc("main.g")
+		if err := p.StepInto(g.Entry); err == nil {
+			t.Fatal("Expected an error stepping into a function not called by the line")
+		}
+	})
+}
+
+func TestStepSkipFunctions(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testreturns", t, func(p *proctl.DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.main")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		p.SkipFunctions = []string{"fmt.*", "runtime.*", "main.square"}
+		for i := 0; i < 200; i++ {
+			assertNoError(p.Step(), t, "Step()")
+
+			_, _, fn := p.PCToLine(currentPC(p, t))
+			if fn.Name != "main.main" {
+				t.Fatalf("Step() stopped in skipped function %s", fn.Name)
+			}
+		}
+	})
+}
+
+func TestContinueTo(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		f := filepath.Join(pwd, "testnextprog.go")
+
+		fn := p.LookupFunc("main.testnext")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		pc, _, err := p.LineToPC(f, 34)
+		assertNoError(err, t, "LineToPC()")
+
+		// Stops at the user breakpoint first, which must not
+		// leave the temporary breakpoint behind.
+		assertNoError(p.ContinueTo(pc), t, "ContinueTo()")
+
+		bp, err := p.CurrentBreakpoint()
+		assertNoError(err, t, "CurrentBreakpoint()")
+
+		if bp == nil || bp.Addr != fn.Entry {
+			t.Fatal("Expected to stop at the breakpoint on main.testnext")
+		}
+
+		if _, ok := p.BreakPoints[pc]; ok {
+			t.Fatal("Temporary breakpoint was not cleared")
+		}
+
+		assertNoError(p.ContinueTo(pc), t, "ContinueTo()")
+
+		cur, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+
+		if cur != pc {
+			t.Fatalf("Expected to stop at %#v, stopped at %#v", pc, cur)
+		}
+
+		if len(p.BreakPoints) != 1 {
+			t.Fatal("Temporary breakpoint was not cleared")
+		}
+	})
+}
+
+func TestNextIntoDeferred(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testdefer", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		f := filepath.Join(pwd, "testdefer.go")
+
+		testcases := []struct {
+			line     int
+			deferred string
+		}{
+			// Returning runs the deferred function.
+			{15, "main.deferred"},
+			// So does panicking, without ever returning to the line.
+			{20, "main.recoverer"},
+		}
+
+		for _, tc := range testcases {
+			pc, _, err := p.LineToPC(f, tc.line)
+			assertNoError(err, t, "LineToPC()")
+
+			_, err = p.Break(uintptr(pc))
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+
+			_, err = p.Clear(pc)
+			assertNoError(err, t, "Clear()")
+
+			var fn *gosym.Func
+			for i := 0; i < 3; i++ {
+				assertNoError(p.Next(), t, "Next()")
+
+				_, _, fn = p.PCToLine(currentPC(p, t))
+				if fn.Name == tc.deferred {
+					break
+				}
+			}
+
+			if fn.Name != tc.deferred {
+				t.Fatalf("Expected Next() to stop in %s, stopped in %s", tc.deferred, fn.Name)
+			}
+
+			if len(p.BreakPoints) != 0 {
+				t.Fatal("Temporary breakpoints were not cleared")
+			}
+		}
+	})
+}
+
+func TestReturnAddress(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnextprog", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		f := filepath.Join(pwd, "testnextprog.go")
+
+		// Both at the entry, before the frame is set up,
+		// and in the body of the function.
+		fn := p.LookupFunc("main.testnext")
+		body, _, err := p.LineToPC(f, 24)
+		assertNoError(err, t, "LineToPC()")
+
+		for _, pc := range []uint64{fn.Entry, body} {
+			_, err := p.Break(uintptr(pc))
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+
+			ret, err := p.ReturnAddress()
+			assertNoError(err, t, "ReturnAddress()")
+
+			frames, err := p.Stacktrace(2)
+			assertNoError(err, t, "Stacktrace()")
+
+			if ret != frames[1].PC {
+				t.Fatalf("Expected return address %#v, got %#v", frames[1].PC, ret)
+			}
+
+			_, err = p.Clear(pc)
+			assertNoError(err, t, "Clear()")
+		}
+	})
+}
+
+func TestNextRecursive(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testrecursion", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "testrecursion.go"), 9)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		// The whole recursion is stepped over, returning
+		// from the outermost invocation of fact.
+		assertNoError(p.Next(), t, "Next()")
+
+		f, l, fn := p.PCToLine(currentPC(p, t))
+		if fn.Name != "main.main" {
+			t.Fatalf("Expected to return to main.main, stopped at %s:%d in %s", f, l, fn.Name)
+		}
+	})
+}
+
+func TestAdvance(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/teststepcall", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.main")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		_, err = p.Clear(fn.Entry)
+		assertNoError(err, t, "Clear()")
+
+		// main.h is called before main.main returns.
+		h := p.LookupFunc("main.h")
+		assertNoError(p.Advance(h.Entry), t, "Advance()")
+
+		if pc := currentPC(p, t); pc != h.Entry {
+			t.Fatalf("Expected to stop at the entry of main.h, stopped at %#v", pc)
+		}
+
+		// From main.h, main.g is next called only after main.h returns.
+		g := p.LookupFunc("main.g")
+		assertNoError(p.Advance(g.Entry), t, "Advance()")
+
+		if _, _, fn := p.PCToLine(currentPC(p, t)); fn.Name != "main.main" {
+			t.Fatalf("Expected to return to main.main, stopped in %s", fn.Name)
+		}
+
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("Temporary breakpoints were not cleared")
+		}
+	})
+}
+
+func TestEvalSymbolInFrame(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testrecursion", t, func(p *proctl.DebuggedProcess) {
+		pwd, _ := filepath.Abs("../_fixtures")
+		pc, _, err := p.LineToPC(filepath.Join(pwd, "testrecursion.go"), 6)
+		assertNoError(err, t, "LineToPC()")
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+
+		// Stop in fact(4), called by fact(5).
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		frames, err := p.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+
+		for i, expected := range []string{"4", "5"} {
+			v, err := p.EvalSymbolInFrame("n", frames[i])
+			assertNoError(err, t, "EvalSymbolInFrame()")
+
+			if v.Value != expected {
+				t.Fatalf("Expected n = %s in frame %d, got %s", expected, i, v.Value)
+			}
+		}
+
+		v, err := p.EvalExpressionInFrame("n * 2", frames[1])
+		assertNoError(err, t, "EvalExpressionInFrame()")
+
+		if v.Value != "10" {
+			t.Fatalf("Expected n * 2 = 10 in frame 1, got %s", v.Value)
+		}
+	})
+}
+
+func TestStacktraceSystemStack(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testgoroutines", t, func(p *proctl.DebuggedProcess) {
+		// newproc1 runs on the system stack, called through systemstack.
+		fn := p.LookupFunc("runtime.newproc1")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		for i := 0; i < 20; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			frames, err := p.Stacktrace(50)
+			assertNoError(err, t, "Stacktrace()")
+
+			for _, frame := range frames {
+				if frame.Fn.Name == "main.main" {
+					return
+				}
+			}
+		}
+
+		t.Fatal("Stack traces never reached main.main from the system stack")
+	})
+}
+
+func TestGoroutineState(t *testing.T) {
+	testcases := []struct {
+		g     proctl.Goroutine
+		state string
+	}{
+		{proctl.Goroutine{Status: proctl.Grunnable}, "runnable"},
+		{proctl.Goroutine{Status: proctl.Gwaiting, WaitReason: "chan receive"}, "chan receive"},
+		{proctl.Goroutine{Status: proctl.Gwaiting}, "waiting"},
+		{proctl.Goroutine{Status: 42}, "status 42"},
+	}
+
+	for _, tc := range testcases {
+		if state := tc.g.State(); state != tc.state {
+			t.Fatalf("Expected state %q for status %d, got %q", tc.state, tc.g.Status, state)
+		}
+	}
+}
+
+func TestGoroutineCreationSite(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testgoroutines", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.stop")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		goroutines, err := p.Goroutines()
+		assertNoError(err, t, "Goroutines()")
+
+		workers := 0
+		for _, g := range goroutines {
+			_, l, fn := p.PCToLine(g.GoPC - 1)
+			if fn == nil || fn.Name != "main.main" {
+				continue
+			}
+
+			if l != 26 {
+				t.Fatalf("Expected goroutine %d to be created at line 26, got %d", g.ID, l)
+			}
+
+			ancestors, _, err := p.GoroutineAncestors(g.ID)
+			assertNoError(err, t, "GoroutineAncestors()")
+
+			if g.ParentID != 0 && (len(ancestors) == 0 || ancestors[0].ID != g.ParentID) {
+				t.Fatalf("Expected the first ancestor of goroutine %d to be %d", g.ID, g.ParentID)
+			}
+
+			workers++
+		}
+
+		if workers != 10 {
+			t.Fatalf("Expected 10 goroutines created by main.main, found %d", workers)
+		}
+	})
+}
+
+func TestCurrentGoroutine(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testgoroutines", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.stop")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		g, err := p.CurrentGoroutine()
+		assertNoError(err, t, "CurrentGoroutine()")
+
+		if g.Status != proctl.Grunning {
+			t.Fatalf("Expected the current goroutine to be running, got %s", g.State())
+		}
+
+		pc, err := p.GoroutinePC(g)
+		assertNoError(err, t, "GoroutinePC()")
+
+		if pc != currentPC(p, t) {
+			t.Fatalf("Expected the current goroutine to be at %#v, got %#v", currentPC(p, t), pc)
+		}
+	})
+}
+
+func TestContinueOtherThreads(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreads", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		// The main thread only sleeps, every hit of the
+		// breakpoint is on one of the locked threads.
+		for i := 0; i < 5; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			if pc := currentPC(p, t); pc != fn.Entry+1 {
+				t.Fatalf("Expected to stop at %#v, got %#v", fn.Entry+1, pc)
+			}
+
+			if _, ok := p.Threads[p.CurrentThread.Id]; !ok {
+				t.Fatalf("Stopped on untraced thread %d", p.CurrentThread.Id)
+			}
+		}
+	})
+}
+
+func TestStopTheWorld(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreads", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// Every thread can only be inspected with ptrace once stopped.
+		for tid := range p.Threads {
+			var regs syscall.PtraceRegs
+			err := syscall.PtraceGetRegs(tid, &regs)
+			if err != nil {
+				t.Fatalf("Thread %d is not stopped: %s", tid, err)
+			}
+		}
+	})
+}
+
+func TestUserSIGSTOP(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			syscall.Kill(p.Pid, syscall.SIGSTOP)
+		}()
+
+		// The program loops forever, only the signal stops it.
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if sig := p.ProcessState.StopSignal(); sig != syscall.SIGSTOP {
+			t.Fatalf("Stopped with %s, expected SIGSTOP", sig)
+		}
+	})
+}
+
+func TestGoroutineLabels(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testlabels", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.stop")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		g, err := p.CurrentGoroutine()
+		assertNoError(err, t, "CurrentGoroutine()")
+
+		if g.Labels["request_id"] != "42" || g.Labels["user"] != "gopher" || len(g.Labels) != 2 {
+			t.Fatalf("Unexpected labels %v", g.Labels)
+		}
+	})
+}
+
+func TestDeadlocked(t *testing.T) {
+	blocked := []*proctl.BlockedGoroutine{
+		{Goroutine: &proctl.Goroutine{ID: 1}, Holders: []int{2}},
+		{Goroutine: &proctl.Goroutine{ID: 2}, Holders: []int{1}},
+		{Goroutine: &proctl.Goroutine{ID: 3}, Holders: []int{4}},
+		{Goroutine: &proctl.Goroutine{ID: 5}, Holders: []int{3}},
+		{Goroutine: &proctl.Goroutine{ID: 6}},
+		{Goroutine: &proctl.Goroutine{ID: 7}, Holders: []int{8}},
+		{Goroutine: &proctl.Goroutine{ID: 8}, Holders: []int{7}, Globals: []string{"main.jobs"}},
+		{Goroutine: &proctl.Goroutine{ID: 9}, Holders: []int{6}},
+	}
+
+	// 4 is running, so 3 and in turn 5 may be woken. Nothing is known
+	// of what refers to what 6 waits on, so it and in turn 9 may be
+	// woken as well, and 8, which a package-level variable refers to,
+	// may wake 7.
+	var ids []int
+	for _, b := range proctl.Deadlocked(blocked) {
+		ids = append(ids, b.ID)
+	}
+
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Fatalf("Expected goroutines [1 2] to be deadlocked, got %v", ids)
+	}
+}
+
+func TestBlockedGoroutines(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testdeadlock", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.stop")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		blocked, err := p.BlockedGoroutines()
+		assertNoError(err, t, "BlockedGoroutines()")
+
+		var n int
+		for _, b := range proctl.Deadlocked(blocked) {
+			frames, err := p.GoroutineStacktrace(b.ID, 20)
+			assertNoError(err, t, "GoroutineStacktrace()")
+
+			for _, frame := range frames {
+				if frame.Fn == nil {
+					continue
+				}
+
+				switch frame.Fn.Name {
+				case "main.lockBoth":
+					n++
+				case "main.worker":
+					t.Fatalf("Worker %d waiting on a package-level channel reported as deadlocked", b.ID)
+				}
+			}
+		}
+
+		if n != 2 {
+			t.Fatalf("Expected both lockBoth goroutines to be deadlocked, found %d", n)
+		}
+
+		var workers int
+		for _, b := range blocked {
+			for _, v := range b.Globals {
+				if v == "main.jobs" {
+					workers++
+				}
+			}
+		}
+
+		if workers != 2 {
+			t.Fatalf("Expected both workers to be found waiting on main.jobs, found %d", workers)
+		}
+	})
+}
+
+func TestSwitchThread(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreads", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if err := p.SwitchThread(-1); err == nil {
+			t.Fatal("Expected an error switching to a nonexistent thread")
+		}
+
+		goroutines, err := p.ThreadGoroutines()
+		assertNoError(err, t, "ThreadGoroutines()")
+
+		for tid := range p.Threads {
+			assertNoError(p.SwitchThread(tid), t, "SwitchThread()")
+
+			regs, err := p.Threads[tid].Registers()
+			assertNoError(err, t, "Registers()")
+
+			if pc := currentPC(p, t); pc != regs.PC() {
+				t.Fatalf("Expected registers of thread %d, got PC %#v instead of %#v", tid, pc, regs.PC())
+			}
+
+			g, err := p.CurrentGoroutine()
+			if err != nil {
+				continue
+			}
+
+			if goroutines[tid] == nil || goroutines[tid].ID != g.ID {
+				t.Fatalf("Goroutine %d on thread %d is not reported by ThreadGoroutines", g.ID, tid)
+			}
+		}
+	})
+}
+
+func TestRunningGoroutineStacktrace(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreads", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		goroutines, err := p.ThreadGoroutines()
+		assertNoError(err, t, "ThreadGoroutines()")
+
+		for tid, g := range goroutines {
+			th := p.GoroutineThread(g)
+			if th == nil || th.Id != tid {
+				t.Fatalf("Expected goroutine %d to be found running on thread %d", g.ID, tid)
+			}
+
+			// The stack of a running goroutine is unwound from the
+			// registers of its thread, not its saved scheduling state.
+			frames, err := p.StacktraceOf(g, 1)
+			assertNoError(err, t, "StacktraceOf()")
+
+			regs, err := th.Registers()
+			assertNoError(err, t, "Registers()")
+
+			pc := regs.PC()
+			if _, ok := p.BreakPoints[pc-1]; ok {
+				pc--
+			}
+
+			if len(frames) == 0 || frames[0].PC != pc {
+				t.Fatalf("Expected the stack of goroutine %d to start at %#v", g.ID, pc)
+			}
+		}
+	})
+}
+
+func TestNewThreadsTraced(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testnewthreads", t, func(p *proctl.DebuggedProcess) {
+		threads := len(p.Threads)
+
+		fn := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		for i := 0; i < 8; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			if pc := currentPC(p, t); pc != fn.Entry+1 {
+				t.Fatalf("Expected to stop at %#v, got %#v", fn.Entry+1, pc)
+			}
+		}
+
+		if len(p.Threads) <= threads {
+			t.Fatalf("Expected threads started after attaching to be traced, still %d threads", len(p.Threads))
+		}
+	})
+}
+
+func TestThreadExit(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreadexit", t, func(p *proctl.DebuggedProcess) {
+		work := p.LookupFunc("main.work")
+		_, err := p.Break(uintptr(work.Entry))
+		assertNoError(err, t, "Break()")
+
+		for i := 0; i < 4; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+		}
+
+		_, err = p.Clear(work.Entry)
+		assertNoError(err, t, "Clear()")
+
+		stop := p.LookupFunc("main.stop")
+		_, err = p.Break(uintptr(stop.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if pc := currentPC(p, t); pc != stop.Entry+1 {
+			t.Fatalf("Expected to stop at %#v, got %#v", stop.Entry+1, pc)
+		}
+
+		// Threads which exited are forgotten.
+		for tid, th := range p.Threads {
+			_, err := th.Registers()
+			if err != nil {
+				t.Fatalf("Thread %d is gone: %s", tid, err)
+			}
+		}
+	})
+}
+
+func TestThreadBreakpoint(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testthreads", t, func(p *proctl.DebuggedProcess) {
+		fn := p.LookupFunc("main.work")
+		bp, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// Each locked thread calls work, only the one
+		// the breakpoint is restricted to stops.
+		tid := p.CurrentThread.Id
+		bp.ThreadID = tid
+		bp.HitCount = 0
+
+		for i := 0; i < 3; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+
+			if p.CurrentThread.Id != tid {
+				t.Fatalf("Expected to stop on thread %d, stopped on %d", tid, p.CurrentThread.Id)
+			}
+		}
+
+		if bp.HitCount != 3 {
+			t.Fatalf("Expected 3 hits, got %d", bp.HitCount)
+		}
+	})
+}
+
+func TestShadowedVariables(t *testing.T) {
+	executablePath := "../_fixtures/testshadow"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		line             int
+		expr, typ, value string
+		noSuchSymbol     bool
+	}{
+		{12, "a", "int", "10", false},
+		{12, "a@1", "string", "inner", false},
+		{12, "a@2", "int", "1", false},
+		{14, "a", "string", "inner", false},
+		{14, "a@1", "int", "1", false},
+		{14, "a@2", "", "", true},
+		{16, "a", "int", "1", false},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		for _, line := range []int{12, 14, 16} {
+			pc, _, _ := p.GoSymTable.LineToPC(fp, line)
+
+			_, err := p.Break(uintptr(pc))
+			assertNoError(err, t, "Break() returned an error")
+		}
+
+		// The first pass through the loop skips the innermost block.
+		for _, line := range []int{14, 12, 14, 16} {
+			err := p.Continue()
+			assertNoError(err, t, "Continue() returned an error")
+
+			for _, tc := range testcases {
+				if tc.line != line {
+					continue
+				}
+
+				typ, err := p.ExpressionTypeInFrame(tc.expr, nil)
+				if tc.noSuchSymbol {
+					if err == nil {
+						t.Fatalf("%d: expected an error evaluating %s", line, tc.expr)
+					}
+					continue
+				}
+				assertNoError(err, t, tc.expr)
+
+				if typ != tc.typ {
+					t.Fatalf("%d: %s: expected type %s got %s", line, tc.expr, tc.typ, typ)
+				}
+
+				v, err := p.EvalExpression(tc.expr)
+				assertNoError(err, t, tc.expr)
+
+				if v.Value != tc.value {
+					t.Fatalf("%d: %s: expected %q got %q", line, tc.expr, tc.value, v.Value)
+				}
+			}
+		}
+	})
+}
+
+func TestConstantNames(t *testing.T) {
+	executablePath := "../_fixtures/testconstants"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value, formatted string
+		base                   int
+	}{
+		{"main.state", "1", "Running (1)", 0},
+		{"main.perms", "5", "Read|Exec (5)", 0},
+		{"main.mode", "18", "Write|16 (18)", 0},
+		{"main.mode", "0x12", "Write|0x10 (0x12)", 16},
+		{"main.odd", "7", "7", 0},
+		// Values of named integer types are numbers in expressions.
+		{"main.state == 1", "true", "true", 0},
+		{"main.state + 1 == main.State(2)", "true", "true", 0},
+		{"main.perms & 4", "4", "4", 0},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 29)
+
+		_, err := p.BreakIf(uintptr(pc), "main.state == 1 && main.odd > 5")
+		assertNoError(err, t, "BreakIf() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		if _, l := currentLineNumber(p, t); l != 29 {
+			t.Fatalf("Expected the condition to hold at line 29, stopped at line %d", l)
+		}
+
+		for _, tc := range testcases {
+			p.IntegerBase = tc.base
+
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Formatted() != tc.formatted {
+				t.Fatalf("%s: expected %q printed as %q got %q printed as %q", tc.expr, tc.value, tc.formatted, v.Value, v.Formatted())
+			}
+		}
+	})
+}
+
+func TestFunctionValues(t *testing.T) {
+	executablePath := "../_fixtures/testfuncvals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value string
+	}{
+		{"main.fn", fmt.Sprintf("main.double at %s:5", fp)},
+		{"main.adder", fmt.Sprintf("main.makeAdder.func1 at %s:10 {base: 40, name: ab}", fp)},
+		{"main.none", "nil"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 22)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value {
+				t.Fatalf("%s: expected %q got %q", tc.expr, tc.value, v.Value)
+			}
+		}
+	})
+}
+
+func TestNumberFormats(t *testing.T) {
+	executablePath := "../_fixtures/testnumbers"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 28)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		double := p.GoSymTable.LookupFunc("main.double")
+		if double == nil {
+			t.Fatal("could not find main.double")
+		}
+
+		f32, err := p.EvalExpression("&main.f32")
+		assertNoError(err, t, "&main.f32")
+
+		testcases := []struct {
+			expr, value, typ string
+		}{
+			{"main.c64", "(1.5-2i)", "complex64"},
+			{"main.c128", "(3+4.25i)", "complex128"},
+			{"main.f32", "1.25", "float32"},
+			{"main.raw", f32.Value + " <main.f32>", "unsafe.Pointer"},
+			{"main.fn", fmt.Sprintf("%#x <main.double>", double.Entry), "unsafe.Pointer"},
+			{"uintptr(main.fn) + 1", fmt.Sprintf("%d", double.Entry+1), "uintptr"},
+		}
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value || v.Type != tc.typ {
+				t.Fatalf("%s: expected %s %s got %s %s", tc.expr, tc.value, tc.typ, v.Value, v.Type)
+			}
+		}
+
+		v, err := p.EvalExpression("main.code")
+		assertNoError(err, t, "main.code")
+
+		if !strings.HasPrefix(v.Value, "0x") || !strings.Contains(v.Value, " <main.main+0x") {
+			t.Fatalf("expected an address within main.main got %s", v.Value)
+		}
+
+		v, err = p.EvalExpression("main.stack")
+		assertNoError(err, t, "main.stack")
+
+		if !strings.HasSuffix(v.Value, " <stack of goroutine 1>") {
+			t.Fatalf("expected an address on the stack of goroutine 1 got %s", v.Value)
+		}
+	})
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	executablePath := "../_fixtures/testembedded"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		expr, value string
+	}{
+		{"main.srv.Addr", ":8080"},
+		{"main.srv.ID", "7"},
+		{"main.srv.Name", "outer"},
+		{"main.srv.Base.Name", "base"},
+		{"main.srv.Server.Name", "base"},
+		{"main.srv.Prefix", "srv: "},
+		{"main.srv.Level + 1", "3"},
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 49)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			v, err := p.EvalExpression(tc.expr)
+			assertNoError(err, t, tc.expr)
+
+			if v.Value != tc.value {
+				t.Fatalf("%s: expected %q got %q", tc.expr, tc.value, v.Value)
+			}
+		}
+
+		for _, expr := range []string{"main.amb.X", "main.bare.Level", "main.srv.Missing"} {
+			if _, err := p.EvalExpression(expr); err == nil {
+				t.Fatalf("expected an error evaluating %s", expr)
+			}
+		}
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 24)
+
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		fn := p.GoSymTable.LookupFunc("main.main")
+		if fn == nil {
+			t.Fatal("could not find main.main")
+		}
+
+		insts, err := p.Disassemble(fn.Entry, fn.End)
+		assertNoError(err, t, "Disassemble()")
+
+		if len(insts) == 0 || insts[0].PC != fn.Entry || insts[0].File != fp {
+			t.Fatalf("expected the first instruction at %#x in %s", fn.Entry, fp)
+		}
+
+		var calls, bps int
+		for i, inst := range insts {
+			if i > 0 && inst.PC != insts[i-1].PC+uint64(len(insts[i-1].Bytes)) {
+				t.Fatalf("instruction at %#x does not follow the previous one", inst.PC)
+			}
+
+			if inst.Breakpoint {
+				bps++
+				if inst.PC != pc || inst.Line != 24 || inst.Text == "INT $0x3" {
+					t.Fatalf("unexpected breakpoint instruction %#v", inst)
+				}
+			}
+
+			if strings.HasPrefix(inst.Text, "CALL main.bump(SB)") {
+				calls++
+			}
+		}
+
+		if bps != 1 || calls != 1 {
+			t.Fatalf("expected a breakpoint and a call to main.bump, got %d and %d", bps, calls)
+		}
+	})
+}
+
+func TestFPRegisters(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		fp, err := p.FPRegisters()
+		assertNoError(err, t, "FPRegisters()")
+
+		// The state a process is started with: all exceptions
+		// masked and rounding to nearest.
+		if fp.Mxcsr&^0x3f != 0x1f80 {
+			t.Fatalf("Unexpected mxcsr %#x", fp.Mxcsr)
+		}
+
+		if fp.Fcw != 0x37f {
+			t.Fatalf("Unexpected fcw %#x", fp.Fcw)
+		}
+	})
+}
+
+func TestWriteMemory(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		counter, err := p.EvalAddressInFrame("&main.counter", nil)
+		assertNoError(err, t, "EvalAddressInFrame(&main.counter)")
+
+		err = p.WriteMemory(counter, []byte{41, 0, 0, 0, 0, 0, 0, 0})
+		assertNoError(err, t, "WriteMemory()")
+
+		v, err := p.EvalSymbol("main.counter")
+		assertNoError(err, t, "EvalSymbol(main.counter)")
+
+		if v.Value != "41" {
+			t.Fatalf("expected 41 got %s", v.Value)
+		}
+
+		// Writing over a breakpoint leaves it set, with the
+		// bytes written as the instruction it replaced.
+		next, _, _ := p.GoSymTable.LineToPC(fp, 25)
+		bp, err := p.Break(uintptr(next))
+		assertNoError(err, t, "Break() returned an error")
+
+		code, err := p.ReadMemory(next-1, 2)
+		assertNoError(err, t, "ReadMemory()")
+
+		if code[1] != bp.OriginalData[0] {
+			t.Fatalf("expected %#x at the breakpoint got %#x", bp.OriginalData[0], code[1])
+		}
+
+		err = p.WriteMemory(next-1, code)
+		assertNoError(err, t, "WriteMemory()")
+
+		if bp.OriginalData[0] != code[1] {
+			t.Fatalf("expected the breakpoint to replace %#x got %#x", code[1], bp.OriginalData[0])
+		}
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		if _, l := currentLineNumber(p, t); l != 25 {
+			t.Fatalf("expected to stop at line 25 got %d", l)
+		}
+	})
+}
+
+func TestReadMemoryBulk(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		text := p.Executable.Section(".text")
+		want, err := text.Data()
+		assertNoError(err, t, "Data()")
+
+		if len(want) > 256<<10 {
+			want = want[:256<<10]
+		}
+
+		data, err := p.ReadMemory(text.Addr, len(want))
+		assertNoError(err, t, "ReadMemory()")
+
+		if !bytes.Equal(data, want) {
+			t.Fatal("memory read differs from the executable")
+		}
+	})
+}
+
+func TestMemoryMap(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		regions, err := p.MemoryMap()
+		assertNoError(err, t, "MemoryMap()")
+
+		text := p.Executable.Section(".text").Addr
+		for _, r := range regions {
+			if text >= r.Start && text < r.End {
+				if !strings.HasPrefix(r.Perms, "r-x") || !filepath.IsAbs(r.Path) {
+					t.Fatalf("unexpected region of the code %#x-%#x %s %s", r.Start, r.End, r.Perms, r.Path)
+				}
+				return
+			}
+		}
+
+		t.Fatalf("no region holds the code at %#x", text)
+	})
+}
+
+func TestSearchMemory(t *testing.T) {
+	executablePath := "../_fixtures/testglobals"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper.WithTestProcess(executablePath, t, func(p *proctl.DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 19)
+		_, err := p.Break(uintptr(pc))
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		config, err := p.EvalAddressInFrame("&main.config", nil)
+		assertNoError(err, t, "EvalAddressInFrame(&main.config)")
+
+		retries, err := p.EvalAddressInFrame("&main.config.Retries", nil)
+		assertNoError(err, t, "EvalAddressInFrame(&main.config.Retries)")
+
+		found, err := p.SearchMemory(config, config+64, []byte{4, 0, 0, 0, 0, 0, 0, 0}, 10)
+		assertNoError(err, t, "SearchMemory()")
+
+		if len(found) == 0 || found[0] != retries {
+			t.Fatalf("expected to find 4 at %#x got %#x", retries, found)
+		}
+
+		// Across the whole address space, skipping what is not
+		// mapped or readable.
+		found, err = p.SearchMemory(0, 1<<47, []byte("delve"), 1)
+		assertNoError(err, t, "SearchMemory()")
+
+		if len(found) != 1 {
+			t.Fatalf("expected to find delve got %#x", found)
+		}
+
+		data, err := p.ReadMemory(found[0], 5)
+		assertNoError(err, t, "ReadMemory()")
+
+		if string(data) != "delve" {
+			t.Fatalf("expected delve at %#x got %q", found[0], data)
+		}
+	})
+}
+
+func TestFindProcesses(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid))
+		assertNoError(err, t, "Readlink()")
+
+		pids, err := proctl.FindProcesses(filepath.Base(exe))
+		assertNoError(err, t, "FindProcesses()")
+
+		if len(pids) != 1 || pids[0] != p.Pid {
+			t.Fatalf("expected to find %d got %v", p.Pid, pids)
+		}
+	})
+}
+
+func TestWaitForProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlv-waitfor")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	sleep, err := exec.LookPath("sleep")
+	assertNoError(err, t, "LookPath()")
+
+	data, err := ioutil.ReadFile(sleep)
+	assertNoError(err, t, "ReadFile()")
+
+	exe := filepath.Join(dir, "dlv-waitfor-sleep")
+	assertNoError(ioutil.WriteFile(exe, data, 0755), t, "WriteFile()")
+
+	cmd := exec.Command(exe, "1")
+	started := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		started <- cmd.Start()
+	}()
+
+	pid, err := proctl.WaitForProcess(filepath.Base(exe))
+	assertNoError(err, t, "WaitForProcess()")
+
+	assertNoError(<-started, t, "Start()")
+	defer cmd.Wait()
+
+	if pid != cmd.Process.Pid {
+		t.Fatalf("expected to find %d got %d", cmd.Process.Pid, pid)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(old *proctl.DebuggedProcess) {
+		fn := old.GoSymTable.LookupFunc("main.helloworld")
+		_, err := old.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		bp, err := old.BreakIf(uintptr(old.GoSymTable.LookupFunc("main.sleepytime").Entry), "hitcount > 2")
+		assertNoError(err, t, "BreakIf()")
+
+		old.SkipFunctions = []string{"fmt.*"}
+
+		helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+			assertNoError(p.Inherit(old), t, "Inherit()")
+
+			nbp := p.FindBreakpointByID(bp.ID)
+			if nbp == nil || nbp.Addr != bp.Addr || nbp.Condition != bp.Condition {
+				t.Fatalf("expected breakpoint %d at %#x if %s got %#v", bp.ID, bp.Addr, bp.Condition, nbp)
+			}
+
+			if len(p.SkipFunctions) != 1 {
+				t.Fatalf("expected skipped functions to be kept got %v", p.SkipFunctions)
+			}
+
+			assertNoError(p.Continue(), t, "Continue()")
+
+			if pc := currentPC(p, t); pc != fn.Entry && pc != fn.Entry+1 {
+				t.Fatalf("expected to stop at main.helloworld got %#x", pc)
+			}
+
+			nb, err := p.Break(uintptr(p.GoSymTable.LookupFunc("main.main").Entry))
+			if err == nil && nb.ID <= bp.ID {
+				t.Fatalf("expected new breakpoints to be numbered after %d got %d", bp.ID, nb.ID)
+			}
+		})
+	})
+}
+
+func TestFollowFork(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testfork", t, func(p *proctl.DebuggedProcess) {
+		p.FollowForks = true
+
+		fn := p.GoSymTable.LookupFunc("main.started")
+		_, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		// Execution also stops when the process
+		// running true exits, with SIGCHLD.
+		for i := 0; i < 3 && currentPC(p, t) != fn.Entry+1; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+		}
+
+		if len(p.Forked) != 1 {
+			t.Fatalf("Followed %d processes, expected 1", len(p.Forked))
+		}
+
+		if len(p.Skipped) != 1 || p.Skipped[0].Err == nil {
+			t.Fatalf("Expected the process running true to be skipped got %v", p.Skipped)
+		}
+
+		child := p.Forked[0]
+		if child.Pid == p.Pid {
+			t.Fatal("Forked process has the pid of its parent")
+		}
+
+		// The child executed the program again, without
+		// the breakpoints of its parent.
+		if len(child.BreakPoints) != 0 {
+			t.Fatalf("Forked process has %d breakpoints, expected none", len(child.BreakPoints))
+		}
+
+		fn = child.GoSymTable.LookupFunc("main.child")
+		_, err = child.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break() in child")
+
+		assertNoError(child.Continue(), t, "Continue() child")
+
+		pc, err := child.CurrentPC()
+		assertNoError(err, t, "CurrentPC() of child")
+
+		if pc-1 != fn.Entry {
+			t.Fatalf("Child stopped at %#x, expected main.child at %#x", pc-1, fn.Entry)
+		}
+
+		assertNoError(child.Continue(), t, "Continue() child to exit")
+		if !child.ProcessState.Exited() {
+			t.Fatal("Child did not exit")
+		}
+	})
+}
+
+func TestPluginSymbols(t *testing.T) {
+	host, err := helper.CompileTestProg("../_fixtures/testplugin")
+	assertNoError(err, t, "CompileTestProg()")
+
+	// The program loads the plugin from its own directory.
+	src, _ := filepath.Abs("../_fixtures/testpluginlib/lib.go")
+	lib := filepath.Join(filepath.Dir(host), "testpluginlib.so")
+	out, err := exec.Command("go", "build", "-buildmode=plugin", "-gcflags=-N -l", "-o", lib, src).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Could not build plugin: %s\n%s", err, out)
+	}
+
+	helper.WithTestProcess("../_fixtures/testplugin", t, func(p *proctl.DebuggedProcess) {
+		loaded := p.LookupFunc("main.loaded")
+		_, err := p.Break(uintptr(loaded.Entry))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if len(p.Plugins) != 1 || p.Plugins[0].Path != lib {
+			t.Fatalf("Expected plugin %s to be loaded, got %v", lib, p.Plugins)
+		}
+
+		pc, fn, err := p.LineToPC(src, 6)
+		assertNoError(err, t, "LineToPC()")
+
+		if !p.Plugins[0].Cover(pc) || fn == nil || !strings.HasSuffix(fn.Name, ".Greet") {
+			t.Fatalf("Expected %s:6 to be in Greet in the plugin, got %#v", src, pc)
+		}
+
+		_, err = p.Break(uintptr(pc))
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if cur := currentPC(p, t); cur != pc+1 {
+			t.Fatalf("Expected to stop at %#v, stopped at %#v", pc, cur-1)
+		}
+
+		if f, l, _ := p.PCToLine(pc); f != src || l != 6 {
+			t.Fatalf("Expected %#v to be at %s:6, got %s:%d", pc, src, f, l)
+		}
+	})
+}
+
+// Counts the calls made to the backend it wraps.
+type countingBackend struct {
+	proctl.PtraceBackend
+	continues, steps int
+}
+
+func (b *countingBackend) Continue(dbp *proctl.DebuggedProcess) error {
+	b.continues++
+	return b.PtraceBackend.Continue(dbp)
+}
+
+func (b *countingBackend) Step(dbp *proctl.DebuggedProcess) error {
+	b.steps++
+	return b.PtraceBackend.Step(dbp)
+}
+
+func TestBackend(t *testing.T) {
+	helper.WithTestProcess("../_fixtures/testprog", t, func(p *proctl.DebuggedProcess) {
+		backend := &countingBackend{}
+		p.Backend = backend
+
+		fn := p.GoSymTable.LookupFunc("main.sleepytime")
+		bp, err := p.Break(uintptr(fn.Entry))
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if pc := currentPC(p, t); pc != bp.Addr+1 {
+			t.Fatalf("Break not respected through the backend, PC %#v", pc)
+		}
+
+		assertNoError(p.Step(), t, "Step()")
+
+		if backend.continues != 1 || backend.steps != 1 {
+			t.Fatalf("Expected 1 continue and 1 step through the backend got %d and %d", backend.continues, backend.steps)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Returns the type with the given name, such as "runtime.g", from the
+// debug information of the main executable. Types are cached since
+// finding them requires a scan of the whole .debug_info section.
+func (dbp *DebuggedProcess) findType(name string) (dwarf.Type, error) {
+	if t, ok := dbp.types[name]; ok {
+		return t, nil
+	}
+
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		switch entry.Tag {
+		case dwarf.TagStructType, dwarf.TagTypedef, dwarf.TagBaseType, dwarf.TagPointerType, dwarf.TagArrayType:
+		default:
+			continue
+		}
+
+		if n, ok := entry.Val(dwarf.AttrName).(string); !ok || n != name {
+			continue
+		}
+
+		t, err := data.Type(entry.Offset)
+		if err != nil {
+			return nil, err
+		}
+
+		dbp.types[name] = t
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("could not find type %s", name)
+}
+
+// Returns the struct type with the given name.
+func (dbp *DebuggedProcess) findStructType(name string) (*dwarf.StructType, error) {
+	t, err := dbp.findType(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if tt, ok := t.(*dwarf.TypedefType); ok {
+		t = tt.Type
+	}
+
+	st, ok := t.(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct", name)
+	}
+
+	return st, nil
+}
+
+// Returns the first field of st named by one of names. Accepting
+// several names lets us cope with fields renamed between Go releases.
+func structField(st *dwarf.StructType, names ...string) (*dwarf.StructField, error) {
+	for _, name := range names {
+		for _, field := range st.Field {
+			if field.Name == name {
+				return field, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no field %s", st.StructName, names[0])
+}
+
+// Reads the pointer sized field of the struct of type st located at addr.
+func (dbp *DebuggedProcess) readUintField(addr uint64, st *dwarf.StructType, names ...string) (uint64, error) {
+	field, err := structField(st, names...)
+	if err != nil {
+		return 0, err
+	}
+
+	return dbp.readUint64(uintptr(addr + uint64(field.ByteOffset)))
+}
+
+func (dbp *DebuggedProcess) readUint64(addr uintptr) (uint64, error) {
+	data, err := dbp.readMemory(addr, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// Returns the address of the g struct of the goroutine running on the
+// current thread.
+func (dbp *DebuggedProcess) currentG() (uint64, error) {
+	return dbp.threadG(dbp.CurrentThread)
+}
+
+// Returns the address of the g struct of the goroutine running on the
+// thread. On linux/amd64 the runtime keeps it in thread local storage,
+// in the word just below the FS base.
+func (dbp *DebuggedProcess) threadG(th *ThreadContext) (uint64, error) {
+	regs, err := th.Registers()
+	if err != nil {
+		return 0, err
+	}
+
+	g, err := dbp.readUint64(uintptr(regs.Fs_base - 8))
+	if err != nil {
+		return 0, err
+	}
+
+	if g == 0 {
+		return 0, fmt.Errorf("no goroutine running on thread %d", th.Id)
+	}
+
+	return g, nil
+}
+
+// Returns the version of Go the debugged program was
+// built with, as reported by runtime.Version.
+func (dbp *DebuggedProcess) GoVersion() (string, error) {
+	addr, err := dbp.symbolAddr("runtime.buildVersion")
+	if err != nil {
+		return "", err
+	}
+
+	return dbp.readGoString(uintptr(addr))
+}
@@ -0,0 +1,637 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Represents a single frame of a stack trace. PC is the address
+// execution will resume at in this frame, SP the value of the stack
+// pointer within it and CFA is the canonical frame address, the value
+// of the stack pointer in the caller before the call instruction was
+// executed.
+type StackFrame struct {
+	PC   uint64
+	SP   uint64
+	CFA  int64
+	File string
+	Line int
+	Fn   *gosym.Func
+}
+
+// Returns the stack trace of the current thread, starting at the
+// innermost frame. At most depth frames are returned. On the system
+// stack, such as in runtime code run through systemstack or C code
+// called through cgo, the trace continues into the stack of the
+// goroutine the thread is running for. Frames of C code are omitted.
+func (dbp *DebuggedProcess) Stacktrace(depth int) ([]*StackFrame, error) {
+	return dbp.threadStacktrace(dbp.CurrentThread, depth)
+}
+
+// Returns the stack trace of a thread, as Stacktrace does
+// for the current one.
+func (dbp *DebuggedProcess) threadStacktrace(th *ThreadContext, depth int) ([]*StackFrame, error) {
+	regs, err := th.Registers()
+	if err != nil {
+		return nil, err
+	}
+
+	pc := regs.PC()
+	if _, ok := dbp.BreakPoints[pc-1]; ok {
+		// We have just hit a breakpoint, the PC
+		// is one past the breakpoint instruction.
+		pc--
+	}
+
+	frames, err := dbp.stacktrace(pc, regs.Rsp, regs.Rbp, depth)
+	if len(frames) == depth || (err == nil && !switchesStack(frames[len(frames)-1])) {
+		return frames, err
+	}
+
+	// We are on the system stack, either in C code we cannot unwind
+	// or at the bottom of the stack. Carry on with the stack of the
+	// goroutine the thread is working for.
+	gpc, gsp, gbp, ok := dbp.suspendedGoroutineContext(th)
+	if !ok {
+		return frames, err
+	}
+
+	more, gerr := dbp.stacktrace(gpc, gsp, gbp, depth-len(frames))
+	if gerr != nil {
+		return frames, err
+	}
+
+	return append(frames, more...), nil
+}
+
+// Functions found at the bottom of the system stack of a thread,
+// below which the stack of a goroutine does not continue.
+var stackSwitchFunctions = []string{
+	"runtime.mstart",
+	"runtime.systemstack",
+	"runtime.asmcgocall",
+	"runtime.morestack",
+	"runtime.mcall",
+}
+
+func switchesStack(frame *StackFrame) bool {
+	for _, name := range stackSwitchFunctions {
+		if frame.Fn.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// When the thread is running on its system stack, on behalf of a
+// goroutine or in C code called through cgo, returns where that
+// goroutine's stack should be unwound from: the state saved when it
+// entered a system call, as cgo calls do, or else when it left its stack.
+// The frame pointer is zero where it was not saved.
+func (dbp *DebuggedProcess) suspendedGoroutineContext(th *ThreadContext) (pc, sp, bp uint64, ok bool) {
+	g, err := dbp.threadG(th)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	mtype, err := dbp.findStructType("runtime.m")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	m, err := dbp.readUintField(g, gtype, "m")
+	if err != nil || m == 0 {
+		return 0, 0, 0, false
+	}
+
+	curg, err := dbp.readUintField(m, mtype, "curg")
+	if err != nil || curg == 0 || curg == g {
+		return 0, 0, 0, false
+	}
+
+	sp, err = dbp.readUintField(curg, gtype, "syscallsp")
+	if err == nil && sp != 0 {
+		pc, err = dbp.readUintField(curg, gtype, "syscallpc")
+		return pc, sp, 0, err == nil
+	}
+
+	suspended, err := dbp.readGoroutine(curg)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	return suspended.PC, suspended.SP, suspended.BP, true
+}
+
+// Unwinds the stack starting at the given pc and stack pointer,
+// using the .debug_frame information to find the canonical frame
+// address and return address of every frame. Where there is no call
+// frame information, as for some assembly functions, the chain of
+// saved frame pointers starting at bp is followed instead, if bp is
+// not zero.
+func (dbp *DebuggedProcess) stacktrace(pc, sp, bp uint64, depth int) ([]*StackFrame, error) {
+	frames := make([]*StackFrame, 0, depth)
+
+	for i := 0; i < depth; i++ {
+		lookup := pc
+		if i > 0 {
+			// The PC of every frame except the innermost is a return
+			// address, which may already belong to the next line.
+			lookup--
+		}
+
+		f, l, fn := dbp.PCToLine(lookup)
+		if fn == nil {
+			break
+		}
+
+		var cfa, retaddr int64
+		fde, err := dbp.FDEForPC(pc)
+		if err == nil {
+			cfa = int64(sp) + fde.EstablishFrame(pc).CFAOffset()
+			retaddr = int64(sp) + fde.ReturnAddressOffset(pc)
+		} else {
+			// The frame pointer points at the saved frame pointer
+			// of the caller, just below the return address.
+			if bp == 0 || bp < sp {
+				break
+			}
+			cfa = int64(bp) + 16
+			retaddr = int64(bp) + 8
+		}
+
+		frame := &StackFrame{
+			PC:   pc,
+			SP:   sp,
+			CFA:  cfa,
+			File: f,
+			Line: l,
+			Fn:   fn,
+		}
+		frames = append(frames, frame)
+
+		if fn.Name == "runtime.goexit" {
+			break
+		}
+
+		// The frames found so far are still of use when the
+		// return address can not be read.
+		data, err := dbp.readMemory(uintptr(retaddr), 8)
+		if err != nil {
+			break
+		}
+
+		bp = dbp.callerFramePointer(frame, bp, fde != nil)
+		pc = binary.LittleEndian.Uint64(data)
+		sp = uint64(frame.CFA)
+		if pc == 0 {
+			break
+		}
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("could not unwind stack at %#v", pc)
+	}
+
+	return frames, nil
+}
+
+// Returns the value of the frame pointer in the caller of frame, where
+// bp is its value in frame. Functions which set up a frame save the
+// frame pointer of their caller just below the return address, once
+// past their prologue, others leave it alone. Returns zero if it
+// cannot be read.
+func (dbp *DebuggedProcess) callerFramePointer(frame *StackFrame, bp uint64, hasCFI bool) uint64 {
+	addr := frame.CFA - 16
+	if hasCFI && (frame.PC == frame.Fn.Entry || addr < int64(frame.SP)) {
+		return bp
+	}
+
+	saved, err := dbp.readUint64(uintptr(addr))
+	if err != nil {
+		return 0
+	}
+
+	return saved
+}
+
+// Returns the arguments of the function executing in frame,
+// with values read relative to that frame.
+func (dbp *DebuggedProcess) FunctionArguments(frame *StackFrame) ([]*Variable, error) {
+	return dbp.frameVariables(frame, func(entry *dwarf.Entry) bool {
+		return entry.Tag == dwarf.TagFormalParameter
+	})
+}
+
+// Returns the local variables of the function executing in frame,
+// with values read relative to that frame.
+func (dbp *DebuggedProcess) LocalVariables(frame *StackFrame) ([]*Variable, error) {
+	return dbp.frameVariables(frame, func(entry *dwarf.Entry) bool {
+		return entry.Tag == dwarf.TagVariable
+	})
+}
+
+// Returns the results of the function executing in frame. These live
+// in the arguments area of the caller, so they can still be read once
+// the function has returned, as long as frame was obtained before.
+func (dbp *DebuggedProcess) ReturnValues(frame *StackFrame) ([]*Variable, error) {
+	return dbp.frameVariables(frame, func(entry *dwarf.Entry) bool {
+		if entry.Tag != dwarf.TagFormalParameter {
+			return false
+		}
+
+		// Newer compilers mark results with DW_AT_variable_parameter,
+		// older ones only give unnamed results names such as ~r1.
+		if out, ok := entry.Val(dwarf.AttrVarParam).(bool); ok {
+			return out
+		}
+
+		n, _ := entry.Val(dwarf.AttrName).(string)
+		return strings.HasPrefix(n, "~r")
+	})
+}
+
+// Returns all of the variables matching the given filter which are in
+// scope in the function executing in frame, including those declared in
+// nested lexical blocks covering its PC. A variable shadowed by another
+// of the same name declared in an inner block is named as it is looked
+// up, as in a@1. Variables whose value cannot be read are reported with
+// the reason in place of their value.
+func (dbp *DebuggedProcess) frameVariables(frame *StackFrame, match func(*dwarf.Entry) bool) ([]*Variable, error) {
+	entries, data, err := dbp.scopeEntries(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make([]*Variable, 0)
+	for _, se := range entries {
+		if !match(se.entry) {
+			continue
+		}
+
+		n, _ := se.entry.Val(dwarf.AttrName).(string)
+
+		v, err := dbp.extractVariableFromEntry(se.entry, data, frame.CFA)
+		if err != nil {
+			if _, ok := err.(noLocationError); ok {
+				continue
+			}
+
+			v = &Variable{Name: n, Value: fmt.Sprintf("(unreadable: %s)", err)}
+		}
+
+		if outer := shadowingEntries(entries, n, se.depth); outer > 0 {
+			v.Name = fmt.Sprintf("%s@%d", n, outer)
+		}
+
+		vars = append(vars, v)
+	}
+
+	return vars, nil
+}
+
+// Returns the address of the named argument or local
+// variable of the function executing in frame.
+func (dbp *DebuggedProcess) frameVariableAddr(frame *StackFrame, name string) (uint64, error) {
+	entry, data, err := dbp.frameEntry(frame, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if entry == nil {
+		return 0, fmt.Errorf("%s has no variable %s", frame.Fn.Name, name)
+	}
+
+	addr, _, err := variableLocation(entry, data, frame.CFA)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(addr), nil
+}
+
+// Returns the debug information entry of the named argument or local
+// variable of the function executing in frame, or nil if there is none
+// with a location. Of variables of the same name, the one declared in
+// the innermost lexical block is returned; name may ask for one it
+// shadows instead, as in a@1 for the next one out.
+func (dbp *DebuggedProcess) frameEntry(frame *StackFrame, name string) (*dwarf.Entry, *dwarf.Data, error) {
+	name, outer := splitShadowed(name)
+
+	entries, data, err := dbp.scopeEntries(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Entries are in the order they are declared in, so that
+	// of those at the same depth the last one declared wins.
+	var found []scopeEntry
+	for _, se := range entries {
+		if n, _ := se.entry.Val(dwarf.AttrName).(string); n != name {
+			continue
+		}
+
+		if _, _, err := variableLocation(se.entry, data, frame.CFA); err != nil {
+			if _, ok := err.(noLocationError); ok {
+				continue
+			}
+			return nil, nil, err
+		}
+
+		found = append(found, se)
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].depth > found[j].depth
+	})
+
+	if outer >= len(found) {
+		return nil, data, nil
+	}
+
+	return found[outer].entry, data, nil
+}
+
+// An argument or local variable of a function, along with the depth of
+// the lexical block declaring it, 0 for the function itself.
+type scopeEntry struct {
+	entry *dwarf.Entry
+	depth int
+}
+
+// Returns the entries of the arguments and local variables of the
+// function executing in frame which are in scope at its PC, in the order
+// they are declared in. Variables of lexical blocks not covering the PC
+// are out of scope, as are those declared after the current line.
+func (dbp *DebuggedProcess) scopeEntries(frame *StackFrame) ([]scopeEntry, *dwarf.Data, error) {
+	data, err := dbp.dwarfForPC(frame.PC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pc := frame.PC - dbp.bias(frame.PC)
+
+	reader := data.Reader()
+	base, err := seekToFunctionEntry(reader, frame.Fn.Entry-dbp.bias(frame.PC))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]scopeEntry, 0)
+	for depth := 1; depth > 0; {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if entry == nil {
+			break
+		}
+
+		switch entry.Tag {
+		case 0:
+			depth--
+			continue
+		case dwarf.TagLexDwarfBlock:
+			covers, err := dbp.blockCovers(entry, pc, base, frame.PC)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if !covers {
+				reader.SkipChildren()
+				continue
+			}
+		case dwarf.TagVariable, dwarf.TagFormalParameter:
+			if line, ok := entry.Val(dwarf.AttrDeclLine).(int64); !ok || frame.Line == 0 || line <= int64(frame.Line) {
+				entries = append(entries, scopeEntry{entry: entry, depth: depth - 1})
+			}
+		}
+
+		if entry.Children {
+			depth++
+		}
+	}
+
+	return entries, data, nil
+}
+
+// Returns how many variables named name are declared in blocks nested
+// deeper than depth among entries, shadowing one declared at depth.
+func shadowingEntries(entries []scopeEntry, name string, depth int) int {
+	depths := make(map[int]bool)
+	for _, se := range entries {
+		if n, _ := se.entry.Val(dwarf.AttrName).(string); n == name && se.depth > depth {
+			depths[se.depth] = true
+		}
+	}
+
+	return len(depths)
+}
+
+// Reports whether the lexical block entry covers pc, with base the low
+// pc of its compilation unit, which ranges are relative to. addr is the
+// unrelocated pc, which tells where the debugging information is from.
+func (dbp *DebuggedProcess) blockCovers(entry *dwarf.Entry, pc, base, addr uint64) (bool, error) {
+	if lowpc, ok := entry.Val(dwarf.AttrLowpc).(uint64); ok {
+		// The high pc is either an address or, since
+		// DWARF 4, an offset from the low pc.
+		switch highpc := entry.Val(dwarf.AttrHighpc).(type) {
+		case uint64:
+			return pc >= lowpc && pc < highpc, nil
+		case int64:
+			return pc >= lowpc && pc < lowpc+uint64(highpc), nil
+		}
+	}
+
+	off, ok := entry.Val(dwarf.AttrRanges).(int64)
+	if !ok {
+		// Blocks without addresses are taken to cover the whole function.
+		return true, nil
+	}
+
+	exe := dbp.Executable
+	if p := dbp.pluginForPC(addr); p != nil {
+		exe = p.Executable
+	}
+
+	sec := exe.Section(".debug_ranges")
+	if sec == nil {
+		return true, nil
+	}
+
+	ranges, err := sec.Data()
+	if err != nil {
+		return false, err
+	}
+
+	// Ranges are pairs of addresses, relative to the base, ending with
+	// a pair of zeros. A pair starting with all ones sets a new base.
+	for i := off; i+16 <= int64(len(ranges)); i += 16 {
+		start := binary.LittleEndian.Uint64(ranges[i:])
+		end := binary.LittleEndian.Uint64(ranges[i+8:])
+
+		switch {
+		case start == 0 && end == 0:
+			return false, nil
+		case start == ^uint64(0):
+			base = end
+		case pc >= base+start && pc < base+end:
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Returns the name of a variable referred to as in a@1,
+// along with how many scopes out it is declared.
+func splitShadowed(name string) (string, int) {
+	name = exprSource(name)
+
+	i := strings.LastIndex(name, shadowedSeparator)
+	if i < 0 {
+		return name, 0
+	}
+
+	outer, err := strconv.Atoi(name[i+len(shadowedSeparator):])
+	if err != nil {
+		return name, 0
+	}
+
+	return name[:i], outer
+}
+
+// Advances reader to just past the DW_TAG_subprogram entry of the
+// function starting at entry, so that the next entries read are
+// that function's children. Returns the low pc of the compilation
+// unit of the function.
+func seekToFunctionEntry(reader *dwarf.Reader, entry uint64) (uint64, error) {
+	var base uint64
+	for e, err := reader.Next(); e != nil; e, err = reader.Next() {
+		if err != nil {
+			return 0, err
+		}
+
+		if e.Tag == dwarf.TagCompileUnit {
+			base, _ = e.Val(dwarf.AttrLowpc).(uint64)
+			continue
+		}
+
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		if lowpc, ok := e.Val(dwarf.AttrLowpc).(uint64); ok && lowpc == entry && e.Children {
+			return base, nil
+		}
+
+		reader.SkipChildren()
+	}
+
+	return 0, fmt.Errorf("could not find debug information for function at %#v", entry)
+}
+
+// Represents a deferred call which has been registered by
+// a function but has not been executed yet.
+type Defer struct {
+	// Function that will be called.
+	Fn *gosym.Func
+	// Stack pointer of the frame which registered the call.
+	SP uint64
+}
+
+// Returns the deferred calls registered by the function executing in
+// frame, in the order they will be run. Only the current goroutine is
+// inspected, so frame must belong to the current thread's stack.
+func (dbp *DebuggedProcess) FrameDefers(frame *StackFrame) ([]*Defer, error) {
+	defers, err := dbp.goroutineDefers()
+	if err != nil {
+		return nil, err
+	}
+
+	framedefers := make([]*Defer, 0)
+	for _, d := range defers {
+		if d.SP >= frame.SP && d.SP < uint64(frame.CFA) {
+			framedefers = append(framedefers, d)
+		}
+	}
+
+	return framedefers, nil
+}
+
+// Bounds the walk of a _defer chain, which could be endless were it
+// corrupt or read while being modified.
+const maxDefers = 1 << 16
+
+// Walks the _defer chain of the current goroutine.
+func (dbp *DebuggedProcess) goroutineDefers() ([]*Defer, error) {
+	g, err := dbp.currentG()
+	if err != nil {
+		return nil, err
+	}
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+
+	dtype, err := dbp.findStructType("runtime._defer")
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := dbp.readUintField(g, gtype, "_defer", "defer")
+	if err != nil {
+		return nil, err
+	}
+
+	defers := make([]*Defer, 0)
+	for d != 0 {
+		if len(defers) == maxDefers {
+			return nil, fmt.Errorf("more than %d deferred calls, the defer chain may be corrupt", maxDefers)
+		}
+
+		sp, err := dbp.readUintField(d, dtype, "sp", "argp")
+		if err != nil {
+			return nil, err
+		}
+
+		fv, err := dbp.readUintField(d, dtype, "fn")
+		if err != nil {
+			return nil, err
+		}
+
+		var fn *gosym.Func
+		if fv != 0 {
+			// fn points to a funcval, the first word
+			// of which is the address of the code.
+			pc, err := dbp.readUint64(uintptr(fv))
+			if err != nil {
+				return nil, err
+			}
+			_, _, fn = dbp.PCToLine(pc)
+		}
+
+		defers = append(defers, &Defer{Fn: fn, SP: sp})
+
+		d, err = dbp.readUintField(d, dtype, "link")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return defers, nil
+}
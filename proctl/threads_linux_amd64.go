@@ -0,0 +1,536 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// An OS thread of the debugged process. Registers and execution
+// are controlled by ptrace one thread at a time, whereas memory
+// and breakpoints are shared by every thread of the process.
+type ThreadContext struct {
+	Id     int
+	Status *syscall.WaitStatus
+
+	// Whether the thread was resumed and has not been seen
+	// to stop since.
+	running bool
+	// Number of SIGSTOPs the debugger sent the thread to stop
+	// it which it has not stopped with yet. Any other SIGSTOP
+	// was sent by someone else, and is reported.
+	stops int
+	// Set while the thread steps over a breakpoint out of line.
+	displaced *displacedStep
+	// Signal to deliver when the thread is next resumed, one
+	// received while the debugger stepped it on its own.
+	sig int
+}
+
+// Returns the register values of the thread.
+func (th *ThreadContext) Registers() (*syscall.PtraceRegs, error) {
+	var regs syscall.PtraceRegs
+
+	err := syscall.PtraceGetRegs(th.Id, &regs)
+	if err != nil {
+		return nil, fmt.Errorf("could not read registers of thread %d: %s", th.Id, err)
+	}
+
+	return &regs, nil
+}
+
+// Makes the thread with the given ID the current thread, so that
+// registers are read from it and execution is stepped on it.
+func (dbp *DebuggedProcess) SwitchThread(tid int) error {
+	th, ok := dbp.Threads[tid]
+	if !ok {
+		return fmt.Errorf("no thread with id %d", tid)
+	}
+
+	dbp.CurrentThread = th
+	return nil
+}
+
+// Returns the goroutine running on each thread, by thread ID. Threads
+// which are not running a goroutine, such as idle threads waiting for
+// work, are left out.
+func (dbp *DebuggedProcess) ThreadGoroutines() (map[int]*Goroutine, error) {
+	goroutines := make(map[int]*Goroutine, len(dbp.Threads))
+	for tid, th := range dbp.Threads {
+		addr, err := dbp.threadG(th)
+		if err != nil {
+			continue
+		}
+
+		g, err := dbp.readGoroutine(addr)
+		if err != nil {
+			return nil, err
+		}
+		goroutines[tid] = g
+	}
+
+	return goroutines, nil
+}
+
+// Returns the thread running the goroutine, or nil
+// if it is not running on any thread.
+func (dbp *DebuggedProcess) GoroutineThread(g *Goroutine) *ThreadContext {
+	if addr, err := dbp.threadG(dbp.CurrentThread); err == nil && addr == g.Addr {
+		return dbp.CurrentThread
+	}
+
+	for _, th := range dbp.Threads {
+		if addr, err := dbp.threadG(th); err == nil && addr == g.Addr {
+			return th
+		}
+	}
+
+	return nil
+}
+
+// Attaches to every thread of the process not already traced.
+func (dbp *DebuggedProcess) attachThreads() error {
+	tids, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", dbp.Pid))
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range tids {
+		tid, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+
+		if _, ok := dbp.Threads[tid]; ok {
+			continue
+		}
+
+		err = syscall.PtraceAttach(tid)
+		if err == syscall.ESRCH {
+			// The thread exited since the directory was read.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not attach to thread %d: %s", tid, err)
+		}
+
+		ps, err := wait(tid)
+		if err != nil {
+			return err
+		}
+
+		err = syscall.PtraceSetOptions(tid, traceOptions)
+		if err != nil {
+			return err
+		}
+
+		dbp.Threads[tid] = &ThreadContext{Id: tid, Status: ps}
+	}
+
+	return nil
+}
+
+// Have the threads the process creates traced, as well as processes
+// it forks so that they can be rid of our breakpoints, or followed,
+// and the programs they execute reported.
+const traceOptions = syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACEEXEC
+
+// Handles the ptrace event a thread stopped for, if any, reporting
+// whether it did. The thread itself is left stopped.
+func (dbp *DebuggedProcess) handleEvent(th *ThreadContext, ps *syscall.WaitStatus) (bool, error) {
+	if ps == nil || !ps.Stopped() || ps.StopSignal() != syscall.SIGTRAP {
+		return false, nil
+	}
+
+	cause := ps.TrapCause()
+	switch cause {
+	case syscall.PTRACE_EVENT_CLONE, syscall.PTRACE_EVENT_FORK, syscall.PTRACE_EVENT_VFORK:
+	case syscall.PTRACE_EVENT_EXEC:
+		// Left to stop execution, so that breakpoints
+		// can be set in the program executed.
+		return false, dbp.handleExec(th)
+	default:
+		return false, nil
+	}
+
+	msg, err := syscall.PtraceGetEventMsg(th.Id)
+	if err != nil {
+		return true, err
+	}
+
+	if cause == syscall.PTRACE_EVENT_CLONE {
+		return true, dbp.addThread(int(msg))
+	}
+
+	if dbp.FollowForks {
+		return true, dbp.followChild(int(msg), cause == syscall.PTRACE_EVENT_VFORK)
+	}
+
+	return true, dbp.releaseChild(int(msg), cause == syscall.PTRACE_EVENT_VFORK)
+}
+
+// Waits for the initial stop of a process or thread we were attached
+// to automatically, unless it already happened.
+func (dbp *DebuggedProcess) waitNew(pid int) (*syscall.WaitStatus, error) {
+	if ps, ok := dbp.newStops[pid]; ok {
+		delete(dbp.newStops, pid)
+		return ps, nil
+	}
+
+	return wait(pid)
+}
+
+// Adds a thread created by the process, leaving it stopped. It is
+// resumed along with every other thread.
+func (dbp *DebuggedProcess) addThread(tid int) error {
+	ps, err := dbp.waitNew(tid)
+	if err != nil {
+		return err
+	}
+
+	dbp.Threads[tid] = &ThreadContext{Id: tid, Status: ps}
+	return nil
+}
+
+// Detaches from a child forked by the process. The child starts with a
+// copy of the memory of the process, so our breakpoints are removed
+// from it first, unless it was created by vfork and shares our memory.
+func (dbp *DebuggedProcess) releaseChild(pid int, vfork bool) error {
+	_, err := dbp.waitNew(pid)
+	if err != nil {
+		return err
+	}
+
+	if !vfork {
+		for _, bp := range dbp.BreakPoints {
+			_, err = syscall.PtracePokeData(pid, uintptr(bp.Addr), bp.OriginalData)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return syscall.PtraceDetach(pid)
+}
+
+// Resumes every stopped thread and waits for any thread of the
+// process to stop. The thread which stopped becomes CurrentThread,
+// and every other thread is then stopped as well so that the state
+// of the program does not change while it is inspected.
+func (dbp *DebuggedProcess) resumeAll() error {
+	err := dbp.resumeStopped()
+	if err != nil {
+		return err
+	}
+
+	for {
+		tid, ps, err := waitAny()
+		if err != nil {
+			return err
+		}
+
+		if ps.Exited() || ps.Signaled() {
+			if tid == dbp.Pid {
+				// The leader is reported last, the process has exited.
+				return dbp.handleStatus(ps)
+			}
+
+			dbp.removeThread(tid)
+			continue
+		}
+
+		th, ok := dbp.Threads[tid]
+		if !ok {
+			// A new thread or forked process, stopped before the
+			// event reporting its creation. It is taken care of
+			// when the event arrives.
+			dbp.newStops[tid] = ps
+			continue
+		}
+		th.Status = ps
+		th.running = false
+
+		if th.displaced != nil && ps.Stopped() {
+			sig, report, err := dbp.displacedStepStopped(th, ps)
+			if err != nil {
+				return err
+			}
+
+			if !report {
+				err = dbp.resumeThread(th, sig)
+				if err != nil {
+					return err
+				}
+				th.running = true
+				continue
+			}
+
+			// Delivered when the thread is resumed.
+			th.sig = sig
+		}
+
+		handled, err := dbp.handleEvent(th, ps)
+		if err != nil {
+			return err
+		}
+
+		if handled {
+			// Resumes the new thread as well.
+			err = dbp.resumeStopped()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if ps.Stopped() && ps.StopSignal() == syscall.SIGURG {
+			// Sent by the runtime to preempt goroutines, which it
+			// does all the time. Deliver it rather than stopping.
+			err = dbp.resumeThread(th, int(syscall.SIGURG))
+			if err != nil {
+				return err
+			}
+			th.running = true
+			continue
+		}
+
+		if ps.Stopped() && ps.StopSignal() == syscall.SIGSTOP && th.stops > 0 {
+			// Left over from stopping the thread, after it
+			// had stopped for another reason.
+			th.stops--
+			err = dbp.resumeThread(th, 0)
+			if err != nil {
+				return err
+			}
+			th.running = true
+			continue
+		}
+
+		dbp.CurrentThread = th
+		err = dbp.stopThreads()
+		if err != nil {
+			return err
+		}
+
+		return dbp.handleStatus(ps)
+	}
+}
+
+// Resumes every thread which is stopped.
+func (dbp *DebuggedProcess) resumeStopped() error {
+	for _, th := range dbp.Threads {
+		if th.running {
+			continue
+		}
+
+		err := dbp.resumeThread(th, 0)
+		if err == syscall.ESRCH {
+			// Exited, the exit status is collected
+			// by the next wait.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not resume thread %d: %s", th.Id, err)
+		}
+		th.running = true
+	}
+
+	return nil
+}
+
+// Stops every running thread with a SIGSTOP, and waits for each
+// of them to stop. A thread which traps on a breakpoint before the
+// signal is delivered has its PC rewound to the breakpoint, so that
+// the breakpoint is hit again once the thread is resumed. Threads
+// stepping over a breakpoint out of line are stopped once done.
+func (dbp *DebuggedProcess) stopThreads() error {
+	for _, th := range dbp.Threads {
+		if !th.running {
+			continue
+		}
+
+		err := syscall.Tgkill(dbp.Pid, th.Id, syscall.SIGSTOP)
+		if err == syscall.ESRCH {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not stop thread %d: %s", th.Id, err)
+		}
+		th.stops++
+	}
+
+	for _, th := range dbp.Threads {
+		if !th.running {
+			continue
+		}
+
+		err := dbp.waitStopped(th)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dbp.finishDisplacedSteps()
+}
+
+// Waits for a thread sent a SIGSTOP to stop with it.
+func (dbp *DebuggedProcess) waitStopped(th *ThreadContext) error {
+	for {
+		ps, err := dbp.waitThread(th.Id)
+		if err != nil {
+			return err
+		}
+
+		th.running = false
+		if ps == nil || ps.Exited() || ps.Signaled() {
+			dbp.removeThread(th.Id)
+			return nil
+		}
+		th.Status = ps
+
+		if ps.StopSignal() == syscall.SIGSTOP {
+			if th.stops > 0 {
+				th.stops--
+			}
+			return nil
+		}
+
+		if th.displaced != nil {
+			sig, _, err := dbp.displacedStepStopped(th, ps)
+			if err != nil {
+				return err
+			}
+
+			// The SIGSTOP is still pending.
+			err = dbp.resumeThread(th, sig)
+			if err != nil {
+				return err
+			}
+			th.running = true
+			continue
+		}
+
+		handled, err := dbp.handleEvent(th, ps)
+		if err != nil {
+			return err
+		}
+
+		var sig int
+		switch {
+		case handled:
+		case ps.StopSignal() == syscall.SIGTRAP:
+			err = dbp.rewindBreakpoint(th)
+			if err != nil {
+				return err
+			}
+		default:
+			// Any other signal is delivered, as it would
+			// have been had we not stopped the thread.
+			sig = int(ps.StopSignal())
+		}
+
+		// The SIGSTOP is still pending, and stops
+		// the thread as soon as it is resumed.
+		err = dbp.resumeThread(th, sig)
+		if err != nil {
+			return err
+		}
+		th.running = true
+	}
+}
+
+// Waits for a thread to change state, returning a nil status if it
+// has exited without it being reported. This is the case of a thread
+// group leader which exits before the other threads: it is not reported
+// until they have all exited, so it is found to be a zombie instead.
+func (dbp *DebuggedProcess) waitThread(tid int) (*syscall.WaitStatus, error) {
+	for {
+		var status syscall.WaitStatus
+
+		wpid, err := syscall.Wait4(tid, &status, syscall.WALL|syscall.WNOHANG, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if wpid == tid {
+			return &status, nil
+		}
+
+		if dbp.threadExited(tid) {
+			return nil, nil
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Reports whether the thread is a zombie, or has been reaped.
+func (dbp *DebuggedProcess) threadExited(tid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/stat", dbp.Pid, tid))
+	if err != nil {
+		return true
+	}
+
+	// The state follows the command name, which is in parentheses
+	// and may itself contain spaces or parentheses.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 || i+2 >= len(stat) {
+		return false
+	}
+
+	state := stat[i+2]
+	return state == 'Z' || state == 'X'
+}
+
+// Forgets about a thread which has exited. If it was the current
+// thread, the thread group leader, or else any remaining thread,
+// becomes the current thread.
+func (dbp *DebuggedProcess) removeThread(tid int) {
+	delete(dbp.Threads, tid)
+
+	if dbp.CurrentThread == nil || dbp.CurrentThread.Id != tid {
+		return
+	}
+
+	if th, ok := dbp.Threads[dbp.Pid]; ok {
+		dbp.CurrentThread = th
+		return
+	}
+
+	for _, th := range dbp.Threads {
+		dbp.CurrentThread = th
+		return
+	}
+}
+
+// Moves the PC of a thread which has just executed a breakpoint
+// back to the address of the breakpoint.
+func (dbp *DebuggedProcess) rewindBreakpoint(th *ThreadContext) error {
+	regs, err := th.Registers()
+	if err != nil {
+		return err
+	}
+
+	bp, ok := dbp.BreakPoints[regs.PC()-1]
+	if !ok {
+		return nil
+	}
+
+	regs.SetPC(bp.Addr)
+	return syscall.PtraceSetRegs(th.Id, regs)
+}
+
+// Waits for any traced thread to change state.
+func waitAny() (int, *syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+
+	tid, err := syscall.Wait4(-1, &status, syscall.WALL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return tid, &status, nil
+}
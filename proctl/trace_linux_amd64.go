@@ -0,0 +1,145 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Records a hit of a tracepoint: the goroutine that reached it,
+// the function it was in and the arguments of that function. For
+// logpoints Message holds the interpolated log message, and for
+// goroutine creation the function launched.
+type TraceRecord struct {
+	BreakPoint  *BreakPoint
+	GoroutineID int
+	Function    string
+	Args        []*Variable
+	Message     string
+}
+
+func (tr *TraceRecord) String() string {
+	if tr.Message != "" {
+		return fmt.Sprintf("> goroutine(%d): %s:%d %s", tr.GoroutineID, tr.BreakPoint.File, tr.BreakPoint.Line, tr.Message)
+	}
+
+	args := make([]string, 0, len(tr.Args))
+	for _, arg := range tr.Args {
+		args = append(args, fmt.Sprintf("%s=%s", arg.Name, arg.Formatted()))
+	}
+
+	return fmt.Sprintf("> goroutine(%d): %s(%s) %s:%d", tr.GoroutineID, tr.Function, strings.Join(args, ", "), tr.BreakPoint.File, tr.BreakPoint.Line)
+}
+
+// If the process is stopped at a tracepoint or logpoint, records the hit and hands
+// the record to the TraceHandler. Returns true if a hit was recorded,
+// in which case execution should be resumed.
+func (dbp *DebuggedProcess) trace() (bool, error) {
+	bp, err := dbp.CurrentBreakpoint()
+	if err != nil {
+		return false, err
+	}
+
+	if bp == nil || !bp.Tracepoint && bp.LogMessage == "" {
+		return false, nil
+	}
+
+	record := &TraceRecord{BreakPoint: bp, Function: bp.FunctionName}
+
+	// Goroutine IDs are only available once the scheduler
+	// is running, report -1 for code running before that.
+	record.GoroutineID, err = dbp.currentGoroutineID()
+	if err != nil {
+		record.GoroutineID = -1
+	}
+
+	switch {
+	case bp.LogMessage != "":
+		record.Message = dbp.interpolate(bp.LogMessage)
+		dbp.handleTrace(record)
+		return true, nil
+	case bp.IsGo():
+		fn, site, err := dbp.GoStatement()
+		if err != nil {
+			return false, err
+		}
+
+		record.Message = fmt.Sprintf("go %s at %s:%d", fn.Name, site.File, site.Line)
+		dbp.handleTrace(record)
+		return true, nil
+	}
+
+	frames, err := dbp.Stacktrace(1)
+	if err != nil {
+		return false, err
+	}
+
+	record.Args, err = dbp.FunctionArguments(frames[0])
+	if err != nil {
+		return false, err
+	}
+
+	dbp.handleTrace(record)
+
+	return true, nil
+}
+
+func (dbp *DebuggedProcess) handleTrace(record *TraceRecord) {
+	if dbp.TraceHandler != nil {
+		dbp.TraceHandler(record)
+	}
+}
+
+// Replaces every {expression} in template with the value of the
+// expression. {{ and }} stand for literal braces. Expressions which
+// fail to evaluate are replaced by the error, so that one bad
+// expression does not hide the rest of the message.
+func (dbp *DebuggedProcess) interpolate(template string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case strings.HasPrefix(template[i:], "{{"), strings.HasPrefix(template[i:], "}}"):
+			buf.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				buf.WriteString(template[i:])
+				return buf.String()
+			}
+
+			expr := template[i+1 : i+end]
+			if v, err := dbp.EvalExpression(expr); err != nil {
+				fmt.Fprintf(&buf, "<%s>", err)
+			} else {
+				buf.WriteString(v.Formatted())
+			}
+			i += end
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	return buf.String()
+}
+
+// Returns the ID of the goroutine running on the current thread.
+func (dbp *DebuggedProcess) currentGoroutineID() (int, error) {
+	g, err := dbp.currentG()
+	if err != nil {
+		return 0, err
+	}
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := dbp.readUintField(g, gtype, "goid")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
@@ -0,0 +1,134 @@
+package proctl
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Returns the type of an expression evaluated in frame, nil for the
+// innermost one, named as in Go. The value of an expression designating
+// a variable, or a field or element of one, is not read, so its type is
+// known even when the memory it lives in can not be.
+func (dbp *DebuggedProcess) ExpressionTypeInFrame(expr string, frame *StackFrame) (string, error) {
+	t, err := parseExpression(expr)
+	if err != nil {
+		return "", err
+	}
+
+	scope := &evalScope{dbp: dbp, frame: frame, src: exprSource(expr)}
+	if _, typ, err := scope.evalAddress(t); err == nil {
+		return goTypeName(typ), nil
+	}
+
+	v, err := scope.evalAST(t)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(v.Type, "struct "), nil
+}
+
+// Returns the name Go gives to a type. Strings, slices and interfaces
+// are described as structs, which are named after the Go type.
+func goTypeName(typ dwarf.Type) string {
+	switch t := typ.(type) {
+	case *dwarf.StructType:
+		if t.StructName != "" {
+			return t.StructName
+		}
+	case *dwarf.PtrType:
+		if _, ok := t.Type.(*dwarf.VoidType); ok {
+			return "unsafe.Pointer"
+		}
+		return "*" + goTypeName(t.Type)
+	case *dwarf.ArrayType:
+		return "[" + strconv.FormatInt(t.Count, 10) + "]" + goTypeName(t.Type)
+	}
+
+	return typ.String()
+}
+
+// Returns the type of a variable as reported in Variable.Type. Pointers
+// which do not say what they point to are unsafe.Pointer values.
+func variableType(typ dwarf.Type) string {
+	if t, ok := typ.(*dwarf.PtrType); ok {
+		if _, ok := t.Type.(*dwarf.VoidType); ok {
+			return "unsafe.Pointer"
+		}
+	}
+
+	return typ.String()
+}
+
+// Returns the predeclared type values of a number or boolean type declared
+// in a package are represented as, as in int64 for a type State int, or
+// an empty string for any other type. Only the size of the underlying
+// type is known, int and int64 are not told apart.
+func basicType(typ dwarf.Type) string {
+	if !strings.Contains(typ.Common().Name, ".") {
+		return ""
+	}
+
+	switch t := underlyingType(typ).(type) {
+	case *dwarf.IntType:
+		return "int" + strconv.FormatInt(8*t.ByteSize, 10)
+	case *dwarf.UintType:
+		return "uint" + strconv.FormatInt(8*t.ByteSize, 10)
+	case *dwarf.FloatType:
+		return "float" + strconv.FormatInt(8*t.ByteSize, 10)
+	case *dwarf.BoolType:
+		return "bool"
+	}
+
+	return ""
+}
+
+// Returns the names of the types of the program matching filter, or all
+// of them if filter is nil, sorted.
+func (dbp *DebuggedProcess) Types(filter *regexp.Regexp) ([]string, error) {
+	data, err := dbp.Executable.DWARF()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	reader := data.Reader()
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		switch entry.Tag {
+		case dwarf.TagSubprogram:
+			reader.SkipChildren()
+			continue
+		case dwarf.TagBaseType, dwarf.TagStructType, dwarf.TagTypedef, dwarf.TagPointerType,
+			dwarf.TagArrayType, dwarf.TagSubroutineType:
+		default:
+			continue
+		}
+
+		// Fields of structs are not types of their own.
+		if entry.Children {
+			reader.SkipChildren()
+		}
+
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok || seen[n] || filter != nil && !filter.MatchString(n) {
+			continue
+		}
+
+		seen[n] = true
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
@@ -0,0 +1,599 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/derekparker/delve/vendor/dwarf"
+)
+
+// Offset of u_debugreg within struct user, as
+// used by PTRACE_PEEKUSER and PTRACE_POKEUSER.
+const debugRegOffset = 848
+
+// The x86 debug registers provide four address registers,
+// DR0 to DR3, so at most four watchpoints can be set.
+const maxWatchpoints = 4
+
+// Control register bits, see the Intel SDM volume 3, section 17.2.4.
+const (
+	dr7RWWrite = 0x1
+	dr7RWShift = 16
+	dr7Len1    = 0x0
+	dr7Len2    = 0x1
+	dr7Len8    = 0x2
+	dr7Len4    = 0x3
+	dr7LenBits = 18
+)
+
+// Represents a hardware watchpoint, which stops execution when
+// the memory of a variable is written to.
+type Watchpoint struct {
+	// Shares its numbering with breakpoints.
+	ID   int
+	Expr string
+	Addr uint64
+	Size int
+	// Value of the watched memory when the watchpoint was set or
+	// last triggered, so that a change can be reported.
+	Value []byte
+	// Number of times the watched memory has been written to.
+	HitCount uint64
+
+	reg int
+	// Nil for a variable which does not live on the stack of a goroutine.
+	scope *watchScope
+}
+
+// Where a watched variable on the stack of a goroutine lives. The
+// watchpoint only holds while the frame of the variable has not returned,
+// and follows the variable when the runtime moves the stack.
+type watchScope struct {
+	// Address of the g struct of the goroutine, and its ID, as the
+	// g struct is reused once the goroutine exits.
+	g    uint64
+	goid uint64
+	// Canonical frame address of the frame of the variable, and its
+	// return address, where a breakpoint detects the frame returning.
+	cfa, ret uint64
+	// Set when the breakpoint at ret was set for the watchpoint, rather
+	// than being there already.
+	bp bool
+	// Top of the stack of the goroutine when last checked.
+	hi uint64
+}
+
+// Records a change the debugger made to a watchpoint on its own,
+// because the variable it watched went out of scope or moved.
+type WatchpointChange struct {
+	Watchpoint *Watchpoint
+	// Why the watchpoint was changed, as in "the frame of j returned".
+	Reason string
+	// The watchpoint was cleared, rather than moved to Watchpoint.Addr.
+	Cleared bool
+}
+
+// Sets a watchpoint on the variable named by expr, which is resolved to
+// an address and size through the debug information of the current
+// frame. The debug registers are set on every thread, so writes made by
+// any of them are detected. A watchpoint on a variable of the innermost
+// frame is cleared once that frame returns, see checkWatchpointScopes.
+func (dbp *DebuggedProcess) Watch(expr string) (*Watchpoint, error) {
+	entry, data, cfa, err := dbp.lookupSymbol(expr, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, typ, err := variableLocation(entry, data, cfa)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(typ.Size())
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("can not watch %s, values of %d bytes are not supported", expr, size)
+	}
+
+	if addr%int64(size) != 0 {
+		return nil, fmt.Errorf("can not watch %s, %#x is not aligned to its size", expr, addr)
+	}
+
+	reg := -1
+	for i, wp := range dbp.Watchpoints {
+		if wp == nil {
+			reg = i
+			break
+		}
+	}
+	if reg < 0 {
+		return nil, fmt.Errorf("can not watch %s, all %d debug registers are in use", expr, maxWatchpoints)
+	}
+
+	val, err := dbp.readMemory(uintptr(addr), uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := dbp.stackScope(uint64(addr), uint64(cfa))
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbp.setDebugRegister(reg, uint64(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	dr7, err := dbp.debugRegister(7)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbp.setDebugRegister(7, dr7|dr7Bits(reg, size))
+	if err != nil {
+		return nil, err
+	}
+
+	if scope != nil {
+		err = dbp.setScopeBreakpoint(scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dbp.breakpointIDCounter++
+	wp := &Watchpoint{
+		ID:    dbp.breakpointIDCounter,
+		Expr:  expr,
+		Addr:  uint64(addr),
+		Size:  size,
+		Value: val,
+		reg:   reg,
+		scope: scope,
+	}
+	dbp.Watchpoints[reg] = wp
+
+	return wp, nil
+}
+
+// Returns the scope of a variable at addr, belonging to the innermost
+// frame, whose canonical frame address is cfa, or nil if the variable
+// is not on the stack of the current goroutine.
+func (dbp *DebuggedProcess) stackScope(addr, cfa uint64) (*watchScope, error) {
+	g, err := dbp.currentG()
+	if err != nil || cfa == 0 {
+		return nil, nil
+	}
+
+	lo, hi, err := dbp.stackBounds(g)
+	if err != nil || addr < lo || addr >= hi {
+		return nil, nil
+	}
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+
+	goid, err := dbp.readUintField(g, gtype, "goid")
+	if err != nil {
+		return nil, err
+	}
+
+	// Watched variables are looked up in the innermost frame.
+	ret, err := dbp.ReturnAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	return &watchScope{g: g, goid: goid, cfa: cfa, ret: ret, hi: hi}, nil
+}
+
+// Sets the breakpoint detecting the frame of a watched variable returning,
+// unless another breakpoint is already there, which stops execution anyway.
+// Where the return address is not in Go code the frame returning is only
+// noticed the next time the process stops.
+func (dbp *DebuggedProcess) setScopeBreakpoint(scope *watchScope) error {
+	_, err := dbp.setTempBreakpoint(uintptr(scope.ret))
+	switch err.(type) {
+	case nil:
+		scope.bp = true
+	case BreakPointExistsError, InvalidAddressError:
+	default:
+		return err
+	}
+
+	return nil
+}
+
+// Clears the breakpoint set for the scope of the watchpoint, unless
+// another watchpoint on a variable of the same frame still needs it.
+func (dbp *DebuggedProcess) clearScopeBreakpoint(wp *Watchpoint) error {
+	if wp.scope == nil || !wp.scope.bp {
+		return nil
+	}
+
+	for _, other := range dbp.Watchpoints {
+		if other != nil && other != wp && other.scope != nil && other.scope.ret == wp.scope.ret {
+			other.scope.bp = true
+			return nil
+		}
+	}
+
+	// It may have been cleared already, along with every other
+	// breakpoint, as when detaching.
+	if _, ok := dbp.BreakPoints[wp.scope.ret]; !ok {
+		return nil
+	}
+
+	_, err := dbp.Clear(wp.scope.ret)
+	return err
+}
+
+// Reports whether bp was set to detect the frame of a watched variable
+// returning. It only stops execution when that frame returns, which
+// checkWatchpointScopes takes care of.
+func (dbp *DebuggedProcess) isScopeBreakpoint(bp *BreakPoint) bool {
+	if bp.ID != 0 {
+		return false
+	}
+
+	for _, wp := range dbp.Watchpoints {
+		if wp != nil && wp.scope != nil && wp.scope.bp && wp.scope.ret == bp.Addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks the watchpoints on variables on the stack of a goroutine,
+// whatever the process stopped for. A watchpoint whose goroutine had its
+// stack moved by the runtime, as happens when it grows, is moved along
+// with the variable. One whose frame has returned, or whose goroutine has
+// exited, is cleared and stop is true, rather than it triggering on
+// unrelated uses of the memory. Both are recorded in WatchpointChanges.
+// Resume is true when the process only stopped because of a write to
+// the old location of a watchpoint which has been moved.
+func (dbp *DebuggedProcess) checkWatchpointScopes() (stop, resume bool, err error) {
+	var dr6 uint64
+	if dbp.hasWatchpoints() {
+		dr6, err = dbp.debugRegister(6)
+		if err != nil {
+			return false, false, err
+		}
+	}
+
+	for _, wp := range dbp.Watchpoints {
+		if wp == nil || wp.scope == nil {
+			continue
+		}
+
+		gone, moved, err := dbp.checkWatchpointScope(wp)
+		if err != nil {
+			return false, false, err
+		}
+
+		switch {
+		case gone != "":
+			err = dbp.ClearWatchpoint(wp)
+			if err != nil {
+				return false, false, err
+			}
+			dbp.WatchpointChanges = append(dbp.WatchpointChanges, WatchpointChange{Watchpoint: wp, Reason: gone, Cleared: true})
+			stop = true
+		case moved != "":
+			dbp.WatchpointChanges = append(dbp.WatchpointChanges, WatchpointChange{Watchpoint: wp, Reason: moved})
+			if dr6&(1<<uint(wp.reg)) != 0 {
+				dr6 &^= 1 << uint(wp.reg)
+				resume = true
+			}
+		}
+	}
+
+	if resume {
+		err = dbp.setDebugRegister(6, dr6)
+		if err != nil {
+			return false, false, err
+		}
+
+		// Another watchpoint may have triggered as well.
+		resume = dr6&(1<<maxWatchpoints-1) == 0
+	}
+
+	return stop, resume, nil
+}
+
+// Returns why the variable watched by wp is gone, or why it has been
+// moved, if either is the case. The watchpoint is moved along with it.
+// When the state of the goroutine can not be read the watchpoint is
+// left alone.
+func (dbp *DebuggedProcess) checkWatchpointScope(wp *Watchpoint) (gone, moved string, err error) {
+	s := wp.scope
+
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return "", "", nil
+	}
+
+	goid, err := dbp.readUintField(s.g, gtype, "goid")
+	if err != nil {
+		return "", "", nil
+	}
+
+	status, err := dbp.readStatusField(s.g, gtype)
+	if err != nil {
+		return "", "", nil
+	}
+
+	if goid != s.goid || status&^gscan == Gdead {
+		return fmt.Sprintf("goroutine %d has exited", s.goid), "", nil
+	}
+
+	_, hi, err := dbp.stackBounds(s.g)
+	if err != nil {
+		return "", "", nil
+	}
+
+	if hi != s.hi {
+		delta := hi - s.hi
+		err = dbp.setDebugRegister(wp.reg, wp.Addr+delta)
+		if err != nil {
+			return "", "", err
+		}
+
+		wp.Addr += delta
+		s.cfa += delta
+		s.hi = hi
+		moved = fmt.Sprintf("the stack of goroutine %d was moved by the runtime", s.goid)
+	}
+
+	sp, ok := dbp.goroutineSP(s.g)
+	if ok && sp >= s.cfa {
+		return fmt.Sprintf("the frame of %s has returned", wp.Expr), moved, nil
+	}
+
+	return "", moved, nil
+}
+
+// Returns the stack pointer of the goroutine whose g struct is at g,
+// from the registers of the thread running it if there is one.
+func (dbp *DebuggedProcess) goroutineSP(g uint64) (uint64, bool) {
+	for _, th := range dbp.Threads {
+		if addr, err := dbp.threadG(th); err != nil || addr != g {
+			continue
+		}
+
+		regs, err := th.Registers()
+		if err != nil {
+			return 0, false
+		}
+
+		return regs.Rsp, true
+	}
+
+	gr, err := dbp.readGoroutine(g)
+	if err != nil {
+		return 0, false
+	}
+
+	return gr.SP, true
+}
+
+// Returns the bounds of the stack of the goroutine whose g struct is at g.
+func (dbp *DebuggedProcess) stackBounds(g uint64) (lo, hi uint64, err error) {
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stack, err := structField(gtype, "stack")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stype, ok := underlyingType(stack.Type).(*dwarf.StructType)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected type for runtime.g.stack %s", stack.Type)
+	}
+
+	lo, err = dbp.readUintField(g+uint64(stack.ByteOffset), stype, "lo")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	hi, err = dbp.readUintField(g+uint64(stack.ByteOffset), stype, "hi")
+	return lo, hi, err
+}
+
+// Removes the watchpoint, freeing its debug register.
+func (dbp *DebuggedProcess) ClearWatchpoint(wp *Watchpoint) error {
+	if dbp.Watchpoints[wp.reg] != wp {
+		return fmt.Errorf("no watchpoint %d", wp.ID)
+	}
+
+	dr7, err := dbp.debugRegister(7)
+	if err != nil {
+		return err
+	}
+
+	err = dbp.setDebugRegister(7, dr7&^dr7Bits(wp.reg, wp.Size))
+	if err != nil {
+		return err
+	}
+
+	err = dbp.setDebugRegister(wp.reg, 0)
+	if err != nil {
+		return err
+	}
+
+	err = dbp.clearScopeBreakpoint(wp)
+	if err != nil {
+		return err
+	}
+
+	dbp.Watchpoints[wp.reg] = nil
+
+	return nil
+}
+
+// Returns the watchpoint which caused the process to stop,
+// or nil if it stopped for any other reason.
+func (dbp *DebuggedProcess) CurrentWatchpoint() (*Watchpoint, error) {
+	if !dbp.hasWatchpoints() {
+		return nil, nil
+	}
+
+	dr6, err := dbp.debugRegister(6)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < maxWatchpoints; i++ {
+		if dr6&(1<<uint(i)) != 0 && dbp.Watchpoints[i] != nil {
+			return dbp.Watchpoints[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Reports whether the process stopped because of a watchpoint,
+// counting the hit if it did.
+func (dbp *DebuggedProcess) watchpointHit() (bool, error) {
+	if dbp.ProcessState.Exited() {
+		return false, nil
+	}
+
+	wp, err := dbp.CurrentWatchpoint()
+	if err != nil || wp == nil {
+		return false, err
+	}
+
+	wp.HitCount++
+
+	return true, nil
+}
+
+// Reports whether the last instruction the thread executed wrote
+// to watched memory, whether or not it is the current thread.
+func (dbp *DebuggedProcess) threadWatchpointHit(th *ThreadContext) (bool, error) {
+	if !dbp.hasWatchpoints() {
+		return false, nil
+	}
+
+	dr6, err := threadDebugRegister(th.Id, 6)
+	if err != nil {
+		return false, err
+	}
+
+	return dr6&(1<<maxWatchpoints-1) != 0, nil
+}
+
+// The processor never clears the debug status register, reset it
+// before resuming so the next stop is not mistaken for another hit.
+func (dbp *DebuggedProcess) resetDebugStatus() error {
+	if !dbp.hasWatchpoints() {
+		return nil
+	}
+
+	return dbp.setDebugRegister(6, 0)
+}
+
+func (dbp *DebuggedProcess) hasWatchpoints() bool {
+	for _, wp := range dbp.Watchpoints {
+		if wp != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the current contents of the watched memory, returning them
+// along with the contents recorded the last time it was read.
+func (dbp *DebuggedProcess) WatchpointValues(wp *Watchpoint) (old, cur uint64, err error) {
+	val, err := dbp.readMemory(uintptr(wp.Addr), uintptr(wp.Size))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	old, cur = littleEndian(wp.Value), littleEndian(val)
+	wp.Value = val
+
+	return old, cur, nil
+}
+
+func littleEndian(b []byte) uint64 {
+	buf := make([]byte, 8)
+	copy(buf, b)
+	return binary.LittleEndian.Uint64(buf)
+}
+
+// Returns the DR7 bits enabling a write watchpoint
+// of the given size in debug register reg.
+func dr7Bits(reg, size int) uint64 {
+	var length uint64
+	switch size {
+	case 1:
+		length = dr7Len1
+	case 2:
+		length = dr7Len2
+	case 4:
+		length = dr7Len4
+	case 8:
+		length = dr7Len8
+	}
+
+	shift := uint(reg * 4)
+
+	return 1<<uint(reg*2) | dr7RWWrite<<(dr7RWShift+shift) | length<<(dr7LenBits+shift)
+}
+
+func (dbp *DebuggedProcess) debugRegister(reg int) (uint64, error) {
+	return threadDebugRegister(dbp.CurrentThread.Id, reg)
+}
+
+func threadDebugRegister(tid, reg int) (uint64, error) {
+	var val uint64
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKUSR, uintptr(tid), uintptr(debugRegOffset+reg*8), uintptr(unsafe.Pointer(&val)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return val, nil
+}
+
+// Clears the debug registers of a thread, the control register
+// first, so that no watchpoint is left set on it.
+func clearDebugRegisters(tid int) error {
+	for _, reg := range []int{7, 0, 1, 2, 3, 6} {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_POKEUSR, uintptr(tid), uintptr(debugRegOffset+reg*8), 0, 0, 0)
+		if errno != 0 {
+			return errno
+		}
+	}
+
+	return nil
+}
+
+// Debug registers are per thread, they are set on every stopped
+// thread so that a watched write is caught whichever thread makes it.
+func (dbp *DebuggedProcess) setDebugRegister(reg int, val uint64) error {
+	for _, th := range dbp.Threads {
+		if th.running {
+			continue
+		}
+
+		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_POKEUSR, uintptr(th.Id), uintptr(debugRegOffset+reg*8), uintptr(val), 0, 0)
+		if errno != 0 {
+			return errno
+		}
+	}
+
+	return nil
+}
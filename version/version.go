@@ -0,0 +1,119 @@
+// Package version describes the version of delve and the
+// releases of Go whose binaries it is able to debug.
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version of delve.
+const Version = "0.2.0"
+
+// Range of Go releases whose runtime data structures delve knows how to
+// read. Goroutine and variable decoding depend on these layouts.
+const (
+	MinGoVersion = "go1.4"
+	MaxGoVersion = "go1.17"
+)
+
+// Oldest release of Go whose binaries delve can call functions in.
+// Calls are made through runtime.debugCallV2 and pass arguments
+// following the register based ABI, both of which appeared in Go 1.17.
+const MinCallGoVersion = "go1.17"
+
+// Returns a human readable description of the version of
+// delve and the range of Go releases it supports.
+func String() string {
+	return fmt.Sprintf("Delve version %s, supports %s through %s", Version, MinGoVersion, MaxGoVersion)
+}
+
+// Represents an incompatibility between delve and the version of Go
+// a binary was built with. Fatal is true when debugging the binary
+// is known not to work, rather than merely being untested.
+type IncompatibleError struct {
+	GoVersion string
+	Fatal     bool
+}
+
+func (ie IncompatibleError) Error() string {
+	if ie.Fatal {
+		return fmt.Sprintf("binary was built with %s, which is older than %s and is not supported", ie.GoVersion, MinGoVersion)
+	}
+
+	return fmt.Sprintf("binary was built with %s, which is newer than %s or unknown, some features may not work", ie.GoVersion, MaxGoVersion)
+}
+
+// Checks whether a binary built with the given version of Go,
+// as reported by runtime.Version, can be debugged.
+func CheckGoVersion(v string) error {
+	major, minor, ok := parseGoVersion(v)
+	if !ok {
+		return IncompatibleError{GoVersion: v}
+	}
+
+	minMajor, minMinor, _ := parseGoVersion(MinGoVersion)
+	maxMajor, maxMinor, _ := parseGoVersion(MaxGoVersion)
+
+	switch {
+	case major < minMajor || (major == minMajor && minor < minMinor):
+		return IncompatibleError{GoVersion: v, Fatal: true}
+	case major > maxMajor || (major == maxMajor && minor > maxMinor):
+		return IncompatibleError{GoVersion: v}
+	}
+
+	return nil
+}
+
+// Checks whether functions can be called in a binary built with
+// the given version of Go. Development builds are assumed to be recent
+// enough.
+func CheckCallSupport(v string) error {
+	major, minor, ok := parseGoVersion(v)
+	if !ok {
+		return nil
+	}
+
+	minMajor, minMinor, _ := parseGoVersion(MinCallGoVersion)
+	if major < minMajor || (major == minMajor && minor < minMinor) {
+		return fmt.Errorf("binary was built with %s, calling functions requires %s or later", v, MinCallGoVersion)
+	}
+
+	return nil
+}
+
+// Parses the major and minor numbers from versions such as
+// "go1.4", "go1.4.2" or "go1.5beta1". Development builds are
+// reported as "devel +hash" and cannot be parsed.
+func parseGoVersion(v string) (major, minor int, ok bool) {
+	if !strings.HasPrefix(v, "go") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(v[2:], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	// Strip any pre-release suffix, as in 1.5beta1.
+	m := parts[1]
+	for i, c := range m {
+		if c < '0' || c > '9' {
+			m = m[:i]
+			break
+		}
+	}
+
+	minor, err = strconv.Atoi(m)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
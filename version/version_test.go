@@ -0,0 +1,61 @@
+package version
+
+import "testing"
+
+func TestCheckGoVersion(t *testing.T) {
+	testcases := []struct {
+		version string
+		ok      bool
+		fatal   bool
+	}{
+		{"go1.4", true, false},
+		{"go1.4.2", true, false},
+		{"go1.3.3", false, true},
+		{"go1.5beta1", true, false},
+		{"go1.17.6", true, false},
+		{"go1.18beta1", false, false},
+		{"devel +a6f8d1c", false, false},
+	}
+
+	for _, tc := range testcases {
+		err := CheckGoVersion(tc.version)
+		if tc.ok {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %s", tc.version, err)
+			}
+			continue
+		}
+
+		ie, ok := err.(IncompatibleError)
+		if !ok {
+			t.Fatalf("%s: expected IncompatibleError got %#v", tc.version, err)
+		}
+
+		if ie.Fatal != tc.fatal {
+			t.Fatalf("%s: expected fatal %v got %v", tc.version, tc.fatal, ie.Fatal)
+		}
+	}
+}
+
+func TestCheckCallSupport(t *testing.T) {
+	testcases := []struct {
+		version string
+		ok      bool
+	}{
+		{"go1.4.2", false},
+		{"go1.16.15", false},
+		{"go1.17", true},
+		{"go1.17.6", true},
+		{"devel +a6f8d1c", true},
+	}
+
+	for _, tc := range testcases {
+		err := CheckCallSupport(tc.version)
+		if tc.ok && err != nil {
+			t.Fatalf("%s: unexpected error %s", tc.version, err)
+		}
+		if !tc.ok && err == nil {
+			t.Fatalf("%s: expected an error", tc.version)
+		}
+	}
+}